@@ -0,0 +1,19 @@
+package auth
+
+import "context"
+
+type keyFingerprintContextKey struct{}
+
+// WithKeyFingerprint returns a copy of ctx that records the fingerprint of the
+// key that authorized the request.
+func WithKeyFingerprint(ctx context.Context, fingerprint string) context.Context {
+	return context.WithValue(ctx, keyFingerprintContextKey{}, fingerprint)
+}
+
+// KeyFingerprint returns the fingerprint of the key that authorized the
+// request, or an empty string if authentication isn't configured, or wasn't
+// required for the request.
+func KeyFingerprint(ctx context.Context) string {
+	fingerprint, _ := ctx.Value(keyFingerprintContextKey{}).(string)
+	return fingerprint
+}
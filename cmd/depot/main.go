@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/a-h/depot/accesslog"
@@ -15,16 +16,20 @@ import (
 	"github.com/a-h/depot/cmd/globals"
 	gocmd "github.com/a-h/depot/gomod/cmd"
 	gomoddb "github.com/a-h/depot/gomod/db"
+	gomoddownload "github.com/a-h/depot/gomod/download"
 	"github.com/a-h/depot/loggedstorage"
 	"github.com/a-h/depot/metrics"
 	depotmetrics "github.com/a-h/depot/metrics"
 	nixcmd "github.com/a-h/depot/nix/cmd"
 	nixdb "github.com/a-h/depot/nix/db"
 	"github.com/a-h/depot/nix/push"
+	"github.com/a-h/depot/nix/upstream"
 	npmcmd "github.com/a-h/depot/npm/cmd"
 	npmdb "github.com/a-h/depot/npm/db"
+	npmdownload "github.com/a-h/depot/npm/download"
 	pythoncmd "github.com/a-h/depot/python/cmd"
 	pythondb "github.com/a-h/depot/python/db"
+	pythonupstream "github.com/a-h/depot/python/upstream"
 	"github.com/a-h/depot/storage"
 
 	"github.com/a-h/depot/routes"
@@ -110,15 +115,23 @@ type S3Flags struct {
 }
 
 type ServeCmd struct {
-	DatabaseType      string  `help:"Choice of database (sqlite, rqlite or postgres)" default:"sqlite" enum:"sqlite,rqlite,postgres" env:"DEPOT_DATABASE_TYPE"`
-	DatabaseURL       string  `help:"Database connection URL" default:"" env:"DEPOT_DATABASE_URL"`
-	ListenAddr        string  `help:"Address to listen on" default:":8080" env:"DEPOT_LISTEN_ADDR"`
-	MetricsListenAddr string  `help:"Address for metrics endpoint" default:":9090" env:"DEPOT_METRICS_LISTEN_ADDR"`
-	StorePath         string  `help:"Path to file store" default:"" env:"DEPOT_STORE_PATH"`
-	AuthFile          string  `help:"Path to SSH public keys auth file (format: r/w ssh-key comment)" env:"DEPOT_AUTH_FILE"`
-	PrivateKey        string  `help:"Path to private key file for signing narinfo files" env:"DEPOT_PRIVATE_KEY"`
-	StorageType       string  `help:"Storage backend type (fs or s3)" default:"fs" enum:"fs,s3" env:"DEPOT_STORAGE_TYPE"`
-	S3                S3Flags `embed:"" prefix:"s3-"`
+	DatabaseType        string        `help:"Choice of database (sqlite, rqlite or postgres)" default:"sqlite" enum:"sqlite,rqlite,postgres" env:"DEPOT_DATABASE_TYPE"`
+	DatabaseURL         string        `help:"Database connection URL" default:"" env:"DEPOT_DATABASE_URL"`
+	BaseURL             string        `help:"Public URL of the depot server, used in links to packages" default:"http://localhost:8080" env:"DEPOT_BASE_URL"`
+	ListenAddr          string        `help:"Address to listen on" default:":8080" env:"DEPOT_LISTEN_ADDR"`
+	MetricsListenAddr   string        `help:"Address for metrics endpoint" default:":9090" env:"DEPOT_METRICS_LISTEN_ADDR"`
+	StorePath           string        `help:"Path to file store" default:"" env:"DEPOT_STORE_PATH"`
+	AuthFile            string        `help:"Path to SSH public keys auth file (format: r/w ssh-key comment)" env:"DEPOT_AUTH_FILE"`
+	PrivateKey          string        `help:"Path to private key file for signing narinfo files" env:"DEPOT_PRIVATE_KEY"`
+	GoUpstream          string        `help:"Upstream GOPROXY URL to fetch missing modules from (e.g. https://proxy.golang.org)" env:"DEPOT_GO_UPSTREAM"`
+	GoRejectChangedZips bool          `help:"Reject pushed Go module zips whose hash differs from the previously recorded hash" env:"DEPOT_GO_REJECT_CHANGED_ZIPS"`
+	NPMUpstream         string        `help:"Upstream NPM registry URL to fetch missing packages from (e.g. https://registry.npmjs.org)" env:"DEPOT_NPM_UPSTREAM"`
+	NPMUpstreamMaxAge   time.Duration `help:"How long to serve package metadata fetched from the upstream NPM registry before checking it for new versions" default:"5m" env:"DEPOT_NPM_UPSTREAM_MAX_AGE"`
+	PythonUpstream      string        `help:"Upstream Python simple index URL to fetch missing packages from (e.g. https://pypi.org/simple)" env:"DEPOT_PYTHON_UPSTREAM"`
+	NixUpstream         []string      `help:"Upstream Nix binary caches to fetch missing store paths from (e.g. https://cache.nixos.org)" env:"DEPOT_NIX_UPSTREAM"`
+	NixTrustedKeys      []string      `help:"Public keys trusted to sign narinfo files from upstream Nix binary caches" default:"cache.nixos.org-1:6NCHdD59X431o0gWypbMrAURkbJ16ZPMQFGspcDShjY=" env:"DEPOT_NIX_TRUSTED_KEYS"`
+	StorageType         string        `help:"Storage backend type (fs or s3)" default:"fs" enum:"fs,s3" env:"DEPOT_STORAGE_TYPE"`
+	S3                  S3Flags       `embed:"" prefix:"s3-"`
 }
 
 func (cmd *ServeCmd) Run(globals *globals.Globals) error {
@@ -188,6 +201,23 @@ func (cmd *ServeCmd) Run(globals *globals.Globals) error {
 		log.Info("loaded private key for signing", slog.String("key", key.ToPublicKey().String()))
 	}
 
+	// Configure upstream Nix binary caches for pull-through caching.
+	var nixUpstream *upstream.Client
+	if len(cmd.NixUpstream) > 0 {
+		trustedKeys := make([]signature.PublicKey, len(cmd.NixTrustedKeys))
+		for i, k := range cmd.NixTrustedKeys {
+			trustedKeys[i], err = signature.ParsePublicKey(k)
+			if err != nil {
+				return fmt.Errorf("failed to parse trusted public key %q: %w", k, err)
+			}
+		}
+		if len(trustedKeys) == 0 {
+			return fmt.Errorf("--nix-trusted-keys must be set when --nix-upstream is set")
+		}
+		nixUpstream = upstream.New(log, cmd.NixUpstream, trustedKeys)
+		log.Info("configured upstream nix binary caches", slog.Any("upstream", cmd.NixUpstream), slog.Any("trustedKeys", cmd.NixTrustedKeys))
+	}
+
 	// Create HTTP server.
 	metrics, err := depotmetrics.New()
 	if err != nil {
@@ -211,11 +241,34 @@ func (cmd *ServeCmd) Run(globals *globals.Globals) error {
 		return err
 	}
 
+	// Configure the upstream Go module proxy for pull-through caching.
+	var goUpstream *gomoddownload.Downloader
+	if cmd.GoUpstream != "" {
+		goUpstream = gomoddownload.New(log, goStorage)
+		goUpstream.SetProxyURL(cmd.GoUpstream)
+		log.Info("configured upstream go module proxy", slog.String("upstream", cmd.GoUpstream))
+	}
+
+	// Configure the upstream NPM registry for pull-through caching.
+	var npmUpstream *npmdownload.Downloader
+	if cmd.NPMUpstream != "" {
+		npmUpstream = npmdownload.New(log, npmStorage)
+		npmUpstream.SetRegistryURL(cmd.NPMUpstream)
+		log.Info("configured upstream npm registry", slog.String("upstream", cmd.NPMUpstream))
+	}
+
+	var pythonUpstream *pythonupstream.Client
+	if cmd.PythonUpstream != "" {
+		pythonUpstream = pythonupstream.New(log, cmd.PythonUpstream)
+		log.Info("configured upstream Python index", slog.String("upstream", cmd.PythonUpstream))
+	}
+
+	baseURL := strings.TrimSuffix(cmd.BaseURL, "/")
 	cfg := routes.HandlerConfig{
-		GoMod:  routes.PackageHandlerConfig[*gomoddb.DB]{DB: gomoddb.New(store), Storage: goStorage},
-		Nix:    routes.NixHandlerConfig{DB: nixdb.New(store), Storage: nixStorage, PrivateKey: privateKey},
-		NPM:    routes.PackageHandlerConfig[*npmdb.DB]{DB: npmdb.New(store), Storage: npmStorage},
-		Python: routes.PythonHandlerConfig{DB: pythondb.New(store), Storage: pythonStorage, BaseURL: "http://localhost:8080/python"},
+		GoMod:  routes.GoModHandlerConfig{DB: gomoddb.New(store), Storage: goStorage, Upstream: goUpstream, RejectChangedZips: cmd.GoRejectChangedZips},
+		Nix:    routes.NixHandlerConfig{DB: nixdb.New(store), Storage: nixStorage, PrivateKey: privateKey, Upstream: nixUpstream},
+		NPM:    routes.NPMHandlerConfig{DB: npmdb.New(store), Storage: npmStorage, Upstream: npmUpstream, UpstreamMaxAge: cmd.NPMUpstreamMaxAge, BaseURL: baseURL},
+		Python: routes.PythonHandlerConfig{DB: pythondb.New(store), Storage: pythonStorage, Upstream: pythonUpstream, BaseURL: baseURL + "/python"},
 	}
 	s := http.Server{
 		Addr:    cmd.ListenAddr,
@@ -253,7 +306,15 @@ func (cmd *ServeCmd) createStorage(ctx context.Context, log *slog.Logger, prefix
 			return nil, nil, fmt.Errorf("failed to create s3 storage: %w", err)
 		}
 	case "fs":
-		baseStorage = storage.NewFileSystem(filepath.Join(cmd.StorePath, prefix))
+		fsStorage := storage.NewFileSystem(filepath.Join(cmd.StorePath, prefix))
+		removed, err := fsStorage.RemoveTempFiles(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to clean up %s storage: %w", prefix, err)
+		}
+		if removed > 0 {
+			log.Info("removed incomplete uploads from storage", slog.String("prefix", prefix), slog.Int("count", removed))
+		}
+		baseStorage = fsStorage
 	default:
 		return nil, nil, fmt.Errorf("unknown storage type %q", cmd.StorageType)
 	}
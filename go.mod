@@ -3,6 +3,7 @@ module github.com/a-h/depot
 go 1.26
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Masterminds/semver/v3 v3.5.0
 	github.com/a-h/kv v0.0.0-20260730155150-9cc1a1dfa5cd
 	github.com/alecthomas/kong v1.16.0
@@ -23,6 +24,7 @@ require (
 	go.opentelemetry.io/otel/exporters/prometheus v0.66.0
 	go.opentelemetry.io/otel/metric v1.44.0
 	go.opentelemetry.io/otel/sdk/metric v1.44.0
+	go.yaml.in/yaml/v3 v3.0.4
 	golang.org/x/crypto v0.54.0
 	golang.org/x/mod v0.38.0
 	zombiezen.com/go/sqlite v1.4.2
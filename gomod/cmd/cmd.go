@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/a-h/depot/cmd/globals"
+	"github.com/a-h/depot/gomod/download"
 	gopush "github.com/a-h/depot/gomod/push"
 	"github.com/a-h/depot/gomod/save"
 	"github.com/a-h/depot/storage"
@@ -13,14 +14,18 @@ import (
 
 // GoCmd groups Go module management commands.
 type GoCmd struct {
-	Save Save `cmd:"" help:"Save Go modules to local store. Fetches modules and their transitive dependencies from proxy.golang.org. Accepts module@version arguments or a path to a go.mod file."`
+	Save Save `cmd:"" help:"Save Go modules to local store. Fetches modules and their transitive dependencies from proxy.golang.org, and their checksum database records. Accepts module@version arguments, or paths to go.mod files, go.work files or directories containing Go modules."`
 	Push Push `cmd:"" help:"Push saved Go modules to a remote depot server."`
 }
 
 // Save downloads Go modules from the upstream proxy.
 type Save struct {
-	Dir     string   `help:"Directory to save modules to." default:".depot-storage/go" env:"DEPOT_GO_DIR"`
-	Modules []string `arg:"" help:"Module specs (module@version) or path to go.mod file." default:"./go.mod"`
+	Dir       string   `help:"Directory to save modules to." default:".depot-storage/go" env:"DEPOT_GO_DIR"`
+	SumDB     string   `name:"sumdb" help:"Checksum database to save the records of the modules from, in GOSUMDB format, or off." default:"sum.golang.org" env:"DEPOT_GO_SUMDB"`
+	VulnDB    string   `name:"vulndb" help:"URL of a Go vulnerability database to save a snapshot of, e.g. https://vuln.go.dev. Not saved if empty." env:"DEPOT_GO_VULNDB"`
+	Toolchain []string `help:"Go toolchains to save, e.g. go1.26.1, so that the go command can switch to them with GOTOOLCHAIN. The toolchain directive of a go.mod file is saved automatically." env:"DEPOT_GO_TOOLCHAIN"`
+	Platform  []string `help:"Platforms to save Go toolchains for, as GOOS/GOARCH. Defaults to the current platform." env:"DEPOT_GO_PLATFORM"`
+	Modules   []string `arg:"" optional:"" help:"Module specs (module@version), or paths to go.mod files, go.work files or directories, which are searched for go.mod files. Defaults to ./go.mod, unless toolchains are specified."`
 }
 
 // Run executes the save command.
@@ -31,11 +36,26 @@ func (cmd *Save) Run(globals *globals.Globals) error {
 	}
 	log := slog.New(slog.NewJSONHandler(os.Stderr, opts))
 
+	checksumDB, err := download.ParseChecksumDB(cmd.SumDB)
+	if err != nil {
+		return err
+	}
+
 	ctx, stop := globals.NewContext()
 	defer stop()
 	s := storage.NewFileSystem(cmd.Dir)
 	saver := save.New(log, s)
-	return saver.Save(ctx, cmd.Modules)
+	saver.SetChecksumDB(checksumDB)
+	saver.SetVulnDB(cmd.VulnDB)
+	saver.SetToolchains(cmd.Toolchain)
+	if len(cmd.Platform) > 0 {
+		saver.SetPlatforms(cmd.Platform)
+	}
+	modules := cmd.Modules
+	if len(modules) == 0 && len(cmd.Toolchain) == 0 {
+		modules = []string{"./go.mod"}
+	}
+	return saver.Save(ctx, modules)
 }
 
 // Push uploads saved Go modules to a remote depot.
@@ -1,12 +1,16 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"path"
+	"slices"
 	"time"
 
 	"github.com/a-h/kv"
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 )
 
 // New creates a new DB instance.
@@ -23,6 +27,8 @@ type DB struct {
 type ModuleVersion struct {
 	Info  VersionInfo `json:"info"`
 	GoMod string      `json:"goMod"`
+	// ZipHash is the h1: hash of the module zip, recorded when the zip is pushed.
+	ZipHash string `json:"zipHash,omitempty"`
 }
 
 // VersionInfo matches the JSON format served by the Go module proxy protocol.
@@ -78,52 +84,101 @@ func (d *DB) GetModuleVersion(ctx context.Context, modulePath, version string) (
 	return mv, true, nil
 }
 
-// ListVersions returns all stored version strings for a module.
+// ListVersions returns the stored versions of a module in semver order.
+// Pseudo-versions are excluded, as in the list served by proxy.golang.org.
 func (d *DB) ListVersions(ctx context.Context, modulePath string) (versions []string, err error) {
-	prefix, err := buildPrefix(modulePath)
+	mvs, err := d.getModuleVersions(ctx, modulePath)
 	if err != nil {
 		return nil, err
 	}
-	records, err := d.store.GetPrefix(ctx, prefix, 0, -1)
-	if err != nil {
-		return nil, err
+	versions = make([]string, 0, len(mvs))
+	for _, mv := range mvs {
+		if module.IsPseudoVersion(mv.Info.Version) {
+			continue
+		}
+		versions = append(versions, mv.Info.Version)
 	}
-	mvs, err := kv.ValuesOf[ModuleVersion](records)
+	semver.Sort(versions)
+	return versions, nil
+}
+
+// GetLatestVersion returns the version of a module that the Go toolchain
+// resolves @latest to: the highest release, or if there are no releases, the
+// highest prerelease, or if there are no prereleases, the highest
+// pseudo-version. Versions retracted by the go.mod of the latest version are
+// skipped, unless every version is retracted.
+func (d *DB) GetLatestVersion(ctx context.Context, modulePath string) (mv ModuleVersion, ok bool, err error) {
+	mvs, err := d.getModuleVersions(ctx, modulePath)
 	if err != nil {
-		return nil, err
+		return ModuleVersion{}, false, err
 	}
-	versions = make([]string, len(mvs))
-	for i, mv := range mvs {
-		versions[i] = mv.Info.Version
+	if len(mvs) == 0 {
+		return ModuleVersion{}, false, nil
 	}
-	return versions, nil
+	slices.SortFunc(mvs, func(a, b ModuleVersion) int {
+		return compareLatest(a.Info.Version, b.Info.Version)
+	})
+	latest := mvs[len(mvs)-1]
+	retracted := retractions(latest.GoMod)
+	for _, mv := range slices.Backward(mvs) {
+		if !isRetracted(retracted, mv.Info.Version) {
+			return mv, true, nil
+		}
+	}
+	return latest, true, nil
 }
 
-// GetLatestVersion returns the module version with the most recent Info.Time.
-func (d *DB) GetLatestVersion(ctx context.Context, modulePath string) (mv ModuleVersion, ok bool, err error) {
+func (d *DB) getModuleVersions(ctx context.Context, modulePath string) (mvs []ModuleVersion, err error) {
 	prefix, err := buildPrefix(modulePath)
 	if err != nil {
-		return ModuleVersion{}, false, err
+		return nil, err
 	}
 	records, err := d.store.GetPrefix(ctx, prefix, 0, -1)
 	if err != nil {
-		return ModuleVersion{}, false, err
+		return nil, err
 	}
-	if len(records) == 0 {
-		return ModuleVersion{}, false, nil
+	return kv.ValuesOf[ModuleVersion](records)
+}
+
+// compareLatest orders versions by preference for @latest: pseudo-versions,
+// then prereleases, then releases, each in semver order.
+func compareLatest(a, b string) int {
+	if c := cmp.Compare(versionClass(a), versionClass(b)); c != 0 {
+		return c
 	}
-	mvs, err := kv.ValuesOf[ModuleVersion](records)
+	return semver.Compare(a, b)
+}
+
+func versionClass(v string) int {
+	switch {
+	case module.IsPseudoVersion(v):
+		return 0
+	case semver.Prerelease(v) != "":
+		return 1
+	}
+	return 2
+}
+
+// retractions returns the version intervals retracted by a go.mod file.
+func retractions(goMod string) (retracted []modfile.VersionInterval) {
+	if goMod == "" {
+		return nil
+	}
+	f, err := modfile.ParseLax("go.mod", []byte(goMod), nil)
 	if err != nil {
-		return ModuleVersion{}, false, err
+		return nil
 	}
+	for _, r := range f.Retract {
+		retracted = append(retracted, r.VersionInterval)
+	}
+	return retracted
+}
 
-	var latest ModuleVersion
-	var latestTime time.Time
-	for _, mv := range mvs {
-		if mv.Info.Time.After(latestTime) {
-			latest = mv
-			latestTime = mv.Info.Time
+func isRetracted(retracted []modfile.VersionInterval, version string) bool {
+	for _, r := range retracted {
+		if semver.Compare(r.Low, version) <= 0 && semver.Compare(version, r.High) <= 0 {
+			return true
 		}
 	}
-	return latest, true, nil
+	return false
 }
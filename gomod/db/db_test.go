@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -180,3 +182,124 @@ func TestGetLatestVersionReturnsNotFoundForMissingModule(t *testing.T) {
 		t.Error("expected latest version to not exist")
 	}
 }
+
+func TestListVersionsIsSortedAndExcludesPseudoVersions(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	modulePath := "github.com/foo/sorted"
+	for _, v := range []string{"v1.10.0", "v1.2.0", "v0.0.0-20240101000000-abcdefabcdef", "v1.3.0-rc.1", "v1.2.10"} {
+		mv := ModuleVersion{Info: VersionInfo{Version: v, Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}
+		if err := db.PutModuleVersion(ctx, modulePath, v, mv); err != nil {
+			t.Fatalf("unexpected error putting %s: %v", v, err)
+		}
+	}
+
+	got, err := db.ListVersions(ctx, modulePath)
+	if err != nil {
+		t.Fatalf("unexpected error listing versions: %v", err)
+	}
+	expected := []string{"v1.2.0", "v1.2.10", "v1.3.0-rc.1", "v1.10.0"}
+	if !slices.Equal(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestGetLatestVersionFollowsGoQueryRules(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	type version struct {
+		version string
+		time    time.Time
+		goMod   string
+	}
+	tests := []struct {
+		name     string
+		versions []version
+		expected string
+	}{
+		{
+			name: "the highest release wins over a newer backport",
+			versions: []version{
+				{version: "v1.3.0", time: day(1)},
+				{version: "v1.2.9", time: day(2)},
+			},
+			expected: "v1.3.0",
+		},
+		{
+			name: "releases are preferred to prereleases and pseudo-versions",
+			versions: []version{
+				{version: "v1.0.0", time: day(1)},
+				{version: "v1.1.0-rc.1", time: day(2)},
+				{version: "v1.1.1-0.20240103000000-abcdefabcdef", time: day(3)},
+			},
+			expected: "v1.0.0",
+		},
+		{
+			name: "prereleases are preferred to pseudo-versions",
+			versions: []version{
+				{version: "v1.1.0-rc.1", time: day(1)},
+				{version: "v1.1.0-rc.2", time: day(2)},
+				{version: "v1.1.1-0.20240103000000-abcdefabcdef", time: day(3)},
+			},
+			expected: "v1.1.0-rc.2",
+		},
+		{
+			name: "the highest pseudo-version is used if there are no tags",
+			versions: []version{
+				{version: "v0.0.0-20240102000000-abcdefabcdef", time: day(2)},
+				{version: "v0.0.0-20240101000000-abcdefabcdef", time: day(3)},
+			},
+			expected: "v0.0.0-20240102000000-abcdefabcdef",
+		},
+		{
+			name: "versions retracted by the latest go.mod are skipped",
+			versions: []version{
+				{version: "v1.0.0", time: day(1)},
+				{version: "v1.1.0", time: day(2)},
+				{version: "v1.2.0", time: day(3), goMod: "module example.com/m\n\nretract (\n\tv1.2.0\n\t[v1.1.0, v1.1.9]\n)\n"},
+			},
+			expected: "v1.0.0",
+		},
+		{
+			name: "retractions in older go.mod files are ignored",
+			versions: []version{
+				{version: "v1.0.0", time: day(1), goMod: "module example.com/m\n\nretract v1.1.0\n"},
+				{version: "v1.1.0", time: day(2)},
+			},
+			expected: "v1.1.0",
+		},
+		{
+			name: "the latest version is used if every version is retracted",
+			versions: []version{
+				{version: "v1.0.0", time: day(1)},
+				{version: "v1.1.0", time: day(2), goMod: "module example.com/m\n\nretract [v1.0.0, v1.1.0]\n"},
+			},
+			expected: "v1.1.0",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			modulePath := fmt.Sprintf("github.com/foo/latest%d", i)
+			for _, v := range tt.versions {
+				mv := ModuleVersion{Info: VersionInfo{Version: v.version, Time: v.time}, GoMod: v.goMod}
+				if err := db.PutModuleVersion(ctx, modulePath, v.version, mv); err != nil {
+					t.Fatalf("unexpected error putting %s: %v", v.version, err)
+				}
+			}
+			got, ok, err := db.GetLatestVersion(ctx, modulePath)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !ok {
+				t.Fatal("expected a latest version to exist")
+			}
+			if got.Info.Version != tt.expected {
+				t.Errorf("got version %q, expected %q", got.Info.Version, tt.expected)
+			}
+		})
+	}
+}
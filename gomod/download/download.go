@@ -1,22 +1,29 @@
 package download
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/a-h/depot/gomod/gosum"
 	"github.com/a-h/depot/storage"
 	"golang.org/x/mod/module"
 )
 
 const defaultProxyURL = "https://proxy.golang.org"
 
+// ErrNotFound is returned when the upstream proxy does not have the requested module or version.
+var ErrNotFound = errors.New("not found")
+
 // ModuleSpec represents a module path and optional version.
 type ModuleSpec struct {
 	Path    string
@@ -81,8 +88,8 @@ func (d *Downloader) ResolveLatest(ctx context.Context, modulePath string) (vers
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	if err = checkStatus(resp, url); err != nil {
+		return "", err
 	}
 
 	var info struct {
@@ -94,48 +101,136 @@ func (d *Downloader) ResolveLatest(ctx context.Context, modulePath string) (vers
 	return info.Version, nil
 }
 
-// Download fetches .info, .mod, and .zip for a module version and stores them.
-// It returns the raw go.mod content for dependency resolution.
-func (d *Downloader) Download(ctx context.Context, modulePath, version string) (goModContent []byte, err error) {
+// List returns the versions of a module known to the upstream proxy.
+func (d *Downloader) List(ctx context.Context, modulePath string) (versions []string, err error) {
 	encoded, err := module.EscapePath(modulePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode module path: %w", err)
 	}
-	escaped, err := module.EscapeVersion(version)
+	url := fmt.Sprintf("%s/%s/@v/list", d.proxyURL, encoded)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatus(resp, url); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if v := strings.TrimSpace(scanner.Text()); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return versions, scanner.Err()
+}
+
+// Download fetches .info, .mod, and .zip for a module version and stores them.
+// It returns the raw go.mod content for dependency resolution.
+func (d *Downloader) Download(ctx context.Context, modulePath, version string) (goModContent []byte, err error) {
+	_, goModContent, err = d.DownloadMetadata(ctx, modulePath, version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to escape version: %w", err)
+		return nil, err
+	}
+	if err = d.DownloadZip(ctx, modulePath, version); err != nil {
+		return nil, err
 	}
+	return goModContent, nil
+}
 
-	base := path.Join(encoded, "@v", escaped)
+// DownloadMetadata fetches .info and .mod for a module version and stores them,
+// without downloading the module zip.
+func (d *Downloader) DownloadMetadata(ctx context.Context, modulePath, version string) (infoContent, goModContent []byte, err error) {
+	encoded, escaped, base, err := escape(modulePath, version)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if _, err := d.downloadFile(ctx, encoded, escaped, base+".info"); err != nil {
-		return nil, fmt.Errorf("failed to download .info: %w", err)
+	infoContent, err = d.downloadFile(ctx, encoded, escaped, base+".info")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download .info: %w", err)
 	}
 
 	goModContent, err = d.downloadFile(ctx, encoded, escaped, base+".mod")
 	if err != nil {
-		return nil, fmt.Errorf("failed to download .mod: %w", err)
+		return nil, nil, fmt.Errorf("failed to download .mod: %w", err)
 	}
 
-	if _, err := d.downloadFile(ctx, encoded, escaped, base+".zip"); err != nil {
-		return nil, fmt.Errorf("failed to download .zip: %w", err)
+	return infoContent, goModContent, nil
+}
+
+// DownloadZip fetches the .zip for a module version and stores it.
+func (d *Downloader) DownloadZip(ctx context.Context, modulePath, version string) (err error) {
+	encoded, escaped, base, err := escape(modulePath, version)
+	if err != nil {
+		return err
 	}
+	if err = d.downloadFileToStorage(ctx, encoded, escaped, base+".zip"); err != nil {
+		return fmt.Errorf("failed to download .zip: %w", err)
+	}
+	return nil
+}
 
-	return goModContent, nil
+func escape(modulePath, version string) (encoded, escaped, base string, err error) {
+	encoded, err = module.EscapePath(modulePath)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to encode module path: %w", err)
+	}
+	escaped, err = module.EscapeVersion(version)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to escape version: %w", err)
+	}
+	return encoded, escaped, path.Join(encoded, "@v", escaped), nil
+}
+
+// checkStatus returns ErrNotFound if the upstream proxy reports that the
+// resource does not exist, and an error for any other unsuccessful status.
+func checkStatus(resp *http.Response, url string) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound, http.StatusGone:
+		return fmt.Errorf("%w: HTTP %d from %s", ErrNotFound, resp.StatusCode, url)
+	}
+	return fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
 }
 
 // downloadFile downloads a single file from the upstream proxy into storage.
 // If the file already exists in storage, it reads and returns the existing content
 // without contacting the upstream proxy. Returns the file content.
 func (d *Downloader) downloadFile(ctx context.Context, encodedPath, escapedVersion, storageKey string) (content []byte, err error) {
+	if err = d.downloadFileToStorage(ctx, encodedPath, escapedVersion, storageKey); err != nil {
+		return nil, err
+	}
 	r, exists, err := d.storage.Get(ctx, storageKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check %s: %w", storageKey, err)
+		return nil, fmt.Errorf("failed to read %s: %w", storageKey, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s not found in storage after download", storageKey)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// downloadFileToStorage streams a single file from the upstream proxy into
+// storage, unless it already exists in storage.
+func (d *Downloader) downloadFileToStorage(ctx context.Context, encodedPath, escapedVersion, storageKey string) (err error) {
+	_, exists, err := d.storage.Stat(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", storageKey, err)
 	}
 	if exists {
-		defer r.Close()
 		d.log.Debug("skipping existing file", slog.String("key", storageKey))
-		return io.ReadAll(r)
+		return nil
 	}
 
 	ext := path.Ext(storageKey)
@@ -143,29 +238,91 @@ func (d *Downloader) downloadFile(ctx context.Context, encodedPath, escapedVersi
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	resp, err := d.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	if err = checkStatus(resp, url); err != nil {
+		return err
 	}
 
 	w, err := d.storage.Put(ctx, storageKey)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
-		if cerr := w.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err != nil {
+			storage.Abort(w)
+			return
 		}
+		err = w.Close()
 	}()
 
-	content, err = io.ReadAll(io.TeeReader(resp.Body, w))
-	return content, err
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Verify checks the stored .mod and .zip of a module version against the
+// hashes in sums. Files without a hash in sums, or that aren't stored, aren't
+// checked. Files that don't match are deleted from storage, so that they
+// aren't pushed.
+func (d *Downloader) Verify(ctx context.Context, modulePath, version string, sums gosum.Sums) (err error) {
+	_, _, base, err := escape(modulePath, version)
+	if err != nil {
+		return err
+	}
+	mv := module.Version{Path: modulePath, Version: version}
+	if expected, ok := sums.GoMod(modulePath, version); ok {
+		data, err := readAll(ctx, d.storage, base+".mod")
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			if err = gosum.VerifyGoMod(mv, data, expected); err != nil {
+				return d.deleteUnverified(ctx, base+".mod", err)
+			}
+		}
+	}
+	if expected, ok := sums.Zip(modulePath, version); ok {
+		return d.verifyZip(ctx, mv, base+".zip", expected)
+	}
+	return nil
+}
+
+// verifyZip checks a stored module zip against the expected hash. The zip is
+// copied to a temporary file, because module zips are hashed from files.
+func (d *Downloader) verifyZip(ctx context.Context, mv module.Version, storageKey, expected string) (err error) {
+	r, exists, err := d.storage.Get(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", storageKey, err)
+	}
+	if !exists {
+		return nil
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp("", "depot-go-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err = io.Copy(tmp, r); err != nil {
+		return fmt.Errorf("failed to read %s: %w", storageKey, err)
+	}
+	if err = gosum.VerifyZip(mv, tmp.Name(), expected); err != nil {
+		return d.deleteUnverified(ctx, storageKey, err)
+	}
+	return nil
+}
+
+func (d *Downloader) deleteUnverified(ctx context.Context, storageKey string, verifyErr error) error {
+	if err := d.storage.Delete(ctx, storageKey); err != nil {
+		return errors.Join(verifyErr, fmt.Errorf("failed to delete %s: %w", storageKey, err))
+	}
+	return verifyErr
 }
@@ -0,0 +1,303 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/a-h/depot/storage"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// DefaultChecksumDB is the verifier key of sum.golang.org, the checksum
+// database used by the Go toolchain unless GOSUMDB is set.
+const DefaultChecksumDB = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+
+// ParseChecksumDB parses a checksum database in the format of GOSUMDB, e.g.
+// "sum.golang.org", or a verifier key, and returns the verifier key. "off"
+// returns an empty key. Any URL after the key is ignored, because records are
+// fetched through the upstream proxy.
+func ParseChecksumDB(gosumdb string) (verifierKey string, err error) {
+	fields := strings.Fields(gosumdb)
+	if len(fields) == 0 || fields[0] == "off" {
+		return "", nil
+	}
+	if fields[0] == "sum.golang.org" {
+		return DefaultChecksumDB, nil
+	}
+	if _, err = note.NewVerifier(fields[0]); err != nil {
+		return "", fmt.Errorf("invalid checksum database %q: %w", gosumdb, err)
+	}
+	return fields[0], nil
+}
+
+// ChecksumDBPath returns the storage path of a checksum database file, using
+// the layout of the checksum database proxy protocol, e.g.
+// sumdb/sum.golang.org/lookup/github.com/foo/bar@v1.0.0.
+func ChecksumDBPath(name, file string) string {
+	return path.Join("sumdb", name, file)
+}
+
+// FetchChecksumDB fetches a file from the checksum database through the
+// upstream proxy, e.g. "lookup/github.com/foo/bar@v1.0.0" or "tile/8/0/000".
+func (d *Downloader) FetchChecksumDB(ctx context.Context, name, file string) (data []byte, err error) {
+	url := fmt.Sprintf("%s/sumdb/%s/%s", d.proxyURL, name, strings.TrimPrefix(file, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = checkStatus(resp, url); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// SaveChecksums saves the checksum database records of the module versions,
+// and the tiles that the Go toolchain needs to verify them, so that depot can
+// serve the checksum database without access to the upstream proxy.
+//
+// The records of every saved module version, including versions saved
+// previously, are stored with the latest signed tree head, and verified with
+// the checksum database client. This means that the Go toolchain only sees a
+// single tree, and the tiles it needs to prove that the tree is consistent
+// with the tree stored by a previous save are saved too.
+//
+// ErrNotFound is returned if the upstream proxy doesn't support the checksum
+// database. Module versions that aren't in the checksum database, e.g. private
+// modules, are skipped.
+func (d *Downloader) SaveChecksums(ctx context.Context, verifierKey string, versions []module.Version) (err error) {
+	verifier, err := note.NewVerifier(verifierKey)
+	if err != nil {
+		return fmt.Errorf("invalid checksum database key: %w", err)
+	}
+	name := verifier.Name()
+	if _, err = d.FetchChecksumDB(ctx, name, "supported"); err != nil {
+		return fmt.Errorf("checksum database %s: %w", name, err)
+	}
+
+	ops := &checksumDBOps{
+		ctx:         ctx,
+		log:         d.log,
+		storage:     d.storage,
+		downloader:  d,
+		name:        name,
+		verifierKey: verifierKey,
+		records:     map[string][]byte{},
+	}
+	previous, err := readAll(ctx, d.storage, ChecksumDBPath(name, "latest"))
+	if err != nil {
+		return err
+	}
+	ops.latest = previous
+
+	// Previously saved records are updated to use the new tree head.
+	stored, err := d.storage.List(ctx, ChecksumDBPath(name, "lookup")+"/", "", -1)
+	if err != nil {
+		return fmt.Errorf("failed to list checksum database records: %w", err)
+	}
+	for _, filename := range stored {
+		mv, ok := parseLookupPath(strings.TrimPrefix(filename, ChecksumDBPath(name, "lookup")+"/"))
+		if !ok {
+			continue
+		}
+		versions = append(versions, mv)
+	}
+
+	var lookups []module.Version
+	for _, mv := range versions {
+		file, err := lookupPath(mv)
+		if err != nil {
+			return err
+		}
+		if _, seen := ops.records[file]; seen {
+			continue
+		}
+		record, err := readAll(ctx, d.storage, ChecksumDBPath(name, file))
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			record, err = d.FetchChecksumDB(ctx, name, file)
+			if errors.Is(err, ErrNotFound) {
+				d.log.Warn("module not found in checksum database", slog.String("module", mv.String()), slog.String("sumdb", name))
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to look up %s in checksum database: %w", mv, err)
+			}
+		}
+		ops.records[file] = record
+		lookups = append(lookups, mv)
+	}
+	if len(lookups) == 0 {
+		return nil
+	}
+
+	// The tree head is fetched after the records, so it contains all of them.
+	latest, err := d.FetchChecksumDB(ctx, name, "latest")
+	if err != nil {
+		return fmt.Errorf("failed to get latest checksum database tree: %w", err)
+	}
+	tree, err := tlog.ParseTree(latest)
+	if err != nil {
+		return fmt.Errorf("invalid checksum database tree: %w", err)
+	}
+	for file, record := range ops.records {
+		id, text, _, err := tlog.ParseRecord(record)
+		if err != nil {
+			return fmt.Errorf("invalid checksum database record %s: %w", file, err)
+		}
+		if id >= tree.N {
+			return fmt.Errorf("checksum database record %s isn't in the latest tree", file)
+		}
+		if ops.records[file], err = tlog.FormatRecord(id, text); err != nil {
+			return fmt.Errorf("invalid checksum database record %s: %w", file, err)
+		}
+		ops.records[file] = append(ops.records[file], latest...)
+	}
+
+	client := sumdb.NewClient(ops)
+	for _, mv := range lookups {
+		if _, err = client.Lookup(mv.Path, mv.Version); err != nil {
+			if ops.securityErr != nil {
+				return ops.securityErr
+			}
+			return fmt.Errorf("failed to verify checksum database record: %w", err)
+		}
+		if ops.err != nil {
+			return ops.err
+		}
+	}
+	if err = storage.WriteFile(ctx, d.storage, ChecksumDBPath(name, "latest"), ops.latest); err != nil {
+		return err
+	}
+	d.log.Info("saved checksum database records", slog.String("sumdb", name), slog.Int("records", len(lookups)), slog.Int64("treeSize", tree.N))
+	return nil
+}
+
+func lookupPath(mv module.Version) (file string, err error) {
+	encoded, escaped, _, err := escape(mv.Path, mv.Version)
+	if err != nil {
+		return "", err
+	}
+	return "lookup/" + encoded + "@" + escaped, nil
+}
+
+func parseLookupPath(file string) (mv module.Version, ok bool) {
+	encoded, escaped, ok := strings.Cut(file, "@")
+	if !ok {
+		return mv, false
+	}
+	var err error
+	if mv.Path, err = module.UnescapePath(encoded); err != nil {
+		return mv, false
+	}
+	if mv.Version, err = module.UnescapeVersion(escaped); err != nil {
+		return mv, false
+	}
+	return mv, true
+}
+
+// checksumDBOps implements sumdb.ClientOps. Lookups are served from the
+// records prepared by SaveChecksums, tiles are read from storage, or fetched
+// from the upstream proxy, and everything that the client verifies is written
+// to storage.
+type checksumDBOps struct {
+	ctx         context.Context
+	log         *slog.Logger
+	storage     storage.Storage
+	downloader  *Downloader
+	name        string
+	verifierKey string
+	records     map[string][]byte
+	latest      []byte
+	// err is the first error that occurred while writing to storage.
+	err         error
+	securityErr error
+}
+
+func (o *checksumDBOps) ReadRemote(file string) ([]byte, error) {
+	file = strings.TrimPrefix(file, "/")
+	if record, ok := o.records[file]; ok {
+		return record, nil
+	}
+	if !strings.HasPrefix(file, "tile/") {
+		return nil, fmt.Errorf("unexpected checksum database request %q", file)
+	}
+	return o.downloader.FetchChecksumDB(o.ctx, o.name, file)
+}
+
+func (o *checksumDBOps) ReadConfig(file string) ([]byte, error) {
+	switch file {
+	case "key":
+		return []byte(o.verifierKey), nil
+	case o.name + "/latest":
+		return o.latest, nil
+	}
+	return nil, fmt.Errorf("unknown checksum database config %q", file)
+}
+
+func (o *checksumDBOps) WriteConfig(file string, old, new []byte) error {
+	if file != o.name+"/latest" {
+		return fmt.Errorf("unknown checksum database config %q", file)
+	}
+	if string(old) != string(o.latest) {
+		return sumdb.ErrWriteConflict
+	}
+	o.latest = new
+	return nil
+}
+
+var errNotCached = errors.New("not cached")
+
+func (o *checksumDBOps) ReadCache(file string) ([]byte, error) {
+	// Records are always read from the prepared records, so they use the latest tree head.
+	if !strings.HasPrefix(file, o.name+"/tile/") {
+		return nil, errNotCached
+	}
+	data, err := readAll(o.ctx, o.storage, path.Join("sumdb", file))
+	if err != nil || data == nil {
+		return nil, errNotCached
+	}
+	return data, nil
+}
+
+func (o *checksumDBOps) WriteCache(file string, data []byte) {
+	if err := storage.WriteFile(o.ctx, o.storage, path.Join("sumdb", file), data); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+func (o *checksumDBOps) Log(msg string) {
+	o.log.Debug(msg, slog.String("sumdb", o.name))
+}
+
+func (o *checksumDBOps) SecurityError(msg string) {
+	o.securityErr = fmt.Errorf("checksum database %s: %s", o.name, msg)
+}
+
+// readAll returns the content of a file in storage, or nil if it doesn't exist.
+func readAll(ctx context.Context, s storage.Storage, filename string) (data []byte, err error) {
+	r, exists, err := s.Get(ctx, filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
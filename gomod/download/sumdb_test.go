@@ -0,0 +1,187 @@
+package download
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/a-h/depot/storage"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func TestSaveChecksums(t *testing.T) {
+	ctx := context.Background()
+	signer, verifierKey, err := note.GenerateKey(rand.Reader, "sum.example.com")
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	checksumDB := sumdb.NewTestServer(signer, func(path, vers string) ([]byte, error) {
+		if path == "example.com/private" {
+			return nil, os.ErrNotExist
+		}
+		return fmt.Appendf(nil, "%s %s h1:hash=\n%s %s/go.mod h1:gomodhash=\n", path, vers, path, vers), nil
+	})
+	// grow adds records to the checksum database, so that the tree of each save is different.
+	grow := func(t *testing.T, prefix string, n int) {
+		t.Helper()
+		for i := range n {
+			if _, err := checksumDB.Lookup(ctx, module.Version{Path: fmt.Sprintf("example.com/%s%d", prefix, i), Version: "v1.0.0"}); err != nil {
+				t.Fatalf("failed to add record: %v", err)
+			}
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sumdb/sum.example.com/supported", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/sumdb/sum.example.com/", http.StripPrefix("/sumdb/sum.example.com", sumdb.NewServer(checksumDB)))
+	proxy := httptest.NewServer(mux)
+	defer proxy.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	fs := storage.NewFileSystem(t.TempDir())
+	d := New(log, fs)
+	d.SetProxyURL(proxy.URL)
+
+	a := module.Version{Path: "example.com/a", Version: "v1.0.0"}
+	b := module.Version{Path: "example.com/b", Version: "v1.2.0"}
+	c := module.Version{Path: "example.com/c", Version: "v0.1.0"}
+
+	grow(t, "first", 300)
+	if err := d.SaveChecksums(ctx, verifierKey, []module.Version{a, b, {Path: "example.com/private", Version: "v1.0.0"}}); err != nil {
+		t.Fatalf("failed to save checksums: %v", err)
+	}
+	firstTree, err := readAll(ctx, fs, ChecksumDBPath("sum.example.com", "latest"))
+	if err != nil || firstTree == nil {
+		t.Fatalf("expected the tree to be saved, got %v", err)
+	}
+	t.Run("private modules are skipped", func(t *testing.T) {
+		if _, exists, _ := fs.Stat(ctx, ChecksumDBPath("sum.example.com", "lookup/example.com/private@v1.0.0")); exists {
+			t.Error("expected no record for the private module")
+		}
+	})
+
+	grow(t, "second", 500)
+	if err := d.SaveChecksums(ctx, verifierKey, []module.Version{c}); err != nil {
+		t.Fatalf("failed to save checksums: %v", err)
+	}
+
+	// The Go toolchain can only read the files that were saved.
+	lookup := func(t *testing.T, latest []byte, versions ...module.Version) {
+		t.Helper()
+		client := sumdb.NewClient(&storageClientOps{ctx: ctx, storage: fs, verifierKey: verifierKey, latest: latest, cache: map[string][]byte{}})
+		for _, mv := range versions {
+			lines, err := client.Lookup(mv.Path, mv.Version)
+			if err != nil {
+				t.Fatalf("failed to look up %s: %v", mv, err)
+			}
+			if len(lines) != 1 || !strings.HasPrefix(lines[0], mv.Path+" "+mv.Version+" h1:") {
+				t.Errorf("unexpected go.sum lines for %s: %v", mv, lines)
+			}
+		}
+	}
+	t.Run("records can be verified in any order", func(t *testing.T) {
+		lookup(t, nil, c, b, a)
+		lookup(t, nil, a, c)
+	})
+	t.Run("records can be verified by a client that has seen the previous tree", func(t *testing.T) {
+		lookup(t, firstTree, b, c)
+	})
+}
+
+func TestParseChecksumDB(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{name: "sum.golang.org uses the known key", input: "sum.golang.org", expected: DefaultChecksumDB},
+		{name: "off disables the checksum database", input: "off", expected: ""},
+		{name: "verifier keys are returned", input: DefaultChecksumDB, expected: DefaultChecksumDB},
+		{name: "URLs are ignored", input: DefaultChecksumDB + " https://sum.golang.google.cn", expected: DefaultChecksumDB},
+		{name: "unknown names are rejected", input: "sum.example.com", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecksumDB(tt.input)
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// storageClientOps implements sumdb.ClientOps, reading from the storage
+// populated by SaveChecksums, as the Go toolchain does through depot.
+type storageClientOps struct {
+	ctx         context.Context
+	storage     storage.Storage
+	verifierKey string
+	m           sync.Mutex
+	latest      []byte
+	cache       map[string][]byte
+}
+
+func (o *storageClientOps) ReadRemote(path string) ([]byte, error) {
+	r, exists, err := o.storage.Get(o.ctx, ChecksumDBPath("sum.example.com", path))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (o *storageClientOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(o.verifierKey), nil
+	}
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.latest, nil
+}
+
+func (o *storageClientOps) WriteConfig(file string, old, new []byte) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if string(old) != string(o.latest) {
+		return sumdb.ErrWriteConflict
+	}
+	o.latest = new
+	return nil
+}
+
+func (o *storageClientOps) ReadCache(file string) ([]byte, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if data, ok := o.cache[file]; ok {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (o *storageClientOps) WriteCache(file string, data []byte) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.cache[file] = data
+}
+
+func (o *storageClientOps) Log(msg string) {}
+
+func (o *storageClientOps) SecurityError(msg string) {
+	panic(msg)
+}
@@ -0,0 +1,33 @@
+package download
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// ToolchainModulePath is the module path of the Go toolchains downloaded by
+// the go command when GOTOOLCHAIN switching selects a newer toolchain.
+const ToolchainModulePath = "golang.org/toolchain"
+
+// ToolchainModule returns the module version of a Go toolchain, e.g.
+// golang.org/toolchain@v0.0.1-go1.26.1.linux-amd64 for the toolchain
+// "go1.26.1" and the platform "linux/amd64".
+func ToolchainModule(toolchain, platform string) (spec ModuleSpec, err error) {
+	if !strings.HasPrefix(toolchain, "go1") {
+		return spec, fmt.Errorf("invalid toolchain %q: expected a name such as go1.26.1", toolchain)
+	}
+	goos, goarch, ok := strings.Cut(platform, "/")
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+		return spec, fmt.Errorf("invalid platform %q: expected GOOS/GOARCH, such as linux/amd64", platform)
+	}
+	spec = ModuleSpec{
+		Path:    ToolchainModulePath,
+		Version: fmt.Sprintf("v0.0.1-%s.%s-%s", toolchain, goos, goarch),
+	}
+	if err = module.Check(spec.Path, spec.Version); err != nil {
+		return ModuleSpec{}, fmt.Errorf("invalid toolchain %q for platform %q: %w", toolchain, platform, err)
+	}
+	return spec, nil
+}
@@ -0,0 +1,55 @@
+package download
+
+import "testing"
+
+func TestToolchainModule(t *testing.T) {
+	tests := []struct {
+		name            string
+		toolchain       string
+		platform        string
+		expectedVersion string
+		expectError     bool
+	}{
+		{
+			name:            "releases are converted to toolchain module versions",
+			toolchain:       "go1.26.1",
+			platform:        "linux/amd64",
+			expectedVersion: "v0.0.1-go1.26.1.linux-amd64",
+		},
+		{
+			name:            "release candidates are converted to toolchain module versions",
+			toolchain:       "go1.27rc1",
+			platform:        "darwin/arm64",
+			expectedVersion: "v0.0.1-go1.27rc1.darwin-arm64",
+		},
+		{
+			name:        "toolchains without the go prefix are rejected",
+			toolchain:   "1.26.1",
+			platform:    "linux/amd64",
+			expectError: true,
+		},
+		{
+			name:        "platforms without an architecture are rejected",
+			toolchain:   "go1.26.1",
+			platform:    "linux",
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToolchainModule(tt.toolchain, tt.platform)
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if tt.expectError {
+				return
+			}
+			if got.Path != ToolchainModulePath {
+				t.Errorf("got path %q, expected %q", got.Path, ToolchainModulePath)
+			}
+			if got.Version != tt.expectedVersion {
+				t.Errorf("got version %q, expected %q", got.Version, tt.expectedVersion)
+			}
+		})
+	}
+}
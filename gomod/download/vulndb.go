@@ -0,0 +1,147 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/a-h/depot/storage"
+)
+
+// VulnDBPath returns the storage path of a vulnerability database file, using
+// the layout of the vulnerability database, e.g. vuln/index/modules.json or
+// vuln/ID/GO-2024-0001.json. The stored files can also be used directly by
+// govulncheck with a file:// GOVULNDB.
+func VulnDBPath(file string) string {
+	return path.Join("vuln", file)
+}
+
+// vulnDBEntry is an entry of the index/vulns.json file of the vulnerability database.
+type vulnDBEntry struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+}
+
+// SaveVulnDB saves a snapshot of the Go vulnerability database at url, i.e.
+// the index files, and the OSV entry of every vulnerability, so that depot can
+// serve it to govulncheck without access to the vulnerability database.
+//
+// Only entries that were modified since the previous snapshot are downloaded.
+// The index/db.json file is written last, so a snapshot is only complete once
+// it exists.
+func (d *Downloader) SaveVulnDB(ctx context.Context, url string) (err error) {
+	url = strings.TrimSuffix(url, "/")
+	dbMeta, err := d.fetchVulnDB(ctx, url, "index/db.json")
+	if err != nil {
+		return err
+	}
+	previousDBMeta, err := readAll(ctx, d.storage, VulnDBPath("index/db.json"))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(dbMeta, previousDBMeta) {
+		d.log.Info("vulnerability database is up to date", slog.String("url", url))
+		return nil
+	}
+
+	modulesIndex, err := d.fetchVulnDB(ctx, url, "index/modules.json")
+	if err != nil {
+		return err
+	}
+	vulnsIndex, err := d.fetchVulnDB(ctx, url, "index/vulns.json")
+	if err != nil {
+		return err
+	}
+	var entries []vulnDBEntry
+	if err = json.Unmarshal(vulnsIndex, &entries); err != nil {
+		return fmt.Errorf("invalid vulnerability database index: %w", err)
+	}
+	previous, err := d.readVulnDBEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	var updated int
+	for _, entry := range entries {
+		if entry.ID == "" || path.Base(entry.ID) != entry.ID || strings.HasPrefix(entry.ID, ".") {
+			return fmt.Errorf("invalid vulnerability ID %q", entry.ID)
+		}
+		file := "ID/" + entry.ID + ".json"
+		if modified, ok := previous[entry.ID]; ok && modified.Equal(entry.Modified) {
+			_, exists, err := d.storage.Stat(ctx, VulnDBPath(file))
+			if err != nil {
+				return fmt.Errorf("failed to check %s: %w", file, err)
+			}
+			if exists {
+				continue
+			}
+		}
+		data, err := d.fetchVulnDB(ctx, url, file)
+		if err != nil {
+			return err
+		}
+		if err = storage.WriteFile(ctx, d.storage, VulnDBPath(file), data); err != nil {
+			return err
+		}
+		d.log.Debug("saved vulnerability", slog.String("id", entry.ID))
+		updated++
+	}
+
+	for _, f := range []struct {
+		file string
+		data []byte
+	}{
+		{file: "index/modules.json", data: modulesIndex},
+		{file: "index/vulns.json", data: vulnsIndex},
+		{file: "index/db.json", data: dbMeta},
+	} {
+		if err = storage.WriteFile(ctx, d.storage, VulnDBPath(f.file), f.data); err != nil {
+			return err
+		}
+	}
+	d.log.Info("saved vulnerability database", slog.String("url", url), slog.Int("vulns", len(entries)), slog.Int("updated", updated))
+	return nil
+}
+
+// readVulnDBEntries returns the modification time of each entry of the
+// previously saved snapshot.
+func (d *Downloader) readVulnDBEntries(ctx context.Context) (modified map[string]time.Time, err error) {
+	data, err := readAll(ctx, d.storage, VulnDBPath("index/vulns.json"))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var entries []vulnDBEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		d.log.Warn("invalid saved vulnerability database index, downloading all entries", slog.Any("error", err))
+		return nil, nil
+	}
+	modified = make(map[string]time.Time, len(entries))
+	for _, entry := range entries {
+		modified[entry.ID] = entry.Modified
+	}
+	return modified, nil
+}
+
+func (d *Downloader) fetchVulnDB(ctx context.Context, url, file string) (data []byte, err error) {
+	url = url + "/" + file
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = checkStatus(resp, url); err != nil {
+		return nil, fmt.Errorf("failed to get vulnerability database file: %w", err)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,118 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/a-h/depot/storage"
+)
+
+func TestSaveVulnDB(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	files := map[string]string{
+		"/index/db.json":        `{"modified":"2024-01-02T00:00:00Z"}`,
+		"/index/modules.json":   `[{"path":"example.com/a","vulns":[{"id":"GO-2024-0001","modified":"2024-01-01T00:00:00Z"},{"id":"GO-2024-0002","modified":"2024-01-02T00:00:00Z"}]}]`,
+		"/index/vulns.json":     `[{"id":"GO-2024-0001","modified":"2024-01-01T00:00:00Z"},{"id":"GO-2024-0002","modified":"2024-01-02T00:00:00Z"}]`,
+		"/ID/GO-2024-0001.json": `{"id":"GO-2024-0001"}`,
+		"/ID/GO-2024-0002.json": `{"id":"GO-2024-0002"}`,
+		"/ID/GO-2024-0003.json": `{"id":"GO-2024-0003"}`,
+	}
+	requests := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+	update := func(path, content string) {
+		mu.Lock()
+		defer mu.Unlock()
+		files[path] = content
+	}
+	requestCount := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	fs := storage.NewFileSystem(t.TempDir())
+	d := New(log, fs)
+
+	if err := d.SaveVulnDB(ctx, ts.URL+"/"); err != nil {
+		t.Fatalf("failed to save vulnerability database: %v", err)
+	}
+	t.Run("the indexes and entries are saved", func(t *testing.T) {
+		for _, file := range []string{"index/db.json", "index/modules.json", "index/vulns.json", "ID/GO-2024-0001.json", "ID/GO-2024-0002.json"} {
+			data, err := readAll(ctx, fs, VulnDBPath(file))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", file, err)
+			}
+			if string(data) != files["/"+file] {
+				t.Errorf("%s: got %q, expected %q", file, data, files["/"+file])
+			}
+		}
+	})
+	t.Run("entries that aren't in the index aren't saved", func(t *testing.T) {
+		if _, exists, _ := fs.Stat(ctx, VulnDBPath("ID/GO-2024-0003.json")); exists {
+			t.Error("expected GO-2024-0003 not to be saved")
+		}
+	})
+
+	t.Run("an unchanged database isn't downloaded again", func(t *testing.T) {
+		if err := d.SaveVulnDB(ctx, ts.URL); err != nil {
+			t.Fatalf("failed to save vulnerability database: %v", err)
+		}
+		if n := requestCount("/index/vulns.json"); n != 1 {
+			t.Errorf("expected the index to be downloaded once, got %d", n)
+		}
+	})
+
+	t.Run("only modified entries are downloaded", func(t *testing.T) {
+		update("/index/db.json", `{"modified":"2024-01-03T00:00:00Z"}`)
+		update("/index/vulns.json", `[{"id":"GO-2024-0001","modified":"2024-01-01T00:00:00Z"},{"id":"GO-2024-0002","modified":"2024-01-03T00:00:00Z"},{"id":"GO-2024-0003","modified":"2024-01-03T00:00:00Z"}]`)
+		update("/ID/GO-2024-0002.json", `{"id":"GO-2024-0002","summary":"updated"}`)
+		if err := d.SaveVulnDB(ctx, ts.URL); err != nil {
+			t.Fatalf("failed to save vulnerability database: %v", err)
+		}
+		expected := map[string]int{
+			"/ID/GO-2024-0001.json": 1,
+			"/ID/GO-2024-0002.json": 2,
+			"/ID/GO-2024-0003.json": 1,
+		}
+		for path, count := range expected {
+			if n := requestCount(path); n != count {
+				t.Errorf("%s: got %d requests, expected %d", path, n, count)
+			}
+		}
+		data, err := readAll(ctx, fs, VulnDBPath("ID/GO-2024-0002.json"))
+		if err != nil {
+			t.Fatalf("failed to read entry: %v", err)
+		}
+		if string(data) != `{"id":"GO-2024-0002","summary":"updated"}` {
+			t.Errorf("expected the updated entry to be saved, got %q", data)
+		}
+	})
+
+	t.Run("invalid IDs are rejected", func(t *testing.T) {
+		update("/index/db.json", `{"modified":"2024-01-04T00:00:00Z"}`)
+		update("/index/vulns.json", `[{"id":"../../etc/passwd","modified":"2024-01-04T00:00:00Z"}]`)
+		if err := d.SaveVulnDB(ctx, ts.URL); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package gosum
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// ErrMismatch is returned when a module's hash doesn't match the expected hash.
+var ErrMismatch = errors.New("checksum mismatch")
+
+// MismatchError describes a module zip or go.mod file whose hash doesn't match the expected hash.
+type MismatchError struct {
+	// Module is the module version, with a /go.mod suffix on the version for go.mod files.
+	Module   module.Version
+	Expected string
+	Actual   string
+}
+
+func (e MismatchError) Error() string {
+	return fmt.Sprintf("verifying %s: %v\n\tdownloaded: %s\n\texpected:   %s", e.Module, ErrMismatch, e.Actual, e.Expected)
+}
+
+func (e MismatchError) Unwrap() error {
+	return ErrMismatch
+}
+
+// Sums are the hashes in a go.sum file, keyed by module version. The go.mod
+// hash of a module is keyed by the version with a /go.mod suffix, as in go.sum.
+type Sums map[module.Version]string
+
+// Parse parses a go.sum file. Only h1: hashes are used. Other hashes are ignored,
+// as the Go toolchain does.
+func Parse(r io.Reader) (sums Sums, err error) {
+	sums = Sums{}
+	scanner := bufio.NewScanner(r)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("go.sum:%d: expected module, version and hash, got %q", lineNumber, scanner.Text())
+		}
+		if !strings.HasPrefix(fields[2], "h1:") {
+			continue
+		}
+		sums[module.Version{Path: fields[0], Version: fields[1]}] = fields[2]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read go.sum: %w", err)
+	}
+	return sums, nil
+}
+
+// Zip returns the expected hash of the zip of a module version.
+func (s Sums) Zip(modulePath, version string) (hash string, ok bool) {
+	hash, ok = s[module.Version{Path: modulePath, Version: version}]
+	return hash, ok
+}
+
+// GoMod returns the expected hash of the go.mod file of a module version.
+func (s Sums) GoMod(modulePath, version string) (hash string, ok bool) {
+	hash, ok = s[module.Version{Path: modulePath, Version: version + "/go.mod"}]
+	return hash, ok
+}
+
+// HashZip returns the h1: hash of a module zip file.
+func HashZip(zipFile string) (hash string, err error) {
+	hash, err = dirhash.HashZip(zipFile, dirhash.Hash1)
+	if err != nil {
+		return "", fmt.Errorf("invalid module zip: %w", err)
+	}
+	return hash, nil
+}
+
+// HashGoMod returns the h1: hash of a go.mod file.
+func HashGoMod(data []byte) (hash string, err error) {
+	return dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+}
+
+// VerifyZip checks the hash of a module zip file against the expected hash.
+func VerifyZip(mv module.Version, zipFile string, expected string) error {
+	actual, err := HashZip(zipFile)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", mv, err)
+	}
+	if actual != expected {
+		return MismatchError{Module: mv, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// VerifyGoMod checks the hash of a go.mod file against the expected hash.
+func VerifyGoMod(mv module.Version, data []byte, expected string) error {
+	actual, err := HashGoMod(data)
+	if err != nil {
+		return fmt.Errorf("verifying %s/go.mod: %w", mv, err)
+	}
+	if actual != expected {
+		return MismatchError{Module: module.Version{Path: mv.Path, Version: mv.Version + "/go.mod"}, Expected: expected, Actual: actual}
+	}
+	return nil
+}
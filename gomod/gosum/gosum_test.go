@@ -0,0 +1,119 @@
+package gosum
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    Sums
+		expectError bool
+	}{
+		{
+			name: "zip and go.mod hashes are parsed",
+			input: "github.com/foo/bar v1.0.0 h1:zip=\n" +
+				"github.com/foo/bar v1.0.0/go.mod h1:mod=\n",
+			expected: Sums{
+				{Path: "github.com/foo/bar", Version: "v1.0.0"}:        "h1:zip=",
+				{Path: "github.com/foo/bar", Version: "v1.0.0/go.mod"}: "h1:mod=",
+			},
+		},
+		{
+			name:     "blank lines and unknown hashes are ignored",
+			input:    "\ngithub.com/foo/bar v1.0.0 h2:zip=\n\n",
+			expected: Sums{},
+		},
+		{
+			name:        "malformed lines are rejected",
+			input:       "github.com/foo/bar v1.0.0\n",
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("got %d sums, expected %d", len(got), len(tt.expected))
+			}
+			for mv, hash := range tt.expected {
+				if got[mv] != hash {
+					t.Errorf("%s: got %q, expected %q", mv, got[mv], hash)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	mv := module.Version{Path: "example.com/foo", Version: "v1.0.0"}
+
+	t.Run("go.mod hashes match the Go toolchain", func(t *testing.T) {
+		if err := VerifyGoMod(mv, []byte("module example.com/foo\n"), "h1:tJ2YS1a8pyA3nrypRdbsq6Ias2I/0YUVbjNBUoLstcw="); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("changed go.mod files don't match", func(t *testing.T) {
+		err := VerifyGoMod(mv, []byte("module example.com/foo\n\ngo 1.21\n"), "h1:tJ2YS1a8pyA3nrypRdbsq6Ias2I/0YUVbjNBUoLstcw=")
+		var mismatch MismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected a mismatch error, got %v", err)
+		}
+		if mismatch.Module.Version != "v1.0.0/go.mod" {
+			t.Errorf("got module %s, expected the go.mod version", mismatch.Module)
+		}
+	})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("example.com/foo@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatalf("failed to create zip file: %v", err)
+	}
+	f.Write([]byte("module example.com/foo\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	zipFile := filepath.Join(t.TempDir(), "v1.0.0.zip")
+	if err := os.WriteFile(zipFile, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	zipHash, err := HashZip(zipFile)
+	if err != nil {
+		t.Fatalf("failed to hash zip: %v", err)
+	}
+
+	t.Run("zip hashes match", func(t *testing.T) {
+		if err := VerifyZip(mv, zipFile, zipHash); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("zip hash mismatches are reported", func(t *testing.T) {
+		err := VerifyZip(mv, zipFile, "h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+		if !errors.Is(err, ErrMismatch) {
+			t.Errorf("expected a mismatch error, got %v", err)
+		}
+	})
+	t.Run("invalid zips are rejected", func(t *testing.T) {
+		invalidFile := filepath.Join(t.TempDir(), "invalid.zip")
+		if err := os.WriteFile(invalidFile, []byte("not a zip"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		err := VerifyZip(mv, invalidFile, zipHash)
+		if err == nil || errors.Is(err, ErrMismatch) {
+			t.Errorf("expected an invalid zip error, got %v", err)
+		}
+	})
+}
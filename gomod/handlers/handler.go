@@ -1,25 +1,43 @@
 package handlers
 
 import (
+	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/a-h/depot/gomod/db"
+	"github.com/a-h/depot/gomod/download"
+	"github.com/a-h/depot/gomod/gosum"
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/storage"
+	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
 )
 
 // New creates an HTTP handler implementing the Go module proxy protocol.
-func New(log *slog.Logger, db *db.DB, storage storage.Storage, metrics metrics.Metrics) http.Handler {
+// If upstream is non-nil, requests for modules that are not stored locally
+// are fetched from the upstream proxy, stored, and served.
+//
+// Pushed zips are validated against the module zip rules of the go command, and
+// must contain the go.mod file pushed for the module version.
+// The h1: hash of each pushed zip is recorded. If rejectChangedZips is true,
+// pushing a zip whose hash differs from the recorded hash is rejected.
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *download.Downloader, rejectChangedZips bool, metrics metrics.Metrics) http.Handler {
 	return &router{
-		metadata: &metadataHandler{log: log, db: db, storage: storage, metrics: metrics},
-		archive:  &archiveHandler{log: log, storage: storage, metrics: metrics},
+		metadata:   &metadataHandler{log: log, db: db, storage: storage, upstream: upstream, metrics: metrics},
+		archive:    &archiveHandler{log: log, db: db, storage: storage, upstream: upstream, rejectChangedZips: rejectChangedZips, metrics: metrics},
+		checksumDB: &checksumDBHandler{log: log, storage: storage, upstream: upstream, metrics: metrics},
+		vulnDB:     &vulnDBHandler{log: log, storage: storage, metrics: metrics},
 	}
 }
 
@@ -77,11 +95,22 @@ func storageKey(modulePath, resource string) (string, error) {
 
 // router dispatches requests to the appropriate resource handler.
 type router struct {
-	metadata *metadataHandler
-	archive  *archiveHandler
+	metadata   *metadataHandler
+	archive    *archiveHandler
+	checksumDB *checksumDBHandler
+	vulnDB     *vulnDBHandler
 }
 
 func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if name, file, ok := parseChecksumDBPath(r.URL.Path); ok {
+		rt.checksumDB.serveHTTP(w, r, name, file)
+		return
+	}
+	if file, gzipped, ok := parseVulnDBPath(r.URL.Path); ok {
+		rt.vulnDB.serveHTTP(w, r, file, gzipped)
+		return
+	}
+
 	info, err := parsePath(r.URL.Path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -100,10 +129,11 @@ func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // These resources are backed by the database and optionally stored to
 // the storage backend on PUT.
 type metadataHandler struct {
-	log     *slog.Logger
-	db      *db.DB
-	storage storage.Storage
-	metrics metrics.Metrics
+	log      *slog.Logger
+	db       *db.DB
+	storage  storage.Storage
+	upstream *download.Downloader
+	metrics  metrics.Metrics
 }
 
 func (h *metadataHandler) serveHTTP(w http.ResponseWriter, r *http.Request, info pathInfo) {
@@ -141,6 +171,14 @@ func (h *metadataHandler) getLatest(w http.ResponseWriter, r *http.Request, modu
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if !ok && h.upstream != nil {
+		mv, ok, err = h.getLatestFromUpstream(r.Context(), modulePath)
+		if err != nil {
+			h.log.Error("failed to get latest version from upstream", slog.String("module", modulePath), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
 	if !ok {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
@@ -156,6 +194,14 @@ func (h *metadataHandler) getList(w http.ResponseWriter, r *http.Request, module
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if h.upstream != nil {
+		// Serve the local versions if the upstream is unavailable.
+		upstreamVersions, err := h.upstream.List(r.Context(), modulePath)
+		if err != nil && !errors.Is(err, download.ErrNotFound) {
+			h.log.Warn("failed to list versions from upstream", slog.String("module", modulePath), slog.Any("error", err))
+		}
+		versions = mergeVersions(versions, upstreamVersions)
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	for _, v := range versions {
 		fmt.Fprintln(w, v)
@@ -169,7 +215,7 @@ func (h *metadataHandler) getVersionInfo(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	mv, ok, err := h.db.GetModuleVersion(r.Context(), modulePath, unescaped)
+	mv, ok, err := h.getModuleVersion(r.Context(), modulePath, unescaped)
 	if err != nil {
 		h.log.Error("failed to get module version", slog.String("module", modulePath), slog.String("version", unescaped), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -190,7 +236,7 @@ func (h *metadataHandler) getGoMod(w http.ResponseWriter, r *http.Request, modul
 		return
 	}
 
-	mv, ok, err := h.db.GetModuleVersion(r.Context(), modulePath, unescaped)
+	mv, ok, err := h.getModuleVersion(r.Context(), modulePath, unescaped)
 	if err != nil {
 		h.log.Error("failed to get module version", slog.String("module", modulePath), slog.String("version", unescaped), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -204,6 +250,57 @@ func (h *metadataHandler) getGoMod(w http.ResponseWriter, r *http.Request, modul
 	io.WriteString(w, mv.GoMod)
 }
 
+// getModuleVersion gets a module version from the database, falling back to
+// the upstream proxy if one is configured.
+func (h *metadataHandler) getModuleVersion(ctx context.Context, modulePath, version string) (mv db.ModuleVersion, ok bool, err error) {
+	mv, ok, err = h.db.GetModuleVersion(ctx, modulePath, version)
+	if err != nil || ok || h.upstream == nil {
+		return mv, ok, err
+	}
+	return h.getFromUpstream(ctx, modulePath, version)
+}
+
+// getFromUpstream downloads the .info and .mod for a module version from the
+// upstream proxy, and stores them in the database.
+func (h *metadataHandler) getFromUpstream(ctx context.Context, modulePath, version string) (mv db.ModuleVersion, ok bool, err error) {
+	info, goMod, err := h.upstream.DownloadMetadata(ctx, modulePath, version)
+	if errors.Is(err, download.ErrNotFound) {
+		return mv, false, nil
+	}
+	if err != nil {
+		return mv, false, err
+	}
+	if err = json.Unmarshal(info, &mv.Info); err != nil {
+		return mv, false, fmt.Errorf("invalid .info from upstream: %w", err)
+	}
+	mv.GoMod = string(goMod)
+	if err = h.db.PutModuleVersion(ctx, modulePath, mv.Info.Version, mv); err != nil {
+		return mv, false, err
+	}
+	h.log.Debug("fetched module version from upstream", slog.String("module", modulePath), slog.String("version", mv.Info.Version))
+	return mv, true, nil
+}
+
+func (h *metadataHandler) getLatestFromUpstream(ctx context.Context, modulePath string) (mv db.ModuleVersion, ok bool, err error) {
+	version, err := h.upstream.ResolveLatest(ctx, modulePath)
+	if errors.Is(err, download.ErrNotFound) {
+		return mv, false, nil
+	}
+	if err != nil {
+		return mv, false, err
+	}
+	return h.getFromUpstream(ctx, modulePath, version)
+}
+
+// mergeVersions returns the union of the local and upstream versions in semver
+// order, excluding pseudo-versions.
+func mergeVersions(local, upstream []string) (versions []string) {
+	versions = append(slices.Clone(local), upstream...)
+	versions = slices.DeleteFunc(versions, module.IsPseudoVersion)
+	semver.Sort(versions)
+	return slices.Compact(versions)
+}
+
 func (h *metadataHandler) put(w http.ResponseWriter, r *http.Request, info pathInfo) {
 	switch {
 	case strings.HasSuffix(info.resource, ".info"):
@@ -238,7 +335,14 @@ func (h *metadataHandler) putVersionInfo(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	mv := db.ModuleVersion{Info: vi}
+	// Get existing record to preserve the go.mod and zip hash, or create new.
+	mv, _, err := h.db.GetModuleVersion(r.Context(), modulePath, unescaped)
+	if err != nil {
+		h.log.Error("failed to get existing module version", slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	mv.Info = vi
 	if err := h.db.PutModuleVersion(r.Context(), modulePath, unescaped, mv); err != nil {
 		h.log.Error("failed to put module version", slog.String("module", modulePath), slog.String("version", unescaped), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -296,11 +400,16 @@ func (h *metadataHandler) putGoMod(w http.ResponseWriter, r *http.Request, modul
 }
 
 // archiveHandler handles .zip requests.
-// These are stored directly in the storage backend without database records.
+// These are stored directly in the storage backend. Pushed zips are validated
+// before they're stored, and their hash is recorded in the database record of
+// the module version.
 type archiveHandler struct {
-	log     *slog.Logger
-	storage storage.Storage
-	metrics metrics.Metrics
+	log               *slog.Logger
+	db                *db.DB
+	storage           storage.Storage
+	upstream          *download.Downloader
+	rejectChangedZips bool
+	metrics           metrics.Metrics
 }
 
 func (h *archiveHandler) serveHTTP(w http.ResponseWriter, r *http.Request, info pathInfo) {
@@ -327,6 +436,14 @@ func (h *archiveHandler) get(w http.ResponseWriter, r *http.Request, info pathIn
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if !exists && h.upstream != nil {
+		file, exists, err = h.getFromUpstream(r.Context(), info, key)
+		if err != nil {
+			h.log.Error("failed to get zip from upstream", slog.String("key", key), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
 	if !exists {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
@@ -342,6 +459,24 @@ func (h *archiveHandler) get(w http.ResponseWriter, r *http.Request, info pathIn
 	h.metrics.IncrementDownloadMetrics(r.Context(), "go", bytesDownloaded)
 }
 
+// getFromUpstream downloads a module zip from the upstream proxy into storage,
+// and returns a reader for it.
+func (h *archiveHandler) getFromUpstream(ctx context.Context, info pathInfo, key string) (file io.ReadCloser, exists bool, err error) {
+	version, err := module.UnescapeVersion(strings.TrimSuffix(info.resource, ".zip"))
+	if err != nil {
+		return nil, false, err
+	}
+	err = h.upstream.DownloadZip(ctx, info.modulePath, version)
+	if errors.Is(err, download.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	h.log.Debug("fetched module zip from upstream", slog.String("module", info.modulePath), slog.String("version", version))
+	return h.storage.Get(ctx, key)
+}
+
 func (h *archiveHandler) put(w http.ResponseWriter, r *http.Request, info pathInfo) {
 	defer r.Body.Close()
 
@@ -350,48 +485,136 @@ func (h *archiveHandler) put(w http.ResponseWriter, r *http.Request, info pathIn
 		http.Error(w, fmt.Sprintf("invalid module path: %v", err), http.StatusBadRequest)
 		return
 	}
+	version, err := module.UnescapeVersion(strings.TrimSuffix(info.resource, ".zip"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid version: %v", err), http.StatusBadRequest)
+		return
+	}
 
-	f, err := h.storage.Put(r.Context(), key)
+	// The zip is written to a temporary file, because it must be validated before it replaces the stored zip.
+	tmp, err := os.CreateTemp("", "depot-go-*.zip")
 	if err != nil {
-		h.log.Error("failed to create storage file", slog.String("key", key), slog.Any("error", err))
+		h.log.Error("failed to create temporary file", slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, io.LimitReader(r.Body, modzip.MaxZipFile+1))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if size > modzip.MaxZipFile {
+		http.Error(w, "module zip too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	bytesWritten, err := io.Copy(f, r.Body)
+	// Zips are pushed after the .info and .mod files that create the database record.
+	mv, exists, err := h.db.GetModuleVersion(r.Context(), info.modulePath, version)
 	if err != nil {
-		f.Close()
-		h.log.Error("failed to write zip to storage", slog.String("key", key), slog.Any("error", err))
+		h.log.Error("failed to get existing module version", slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if !exists || mv.GoMod == "" {
+		http.Error(w, fmt.Sprintf("the .mod file of %s@%s must be pushed before its zip", info.modulePath, version), http.StatusBadRequest)
+		return
+	}
+	hash, err := validateZip(module.Version{Path: info.modulePath, Version: version}, tmp, size, mv.GoMod)
+	if err != nil {
+		h.log.Warn("rejected invalid module zip", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if mv.ZipHash != "" && hash != mv.ZipHash {
+		if h.rejectChangedZips {
+			h.log.Warn("rejected changed module zip", slog.String("key", key), slog.String("recorded", mv.ZipHash), slog.String("pushed", hash))
+			http.Error(w, fmt.Sprintf("verifying %s@%s: %v: recorded %s, pushed %s", info.modulePath, version, gosum.ErrMismatch, mv.ZipHash, hash), http.StatusConflict)
+			return
+		}
+		h.log.Warn("module zip changed", slog.String("key", key), slog.String("recorded", mv.ZipHash), slog.String("pushed", hash))
+	}
 
-	if err := f.Close(); err != nil {
-		h.log.Error("failed to complete upload to storage", slog.String("key", key), slog.Any("error", err))
+	if err := copyToStorage(r.Context(), h.storage, key, io.NewSectionReader(tmp, 0, size)); err != nil {
+		h.log.Error("failed to write zip to storage", slog.String("key", key), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	h.metrics.IncrementUploadMetrics(r.Context(), "go", bytesWritten)
+	if hash != mv.ZipHash {
+		mv.ZipHash = hash
+		if err := h.db.PutModuleVersion(r.Context(), info.modulePath, version, mv); err != nil {
+			h.log.Error("failed to put module version", slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.metrics.IncrementUploadMetrics(r.Context(), "go", size)
 	w.WriteHeader(http.StatusOK)
 }
 
+// validateZip checks that a pushed module zip follows the module zip rules of
+// the go command, and that its go.mod file matches the pushed .mod file, and
+// returns its h1: hash. Modules without a go.mod file are served with a
+// synthesized .mod file, as in the go command.
+func validateZip(mv module.Version, f *os.File, size int64, goMod string) (hash string, err error) {
+	if _, err = modzip.CheckZip(mv, f.Name()); err != nil {
+		return "", fmt.Errorf("invalid module zip for %s: %w", mv, err)
+	}
+	z, err := zip.NewReader(f, size)
+	if err != nil {
+		return "", fmt.Errorf("invalid module zip for %s: %w", mv, err)
+	}
+	zipGoMod, found, err := readZipFile(z, mv.String()+"/go.mod")
+	if err != nil {
+		return "", fmt.Errorf("invalid module zip for %s: %w", mv, err)
+	}
+	if !found {
+		zipGoMod = []byte("module " + modfile.AutoQuote(mv.Path) + "\n")
+	}
+	if string(zipGoMod) != goMod {
+		if !found {
+			return "", fmt.Errorf("invalid module zip for %s: missing go.mod file", mv)
+		}
+		return "", fmt.Errorf("invalid module zip for %s: go.mod file does not match the pushed .mod file", mv)
+	}
+	return gosum.HashZip(f.Name())
+}
+
+func readZipFile(z *zip.Reader, name string) (data []byte, found bool, err error) {
+	for _, zf := range z.File {
+		if zf.Name != name {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return nil, true, err
+		}
+		defer r.Close()
+		data, err = io.ReadAll(r)
+		return data, true, err
+	}
+	return nil, false, nil
+}
+
 func writeToStorage(ctx context.Context, s storage.Storage, modulePath, resource string, data []byte) (err error) {
 	key, err := storageKey(modulePath, resource)
 	if err != nil {
 		return err
 	}
+	return storage.WriteFile(ctx, s, key, data)
+}
 
+func copyToStorage(ctx context.Context, s storage.Storage, key string, r io.Reader) (err error) {
 	f, err := s.Put(ctx, key)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if cerr := f.Close(); cerr != nil && err == nil {
-			err = cerr
-		}
-	}()
-
-	_, err = f.Write(data)
-	return err
+	if _, err = io.Copy(f, r); err != nil {
+		storage.Abort(f)
+		return err
+	}
+	return f.Close()
 }
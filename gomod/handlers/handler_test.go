@@ -1,23 +1,41 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/a-h/depot/gomod/db"
+	"github.com/a-h/depot/gomod/download"
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/storage"
 	"github.com/a-h/depot/store"
+	modzip "golang.org/x/mod/zip"
 )
 
 func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	return newTestHandlerWithUpstream(t, "")
+}
+
+func newTestHandlerWithUpstream(t *testing.T, upstreamURL string) http.Handler {
+	t.Helper()
+	return newTestHandlerWithOptions(t, upstreamURL, false)
+}
+
+func newTestHandlerWithOptions(t *testing.T, upstreamURL string, rejectChangedZips bool) http.Handler {
 	t.Helper()
 	s, closer, err := store.New(context.Background(), "sqlite", "file::memory:?cache=shared")
 	if err != nil {
@@ -32,7 +50,13 @@ func newTestHandler(t *testing.T) http.Handler {
 		t.Fatalf("failed to create metrics: %v", err)
 	}
 	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
-	return New(log, db.New(s), storage.NewFileSystem(t.TempDir()), m)
+	fs := storage.NewFileSystem(t.TempDir())
+	var upstream *download.Downloader
+	if upstreamURL != "" {
+		upstream = download.New(log, fs)
+		upstream.SetProxyURL(upstreamURL)
+	}
+	return New(log, db.New(s), fs, upstream, rejectChangedZips, m)
 }
 
 func TestParsePath(t *testing.T) {
@@ -146,6 +170,24 @@ func TestGetReturnsNotFoundForMissingModule(t *testing.T) {
 	}
 }
 
+// moduleZip creates a zip containing files, keyed by their path in the zip.
+func moduleZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip file: %v", err)
+		}
+		io.WriteString(f, files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
 func TestPutThenGetRoundTrip(t *testing.T) {
 	h := newTestHandler(t)
 
@@ -168,9 +210,12 @@ func TestPutThenGetRoundTrip(t *testing.T) {
 	}
 
 	// PUT .zip.
-	zipBody := "fake-zip-content"
+	zipBody := moduleZip(t, map[string]string{
+		"github.com/foo/bar@v1.0.0/go.mod":  modBody,
+		"github.com/foo/bar@v1.0.0/main.go": "package bar\n",
+	})
 	rr = httptest.NewRecorder()
-	req = httptest.NewRequest(http.MethodPut, "/github.com/foo/bar/@v/v1.0.0.zip", bytes.NewBufferString(zipBody))
+	req = httptest.NewRequest(http.MethodPut, "/github.com/foo/bar/@v/v1.0.0.zip", bytes.NewReader(zipBody))
 	h.ServeHTTP(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Fatalf("PUT .zip got status %d: %s", rr.Code, rr.Body.String())
@@ -209,8 +254,8 @@ func TestPutThenGetRoundTrip(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Fatalf("GET .zip got status %d", rr.Code)
 	}
-	if rr.Body.String() != zipBody {
-		t.Errorf("got zip %q, expected %q", rr.Body.String(), zipBody)
+	if !bytes.Equal(rr.Body.Bytes(), zipBody) {
+		t.Error("unexpected zip content")
 	}
 
 	// GET list.
@@ -240,3 +285,497 @@ func TestPutThenGetRoundTrip(t *testing.T) {
 		t.Errorf("got latest version %q, expected %q", latestInfo.Version, "v1.0.0")
 	}
 }
+
+func putBody(t *testing.T, h http.Handler, p string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, p, bytes.NewReader(body)))
+	return rr
+}
+
+func TestPutChangedZip(t *testing.T) {
+	tests := []struct {
+		name              string
+		modulePath        string
+		rejectChangedZips bool
+		expectedStatus    int
+		expectChanged     bool
+	}{
+		{name: "changed zips are accepted by default", modulePath: "github.com/foo/changed", expectedStatus: http.StatusOK, expectChanged: true},
+		{name: "changed zips are rejected if configured", modulePath: "github.com/foo/rejected", rejectChangedZips: true, expectedStatus: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goMod := "module " + tt.modulePath + "\n"
+			original := moduleZip(t, map[string]string{
+				tt.modulePath + "@v1.0.0/go.mod":  goMod,
+				tt.modulePath + "@v1.0.0/main.go": "package changed\n",
+			})
+			changed := moduleZip(t, map[string]string{
+				tt.modulePath + "@v1.0.0/go.mod":  goMod,
+				tt.modulePath + "@v1.0.0/main.go": "package changed // changed\n",
+			})
+			expectedZip := original
+			if tt.expectChanged {
+				expectedZip = changed
+			}
+
+			h := newTestHandlerWithOptions(t, "", tt.rejectChangedZips)
+			prefix := "/" + tt.modulePath + "/@v/v1.0.0"
+			if rr := putBody(t, h, prefix+".info", []byte(`{"Version":"v1.0.0","Time":"2024-01-01T00:00:00Z"}`)); rr.Code != http.StatusOK {
+				t.Fatalf("PUT .info got status %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr := putBody(t, h, prefix+".mod", []byte(goMod)); rr.Code != http.StatusOK {
+				t.Fatalf("PUT .mod got status %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr := putBody(t, h, prefix+".zip", original); rr.Code != http.StatusOK {
+				t.Fatalf("PUT .zip got status %d: %s", rr.Code, rr.Body.String())
+			}
+			// Pushing the same zip again is always allowed.
+			if rr := putBody(t, h, prefix+".zip", original); rr.Code != http.StatusOK {
+				t.Fatalf("PUT .zip got status %d: %s", rr.Code, rr.Body.String())
+			}
+			// Pushing the .info again must not lose the recorded hash.
+			if rr := putBody(t, h, prefix+".info", []byte(`{"Version":"v1.0.0","Time":"2024-01-01T00:00:00Z"}`)); rr.Code != http.StatusOK {
+				t.Fatalf("PUT .info got status %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr := putBody(t, h, prefix+".zip", changed); rr.Code != tt.expectedStatus {
+				t.Fatalf("PUT changed .zip got status %d, expected %d: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, prefix+".zip", nil))
+			if !bytes.Equal(rr.Body.Bytes(), expectedZip) {
+				t.Error("unexpected zip content")
+			}
+		})
+	}
+}
+
+func TestPutInvalidZip(t *testing.T) {
+	tests := []struct {
+		name           string
+		modulePath     string
+		goMod          string
+		zip            []byte
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "zips without a go.mod file are accepted if the .mod file is synthesized",
+			modulePath:     "github.com/invalid/nogomod",
+			goMod:          "module github.com/invalid/nogomod\n",
+			zip:            moduleZip(t, map[string]string{"github.com/invalid/nogomod@v1.0.0/main.go": "package nogomod\n"}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "zips must be zip files",
+			modulePath:     "github.com/invalid/notzip",
+			goMod:          "module github.com/invalid/notzip\n",
+			zip:            []byte("not-a-zip"),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "not a valid zip file",
+		},
+		{
+			name:       "files must have the module version prefix",
+			modulePath: "github.com/invalid/prefix",
+			goMod:      "module github.com/invalid/prefix\n",
+			zip: moduleZip(t, map[string]string{
+				"github.com/invalid/prefix@v1.0.0/go.mod": "module github.com/invalid/prefix\n",
+				"github.com/invalid/other@v1.0.0/main.go": "package prefix\n",
+			}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `path does not have prefix "github.com/invalid/prefix@v1.0.0/"`,
+		},
+		{
+			name:       "file names must not collide when case is ignored",
+			modulePath: "github.com/invalid/case",
+			goMod:      "module github.com/invalid/case\n",
+			zip: moduleZip(t, map[string]string{
+				"github.com/invalid/case@v1.0.0/go.mod":  "module github.com/invalid/case\n",
+				"github.com/invalid/case@v1.0.0/main.go": "package main\n",
+				"github.com/invalid/case@v1.0.0/MAIN.go": "package main\n",
+			}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "case-insensitive file name collision",
+		},
+		{
+			name:       "files must not exceed size limits",
+			modulePath: "github.com/invalid/license",
+			goMod:      "module github.com/invalid/license\n",
+			zip: moduleZip(t, map[string]string{
+				"github.com/invalid/license@v1.0.0/go.mod":  "module github.com/invalid/license\n",
+				"github.com/invalid/license@v1.0.0/LICENSE": strings.Repeat("x", modzip.MaxLICENSE+1),
+			}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "LICENSE file too large",
+		},
+		{
+			name:           "zips must contain a go.mod file",
+			modulePath:     "github.com/invalid/missing",
+			goMod:          "module github.com/invalid/missing\n\ngo 1.21\n",
+			zip:            moduleZip(t, map[string]string{"github.com/invalid/missing@v1.0.0/main.go": "package missing\n"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "missing go.mod file",
+		},
+		{
+			name:           "the go.mod file must match the .mod file",
+			modulePath:     "github.com/invalid/mismatch",
+			goMod:          "module github.com/invalid/mismatch\n",
+			zip:            moduleZip(t, map[string]string{"github.com/invalid/mismatch@v1.0.0/go.mod": "module github.com/invalid/other\n"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "go.mod file does not match the pushed .mod file",
+		},
+		{
+			name:           "the .mod file must be pushed first",
+			modulePath:     "github.com/invalid/nomod",
+			zip:            moduleZip(t, map[string]string{"github.com/invalid/nomod@v1.0.0/go.mod": "module github.com/invalid/nomod\n"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "must be pushed before its zip",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			prefix := "/" + tt.modulePath + "/@v/v1.0.0"
+			if tt.goMod != "" {
+				if rr := putBody(t, h, prefix+".mod", []byte(tt.goMod)); rr.Code != http.StatusOK {
+					t.Fatalf("PUT .mod got status %d: %s", rr.Code, rr.Body.String())
+				}
+			}
+			rr := putBody(t, h, prefix+".zip", tt.zip)
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("PUT .zip got status %d, expected %d: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedError) {
+				t.Errorf("expected error containing %q, got %q", tt.expectedError, rr.Body.String())
+			}
+
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, prefix+".zip", nil))
+			if tt.expectedStatus != http.StatusOK && rr.Code != http.StatusNotFound {
+				t.Errorf("expected invalid zip not to be stored, GET .zip got status %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestUpstreamPullThrough(t *testing.T) {
+	infoBody := `{"Version":"v1.2.0","Time":"2024-01-01T00:00:00Z"}`
+	modBody := "module github.com/upstream/mod\n\ngo 1.21\n"
+	zipBody := "fake-zip-content"
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	requestCount := func(p string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[p]
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/github.com/upstream/mod/@v/list":
+			io.WriteString(w, "v1.1.0\nv1.2.0\n")
+		case "/github.com/upstream/mod/@latest", "/github.com/upstream/mod/@v/v1.2.0.info":
+			io.WriteString(w, infoBody)
+		case "/github.com/upstream/mod/@v/v1.2.0.mod":
+			io.WriteString(w, modBody)
+		case "/github.com/upstream/mod/@v/v1.2.0.zip":
+			io.WriteString(w, zipBody)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	h := newTestHandlerWithUpstream(t, ts.URL)
+
+	get := func(t *testing.T, p string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, p, nil))
+		return rr
+	}
+
+	t.Run("missing @latest is resolved from upstream", func(t *testing.T) {
+		rr := get(t, "/github.com/upstream/mod/@latest")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		var latestInfo db.VersionInfo
+		if err := json.Unmarshal(rr.Body.Bytes(), &latestInfo); err != nil {
+			t.Fatalf("failed to decode @latest response: %v", err)
+		}
+		if latestInfo.Version != "v1.2.0" {
+			t.Errorf("got latest version %q, expected %q", latestInfo.Version, "v1.2.0")
+		}
+	})
+	t.Run("missing .info is fetched from upstream", func(t *testing.T) {
+		rr := get(t, "/github.com/upstream/mod/@v/v1.2.0.info")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		var gotInfo db.VersionInfo
+		if err := json.Unmarshal(rr.Body.Bytes(), &gotInfo); err != nil {
+			t.Fatalf("failed to decode .info response: %v", err)
+		}
+		if gotInfo.Version != "v1.2.0" {
+			t.Errorf("got version %q, expected %q", gotInfo.Version, "v1.2.0")
+		}
+	})
+	t.Run("fetched .mod is served from the database", func(t *testing.T) {
+		rr := get(t, "/github.com/upstream/mod/@v/v1.2.0.mod")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Body.String() != modBody {
+			t.Errorf("got mod %q, expected %q", rr.Body.String(), modBody)
+		}
+		if n := requestCount("/github.com/upstream/mod/@v/v1.2.0.mod"); n != 1 {
+			t.Errorf("expected 1 upstream request for .mod, got %d", n)
+		}
+	})
+	t.Run("missing .zip is fetched from upstream", func(t *testing.T) {
+		for range 2 {
+			rr := get(t, "/github.com/upstream/mod/@v/v1.2.0.zip")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+			}
+			if rr.Body.String() != zipBody {
+				t.Errorf("got zip %q, expected %q", rr.Body.String(), zipBody)
+			}
+		}
+		if n := requestCount("/github.com/upstream/mod/@v/v1.2.0.zip"); n != 1 {
+			t.Errorf("expected 1 upstream request for .zip, got %d", n)
+		}
+	})
+	t.Run("list merges local and upstream versions", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/github.com/upstream/mod/@v/v1.3.0.info", bytes.NewBufferString(`{"Version":"v1.3.0","Time":"2024-02-01T00:00:00Z"}`))
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("PUT .info got status %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = get(t, "/github.com/upstream/mod/@v/list")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+		}
+		expected := "v1.1.0\nv1.2.0\nv1.3.0\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got list %q, expected %q", rr.Body.String(), expected)
+		}
+	})
+	t.Run("module missing upstream returns 404", func(t *testing.T) {
+		for _, p := range []string{
+			"/github.com/upstream/missing/@v/v1.0.0.info",
+			"/github.com/upstream/missing/@v/v1.0.0.mod",
+			"/github.com/upstream/missing/@v/v1.0.0.zip",
+			"/github.com/upstream/missing/@latest",
+		} {
+			if rr := get(t, p); rr.Code != http.StatusNotFound {
+				t.Errorf("got status %d, expected %d for %s", rr.Code, http.StatusNotFound, p)
+			}
+		}
+	})
+}
+
+func TestParseChecksumDBPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		expectedName string
+		expectedFile string
+		expectedOK   bool
+	}{
+		{
+			name:         "lookups are parsed",
+			path:         "/sumdb/sum.golang.org/lookup/github.com/foo/bar@v1.0.0",
+			expectedName: "sum.golang.org",
+			expectedFile: "lookup/github.com/foo/bar@v1.0.0",
+			expectedOK:   true,
+		},
+		{
+			name:         "partial tiles are parsed",
+			path:         "/sumdb/sum.golang.org/tile/8/1/x001/234.p/12",
+			expectedName: "sum.golang.org",
+			expectedFile: "tile/8/1/x001/234.p/12",
+			expectedOK:   true,
+		},
+		{
+			name:         "supported is parsed",
+			path:         "/sumdb/sum.golang.org/supported",
+			expectedName: "sum.golang.org",
+			expectedFile: "supported",
+			expectedOK:   true,
+		},
+		{
+			name: "module paths are not checksum database paths",
+			path: "/github.com/foo/bar/@v/list",
+		},
+		{
+			name: "unknown files are rejected",
+			path: "/sumdb/sum.golang.org/unknown",
+		},
+		{
+			name: "paths outside the checksum database are rejected",
+			path: "/sumdb/sum.golang.org/tile/../../../etc/passwd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, file, ok := parseChecksumDBPath(tt.path)
+			if ok != tt.expectedOK || name != tt.expectedName || file != tt.expectedFile {
+				t.Errorf("got (%q, %q, %v), expected (%q, %q, %v)", name, file, ok, tt.expectedName, tt.expectedFile, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestChecksumDB(t *testing.T) {
+	h := newTestHandler(t)
+
+	do := func(method, p, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, p, bytes.NewBufferString(body)))
+		return rr
+	}
+
+	if rr := do(http.MethodGet, "/sumdb/sum.golang.org/supported", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the checksum database to be unsupported before records are pushed, got status %d", rr.Code)
+	}
+
+	record := "123\ngithub.com/foo/bar v1.0.0 h1:hash=\ngithub.com/foo/bar v1.0.0/go.mod h1:gomodhash=\n\ngo.sum database tree\n"
+	files := map[string]string{
+		"lookup/github.com/foo/bar@v1.0.0": record,
+		"tile/8/0/000":                     "tile-data",
+		"latest":                           "go.sum database tree\n",
+	}
+	for file, body := range files {
+		if rr := do(http.MethodPut, "/sumdb/sum.golang.org/"+file, body); rr.Code != http.StatusOK {
+			t.Fatalf("PUT %s got status %d: %s", file, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := do(http.MethodGet, "/sumdb/sum.golang.org/supported", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the checksum database to be supported, got status %d", rr.Code)
+	}
+	for file, body := range files {
+		rr := do(http.MethodGet, "/sumdb/sum.golang.org/"+file, "")
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %s got status %d", file, rr.Code)
+			continue
+		}
+		if rr.Body.String() != body {
+			t.Errorf("GET %s got %q, expected %q", file, rr.Body.String(), body)
+		}
+	}
+	if rr := do(http.MethodGet, "/sumdb/sum.golang.org/lookup/github.com/foo/baz@v1.0.0", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing lookups to return not found, got status %d", rr.Code)
+	}
+}
+
+func TestParseVulnDBPath(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		expectedFile    string
+		expectedGzipped bool
+		expectedOK      bool
+	}{
+		{
+			name:            "gzipped indexes are parsed",
+			path:            "/vuln/index/modules.json.gz",
+			expectedFile:    "index/modules.json",
+			expectedGzipped: true,
+			expectedOK:      true,
+		},
+		{
+			name:         "entries are parsed",
+			path:         "/vuln/ID/GO-2024-0001.json",
+			expectedFile: "ID/GO-2024-0001.json",
+			expectedOK:   true,
+		},
+		{
+			name: "module paths are not vulnerability database paths",
+			path: "/github.com/foo/bar/@v/list",
+		},
+		{
+			name: "unknown directories are rejected",
+			path: "/vuln/unknown/db.json",
+		},
+		{
+			name: "paths outside the vulnerability database are rejected",
+			path: "/vuln/ID/../../etc/passwd.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, gzipped, ok := parseVulnDBPath(tt.path)
+			if ok != tt.expectedOK || file != tt.expectedFile || gzipped != tt.expectedGzipped {
+				t.Errorf("got (%q, %v, %v), expected (%q, %v, %v)", file, gzipped, ok, tt.expectedFile, tt.expectedGzipped, tt.expectedOK)
+			}
+		})
+	}
+}
+
+func TestVulnDB(t *testing.T) {
+	h := newTestHandler(t)
+
+	do := func(method, p, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, p, bytes.NewBufferString(body)))
+		return rr
+	}
+
+	if rr := do(http.MethodGet, "/vuln/index/db.json.gz", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected not found before the database is pushed, got status %d", rr.Code)
+	}
+
+	files := map[string]string{
+		"ID/GO-2024-0001.json": `{"id":"GO-2024-0001"}`,
+		"index/modules.json":   `[{"path":"example.com/a","vulns":[{"id":"GO-2024-0001"}]}]`,
+		"index/db.json":        `{"modified":"2024-01-01T00:00:00Z"}`,
+	}
+	for file, body := range files {
+		if rr := do(http.MethodPut, "/vuln/"+file, body); rr.Code != http.StatusOK {
+			t.Fatalf("PUT %s got status %d: %s", file, rr.Code, rr.Body.String())
+		}
+	}
+
+	for file, body := range files {
+		t.Run(file, func(t *testing.T) {
+			rr := do(http.MethodGet, "/vuln/"+file, "")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GET %s got status %d", file, rr.Code)
+			}
+			if rr.Body.String() != body {
+				t.Errorf("GET %s got %q, expected %q", file, rr.Body.String(), body)
+			}
+
+			// govulncheck requests gzipped files.
+			rr = do(http.MethodGet, "/vuln/"+file+".gz", "")
+			if rr.Code != http.StatusOK {
+				t.Fatalf("GET %s.gz got status %d", file, rr.Code)
+			}
+			gr, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatalf("GET %s.gz returned invalid gzip: %v", file, err)
+			}
+			got, err := io.ReadAll(gr)
+			if err != nil {
+				t.Fatalf("GET %s.gz returned invalid gzip: %v", file, err)
+			}
+			if string(got) != body {
+				t.Errorf("GET %s.gz got %q, expected %q", file, got, body)
+			}
+		})
+	}
+
+	if rr := do(http.MethodPut, "/vuln/index/db.json.gz", "data"); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected gzipped files to be read-only, got status %d", rr.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/a-h/depot/gomod/download"
+	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/storage"
+)
+
+// checksumDBHandler serves the checksum database proxy endpoints, e.g.
+// sumdb/sum.golang.org/lookup/github.com/foo/bar@v1.0.0, from the records and
+// tiles saved by depot go save. If upstream is non-nil, lookups and tiles that
+// aren't stored are fetched from the upstream proxy, and stored.
+type checksumDBHandler struct {
+	log      *slog.Logger
+	storage  storage.Storage
+	upstream *download.Downloader
+	metrics  metrics.Metrics
+}
+
+// parseChecksumDBPath extracts the checksum database name and file from a
+// path such as sumdb/sum.golang.org/tile/8/0/000.
+func parseChecksumDBPath(requestPath string) (name, file string, ok bool) {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(requestPath, "/"), "sumdb/")
+	if !ok {
+		return "", "", false
+	}
+	name, file, ok = strings.Cut(rest, "/")
+	if !ok || name == "" || path.Clean(file) != file {
+		return "", "", false
+	}
+	switch {
+	case file == "supported", file == "latest", strings.HasPrefix(file, "lookup/"), strings.HasPrefix(file, "tile/"):
+		return name, file, true
+	}
+	return "", "", false
+}
+
+func (h *checksumDBHandler) serveHTTP(w http.ResponseWriter, r *http.Request, name, file string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, name, file)
+	case http.MethodPut:
+		h.put(w, r, name, file)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *checksumDBHandler) get(w http.ResponseWriter, r *http.Request, name, file string) {
+	// The Go toolchain only uses the proxy for the checksum database if the proxy supports it.
+	if file == "supported" {
+		_, exists, err := h.storage.Stat(r.Context(), download.ChecksumDBPath(name, "latest"))
+		if err != nil {
+			h.log.Error("failed to check checksum database", slog.String("sumdb", name), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !exists && h.upstream == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	key := download.ChecksumDBPath(name, file)
+	f, exists, err := h.storage.Get(r.Context(), key)
+	if err != nil {
+		h.log.Error("failed to get checksum database file", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists && h.upstream != nil {
+		f, exists, err = h.getFromUpstream(r, name, file)
+		if err != nil {
+			h.log.Error("failed to get checksum database file from upstream", slog.String("key", key), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !exists {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasPrefix(file, "tile/") {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	bytesDownloaded, err := io.Copy(w, f)
+	if err != nil {
+		h.log.Error("failed to serve checksum database file", slog.String("key", key), slog.Any("error", err))
+		return
+	}
+	h.metrics.IncrementDownloadMetrics(r.Context(), "go", bytesDownloaded)
+}
+
+// getFromUpstream fetches a checksum database file from the upstream proxy.
+// Lookups and tiles don't change, so they're stored, but the latest tree head
+// is passed through.
+func (h *checksumDBHandler) getFromUpstream(r *http.Request, name, file string) (f io.ReadCloser, exists bool, err error) {
+	data, err := h.upstream.FetchChecksumDB(r.Context(), name, file)
+	if errors.Is(err, download.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if file != "latest" {
+		if err = storage.WriteFile(r.Context(), h.storage, download.ChecksumDBPath(name, file), data); err != nil {
+			return nil, false, err
+		}
+		h.log.Debug("fetched checksum database file from upstream", slog.String("sumdb", name), slog.String("file", file))
+	}
+	return io.NopCloser(bytes.NewReader(data)), true, nil
+}
+
+// put stores checksum database files pushed by depot go push.
+func (h *checksumDBHandler) put(w http.ResponseWriter, r *http.Request, name, file string) {
+	defer r.Body.Close()
+	if file == "supported" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	key := download.ChecksumDBPath(name, file)
+	if err = storage.WriteFile(r.Context(), h.storage, key, body); err != nil {
+		h.log.Error("failed to write checksum database file to storage", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.metrics.IncrementUploadMetrics(r.Context(), "go", int64(len(body)))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/a-h/depot/gomod/download"
+	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/storage"
+)
+
+// vulnDBHandler serves the Go vulnerability database saved by depot go save,
+// e.g. vuln/index/modules.json, so that it can be used as GOVULNDB.
+type vulnDBHandler struct {
+	log     *slog.Logger
+	storage storage.Storage
+	metrics metrics.Metrics
+}
+
+// parseVulnDBPath extracts the file from a vulnerability database path such as
+// vuln/ID/GO-2024-0001.json.gz. govulncheck requests gzipped files, which are
+// compressed from the stored JSON files.
+func parseVulnDBPath(requestPath string) (file string, gzipped, ok bool) {
+	file, ok = strings.CutPrefix(strings.TrimPrefix(requestPath, "/"), "vuln/")
+	if !ok || path.Clean(file) != file {
+		return "", false, false
+	}
+	file, gzipped = strings.CutSuffix(file, ".gz")
+	dir, name := path.Split(file)
+	if (dir != "index/" && dir != "ID/") || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+		return "", false, false
+	}
+	return file, gzipped, true
+}
+
+func (h *vulnDBHandler) serveHTTP(w http.ResponseWriter, r *http.Request, file string, gzipped bool) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, file, gzipped)
+	case http.MethodPut:
+		if gzipped {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.put(w, r, file)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *vulnDBHandler) get(w http.ResponseWriter, r *http.Request, file string, gzipped bool) {
+	key := download.VulnDBPath(file)
+	f, exists, err := h.storage.Get(r.Context(), key)
+	if err != nil {
+		h.log.Error("failed to get vulnerability database file", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	if !gzipped {
+		w.Header().Set("Content-Type", "application/json")
+		bytesDownloaded, err := io.Copy(w, f)
+		if err != nil {
+			h.log.Error("failed to serve vulnerability database file", slog.String("key", key), slog.Any("error", err))
+			return
+		}
+		h.metrics.IncrementDownloadMetrics(r.Context(), "go", bytesDownloaded)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	cw := &countingWriter{w: w}
+	gw := gzip.NewWriter(cw)
+	if _, err = io.Copy(gw, f); err != nil {
+		h.log.Error("failed to serve vulnerability database file", slog.String("key", key), slog.Any("error", err))
+		return
+	}
+	if err = gw.Close(); err != nil {
+		h.log.Error("failed to serve vulnerability database file", slog.String("key", key), slog.Any("error", err))
+		return
+	}
+	h.metrics.IncrementDownloadMetrics(r.Context(), "go", cw.n)
+}
+
+// put stores vulnerability database files pushed by depot go push.
+func (h *vulnDBHandler) put(w http.ResponseWriter, r *http.Request, file string) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	key := download.VulnDBPath(file)
+	if err = storage.WriteFile(r.Context(), h.storage, key, body); err != nil {
+		h.log.Error("failed to write vulnerability database file to storage", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.metrics.IncrementUploadMetrics(r.Context(), "go", int64(len(body)))
+	w.WriteHeader(http.StatusOK)
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
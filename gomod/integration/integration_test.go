@@ -25,7 +25,8 @@ import (
 // TestEndToEnd downloads a real module from proxy.golang.org, pushes it to a
 // depot server, then creates a Go project that fetches the module from depot.
 // It validates that the Go toolchain can resolve both direct and transitive
-// dependencies through the depot proxy.
+// dependencies through the depot proxy, and verify them against the checksum
+// database records that were saved and pushed with the modules.
 func TestEndToEnd(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping integration test in short mode")
@@ -50,7 +51,8 @@ func TestEndToEnd(t *testing.T) {
 	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	ctx := context.Background()
 
-	// Step 1: Save rsc.io/quote@v1.5.2 and its transitive dependencies.
+	// Step 1: Save rsc.io/quote@v1.5.2 and its transitive dependencies, with
+	// their checksum database records.
 	saveDir := t.TempDir()
 	saveStorage := storage.NewFileSystem(saveDir)
 	saver := save.New(log, saveStorage)
@@ -64,6 +66,8 @@ func TestEndToEnd(t *testing.T) {
 	expectedModules := []string{
 		"rsc.io/quote/@v/v1.5.2.zip",
 		"rsc.io/sampler/@v/v1.3.0.zip",
+		"sumdb/sum.golang.org/lookup/rsc.io/quote@v1.5.2",
+		"sumdb/sum.golang.org/latest",
 	}
 	for _, m := range expectedModules {
 		if _, err := os.Stat(filepath.Join(saveDir, m)); err != nil {
@@ -90,7 +94,7 @@ func TestEndToEnd(t *testing.T) {
 	serverStorageDir := t.TempDir()
 	serverStorage := storage.NewFileSystem(serverStorageDir)
 	goDb := db.New(kvStore)
-	handler := gomodhandler.New(log, goDb, serverStorage, m)
+	handler := gomodhandler.New(log, goDb, serverStorage, nil, false, m)
 	mux := http.NewServeMux()
 	mux.Handle("/go/", http.StripPrefix("/go", handler))
 	server := httptest.NewServer(mux)
@@ -129,6 +133,16 @@ func TestEndToEnd(t *testing.T) {
 		if info.Version != "v1.5.2" {
 			t.Errorf("got latest version %q, expected %q", info.Version, "v1.5.2")
 		}
+
+		// The checksum database record saved with the module should be served.
+		resp, err = http.Get(server.URL + "/go/sumdb/sum.golang.org/lookup/rsc.io/quote@v1.5.2")
+		if err != nil {
+			t.Fatalf("GET sumdb lookup failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET sumdb lookup got %d", resp.StatusCode)
+		}
 	})
 
 	// Step 4: Create a Go project that imports rsc.io/quote, then resolve deps from depot.
@@ -164,16 +178,18 @@ func main() {
 		gopath := t.TempDir()
 
 		// Run go mod download with GOPROXY pointing at our depot server.
-		// No ",direct" fallback -- depot must serve everything.
-		cmd := exec.Command(goBin, "mod", "download", "-x", "all")
-		cmd.Dir = projectDir
-		cmd.Env = append(os.Environ(),
+		// No ",direct" fallback -- depot must serve everything, including the
+		// checksum database that the modules are verified against.
+		env := append(os.Environ(),
 			"GOPROXY="+server.URL+"/go",
-			"GONOSUMCHECK=*",
-			"GONOSUMDB=*",
+			"GOSUMDB=sum.golang.org",
+			"GONOSUMDB=",
+			"GOPRIVATE=",
 			"GOPATH="+gopath,
-			"GOFLAGS=-modcacherw",
 		)
+		cmd := exec.Command(goBin, "mod", "download", "-x", "all")
+		cmd.Dir = projectDir
+		cmd.Env = append(env, "GOFLAGS=-modcacherw")
 		output, err := cmd.CombinedOutput()
 		t.Logf("go mod download output:\n%s", string(output))
 		if err != nil {
@@ -183,12 +199,7 @@ func main() {
 		// Verify that modules were downloaded to the local cache.
 		cmd = exec.Command(goBin, "list", "-m", "all")
 		cmd.Dir = projectDir
-		cmd.Env = append(os.Environ(),
-			"GOPROXY="+server.URL+"/go",
-			"GONOSUMCHECK=*",
-			"GONOSUMDB=*",
-			"GOPATH="+gopath,
-		)
+		cmd.Env = env
 		output, err = cmd.CombinedOutput()
 		t.Logf("go list -m all output:\n%s", string(output))
 		if err != nil {
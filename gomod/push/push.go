@@ -34,9 +34,11 @@ func (p *Pusher) SetAuthToken(token string) {
 }
 
 // Push uploads all saved Go module files to the remote depot.
-// Files are pushed in order: .info, .mod, then .zip.
+// Files are pushed in order: .info, .mod, .zip, then the checksum database
+// records and tiles, followed by the checksum database tree heads, and then
+// the vulnerability database entries, followed by its indexes.
 func (p *Pusher) Push(ctx context.Context, baseDir string) error {
-	var infoFiles, modFiles, zipFiles []string
+	var infoFiles, modFiles, zipFiles, checksumDBFiles, checksumDBTrees, vulnDBFiles, vulnDBIndexes, vulnDBMeta []string
 
 	err := filepath.Walk(baseDir, func(fpath string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -50,6 +52,25 @@ func (p *Pusher) Push(ctx context.Context, baseDir string) error {
 			return err
 		}
 		switch {
+		case strings.HasPrefix(filepath.ToSlash(rel), "sumdb/"):
+			// The tree head is pushed last, so it's only served once the records and tiles exist.
+			if filepath.Base(rel) == "latest" {
+				checksumDBTrees = append(checksumDBTrees, rel)
+				return nil
+			}
+			checksumDBFiles = append(checksumDBFiles, rel)
+		case strings.HasPrefix(filepath.ToSlash(rel), "vuln/"):
+			// The indexes are pushed last, so they only list entries that exist, and
+			// db.json is pushed after the other indexes.
+			if filepath.ToSlash(rel) == "vuln/index/db.json" {
+				vulnDBMeta = append(vulnDBMeta, rel)
+				return nil
+			}
+			if strings.HasPrefix(filepath.ToSlash(rel), "vuln/index/") {
+				vulnDBIndexes = append(vulnDBIndexes, rel)
+				return nil
+			}
+			vulnDBFiles = append(vulnDBFiles, rel)
 		case strings.HasSuffix(rel, ".info"):
 			infoFiles = append(infoFiles, rel)
 		case strings.HasSuffix(rel, ".mod"):
@@ -69,7 +90,7 @@ func (p *Pusher) Push(ctx context.Context, baseDir string) error {
 	}
 
 	// Push in order: .info first (creates DB record), then .mod (updates it), then .zip.
-	for _, groups := range [][]string{infoFiles, modFiles, zipFiles} {
+	for _, groups := range [][]string{infoFiles, modFiles, zipFiles, checksumDBFiles, checksumDBTrees, vulnDBFiles, vulnDBIndexes, vulnDBMeta} {
 		for _, rel := range groups {
 			if err := p.pushFile(ctx, baseDir, rel); err != nil {
 				return err
@@ -77,7 +98,7 @@ func (p *Pusher) Push(ctx context.Context, baseDir string) error {
 		}
 	}
 
-	p.log.Info("all module files pushed", slog.Int("count", total))
+	p.log.Info("all module files pushed", slog.Int("count", total), slog.Int("checksumDBFiles", len(checksumDBFiles)+len(checksumDBTrees)), slog.Int("vulnDBFiles", len(vulnDBFiles)+len(vulnDBIndexes)+len(vulnDBMeta)))
 	return nil
 }
 
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"sync"
 	"testing"
@@ -153,3 +154,101 @@ func TestPushWalksNestedDirectories(t *testing.T) {
 		t.Fatalf("got %d requests, expected 6: %v", len(receivedPaths), receivedPaths)
 	}
 }
+
+func TestPushSendsChecksumDatabaseLast(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"github.com/foo/bar/@v/v1.0.0.info":                     `{"Version":"v1.0.0"}`,
+		"github.com/foo/bar/@v/v1.0.0.mod":                      "module github.com/foo/bar\n",
+		"github.com/foo/bar/@v/v1.0.0.zip":                      "fake-zip",
+		"sumdb/sum.golang.org/latest":                           "tree",
+		"sumdb/sum.golang.org/lookup/github.com/foo/bar@v1.0.0": "record",
+		"sumdb/sum.golang.org/tile/8/0/000":                     "tile",
+	}
+	for name, content := range files {
+		fpath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var receivedPaths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		receivedPaths = append(receivedPaths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	p := New(log, ts.URL, http.DefaultClient)
+
+	if err := p.Push(context.Background(), dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(receivedPaths) != 6 {
+		t.Fatalf("got %d requests, expected 6: %v", len(receivedPaths), receivedPaths)
+	}
+	if receivedPaths[3] != "/go/sumdb/sum.golang.org/lookup/github.com/foo/bar@v1.0.0" {
+		t.Errorf("expected the checksum database records to be sent after the module files, got %v", receivedPaths)
+	}
+	if receivedPaths[5] != "/go/sumdb/sum.golang.org/latest" {
+		t.Errorf("expected the checksum database tree to be sent last, got %v", receivedPaths)
+	}
+}
+
+func TestPushSendsVulnerabilityDatabaseIndexesLast(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"github.com/foo/bar/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"vuln/index/db.json":                `{"modified":"2024-01-01T00:00:00Z"}`,
+		"vuln/index/modules.json":           "[]",
+		"vuln/index/vulns.json":             "[]",
+		"vuln/ID/GO-2024-0001.json":         "{}",
+	}
+	for name, content := range files {
+		fpath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var receivedPaths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		receivedPaths = append(receivedPaths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	p := New(log, ts.URL, http.DefaultClient)
+
+	if err := p.Push(context.Background(), dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"/go/github.com/foo/bar/@v/v1.0.0.info",
+		"/go/vuln/ID/GO-2024-0001.json",
+		"/go/vuln/index/modules.json",
+		"/go/vuln/index/vulns.json",
+		"/go/vuln/index/db.json",
+	}
+	if !slices.Equal(receivedPaths, expected) {
+		t.Errorf("got %v, expected %v", receivedPaths, expected)
+	}
+}
@@ -2,21 +2,32 @@ package save
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"iter"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/a-h/depot/gomod/download"
+	"github.com/a-h/depot/gomod/gosum"
 	"github.com/a-h/depot/storage"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
 )
 
 // Saver saves Go modules from various sources.
 type Saver struct {
 	log        *slog.Logger
 	downloader *download.Downloader
+	checksumDB string
+	vulnDB     string
+	toolchains []string
+	platforms  []string
 }
 
 // New creates a new Saver.
@@ -24,61 +35,159 @@ func New(log *slog.Logger, storage storage.Storage) *Saver {
 	return &Saver{
 		log:        log,
 		downloader: download.New(log, storage),
+		checksumDB: download.DefaultChecksumDB,
+		platforms:  []string{runtime.GOOS + "/" + runtime.GOARCH},
 	}
 }
 
+// SetChecksumDB sets the verifier key of the checksum database that records of
+// the saved modules are saved from. An empty key disables saving records.
+func (s *Saver) SetChecksumDB(verifierKey string) {
+	s.checksumDB = verifierKey
+}
+
+// SetVulnDB sets the URL of the Go vulnerability database to save a snapshot
+// of. An empty URL disables saving the vulnerability database.
+func (s *Saver) SetVulnDB(url string) {
+	s.vulnDB = url
+}
+
+// SetToolchains sets the Go toolchains, e.g. go1.26.1, to save in addition to
+// the modules, so that the go command can switch to them with GOTOOLCHAIN.
+// The toolchain named by the toolchain directive of a go.mod file is saved
+// automatically.
+func (s *Saver) SetToolchains(toolchains []string) {
+	s.toolchains = toolchains
+}
+
+// SetPlatforms sets the platforms, e.g. linux/amd64, to save toolchains for.
+// The default is the current platform.
+func (s *Saver) SetPlatforms(platforms []string) {
+	s.platforms = platforms
+}
+
 // SetProxyURL overrides the upstream proxy URL for testing.
 func (s *Saver) SetProxyURL(url string) {
 	s.downloader.SetProxyURL(url)
 }
 
-// Save saves modules specified as "module@version" strings or a path to go.mod.
+// Save saves modules specified as "module@version" strings, or the modules
+// needed to build the modules in go.mod files, go.work files, or directories,
+// which are searched recursively for go.mod files. The configured toolchains
+// are saved too.
 func (s *Saver) Save(ctx context.Context, specs []string) error {
-	if len(specs) == 0 {
+	if len(specs) == 0 && len(s.toolchains) == 0 {
 		return fmt.Errorf("no modules specified")
 	}
 
-	// If a single argument is a go.mod file, parse it.
-	if len(specs) == 1 && strings.HasSuffix(specs[0], "go.mod") {
-		return s.saveFromGoMod(ctx, specs[0])
+	if slices.ContainsFunc(specs, isLocalSpec) {
+		return s.saveFromFiles(ctx, specs)
 	}
 
 	moduleSpecs := make([]download.ModuleSpec, len(specs))
 	for i, spec := range specs {
 		moduleSpecs[i] = download.ParseModuleSpec(strings.TrimSpace(spec))
 	}
-	return s.saveModules(ctx, moduleSpecs)
+	toolchainSpecs, err := s.toolchainSpecs(s.toolchains)
+	if err != nil {
+		return err
+	}
+	return s.saveModules(ctx, append(moduleSpecs, toolchainSpecs...), nil)
 }
 
-func (s *Saver) saveFromGoMod(ctx context.Context, goModPath string) error {
-	data, err := os.ReadFile(goModPath)
-	if err != nil {
-		return fmt.Errorf("failed to read go.mod: %w", err)
+// isLocalSpec returns true if spec is a go.mod file, go.work file or directory,
+// rather than a module.
+func isLocalSpec(spec string) bool {
+	if strings.HasSuffix(spec, "go.mod") || strings.HasSuffix(spec, "go.work") {
+		return true
 	}
+	fi, err := os.Stat(spec)
+	return err == nil && fi.IsDir()
+}
 
-	f, err := modfile.Parse(goModPath, data, nil)
-	if err != nil {
-		return fmt.Errorf("failed to parse go.mod: %w", err)
+// saveFromFiles saves the modules needed to build the modules in go.mod files,
+// go.work files and directories. Each go.mod file, whether specified or found
+// in a directory, is resolved on its own, and each go.work file is resolved
+// as a workspace, as the go command would build them.
+func (s *Saver) saveFromFiles(ctx context.Context, paths []string) error {
+	var goModPaths, goWorkPaths []string
+	for _, p := range paths {
+		switch {
+		case strings.HasSuffix(p, "go.work"):
+			goWorkPaths = append(goWorkPaths, p)
+		case strings.HasSuffix(p, "go.mod"):
+			goModPaths = append(goModPaths, p)
+		case isLocalSpec(p):
+			found, err := findGoMods(p)
+			if err != nil {
+				return err
+			}
+			goModPaths = append(goModPaths, found...)
+		default:
+			return fmt.Errorf("can't save %q: module specs can't be combined with go.mod files, go.work files or directories", p)
+		}
 	}
 
-	var specs []download.ModuleSpec
-	for _, req := range f.Require {
-		specs = append(specs, download.ModuleSpec{
-			Path:    req.Mod.Path,
-			Version: req.Mod.Version,
-		})
+	sums := gosum.Sums{}
+	var workspaces []*workspace
+	for _, p := range goWorkPaths {
+		ws, wsSums, err := loadGoWork(s.log, p)
+		if err != nil {
+			return err
+		}
+		workspaces = append(workspaces, ws)
+		maps.Copy(sums, wsSums)
+	}
+	for _, p := range goModPaths {
+		ws, wsSums, err := loadGoMod(s.log, p)
+		if err != nil {
+			return err
+		}
+		workspaces = append(workspaces, ws)
+		maps.Copy(sums, wsSums)
+	}
+	for _, ws := range workspaces {
+		ws.toolchains = append(ws.toolchains, s.toolchains...)
+		if len(ws.requirements) == 0 && len(ws.toolchains) == 0 {
+			s.log.Warn("no dependencies found", slog.String("file", ws.file))
+		}
+		s.log.Info("parsed "+filepath.Base(ws.file), slog.String("file", ws.file), slog.Int("dependencies", len(ws.requirements)))
 	}
+	return s.saveWorkspaces(ctx, workspaces, sums)
+}
 
-	if len(specs) == 0 {
-		return fmt.Errorf("no dependencies found in %s", goModPath)
+// toolchainSpecs returns the toolchain module of each toolchain for each platform.
+func (s *Saver) toolchainSpecs(toolchains []string) (specs []download.ModuleSpec, err error) {
+	for _, toolchain := range toolchains {
+		for _, platform := range s.platforms {
+			spec, err := download.ToolchainModule(toolchain, platform)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, spec)
+		}
 	}
+	return specs, nil
+}
 
-	s.log.Info("parsed go.mod", slog.String("file", goModPath), slog.Int("dependencies", len(specs)))
-	return s.saveModules(ctx, specs)
+// readGoSum reads the go.sum file at path. It returns nil if the file doesn't exist.
+func readGoSum(path string) (sums gosum.Sums, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.sum: %w", err)
+	}
+	defer f.Close()
+	return gosum.Parse(f)
 }
 
-func (s *Saver) saveModules(ctx context.Context, specs []download.ModuleSpec) error {
+// saveModules saves the modules and their transitive dependencies. Modules
+// with hashes in sums are verified against them.
+func (s *Saver) saveModules(ctx context.Context, specs []download.ModuleSpec, sums gosum.Sums) error {
 	alreadySeen := make(map[string]bool)
+	var saved []module.Version
 	specIter := newSliceIterator(specs)
 
 	for spec := range specIter.iterate() {
@@ -106,7 +215,11 @@ func (s *Saver) saveModules(ctx context.Context, specs []download.ModuleSpec) er
 		if err != nil {
 			return fmt.Errorf("failed to download %s: %w", spec.String(), err)
 		}
+		if err = s.downloader.Verify(ctx, spec.Path, spec.Version, sums); err != nil {
+			return err
+		}
 		s.log.Info("downloaded module", slog.String("module", spec.String()))
+		saved = append(saved, module.Version{Path: spec.Path, Version: spec.Version})
 
 		// Parse the downloaded go.mod for transitive dependencies.
 		deps := parseTransitiveDeps(s.log, goModContent)
@@ -119,6 +232,38 @@ func (s *Saver) saveModules(ctx context.Context, specs []download.ModuleSpec) er
 	}
 
 	s.log.Info("all modules saved", slog.Int("total", len(alreadySeen)))
+	if err := s.saveChecksums(ctx, saved); err != nil {
+		return err
+	}
+	return s.saveVulnDB(ctx)
+}
+
+// saveChecksums saves the checksum database records of the modules, so that
+// the Go toolchain can verify them through depot.
+func (s *Saver) saveChecksums(ctx context.Context, saved []module.Version) error {
+	if s.checksumDB == "" {
+		return nil
+	}
+	err := s.downloader.SaveChecksums(ctx, s.checksumDB, saved)
+	if errors.Is(err, download.ErrNotFound) {
+		s.log.Warn("upstream proxy doesn't support the checksum database, records not saved", slog.Any("error", err))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save checksum database records: %w", err)
+	}
+	return nil
+}
+
+// saveVulnDB saves a snapshot of the Go vulnerability database, so that
+// govulncheck can use it through depot.
+func (s *Saver) saveVulnDB(ctx context.Context) error {
+	if s.vulnDB == "" {
+		return nil
+	}
+	if err := s.downloader.SaveVulnDB(ctx, s.vulnDB); err != nil {
+		return fmt.Errorf("failed to save vulnerability database: %w", err)
+	}
 	return nil
 }
 
@@ -1,14 +1,21 @@
 package save
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/a-h/depot/gomod/gosum"
 	"github.com/a-h/depot/storage"
 )
 
@@ -203,3 +210,172 @@ func TestSaveResolvesLatestVersion(t *testing.T) {
 		t.Error("expected v3.0.0 to be downloaded after resolving latest")
 	}
 }
+
+func TestSaveVerifiesGoSum(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("github.com/foo/bar@v1.0.0/bar.go")
+	if err != nil {
+		t.Fatalf("failed to create zip file: %v", err)
+	}
+	io.WriteString(f, "package bar\n")
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	zipContent := buf.Bytes()
+	modContent := []byte("module github.com/foo/bar\n\ngo 1.21\n")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/github.com/foo/bar/@v/v1.0.0.info":
+			w.Write([]byte(`{"Version":"v1.0.0","Time":"2024-01-01T00:00:00Z"}`))
+		case "/github.com/foo/bar/@v/v1.0.0.mod":
+			w.Write(modContent)
+		case "/github.com/foo/bar/@v/v1.0.0.zip":
+			w.Write(zipContent)
+		default:
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	zipFile := filepath.Join(t.TempDir(), "v1.0.0.zip")
+	if err := os.WriteFile(zipFile, zipContent, 0644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+	zipHash, err := gosum.HashZip(zipFile)
+	if err != nil {
+		t.Fatalf("failed to hash zip: %v", err)
+	}
+	modHash, err := gosum.HashGoMod(modContent)
+	if err != nil {
+		t.Fatalf("failed to hash go.mod: %v", err)
+	}
+
+	save := func(t *testing.T, goSum string) (storeDir string, err error) {
+		t.Helper()
+		dir := t.TempDir()
+		goModPath := filepath.Join(dir, "go.mod")
+		if err := os.WriteFile(goModPath, []byte("module example.com/myapp\n\ngo 1.21\n\nrequire github.com/foo/bar v1.0.0\n"), 0644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte(goSum), 0644); err != nil {
+			t.Fatalf("failed to write go.sum: %v", err)
+		}
+		storeDir = t.TempDir()
+		log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+		saver := New(log, storage.NewFileSystem(storeDir))
+		saver.SetProxyURL(ts.URL)
+		return storeDir, saver.Save(context.Background(), []string{goModPath})
+	}
+
+	t.Run("matching hashes are saved", func(t *testing.T) {
+		goSum := "github.com/foo/bar v1.0.0 " + zipHash + "\ngithub.com/foo/bar v1.0.0/go.mod " + modHash + "\n"
+		if _, err := save(t, goSum); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("a zip hash mismatch fails, and the zip is removed", func(t *testing.T) {
+		goSum := "github.com/foo/bar v1.0.0 h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\ngithub.com/foo/bar v1.0.0/go.mod " + modHash + "\n"
+		storeDir, err := save(t, goSum)
+		if !errors.Is(err, gosum.ErrMismatch) {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(storeDir, "github.com/foo/bar/@v/v1.0.0.zip")); !os.IsNotExist(err) {
+			t.Errorf("expected the zip to be removed, got %v", err)
+		}
+	})
+	t.Run("a go.mod hash mismatch fails", func(t *testing.T) {
+		goSum := "github.com/foo/bar v1.0.0 " + zipHash + "\ngithub.com/foo/bar v1.0.0/go.mod h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"
+		if _, err := save(t, goSum); !errors.Is(err, gosum.ErrMismatch) {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+	})
+}
+
+func TestSaveToolchains(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/github.com/foo/bar/@v/v1.0.0.info":
+			w.Write([]byte(`{"Version":"v1.0.0","Time":"2024-01-01T00:00:00Z"}`))
+		case "/github.com/foo/bar/@v/v1.0.0.mod":
+			w.Write([]byte("module github.com/foo/bar\n\ngo 1.21\n"))
+		case "/github.com/foo/bar/@v/v1.0.0.zip":
+			w.Write([]byte("fake-zip"))
+		case "/golang.org/toolchain/@v/v0.0.1-go1.26.1.linux-amd64.info", "/golang.org/toolchain/@v/v0.0.1-go1.26.1.darwin-arm64.info", "/golang.org/toolchain/@v/v0.0.1-go1.25.0.linux-amd64.info":
+			w.Write([]byte(`{"Version":"` + strings.TrimSuffix(path.Base(r.URL.Path), ".info") + `","Time":"2024-01-01T00:00:00Z"}`))
+		case "/golang.org/toolchain/@v/v0.0.1-go1.26.1.linux-amd64.mod", "/golang.org/toolchain/@v/v0.0.1-go1.26.1.darwin-arm64.mod", "/golang.org/toolchain/@v/v0.0.1-go1.25.0.linux-amd64.mod":
+			w.Write([]byte("module golang.org/toolchain\n"))
+		case "/golang.org/toolchain/@v/v0.0.1-go1.26.1.linux-amd64.zip", "/golang.org/toolchain/@v/v0.0.1-go1.26.1.darwin-arm64.zip", "/golang.org/toolchain/@v/v0.0.1-go1.25.0.linux-amd64.zip":
+			w.Write([]byte("fake-zip"))
+		default:
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name       string
+		goMod      string
+		specs      []string
+		toolchains []string
+		platforms  []string
+		expected   []string
+	}{
+		{
+			name:      "the toolchain directive of a go.mod file is saved",
+			goMod:     "module example.com/myapp\n\ngo 1.26\n\ntoolchain go1.26.1\n\nrequire github.com/foo/bar v1.0.0\n",
+			platforms: []string{"linux/amd64"},
+			expected: []string{
+				"github.com/foo/bar/@v/v1.0.0.zip",
+				"golang.org/toolchain/@v/v0.0.1-go1.26.1.linux-amd64.zip",
+			},
+		},
+		{
+			name:       "toolchains are saved for each platform",
+			toolchains: []string{"go1.26.1"},
+			platforms:  []string{"linux/amd64", "darwin/arm64"},
+			expected: []string{
+				"golang.org/toolchain/@v/v0.0.1-go1.26.1.linux-amd64.zip",
+				"golang.org/toolchain/@v/v0.0.1-go1.26.1.darwin-arm64.zip",
+			},
+		},
+		{
+			name:       "toolchains are saved with the modules",
+			specs:      []string{"github.com/foo/bar@v1.0.0"},
+			toolchains: []string{"go1.25.0"},
+			platforms:  []string{"linux/amd64"},
+			expected: []string{
+				"github.com/foo/bar/@v/v1.0.0.zip",
+				"golang.org/toolchain/@v/v0.0.1-go1.25.0.linux-amd64.zip",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs := tt.specs
+			if tt.goMod != "" {
+				goModPath := filepath.Join(t.TempDir(), "go.mod")
+				if err := os.WriteFile(goModPath, []byte(tt.goMod), 0644); err != nil {
+					t.Fatalf("failed to write go.mod: %v", err)
+				}
+				specs = []string{goModPath}
+			}
+			storeDir := t.TempDir()
+			saver := New(log, storage.NewFileSystem(storeDir))
+			saver.SetProxyURL(ts.URL)
+			saver.SetToolchains(tt.toolchains)
+			saver.SetPlatforms(tt.platforms)
+
+			if err := saver.Save(context.Background(), specs); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, name := range tt.expected {
+				if _, err := os.Stat(filepath.Join(storeDir, name)); err != nil {
+					t.Errorf("expected file %s to exist: %v", name, err)
+				}
+			}
+		})
+	}
+}
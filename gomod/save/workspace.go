@@ -0,0 +1,419 @@
+package save
+
+import (
+	"context"
+	"fmt"
+	"go/version"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/a-h/depot/gomod/gosum"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// workspace is a set of main modules that are built together, i.e. a single
+// module, or the modules used by a go.work file.
+type workspace struct {
+	// file is the go.mod or go.work file that the workspace was loaded from.
+	file string
+	// mainModules are the paths of the main modules. Requirements on main
+	// modules are satisfied by the workspace.
+	mainModules map[string]bool
+	// requirements are the requirements of the main modules.
+	requirements []module.Version
+	// pruning is how the module graph of the requirements is pruned.
+	pruning modPruning
+	// replacements are the replace directives of the go.work file and the
+	// main modules. Replacements of every version of a module have an empty
+	// version in the key.
+	replacements map[module.Version]replacement
+	toolchains   []string
+}
+
+// modPruning is how much of the module graph below a module is loaded, as in
+// the go command.
+type modPruning int
+
+const (
+	// pruned modules, at go 1.17 or later, require every module that provides
+	// packages to them, so the requirements of their requirements aren't loaded.
+	pruned modPruning = iota
+	// unpruned modules, before go 1.17, need their full transitive requirements.
+	unpruned
+	// workspacePruning applies to the requirements of the main modules of a
+	// go.work file, whose own requirements are loaded.
+	workspacePruning
+)
+
+// pruningOf returns the pruning of a module from the go version of its go.mod file.
+func pruningOf(f *modfile.File) modPruning {
+	if f.Go == nil || version.Compare("go"+f.Go.Version, "go1.17") < 0 {
+		return unpruned
+	}
+	return pruned
+}
+
+// replacement is the target of a replace directive. Local path replacements
+// have a directory, and no module version.
+type replacement struct {
+	mod module.Version
+	dir string
+}
+
+func (ws *workspace) replacement(mv module.Version) (r replacement, ok bool) {
+	if r, ok = ws.replacements[mv]; ok {
+		return r, true
+	}
+	r, ok = ws.replacements[module.Version{Path: mv.Path}]
+	return r, ok
+}
+
+// addReplacements adds the replace directives of a go.mod or go.work file in
+// dir. Existing replacements take precedence, so the replace directives of a
+// go.work file must be added first.
+func (ws *workspace) addReplacements(log *slog.Logger, dir string, replaces []*modfile.Replace) {
+	for _, rep := range replaces {
+		if _, exists := ws.replacements[rep.Old]; exists {
+			log.Debug("ignoring overridden replace directive", slog.String("module", rep.Old.String()), slog.String("file", ws.file))
+			continue
+		}
+		r := replacement{mod: rep.New}
+		if isLocalPath(rep.New.Path) {
+			r = replacement{dir: filepath.Join(dir, rep.New.Path)}
+		}
+		ws.replacements[rep.Old] = r
+	}
+}
+
+// loadGoMod loads the workspace of a single go.mod file, and its go.sum.
+func loadGoMod(log *slog.Logger, goModPath string) (ws *workspace, sums gosum.Sums, err error) {
+	f, err := readGoMod(goModPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ws = &workspace{
+		file:         goModPath,
+		mainModules:  map[string]bool{},
+		replacements: map[module.Version]replacement{},
+	}
+	ws.addModule(log, filepath.Dir(goModPath), f)
+	ws.pruning = pruningOf(f)
+	if sums, err = readGoSum(filepath.Join(filepath.Dir(goModPath), "go.sum")); err != nil {
+		return nil, nil, err
+	}
+	if sums == nil {
+		log.Warn("go.sum not found, downloaded modules won't be verified", slog.String("file", goModPath))
+	}
+	return ws, sums, nil
+}
+
+// loadGoWork loads the workspace of a go.work file, and the go.sum files of
+// its modules, and its go.work.sum.
+func loadGoWork(log *slog.Logger, goWorkPath string) (ws *workspace, sums gosum.Sums, err error) {
+	data, err := os.ReadFile(goWorkPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read go.work: %w", err)
+	}
+	wf, err := modfile.ParseWork(goWorkPath, data, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse go.work: %w", err)
+	}
+	dir := filepath.Dir(goWorkPath)
+	ws = &workspace{
+		file:         goWorkPath,
+		mainModules:  map[string]bool{},
+		replacements: map[module.Version]replacement{},
+		pruning:      workspacePruning,
+	}
+	ws.addToolchain(log, goWorkPath, wf.Toolchain)
+	ws.addReplacements(log, dir, wf.Replace)
+
+	sums = gosum.Sums{}
+	sumFiles := []string{goWorkPath + ".sum"}
+	for _, use := range wf.Use {
+		moduleDir := filepath.Join(dir, filepath.FromSlash(use.Path))
+		f, err := readGoMod(filepath.Join(moduleDir, "go.mod"))
+		if err != nil {
+			return nil, nil, err
+		}
+		ws.addModule(log, moduleDir, f)
+		sumFiles = append(sumFiles, filepath.Join(moduleDir, "go.sum"))
+	}
+	for _, sumFile := range sumFiles {
+		fileSums, err := readGoSum(sumFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		maps.Copy(sums, fileSums)
+	}
+	return ws, sums, nil
+}
+
+// addModule adds a main module in dir to the workspace.
+func (ws *workspace) addModule(log *slog.Logger, dir string, f *modfile.File) {
+	if f.Module != nil {
+		ws.mainModules[f.Module.Mod.Path] = true
+	}
+	for _, req := range f.Require {
+		ws.requirements = append(ws.requirements, req.Mod)
+	}
+	ws.addToolchain(log, filepath.Join(dir, "go.mod"), f.Toolchain)
+	ws.addReplacements(log, dir, f.Replace)
+}
+
+// addToolchain adds the toolchain of a toolchain directive to the workspace.
+// Only go1.x toolchains are published as modules, so others, such as default,
+// which selects the toolchain bundled with the go command, are skipped.
+func (ws *workspace) addToolchain(log *slog.Logger, file string, toolchain *modfile.Toolchain) {
+	if toolchain == nil {
+		return
+	}
+	if !strings.HasPrefix(toolchain.Name, "go1") {
+		log.Info("skipping toolchain directive, only go1.x toolchains can be saved", slog.String("file", file), slog.String("toolchain", toolchain.Name))
+		return
+	}
+	ws.toolchains = append(ws.toolchains, toolchain.Name)
+}
+
+// findGoMods returns the go.mod files in dir and its subdirectories. As with
+// the go command, vendor and testdata directories, and directories beginning
+// with . or _ are skipped.
+func findGoMods(dir string) (goModPaths []string, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if p != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == "go.mod" {
+			goModPaths = append(goModPaths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find go.mod files in %s: %w", dir, err)
+	}
+	if len(goModPaths) == 0 {
+		return nil, fmt.Errorf("no go.mod files found in %s", dir)
+	}
+	return goModPaths, nil
+}
+
+func readGoMod(goModPath string) (f *modfile.File, err error) {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	f, err = modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go.mod: %w", err)
+	}
+	return f, nil
+}
+
+// buildList is the result of resolving the module graph of a workspace.
+type buildList struct {
+	// selected are the module versions chosen by minimal version selection,
+	// after replacement. Their zips are needed to build the workspace.
+	selected []module.Version
+	// graph are the module versions in the module graph, after replacement.
+	// The go command reads their go.mod files to compute the build list.
+	graph []module.Version
+}
+
+// moduleLoad is a module version whose requirements are loaded into the
+// module graph, and the pruning that applies to them.
+type moduleLoad struct {
+	mv      module.Version
+	pruning modPruning
+}
+
+// resolve downloads the go.mod file of every module version in the module
+// graph of the workspace, and returns the versions selected by minimal
+// version selection. As in the go command, only the replace directives of
+// the workspace apply, requirements on main modules are satisfied by the
+// workspace, and the module graph is pruned below modules at go 1.17 or later.
+func (s *Saver) resolve(ctx context.Context, ws *workspace, sums gosum.Sums) (bl buildList, err error) {
+	selected := map[string]string{}
+	goMods := map[module.Version]*modfile.File{}
+	// add adds a module version to the module graph, and returns its go.mod
+	// file, which is nil if it can't be read.
+	add := func(mv module.Version) (f *modfile.File, err error) {
+		if v, ok := selected[mv.Path]; !ok || semver.Compare(mv.Version, v) > 0 {
+			selected[mv.Path] = mv.Version
+		}
+		if f, ok := goMods[mv]; ok {
+			return f, nil
+		}
+		if f, err = s.readRequirements(ctx, ws, mv, sums, &bl); err != nil {
+			return nil, err
+		}
+		goMods[mv] = f
+		return f, nil
+	}
+
+	loaded := map[moduleLoad]bool{}
+	queue := newSliceIterator[moduleLoad](nil)
+	for _, req := range ws.requirements {
+		queue.append(moduleLoad{mv: req, pruning: ws.pruning})
+	}
+	for l := range queue.iterate() {
+		if ws.mainModules[l.mv.Path] {
+			continue
+		}
+		// Requirements that were loaded pruned are loaded again if they're
+		// reached through an unpruned module, which needs all of them.
+		key := l
+		if key.pruning == workspacePruning {
+			key.pruning = pruned
+		}
+		if loaded[key] {
+			continue
+		}
+		loaded[key] = true
+
+		f, err := add(l.mv)
+		if err != nil {
+			return bl, err
+		}
+		if f == nil {
+			continue
+		}
+		modulePruning := pruningOf(f)
+		next := modulePruning
+		if l.pruning == unpruned {
+			next = unpruned
+		}
+		for _, req := range f.Require {
+			if ws.mainModules[req.Mod.Path] {
+				continue
+			}
+			if l.pruning != pruned || modulePruning == unpruned {
+				queue.append(moduleLoad{mv: req.Mod, pruning: next})
+				continue
+			}
+			// The requirements of a pruned module are part of the module graph,
+			// but their own requirements aren't.
+			if _, err = add(req.Mod); err != nil {
+				return bl, err
+			}
+		}
+	}
+
+	for _, p := range slices.Sorted(maps.Keys(selected)) {
+		mv := module.Version{Path: p, Version: selected[p]}
+		r, replaced := ws.replacement(mv)
+		switch {
+		case replaced && r.dir != "":
+			continue
+		case replaced:
+			mv = r.mod
+		}
+		bl.selected = append(bl.selected, mv)
+	}
+
+	// Toolchains aren't part of the module graph, and each platform is a
+	// different version of the toolchain module, so they're all selected.
+	toolchainSpecs, err := s.toolchainSpecs(ws.toolchains)
+	if err != nil {
+		return bl, err
+	}
+	for _, spec := range toolchainSpecs {
+		if _, _, err = s.downloader.DownloadMetadata(ctx, spec.Path, spec.Version); err != nil {
+			return bl, fmt.Errorf("failed to download %s: %w", spec, err)
+		}
+		mv := module.Version{Path: spec.Path, Version: spec.Version}
+		bl.selected = append(bl.selected, mv)
+		bl.graph = append(bl.graph, mv)
+	}
+	s.log.Info("resolved module graph", slog.String("file", ws.file), slog.Int("selected", len(bl.selected)), slog.Int("graph", len(bl.graph)))
+	return bl, nil
+}
+
+// readRequirements reads the go.mod file of a module version in the module
+// graph, downloading it unless the module is replaced by a local path. It
+// returns nil if the go.mod file can't be read or parsed.
+func (s *Saver) readRequirements(ctx context.Context, ws *workspace, mv module.Version, sums gosum.Sums, bl *buildList) (f *modfile.File, err error) {
+	r, replaced := ws.replacement(mv)
+	if replaced && r.dir != "" {
+		// Local path replacements, e.g. of sibling modules in a monorepo, aren't
+		// saved, but their requirements are.
+		f, err := readGoMod(filepath.Join(r.dir, "go.mod"))
+		if err != nil {
+			s.log.Warn("skipping local path replacement, module will be missing from mirror", slog.String("module", mv.Path), slog.String("replacement", r.dir), slog.Any("error", err))
+			return nil, nil
+		}
+		return f, nil
+	}
+	target := mv
+	if replaced {
+		target = r.mod
+	}
+	_, goModContent, err := s.downloader.DownloadMetadata(ctx, target.Path, target.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", target, err)
+	}
+	if err = s.downloader.Verify(ctx, target.Path, target.Version, sums); err != nil {
+		return nil, err
+	}
+	bl.graph = append(bl.graph, target)
+	f, err = modfile.ParseLax("go.mod", goModContent, nil)
+	if err != nil {
+		s.log.Warn("failed to parse downloaded go.mod for transitive deps", slog.String("module", target.String()), slog.Any("error", err))
+		return nil, nil
+	}
+	return f, nil
+}
+
+// saveWorkspaces saves the modules needed to build each workspace. The go.mod
+// file of every module version in each module graph is saved, but only the
+// zips of the versions selected by minimal version selection are saved.
+func (s *Saver) saveWorkspaces(ctx context.Context, workspaces []*workspace, sums gosum.Sums) error {
+	var selected, graph []module.Version
+	for _, ws := range workspaces {
+		bl, err := s.resolve(ctx, ws, sums)
+		if err != nil {
+			return err
+		}
+		selected = append(selected, bl.selected...)
+		graph = append(graph, bl.graph...)
+	}
+	selected = compactVersions(selected)
+	for _, mv := range selected {
+		if err := s.downloader.DownloadZip(ctx, mv.Path, mv.Version); err != nil {
+			return fmt.Errorf("failed to download %s: %w", mv, err)
+		}
+		if err := s.downloader.Verify(ctx, mv.Path, mv.Version, sums); err != nil {
+			return err
+		}
+		s.log.Info("downloaded module", slog.String("module", mv.String()))
+	}
+
+	s.log.Info("all modules saved", slog.Int("total", len(selected)))
+	if err := s.saveChecksums(ctx, compactVersions(graph)); err != nil {
+		return err
+	}
+	return s.saveVulnDB(ctx)
+}
+
+func compactVersions(versions []module.Version) []module.Version {
+	slices.SortFunc(versions, func(a, b module.Version) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return semver.Compare(a.Version, b.Version)
+	})
+	return slices.Compact(versions)
+}
@@ -0,0 +1,282 @@
+package save
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/a-h/depot/storage"
+)
+
+// newModuleProxy serves the .info, .mod and .zip files of module versions,
+// keyed by module@version, with the go.mod file as the value.
+func newModuleProxy(t *testing.T, goMods map[string]string) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modulePath, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/@v/")
+		ext := filepath.Ext(file)
+		version := strings.TrimSuffix(file, ext)
+		goMod, exists := goMods[modulePath+"@"+version]
+		if !ok || !exists {
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		switch ext {
+		case ".info":
+			w.Write([]byte(`{"Version":"` + version + `","Time":"2024-01-01T00:00:00Z"}`))
+		case ".mod":
+			w.Write([]byte(goMod))
+		case ".zip":
+			w.Write([]byte("fake-zip"))
+		default:
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fpath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestSaveFromGoWork(t *testing.T) {
+	ts := newModuleProxy(t, map[string]string{
+		"github.com/dep/x@v1.0.0": "module github.com/dep/x\n\ngo 1.21\n\nrequire github.com/dep/y v0.9.0\n",
+		"github.com/dep/x@v1.2.0": "module github.com/dep/x\n\ngo 1.21\n",
+		"github.com/dep/y@v0.9.0": "module github.com/dep/y\n\ngo 1.21\n",
+		"github.com/dep/y@v1.0.0": "module github.com/dep/y\n\ngo 1.21\n",
+		"github.com/dep/z@v1.0.0": "module github.com/dep/z\n\ngo 1.21\n",
+		// The published version of a workspace module isn't needed.
+		"example.com/b@v1.0.0": "module example.com/b\n\ngo 1.21\n\nrequire github.com/dep/unused v1.0.0\n",
+	})
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.work": "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.21\n\nrequire (\n\texample.com/b v1.0.0\n\texample.com/c v0.0.0\n\tgithub.com/dep/x v1.0.0\n)\n\n" +
+			"replace example.com/c => ../c\n",
+		"b/go.mod": "module example.com/b\n\ngo 1.21\n\nrequire (\n\tgithub.com/dep/x v1.2.0\n\tgithub.com/dep/y v1.0.0\n)\n",
+		// c is a sibling module that isn't in the workspace, used through a replace directive.
+		"c/go.mod": "module example.com/c\n\ngo 1.21\n\nrequire github.com/dep/z v1.0.0\n",
+	})
+
+	storeDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	saver := New(log, storage.NewFileSystem(storeDir))
+	saver.SetProxyURL(ts.URL)
+	if err := saver.Save(context.Background(), []string{filepath.Join(dir, "go.work")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(storeDir, name))
+		return err == nil
+	}
+	t.Run("the selected versions are saved", func(t *testing.T) {
+		for _, name := range []string{
+			"github.com/dep/x/@v/v1.2.0.zip",
+			"github.com/dep/y/@v/v1.0.0.zip",
+			"github.com/dep/z/@v/v1.0.0.zip",
+		} {
+			if !exists(name) {
+				t.Errorf("expected %s to be saved", name)
+			}
+		}
+	})
+	t.Run("only the go.mod files of versions that aren't selected are saved", func(t *testing.T) {
+		for _, name := range []string{"github.com/dep/x/@v/v1.0.0.mod", "github.com/dep/y/@v/v0.9.0.mod"} {
+			if !exists(name) {
+				t.Errorf("expected %s to be saved", name)
+			}
+		}
+		for _, name := range []string{"github.com/dep/x/@v/v1.0.0.zip", "github.com/dep/y/@v/v0.9.0.zip"} {
+			if exists(name) {
+				t.Errorf("expected %s not to be saved", name)
+			}
+		}
+	})
+	t.Run("workspace and locally replaced modules aren't saved", func(t *testing.T) {
+		for _, name := range []string{"example.com/b/@v/v1.0.0.mod", "example.com/c/@v/v0.0.0.mod"} {
+			if exists(name) {
+				t.Errorf("expected %s not to be saved", name)
+			}
+		}
+	})
+}
+
+func TestSaveFromDirectory(t *testing.T) {
+	ts := newModuleProxy(t, map[string]string{
+		"github.com/dep/x@v1.0.0": "module github.com/dep/x\n\ngo 1.21\n",
+		"github.com/dep/x@v1.2.0": "module github.com/dep/x\n\ngo 1.21\n",
+	})
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                  "module example.com/root\n\ngo 1.21\n\nrequire github.com/dep/x v1.0.0\n",
+		"tools/go.mod":            "module example.com/root/tools\n\ngo 1.21\n\nrequire github.com/dep/x v1.2.0\n",
+		"vendor/example/go.mod":   "module example.com/vendored\n\ngo 1.21\n\nrequire github.com/dep/missing v1.0.0\n",
+		"testdata/module/go.mod":  "module example.com/testdata\n\ngo 1.21\n\nrequire github.com/dep/missing v1.0.0\n",
+		".hidden/module/go.mod":   "module example.com/hidden\n\ngo 1.21\n\nrequire github.com/dep/missing v1.0.0\n",
+		"tools/internal/notes.md": "",
+	})
+
+	storeDir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	saver := New(log, storage.NewFileSystem(storeDir))
+	saver.SetProxyURL(ts.URL)
+	if err := saver.Save(context.Background(), []string{dir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each module is built on its own, so each needs its own version.
+	for _, name := range []string{"github.com/dep/x/@v/v1.0.0.zip", "github.com/dep/x/@v/v1.2.0.zip"} {
+		if _, err := os.Stat(filepath.Join(storeDir, name)); err != nil {
+			t.Errorf("expected %s to be saved: %v", name, err)
+		}
+	}
+}
+
+func TestSaveSkipsDefaultToolchain(t *testing.T) {
+	ts := newModuleProxy(t, map[string]string{
+		"github.com/dep/x@v1.0.0": "module github.com/dep/x\n\ngo 1.21\n",
+	})
+	goMod := "module example.com/a\n\ngo 1.21\n\ntoolchain default\n\nrequire github.com/dep/x v1.0.0\n"
+	tests := []struct {
+		name  string
+		files map[string]string
+		path  string
+	}{
+		{
+			name:  "go.mod",
+			files: map[string]string{"go.mod": goMod},
+			path:  "go.mod",
+		},
+		{
+			name:  "go.work",
+			files: map[string]string{"go.work": "go 1.21\n\ntoolchain default\n\nuse ./a\n", "a/go.mod": goMod},
+			path:  "go.work",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			storeDir := t.TempDir()
+			log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+			saver := New(log, storage.NewFileSystem(storeDir))
+			saver.SetProxyURL(ts.URL)
+			if err := saver.Save(context.Background(), []string{filepath.Join(dir, tt.path)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(storeDir, "github.com/dep/x/@v/v1.0.0.zip")); err != nil {
+				t.Errorf("expected github.com/dep/x v1.0.0 to be saved: %v", err)
+			}
+		})
+	}
+}
+
+func TestSavePrunesModuleGraph(t *testing.T) {
+	ts := newModuleProxy(t, map[string]string{
+		"github.com/dep/a@v1.0.0": "module github.com/dep/a\n\ngo 1.21\n\nrequire github.com/dep/b v1.0.0\n",
+		"github.com/dep/b@v1.0.0": "module github.com/dep/b\n\ngo 1.21\n\nrequire github.com/dep/c v1.0.0\n",
+		"github.com/dep/b@v1.1.0": "module github.com/dep/b\n\ngo 1.21\n",
+		"github.com/dep/c@v1.0.0": "module github.com/dep/c\n\ngo 1.21\n",
+		// Modules before go 1.17 aren't pruned, so all of their requirements are loaded.
+		"github.com/dep/old@v1.0.0": "module github.com/dep/old\n\ngo 1.16\n\nrequire github.com/dep/d v1.0.0\n",
+		"github.com/dep/d@v1.0.0":   "module github.com/dep/d\n\ngo 1.21\n\nrequire github.com/dep/e v1.0.0\n",
+		"github.com/dep/e@v1.0.0":   "module github.com/dep/e\n\ngo 1.21\n",
+	})
+
+	tests := []struct {
+		name      string
+		goVersion string
+		saved     []string
+		notSaved  []string
+	}{
+		{
+			name:      "the requirements of requirements of pruned modules aren't loaded",
+			goVersion: "1.21",
+			saved: []string{
+				"github.com/dep/a/@v/v1.0.0.zip",
+				"github.com/dep/b/@v/v1.0.0.mod",
+				"github.com/dep/b/@v/v1.1.0.zip",
+				"github.com/dep/old/@v/v1.0.0.zip",
+				"github.com/dep/d/@v/v1.0.0.zip",
+				"github.com/dep/e/@v/v1.0.0.zip",
+			},
+			notSaved: []string{
+				"github.com/dep/b/@v/v1.0.0.zip",
+				"github.com/dep/c/@v/v1.0.0.mod",
+				"github.com/dep/c/@v/v1.0.0.zip",
+			},
+		},
+		{
+			name:      "unpruned main modules load every requirement",
+			goVersion: "1.16",
+			saved: []string{
+				"github.com/dep/a/@v/v1.0.0.zip",
+				"github.com/dep/b/@v/v1.1.0.zip",
+				"github.com/dep/c/@v/v1.0.0.zip",
+				"github.com/dep/e/@v/v1.0.0.zip",
+			},
+			notSaved: []string{
+				"github.com/dep/b/@v/v1.0.0.zip",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"go.mod": "module example.com/root\n\ngo " + tt.goVersion + "\n\nrequire (\n" +
+					"\tgithub.com/dep/a v1.0.0\n\tgithub.com/dep/b v1.1.0\n\tgithub.com/dep/old v1.0.0\n)\n",
+			})
+
+			storeDir := t.TempDir()
+			log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+			saver := New(log, storage.NewFileSystem(storeDir))
+			saver.SetProxyURL(ts.URL)
+			if err := saver.Save(context.Background(), []string{filepath.Join(dir, "go.mod")}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, name := range tt.saved {
+				if _, err := os.Stat(filepath.Join(storeDir, name)); err != nil {
+					t.Errorf("expected %s to be saved: %v", name, err)
+				}
+			}
+			for _, name := range tt.notSaved {
+				if _, err := os.Stat(filepath.Join(storeDir, name)); err == nil {
+					t.Errorf("expected %s not to be saved", name)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveRejectsModulesCombinedWithFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/root\n\ngo 1.21\n"})
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	saver := New(log, storage.NewFileSystem(t.TempDir()))
+	if err := saver.Save(context.Background(), []string{filepath.Join(dir, "go.mod"), "github.com/foo/bar@v1.0.0"}); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -47,7 +47,7 @@ func newBufferedAccessLog(ctx context.Context, log *slog.Logger, accessLog *acce
 			case eventTypeWrite:
 				err = accessLog.Write(ctx, event.Filename)
 			case eventTypeDelete:
-				err = accessLog.Write(ctx, event.Filename)
+				err = accessLog.Delete(ctx, event.Filename)
 			default:
 				err = fmt.Errorf("unknown event type: %v", event.Type)
 			}
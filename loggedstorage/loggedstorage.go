@@ -52,3 +52,15 @@ func (ls *LoggedStorage) Put(ctx context.Context, filename string) (w io.WriteCl
 	ls.c <- newEvent(filename, eventTypeWrite)
 	return w, err
 }
+
+func (ls *LoggedStorage) Delete(ctx context.Context, filename string) (err error) {
+	if err = ls.wrapped.Delete(ctx, filename); err != nil {
+		return err
+	}
+	ls.c <- newEvent(filename, eventTypeDelete)
+	return nil
+}
+
+func (ls *LoggedStorage) List(ctx context.Context, prefix, startAfter string, limit int) (filenames []string, err error) {
+	return ls.wrapped.List(ctx, prefix, startAfter, limit)
+}
@@ -58,6 +58,10 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(token, "Bearer ") {
 		token = strings.TrimPrefix(token, "Bearer ")
 	}
+	// Tools that only support basic auth, e.g. twine, send the JWT token as the password.
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
 
 	// Verify JWT token.
 	keyFingerprint, err := auth.VerifyJWT(token, m.authConfig)
@@ -89,5 +93,5 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	m.log.Debug("authorized request", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.String("fingerprint", keyFingerprint), slog.String("permission", string(authorizedKey.Permission)))
-	m.next.ServeHTTP(w, r)
+	m.next.ServeHTTP(w, r.WithContext(auth.WithKeyFingerprint(r.Context(), keyFingerprint)))
 }
@@ -13,14 +13,15 @@ import (
 	narhandler "github.com/a-h/depot/nix/handlers/nar"
 	narinfohandler "github.com/a-h/depot/nix/handlers/narinfo"
 	nixcacheinfo "github.com/a-h/depot/nix/handlers/nixcacheinfo"
+	"github.com/a-h/depot/nix/upstream"
 	"github.com/a-h/depot/storage"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 )
 
-func New(log *slog.Logger, db *db.DB, storage storage.Storage, privateKey *signature.SecretKey, metrics metrics.Metrics) http.Handler {
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, privateKey *signature.SecretKey, upstream *upstream.Client, metrics metrics.Metrics) http.Handler {
 	nci := nixcacheinfo.New(log, privateKey)
-	nih := narinfohandler.New(log, db, privateKey, metrics)
-	nh := narhandler.New(log, storage, metrics)
+	nih := narinfohandler.New(log, db, privateKey, upstream, metrics)
+	nh := narhandler.New(log, storage, upstream, metrics)
 	lh := loghandler.New(log)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
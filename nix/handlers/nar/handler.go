@@ -1,6 +1,7 @@
 package nar
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,22 +10,27 @@ import (
 	"strings"
 
 	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/nix/upstream"
 	"github.com/a-h/depot/storage"
 	"github.com/nix-community/go-nix/pkg/nixbase32"
 )
 
-func New(log *slog.Logger, storage storage.Storage, metrics metrics.Metrics) Handler {
+// New creates a NAR handler. If upstream is non-nil, NAR files that are not in
+// storage are downloaded from the upstream substituters.
+func New(log *slog.Logger, storage storage.Storage, upstream *upstream.Client, metrics metrics.Metrics) Handler {
 	return Handler{
-		log:     log,
-		storage: storage,
-		metrics: metrics,
+		log:      log,
+		storage:  storage,
+		upstream: upstream,
+		metrics:  metrics,
 	}
 }
 
 type Handler struct {
-	log     *slog.Logger
-	storage storage.Storage
-	metrics metrics.Metrics
+	log      *slog.Logger
+	storage  storage.Storage
+	upstream *upstream.Client
+	metrics  metrics.Metrics
 }
 
 // getFileExtensionAndContentType extracts the file extension from the URL path
@@ -82,6 +88,25 @@ func (h *Handler) GetHead(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if !exists && h.upstream != nil {
+		if exists, err = h.upstream.DownloadNar(r.Context(), h.storage, narPath); err != nil {
+			h.log.Error("failed to download NAR file from upstream", slog.String("narPath", narPath), slog.String("hashPart", hashPart), slog.Any("error", err))
+			if errors.Is(err, upstream.ErrHashMismatch) {
+				http.Error(w, "upstream NAR file failed verification", http.StatusBadGateway)
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			h.log.Debug("downloaded NAR file from upstream", slog.String("narPath", narPath))
+			if size, exists, err = h.storage.Stat(r.Context(), narPath); err != nil {
+				h.log.Error("failed to stat NAR file", slog.String("narPath", narPath), slog.String("hashPart", hashPart), slog.Any("error", err))
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
 	if !exists {
 		h.log.Debug("NAR file not found", slog.String("narPath", narPath), slog.String("hashPart", hashPart))
 		http.Error(w, "NAR file not found", http.StatusNotFound)
@@ -141,7 +166,7 @@ func (h *Handler) Put(w http.ResponseWriter, r *http.Request) {
 
 	bytesWritten, err := io.Copy(file, r.Body)
 	if err != nil {
-		file.Close()
+		storage.Abort(file)
 		h.log.Error("failed to write NAR file", slog.String("hashPart", hashPart), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
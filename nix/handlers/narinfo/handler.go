@@ -1,6 +1,7 @@
 package narinfo
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -9,15 +10,19 @@ import (
 
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/nix/db"
+	"github.com/a-h/depot/nix/upstream"
 	"github.com/nix-community/go-nix/pkg/narinfo"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 )
 
-func New(log *slog.Logger, db *db.DB, privateKey *signature.SecretKey, metrics metrics.Metrics) Handler {
+// New creates a narinfo handler. If upstream is non-nil, narinfo files that are
+// not in the database are fetched from the upstream substituters.
+func New(log *slog.Logger, db *db.DB, privateKey *signature.SecretKey, upstream *upstream.Client, metrics metrics.Metrics) Handler {
 	return Handler{
 		log:        log,
 		db:         db,
 		privateKey: privateKey,
+		upstream:   upstream,
 		metrics:    metrics,
 	}
 }
@@ -26,6 +31,7 @@ type Handler struct {
 	log        *slog.Logger
 	db         *db.DB
 	privateKey *signature.SecretKey
+	upstream   *upstream.Client
 	metrics    metrics.Metrics
 }
 
@@ -49,6 +55,16 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	source := "cache"
+	if !ok && h.upstream != nil {
+		ni, ok, err = h.getFromUpstream(r.Context(), r.URL.Path)
+		if err != nil {
+			h.log.Error("failed to fetch narinfo from upstream", slog.String("path", r.URL.Path), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		source = "upstream"
+	}
 	if !ok {
 		http.Error(w, fmt.Sprintf("%s not found", r.URL.Path), http.StatusNotFound)
 		return
@@ -56,7 +72,7 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", ni.ContentType())
 
-	h.log.Debug(r.URL.String(), slog.String("storePath", ni.StorePath), slog.String("source", "cache"))
+	h.log.Debug(r.URL.String(), slog.String("storePath", ni.StorePath), slog.String("source", source))
 
 	output := ni.String()
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(output)))
@@ -90,14 +106,10 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If we have a private key, sign this narinfo.
-	if h.privateKey != nil {
-		sig, err := h.privateKey.Sign(nil, ni.Fingerprint())
-		if err != nil {
-			h.log.Error("failed to sign narinfo during upload", slog.String("path", r.URL.Path), slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
-		}
-		ni.Signatures = append(ni.Signatures, sig)
+	if err = h.sign(ni); err != nil {
+		h.log.Error("failed to sign narinfo during upload", slog.String("path", r.URL.Path), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	// Store the NAR info.
@@ -111,6 +123,37 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// getFromUpstream fetches a narinfo that is not in the database from the
+// upstream substituters, signs it with our key, and stores it so that
+// subsequent requests are served from the database.
+func (h Handler) getFromUpstream(ctx context.Context, narinfoPath string) (ni *narinfo.NarInfo, ok bool, err error) {
+	hashPart := strings.TrimSuffix(filepath.Base(narinfoPath), ".narinfo")
+	ni, ok, err = h.upstream.GetNarInfo(ctx, hashPart)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if err = h.sign(ni); err != nil {
+		return nil, false, fmt.Errorf("failed to sign narinfo: %w", err)
+	}
+	if err = h.db.PutNarInfo(ctx, narinfoPath, ni); err != nil {
+		return nil, false, fmt.Errorf("failed to store narinfo: %w", err)
+	}
+	return ni, true, nil
+}
+
+// sign adds a signature to the narinfo if a private key is configured.
+func (h Handler) sign(ni *narinfo.NarInfo) error {
+	if h.privateKey == nil {
+		return nil
+	}
+	sig, err := h.privateKey.Sign(nil, ni.Fingerprint())
+	if err != nil {
+		return err
+	}
+	ni.Signatures = append(ni.Signatures, sig)
+	return nil
+}
+
 func getHashPartFromStorePath(storePath string) string {
 	// Store paths are like /nix/store/abc123...-name
 	// We need to extract the hash part (abc123...)
@@ -3,9 +3,12 @@ package narinfo
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -13,7 +16,10 @@ import (
 
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/nix/db"
+	"github.com/a-h/depot/nix/upstream"
 	"github.com/a-h/depot/store"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 )
 
 //go:embed testdata/16hvpw4b3r05girazh4rnwbw0jgjkb4l.narinfo
@@ -33,7 +39,7 @@ func TestHandler(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create metrics: %v", err)
 	}
-	h := New(log, db.New(store), nil, metrics)
+	h := New(log, db.New(store), nil, nil, metrics)
 
 	t.Run("Get returns 404 if narinfo not found", func(t *testing.T) {
 		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/16hvpw4b3r05girazh4rnwbw0jgjkb4l.narinfo", nil)
@@ -63,3 +69,92 @@ func TestHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlerUpstream(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	ctx := context.Background()
+	store, closer, err := store.New(ctx, "sqlite", "file::memory:?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer closer()
+
+	metrics, err := metrics.New()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+
+	upstreamSecretKey, upstreamPublicKey, err := signature.GenerateKeypair("upstream-1", rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate upstream keypair: %v", err)
+	}
+	privateKey, publicKey, err := signature.GenerateKeypair("depot-1", rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate keypair: %v", err)
+	}
+
+	// Re-sign the test narinfo with the upstream key.
+	ni, err := narinfo.Parse(strings.NewReader(libGCCNarInfo))
+	if err != nil {
+		t.Fatalf("failed to parse narinfo: %v", err)
+	}
+	sig, err := upstreamSecretKey.Sign(nil, ni.Fingerprint())
+	if err != nil {
+		t.Fatalf("failed to sign narinfo: %v", err)
+	}
+	ni.Signatures = []signature.Signature{sig}
+	upstreamNarInfo := ni.String()
+
+	var upstreamRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+		if r.URL.Path != "/16hvpw4b3r05girazh4rnwbw0jgjkb4l.narinfo" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, upstreamNarInfo)
+	}))
+	defer ts.Close()
+
+	u := upstream.New(log, []string{ts.URL}, []signature.PublicKey{upstreamPublicKey})
+	h := New(log, db.New(store), &privateKey, u, metrics)
+
+	t.Run("Get fetches missing narinfo from upstream and signs it", func(t *testing.T) {
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/upstream/16hvpw4b3r05girazh4rnwbw0jgjkb4l.narinfo", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d with body:\n%s", http.StatusOK, w.Code, w.Body.String())
+		}
+		served, err := narinfo.Parse(w.Body)
+		if err != nil {
+			t.Fatalf("failed to parse served narinfo: %v", err)
+		}
+		if !signature.VerifyFirst(served.Fingerprint(), served.Signatures, []signature.PublicKey{upstreamPublicKey}) {
+			t.Error("expected served narinfo to keep the upstream signature")
+		}
+		if !signature.VerifyFirst(served.Fingerprint(), served.Signatures, []signature.PublicKey{publicKey}) {
+			t.Error("expected served narinfo to be signed with the depot key")
+		}
+	})
+	t.Run("Get serves previously fetched narinfo from the database", func(t *testing.T) {
+		before := upstreamRequests
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/upstream/16hvpw4b3r05girazh4rnwbw0jgjkb4l.narinfo", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code %d, got %d with body:\n%s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if upstreamRequests != before {
+			t.Errorf("expected no upstream requests, got %d", upstreamRequests-before)
+		}
+	})
+	t.Run("Get returns 404 if narinfo is not found upstream", func(t *testing.T) {
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/upstream/00000000000000000000000000000000.narinfo", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status code %d, got %d with body:\n%s", http.StatusNotFound, w.Code, w.Body.String())
+		}
+	})
+}
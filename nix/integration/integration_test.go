@@ -148,7 +148,7 @@ func (ts *testServer) start(t *testing.T) {
 	// Create HTTP server.
 	ts.server = &http.Server{
 		Addr:    ":8080",
-		Handler: handlers.New(log, db.New(store), storage, &privateKey, metrics),
+		Handler: handlers.New(log, db.New(store), storage, &privateKey, nil, metrics),
 	}
 
 	// Start server in goroutine.
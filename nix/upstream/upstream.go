@@ -0,0 +1,171 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/a-h/depot/storage"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+)
+
+// ErrHashMismatch is returned when a NAR file downloaded from a substituter
+// does not match the hash in its filename.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// Client fetches narinfo and NAR files from upstream substituters, such as
+// https://cache.nixos.org.
+type Client struct {
+	log          *slog.Logger
+	client       *http.Client
+	substituters []string
+	trustedKeys  []signature.PublicKey
+}
+
+// New creates a new Client. Substituters are queried in order, and narinfo
+// files are only accepted if they are signed by one of the trusted keys.
+func New(log *slog.Logger, substituters []string, trustedKeys []signature.PublicKey) *Client {
+	urls := make([]string, len(substituters))
+	for i, s := range substituters {
+		urls[i] = strings.TrimSuffix(s, "/")
+	}
+	return &Client{
+		log:          log,
+		client:       &http.Client{Timeout: 5 * time.Minute},
+		substituters: urls,
+		trustedKeys:  trustedKeys,
+	}
+}
+
+// GetNarInfo fetches the narinfo for the hash part of a store path from the
+// first substituter that has it. Narinfo files that fail verification are
+// skipped.
+func (c *Client) GetNarInfo(ctx context.Context, hashPart string) (ni *narinfo.NarInfo, ok bool, err error) {
+	for _, substituter := range c.substituters {
+		ni, ok, err = c.getNarInfo(ctx, substituter, hashPart)
+		if err != nil {
+			c.log.Warn("failed to get narinfo from substituter", slog.String("substituter", substituter), slog.String("hashPart", hashPart), slog.Any("error", err))
+			continue
+		}
+		if ok {
+			return ni, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (c *Client) getNarInfo(ctx context.Context, substituter, hashPart string) (ni *narinfo.NarInfo, ok bool, err error) {
+	body, ok, err := c.get(ctx, substituter+"/"+hashPart+".narinfo")
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	defer body.Close()
+
+	ni, err = narinfo.Parse(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse narinfo: %w", err)
+	}
+	if err = ni.Check(); err != nil {
+		return nil, false, fmt.Errorf("invalid narinfo: %w", err)
+	}
+	if actual, _, _ := strings.Cut(path.Base(ni.StorePath), "-"); actual != hashPart {
+		return nil, false, fmt.Errorf("narinfo store path %q does not match hash part %q", ni.StorePath, hashPart)
+	}
+	if !signature.VerifyFirst(ni.Fingerprint(), ni.Signatures, c.trustedKeys) {
+		return nil, false, fmt.Errorf("narinfo for %q is not signed by a trusted key", ni.StorePath)
+	}
+	return ni, true, nil
+}
+
+// DownloadNar copies the NAR file at narPath (e.g. nar/<filehash>.nar.xz) from
+// the first substituter that has it into storage. If the file hash in the name
+// is a nixbase32 encoded sha256 hash, the content is verified against it, and
+// nothing is stored if it does not match.
+//
+// If no substituter has the NAR file, and any substituter failed, the last
+// error is returned, e.g. an error wrapping ErrHashMismatch.
+func (c *Client) DownloadNar(ctx context.Context, s storage.Storage, narPath string) (ok bool, err error) {
+	var lastErr error
+	for _, substituter := range c.substituters {
+		ok, err = c.downloadNar(ctx, s, substituter, narPath)
+		if err != nil {
+			c.log.Warn("failed to download NAR from substituter", slog.String("substituter", substituter), slog.String("narPath", narPath), slog.Any("error", err))
+			lastErr = err
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+func (c *Client) downloadNar(ctx context.Context, s storage.Storage, substituter, narPath string) (ok bool, err error) {
+	body, ok, err := c.get(ctx, substituter+"/"+narPath)
+	if err != nil || !ok {
+		return ok, err
+	}
+	defer body.Close()
+
+	f, err := s.Put(ctx, narPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to create storage file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			storage.Abort(f)
+			return
+		}
+		err = f.Close()
+	}()
+
+	fileHash, _, _ := strings.Cut(path.Base(narPath), ".")
+	var h hash.Hash
+	if len(fileHash) == nixbase32.EncodedLen(sha256.Size) {
+		h = sha256.New()
+	}
+
+	w := io.Writer(f)
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	if _, err = io.Copy(w, body); err != nil {
+		return false, fmt.Errorf("failed to write NAR to storage: %w", err)
+	}
+	if h != nil {
+		if actual := nixbase32.EncodeToString(h.Sum(nil)); actual != fileHash {
+			return false, fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, fileHash, actual)
+		}
+	}
+	return true, nil
+}
+
+func (c *Client) get(ctx context.Context, url string) (body io.ReadCloser, ok bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, true, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		resp.Body.Close()
+		return nil, false, nil
+	}
+	resp.Body.Close()
+	return nil, false, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+}
@@ -0,0 +1,206 @@
+package upstream
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/a-h/depot/storage"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+)
+
+const storePathHash = "16hvpw4b3r05girazh4rnwbw0jgjkb4l"
+
+func newSignedNarInfo(t *testing.T, sk signature.SecretKey, nar []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(nar)
+	fileHash := nixbase32.EncodeToString(sum[:])
+	text := fmt.Sprintf(`StorePath: /nix/store/%s-hello
+URL: nar/%s.nar
+Compression: none
+FileHash: sha256:%s
+FileSize: %d
+NarHash: sha256:%s
+NarSize: %d
+`, storePathHash, fileHash, fileHash, len(nar), fileHash, len(nar))
+	ni, err := narinfo.Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("failed to parse narinfo: %v", err)
+	}
+	sig, err := sk.Sign(nil, ni.Fingerprint())
+	if err != nil {
+		t.Fatalf("failed to sign narinfo: %v", err)
+	}
+	ni.Signatures = append(ni.Signatures, sig)
+	return ni.String()
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	trustedSecretKey, trustedPublicKey, err := signature.GenerateKeypair("upstream-1", rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate keypair: %v", err)
+	}
+	untrustedSecretKey, _, err := signature.GenerateKeypair("untrusted-1", rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate keypair: %v", err)
+	}
+
+	nar := []byte("nar content")
+	sum := sha256.Sum256(nar)
+	narPath := "nar/" + nixbase32.EncodeToString(sum[:]) + ".nar"
+
+	untrustedNarInfo := newSignedNarInfo(t, untrustedSecretKey, nar)
+	trustedNarInfo := newSignedNarInfo(t, trustedSecretKey, nar)
+
+	untrusted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + storePathHash + ".narinfo":
+			io.WriteString(w, untrustedNarInfo)
+		case "/" + narPath:
+			io.WriteString(w, "tampered content")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer untrusted.Close()
+	trusted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + storePathHash + ".narinfo":
+			io.WriteString(w, trustedNarInfo)
+		case "/" + narPath:
+			w.Write(nar)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer trusted.Close()
+
+	t.Run("narinfo signed by a trusted key is returned", func(t *testing.T) {
+		c := New(log, []string{trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		ni, ok, err := c.GetNarInfo(ctx, storePathHash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected narinfo to be found")
+		}
+		if ni.StorePath != "/nix/store/"+storePathHash+"-hello" {
+			t.Errorf("unexpected store path %q", ni.StorePath)
+		}
+	})
+	t.Run("narinfo not signed by a trusted key is skipped", func(t *testing.T) {
+		c := New(log, []string{untrusted.URL}, []signature.PublicKey{trustedPublicKey})
+		_, ok, err := c.GetNarInfo(ctx, storePathHash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			t.Error("expected untrusted narinfo to be rejected")
+		}
+	})
+	t.Run("substituters are tried in order", func(t *testing.T) {
+		c := New(log, []string{untrusted.URL, trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		_, ok, err := c.GetNarInfo(ctx, storePathHash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Error("expected narinfo to be found in second substituter")
+		}
+	})
+	t.Run("missing narinfo is not found", func(t *testing.T) {
+		c := New(log, []string{trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		_, ok, err := c.GetNarInfo(ctx, "00000000000000000000000000000000")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			t.Error("expected narinfo not to be found")
+		}
+	})
+	t.Run("NAR is downloaded to storage", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		c := New(log, []string{trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		ok, err := c.DownloadNar(ctx, s, narPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Fatal("expected NAR to be found")
+		}
+		r, exists, err := s.Get(ctx, narPath)
+		if err != nil || !exists {
+			t.Fatalf("expected NAR in storage, exists=%v, err=%v", exists, err)
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read NAR: %v", err)
+		}
+		if string(content) != string(nar) {
+			t.Errorf("expected %q, got %q", nar, content)
+		}
+	})
+	t.Run("NAR with mismatched hash is skipped if another substituter has it", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		c := New(log, []string{untrusted.URL, trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		ok, err := c.DownloadNar(ctx, s, narPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ok {
+			t.Error("expected NAR to be found in second substituter")
+		}
+	})
+	t.Run("NAR with mismatched hash returns an error", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		c := New(log, []string{untrusted.URL}, []signature.PublicKey{trustedPublicKey})
+		ok, err := c.DownloadNar(ctx, s, narPath)
+		if !errors.Is(err, ErrHashMismatch) {
+			t.Fatalf("expected hash mismatch error, got %v", err)
+		}
+		if ok {
+			t.Error("expected NAR not to be found")
+		}
+	})
+	t.Run("missing NAR is not found", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		c := New(log, []string{trusted.URL}, []signature.PublicKey{trustedPublicKey})
+		ok, err := c.DownloadNar(ctx, s, "nar/missing.nar")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			t.Error("expected NAR not to be found")
+		}
+	})
+	t.Run("NAR with mismatched hash is not stored", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		c := New(log, []string{untrusted.URL}, []signature.PublicKey{trustedPublicKey})
+		_, err := c.downloadNar(ctx, s, untrusted.URL, narPath)
+		if !errors.Is(err, ErrHashMismatch) {
+			t.Fatalf("expected hash mismatch error, got %v", err)
+		}
+		_, exists, err := s.Stat(ctx, narPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected NAR with mismatched hash not to be stored")
+		}
+	})
+}
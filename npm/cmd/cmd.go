@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"strings"
 
 	"github.com/a-h/depot/cmd/globals"
+	"github.com/a-h/depot/npm/download"
 	"github.com/a-h/depot/npm/pkglock"
 	npmpush "github.com/a-h/depot/npm/push"
 	"github.com/a-h/depot/npm/save"
@@ -19,9 +19,14 @@ type NPMCmd struct {
 }
 
 type Save struct {
-	Dir      string   `help:"Directory to save packages to" default:".depot-storage/npm" env:"DEPOT_NPM_DIR"`
-	Packages []string `arg:"" help:"Package names to save (format: package@version or ./path/to/package-lock.json)" default:"./package-lock.json"`
-	Stdin    bool     `help:"Read package list from stdin" default:"false"`
+	Dir             string   `help:"Directory to save packages to" default:".depot-storage/npm" env:"DEPOT_NPM_DIR"`
+	Packages        []string `arg:"" help:"Package names to save (format: package@version, or the path to a package-lock.json, yarn.lock or pnpm-lock.yaml file)" default:"./package-lock.json"`
+	Stdin           bool     `help:"Read package list from stdin" default:"false"`
+	IncludeOptional bool     `help:"Save optional dependencies, e.g. platform-specific binaries" default:"false"`
+	IncludePeer     bool     `help:"Save peer dependencies" default:"false"`
+	OS              []string `help:"Only save optional dependencies that support these operating systems (e.g. linux, darwin, win32)"`
+	CPU             []string `help:"Only save optional dependencies that support these CPU architectures (e.g. x64, arm64)"`
+	Libc            []string `help:"Only save optional dependencies that support these C libraries (glibc or musl)"`
 }
 
 func (cmd *Save) Run(globals *globals.Globals) error {
@@ -35,22 +40,18 @@ func (cmd *Save) Run(globals *globals.Globals) error {
 	defer stop()
 	storage := storage.NewFileSystem(cmd.Dir)
 	saver := save.New(log, storage)
+	saver.SetOptions(download.Options{
+		Optional: cmd.IncludeOptional,
+		Peer:     cmd.IncludePeer,
+		Platform: download.Platform{OS: cmd.OS, CPU: cmd.CPU, Libc: cmd.Libc},
+	})
 
 	if cmd.Stdin {
 		return saver.SaveFromReader(ctx, os.Stdin)
 	}
 
-	if len(cmd.Packages) == 1 && strings.HasSuffix(cmd.Packages[0], "package-lock.json") {
-		f, err := os.Open(cmd.Packages[0])
-		if err != nil {
-			return fmt.Errorf("failed to open package-lock.json: %w", err)
-		}
-		defer f.Close()
-		pkgs, err := pkglock.Parse(ctx, f)
-		if err != nil {
-			return fmt.Errorf("failed to parse package-lock.json: %w", err)
-		}
-		cmd.Packages = pkgs
+	if len(cmd.Packages) == 1 && pkglock.IsLockfile(cmd.Packages[0]) {
+		return saver.SaveFromLockfile(ctx, cmd.Packages[0])
 	}
 
 	if len(cmd.Packages) == 0 {
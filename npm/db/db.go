@@ -1,10 +1,17 @@
 package db
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"net/url"
 	"path"
+	"slices"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/a-h/depot/npm/models"
 	"github.com/a-h/kv"
 )
@@ -17,6 +24,12 @@ type DB struct {
 	store kv.Store
 }
 
+// buildPackageKey builds a database key for package level metadata, such as dist-tags.
+// The key doesn't end with a slash, so it isn't returned by prefix queries for versions.
+func (d *DB) buildPackageKey(packageName string) string {
+	return path.Join("/npm", url.PathEscape(packageName))
+}
+
 // buildVersionKey builds a database key for version metadata.
 func (d *DB) buildVersionKey(packageName, version string) string {
 	encodedName := url.PathEscape(packageName)
@@ -24,85 +37,432 @@ func (d *DB) buildVersionKey(packageName, version string) string {
 	return path.Join("/npm", encodedName, encodedVersion)
 }
 
-// GetPackageVersion retrieves specific version metadata.
-func (d *DB) GetPackageVersion(ctx context.Context, packageName, version string) (metadata models.AbbreviatedVersion, ok bool, err error) {
+// getPackageRecord retrieves the package level metadata, e.g. dist-tags, time
+// and readme. The versions are stored separately. The version of the record is
+// 0 if it doesn't exist.
+func (d *DB) getPackageRecord(ctx context.Context, packageName string) (record models.Package, version int, err error) {
+	r, _, err := d.store.Get(ctx, d.buildPackageKey(packageName), &record)
+	if err != nil {
+		return record, 0, err
+	}
+	if record.DistTags == nil {
+		record.DistTags = map[string]string{}
+	}
+	if record.Time == nil {
+		record.Time = map[string]time.Time{}
+	}
+	return record, r.Version, nil
+}
+
+// maxUpdateAttempts is the number of times that an update of a package record
+// is attempted if the record is modified concurrently.
+const maxUpdateAttempts = 10
+
+// updatePackageRecord applies update to the package level metadata of a
+// package, and saves it if it hasn't been modified since it was read, e.g. by
+// a concurrent publish. If it has, the update is applied to the latest record.
+func (d *DB) updatePackageRecord(ctx context.Context, packageName string, update func(record *models.Package)) error {
+	for range maxUpdateAttempts {
+		record, version, err := d.getPackageRecord(ctx, packageName)
+		if err != nil {
+			return err
+		}
+		update(&record)
+		record.Versions = nil
+		err = d.store.Put(ctx, d.buildPackageKey(packageName), version, record)
+		if errors.Is(err, kv.ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return d.updateSummary(ctx, packageName)
+	}
+	return fmt.Errorf("failed to update package %s after %d attempts: %w", packageName, maxUpdateAttempts, kv.ErrVersionMismatch)
+}
+
+// buildSummaryKey builds a database key for the search summary of a package.
+// It's outside the /npm/ prefix, so that summaries can be listed without reading every version.
+func (d *DB) buildSummaryKey(packageName string) string {
+	return path.Join("/npm-search", url.PathEscape(packageName))
+}
+
+// updateSummary rebuilds the search summary of a package from its latest
+// version. It's called whenever the package record changes, so that the
+// summary is rebuilt after versions and dist-tags are saved or deleted.
+func (d *DB) updateSummary(ctx context.Context, packageName string) error {
+	key := d.buildSummaryKey(packageName)
+	for range maxUpdateAttempts {
+		// Read the version of the summary before the package, so that a summary
+		// built from an older package can't overwrite a newer one.
+		var existing models.PackageSummary
+		r, _, err := d.store.Get(ctx, key, &existing)
+		if err != nil {
+			return err
+		}
+		pkg, ok, err := d.GetPackage(ctx, packageName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Dist-tags can be saved before any versions are.
+			return nil
+		}
+		err = d.store.Put(ctx, key, r.Version, newSummary(pkg))
+		if errors.Is(err, kv.ErrVersionMismatch) {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("failed to update search summary of %s after %d attempts: %w", packageName, maxUpdateAttempts, kv.ErrVersionMismatch)
+}
+
+// newSummary summarises a package using the metadata of the version tagged as
+// latest, or the highest version, falling back to the package level metadata.
+func newSummary(pkg models.Package) models.PackageSummary {
+	v, ok := pkg.Versions[pkg.DistTags["latest"]]
+	if !ok {
+		v = pkg.Versions[highestVersion(slices.Collect(maps.Keys(pkg.Versions)))]
+	}
+	keywords := v.Keywords
+	if len(keywords) == 0 {
+		keywords = pkg.Keywords
+	}
+	return models.PackageSummary{
+		Name:        pkg.Name,
+		Version:     v.Version,
+		Description: cmp.Or(v.Description, pkg.Description),
+		Keywords:    keywords,
+		Publisher:   v.NpmUser,
+	}
+}
+
+// ListSummaries retrieves the search summaries of all packages.
+func (d *DB) ListSummaries(ctx context.Context) (summaries []models.PackageSummary, err error) {
+	records, err := d.store.GetPrefix(ctx, "/npm-search/", 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return kv.ValuesOf[models.PackageSummary](records)
+}
+
+// GetPackageVersion retrieves specific version metadata. The version may be a dist-tag.
+func (d *DB) GetPackageVersion(ctx context.Context, packageName, version string) (metadata models.Version, ok bool, err error) {
 	key := d.buildVersionKey(packageName, version)
 	_, ok, err = d.store.Get(ctx, key, &metadata)
 	if err != nil {
-		return models.AbbreviatedVersion{}, false, err
+		return models.Version{}, false, err
 	}
-	if !ok {
-		return models.AbbreviatedVersion{}, false, nil
+	if ok {
+		return metadata, true, nil
+	}
+	tags, err := d.GetDistTags(ctx, packageName)
+	if err != nil {
+		return models.Version{}, false, err
+	}
+	tagged, isTag := tags[version]
+	if !isTag {
+		return models.Version{}, false, nil
+	}
+	_, ok, err = d.store.Get(ctx, d.buildVersionKey(packageName, tagged), &metadata)
+	if err != nil || !ok {
+		return models.Version{}, false, err
 	}
 	return metadata, true, nil
 }
 
 // GetPackage retrieves complete package metadata with all versions.
-// This builds the AbbreviatedMetadata format that NPM clients expect.
-func (d *DB) GetPackage(ctx context.Context, packageName string) (metadata models.AbbreviatedPackage, ok bool, err error) {
+// Use Abbreviated to get the format that NPM clients use to install packages.
+func (d *DB) GetPackage(ctx context.Context, packageName string) (metadata models.Package, ok bool, err error) {
 	encodedName := url.PathEscape(packageName)
 	prefix := path.Join("/npm", encodedName) + "/"
 
 	records, err := d.store.GetPrefix(ctx, prefix, 0, -1)
 	if err != nil {
-		return models.AbbreviatedPackage{}, false, err
+		return models.Package{}, false, err
 	}
 
 	if len(records) == 0 {
-		return models.AbbreviatedPackage{}, false, nil
+		return models.Package{}, false, nil
 	}
 
-	allVersions, err := kv.ValuesOf[models.AbbreviatedVersion](records)
+	record, _, err := d.getPackageRecord(ctx, packageName)
 	if err != nil {
-		return models.AbbreviatedPackage{}, false, err
+		return models.Package{}, false, err
 	}
+	return newPackage(packageName, records, record)
+}
 
-	if len(allVersions) == 0 {
-		return models.AbbreviatedPackage{}, false, nil
+// newPackage builds package metadata from the version records and package record of a package.
+func newPackage(packageName string, records []kv.Record, record models.Package) (metadata models.Package, ok bool, err error) {
+	allVersions, err := kv.ValuesOf[models.Version](records)
+	if err != nil {
+		return models.Package{}, false, err
 	}
 
-	var latestVersion string
-	for i, r := range records {
-		if path.Base(r.Key) == "latest" {
-			latestVersion = allVersions[i].Version
-		}
+	if len(allVersions) == 0 {
+		return models.Package{}, false, nil
 	}
-	versions := make(map[string]models.AbbreviatedVersion, len(allVersions))
-	for _, v := range allVersions {
+
+	versions := make(map[string]models.Version, len(allVersions))
+	for i, v := range allVersions {
 		versions[v.Version] = v
+		// Versions pushed before dist-tags were stored separately have a copy
+		// of the tagged version stored under the tag name.
+		if tag := path.Base(records[i].Key); tag != url.PathEscape(v.Version) {
+			if _, exists := record.DistTags[tag]; !exists {
+				record.DistTags[tag] = v.Version
+			}
+		}
 	}
 
 	// Build complete package metadata.
-	packageMetadata := models.AbbreviatedPackage{
-		Name:     packageName,
-		Versions: versions,
-		DistTags: map[string]string{
-			"latest": latestVersion,
-		},
+	metadata = record
+	metadata.ID = packageName
+	metadata.Name = packageName
+	metadata.Versions = versions
+	return metadata, true, nil
+}
+
+// PutPackageVersion saves specific version metadata, and records the time
+// that the version was published if it isn't already known.
+func (d *DB) PutPackageVersion(ctx context.Context, packageName, version string, metadata models.Version) error {
+	key := d.buildVersionKey(packageName, version)
+	if err := d.store.Put(ctx, key, -1, metadata); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	return d.updatePackageRecord(ctx, packageName, func(record *models.Package) {
+		if _, ok := record.Time[version]; !ok {
+			record.Time[version] = now
+		}
+		if _, ok := record.Time["created"]; !ok {
+			record.Time["created"] = now
+		}
+		record.Time["modified"] = now
+	})
+}
+
+// MergePackage adds the versions, dist-tags and times of a package fetched
+// from an upstream registry to the database. Stored versions are never
+// replaced, and versions in the previous upstream check aren't added again,
+// so versions that have since been unpublished from depot stay unpublished.
+// Dist-tags are only added or moved if they haven't been changed in depot
+// since the previous check. Package level metadata, e.g. the readme, is only
+// set if the package has none.
+func (d *DB) MergePackage(ctx context.Context, metadata models.Package, previous UpstreamCheck) error {
+	for version, versionMetadata := range metadata.Versions {
+		if slices.Contains(previous.Versions, version) {
+			continue
+		}
+		err := d.store.Put(ctx, d.buildVersionKey(metadata.Name, version), 0, versionMetadata)
+		if errors.Is(err, kv.ErrVersionMismatch) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save version %s: %w", version, err)
+		}
+	}
+	return d.updatePackageRecord(ctx, metadata.Name, func(record *models.Package) {
+		tags, times := record.DistTags, record.Time
+		for tag, version := range metadata.DistTags {
+			if current, ok := tags[tag]; !ok || current == previous.DistTags[tag] {
+				tags[tag] = version
+			}
+		}
+		for key, t := range metadata.Time {
+			if _, ok := times[key]; !ok {
+				times[key] = t
+			}
+		}
+		if record.Name == "" {
+			*record = metadata
+		}
+		record.DistTags, record.Time = tags, times
+	})
+}
+
+// UpstreamCheck records when a package was last checked for changes in the upstream registry.
+type UpstreamCheck struct {
+	Checked time.Time `json:"checked"`
+	// ETag is the ETag of the last response from the upstream registry, if it sent one.
+	ETag string `json:"etag,omitempty"`
+	// Versions are the versions that the upstream registry has listed.
+	Versions []string `json:"versions,omitempty"`
+	// DistTags are the dist-tags of the last response from the upstream registry.
+	DistTags map[string]string `json:"distTags,omitempty"`
+}
+
+// buildUpstreamCheckKey builds a database key for the upstream check of a package.
+// It's outside the /npm/ prefix, so it isn't returned by package queries.
+func (d *DB) buildUpstreamCheckKey(packageName string) string {
+	return path.Join("/npm-upstream", url.PathEscape(packageName))
+}
+
+// GetUpstreamCheck retrieves when a package was last checked for changes in the upstream registry.
+func (d *DB) GetUpstreamCheck(ctx context.Context, packageName string) (check UpstreamCheck, ok bool, err error) {
+	_, ok, err = d.store.Get(ctx, d.buildUpstreamCheckKey(packageName), &check)
+	return check, ok, err
+}
+
+// PutUpstreamCheck records when a package was last checked for changes in the upstream registry.
+func (d *DB) PutUpstreamCheck(ctx context.Context, packageName string, check UpstreamCheck) error {
+	return d.store.Put(ctx, d.buildUpstreamCheckKey(packageName), -1, check)
+}
+
+// buildPublishedKey builds a database key for the time that a version of a package was last published to depot.
+// It's outside the /npm/ prefix, so it isn't returned by package queries.
+func (d *DB) buildPublishedKey(packageName string) string {
+	return path.Join("/npm-published", url.PathEscape(packageName))
+}
+
+// PublishPackageVersion saves version metadata that was published to depot,
+// rather than fetched from the upstream registry, see PutPackageVersion.
+func (d *DB) PublishPackageVersion(ctx context.Context, packageName, version string, metadata models.Version) error {
+	if err := d.store.Put(ctx, d.buildPublishedKey(packageName), -1, time.Now().UTC()); err != nil {
+		return err
 	}
+	return d.PutPackageVersion(ctx, packageName, version, metadata)
+}
 
-	return packageMetadata, true, nil
+// HasPublishedVersions returns true if a version of the package has been
+// published to depot. Depot is authoritative for these packages, so they
+// aren't refreshed from the upstream registry.
+func (d *DB) HasPublishedVersions(ctx context.Context, packageName string) (ok bool, err error) {
+	var published time.Time
+	_, ok, err = d.store.Get(ctx, d.buildPublishedKey(packageName), &published)
+	return ok, err
 }
 
-// PutPackageVersion saves specific version metadata.
-func (d *DB) PutPackageVersion(ctx context.Context, packageName, version string, metadata models.AbbreviatedVersion) error {
-	key := d.buildVersionKey(packageName, version)
-	return d.store.Put(ctx, key, -1, metadata)
+// PutPackageMetadata saves the package level metadata of a package, e.g. the
+// readme and description. Existing dist-tags are kept, and times are merged.
+// Versions are not saved.
+func (d *DB) PutPackageMetadata(ctx context.Context, metadata models.Package) error {
+	return d.updatePackageRecord(ctx, metadata.Name, func(record *models.Package) {
+		tags, times := record.DistTags, record.Time
+		maps.Copy(times, metadata.Time)
+		*record = metadata
+		record.DistTags, record.Time = tags, times
+	})
+}
+
+// GetDistTags retrieves the dist-tags of a package. The map is empty if the package has no tags.
+func (d *DB) GetDistTags(ctx context.Context, packageName string) (tags map[string]string, err error) {
+	record, _, err := d.getPackageRecord(ctx, packageName)
+	if err != nil {
+		return nil, err
+	}
+	return record.DistTags, nil
+}
+
+// PutDistTags replaces all of the dist-tags of a package.
+func (d *DB) PutDistTags(ctx context.Context, packageName string, tags map[string]string) error {
+	return d.updatePackageRecord(ctx, packageName, func(record *models.Package) {
+		record.DistTags = maps.Clone(tags)
+	})
 }
 
-// DeletePackage deletes all versions of a package.
+// PutDistTag points a dist-tag at a version, adding the tag if it doesn't exist.
+func (d *DB) PutDistTag(ctx context.Context, packageName, tag, version string) error {
+	return d.updatePackageRecord(ctx, packageName, func(record *models.Package) {
+		record.DistTags[tag] = version
+	})
+}
+
+// DeleteDistTag removes a dist-tag from a package.
+func (d *DB) DeleteDistTag(ctx context.Context, packageName, tag string) error {
+	if _, err := d.store.Delete(ctx, d.buildVersionKey(packageName, tag)); err != nil {
+		return err
+	}
+	return d.updatePackageRecord(ctx, packageName, func(record *models.Package) {
+		delete(record.DistTags, tag)
+	})
+}
+
+// DeletePackage deletes all versions, dist-tags and package level metadata of a package,
+// its search summary, and when it was last checked in the upstream registry or published.
 func (d *DB) DeletePackage(ctx context.Context, packageName string) error {
 	encodedName := url.PathEscape(packageName)
 	prefix := path.Join("/npm", encodedName) + "/"
 	if _, err := d.store.DeletePrefix(ctx, prefix, 0, -1); err != nil {
 		return err
 	}
+	if _, err := d.store.Delete(ctx, d.buildPackageKey(packageName)); err != nil {
+		return err
+	}
+	if _, err := d.store.Delete(ctx, d.buildSummaryKey(packageName)); err != nil {
+		return err
+	}
+	if _, err := d.store.Delete(ctx, d.buildUpstreamCheckKey(packageName)); err != nil {
+		return err
+	}
+	if _, err := d.store.Delete(ctx, d.buildPublishedKey(packageName)); err != nil {
+		return err
+	}
 	return nil
 }
 
-// DeletePackageVersion deletes a specific version of a package.
+// DeletePackageVersion deletes a specific version of a package, and any dist-tags
+// that point at it. If the latest tag pointed at the version, it's moved to the
+// highest remaining version. If no versions remain, the package is deleted.
 func (d *DB) DeletePackageVersion(ctx context.Context, packageName, version string) error {
-	key := d.buildVersionKey(packageName, version)
-	_, err := d.store.Delete(ctx, key)
-	return err
+	// Versions pushed before dist-tags were stored separately may also be stored under tag names.
+	prefix := path.Join("/npm", url.PathEscape(packageName)) + "/"
+	records, err := d.store.GetPrefix(ctx, prefix, 0, -1)
+	if err != nil {
+		return err
+	}
+	versions, err := kv.ValuesOf[models.Version](records)
+	if err != nil {
+		return err
+	}
+	remaining := make(map[string]models.Version, len(versions))
+	for i, v := range versions {
+		if v.Version != version {
+			remaining[v.Version] = v
+			continue
+		}
+		if _, err := d.store.Delete(ctx, records[i].Key); err != nil {
+			return err
+		}
+	}
+	if len(remaining) == 0 {
+		return d.DeletePackage(ctx, packageName)
+	}
+
+	return d.updatePackageRecord(ctx, packageName, func(record *models.Package) {
+		maps.DeleteFunc(record.DistTags, func(_, v string) bool { return v == version })
+		if _, ok := record.DistTags["latest"]; !ok {
+			record.DistTags["latest"] = highestVersion(slices.Collect(maps.Keys(remaining)))
+		}
+		delete(record.Time, version)
+		record.Time["modified"] = time.Now().UTC()
+	})
+}
+
+// highestVersion returns the highest of the versions, preferring versions that
+// aren't prereleases, as npm does when it picks the latest version.
+func highestVersion(versions []string) (highest string) {
+	var highestVersion *semver.Version
+	for _, version := range versions {
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+		if highestVersion == nil || isHigherVersion(v, highestVersion) {
+			highest, highestVersion = version, v
+		}
+	}
+	if highest == "" && len(versions) > 0 {
+		return slices.Max(versions)
+	}
+	return highest
+}
+
+func isHigherVersion(v, than *semver.Version) bool {
+	isStable, thanIsStable := v.Prerelease() == "", than.Prerelease() == ""
+	if isStable != thanIsStable {
+		return isStable
+	}
+	return v.GreaterThan(than)
 }
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/a-h/depot/npm/models"
+	"github.com/a-h/depot/store"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	name := strings.ReplaceAll(t.Name(), "/", "_")
+	s, closer, err := store.New(context.Background(), "sqlite", "file:"+name+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() { closer() })
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatalf("failed to init store: %v", err)
+	}
+	return New(s)
+}
+
+func TestUpdatePackageRecordRetriesConcurrentUpdates(t *testing.T) {
+	tests := []struct {
+		name         string
+		existingTags map[string]string
+		expectedTags map[string]string
+	}{
+		{
+			name:         "new package",
+			expectedTags: map[string]string{"latest": "1.0.0", "next": "2.0.0"},
+		},
+		{
+			name:         "existing package",
+			existingTags: map[string]string{"beta": "0.9.0"},
+			expectedTags: map[string]string{"beta": "0.9.0", "latest": "1.0.0", "next": "2.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newTestDB(t)
+			for tag, version := range tt.existingTags {
+				if err := d.PutDistTag(ctx, "pkg", tag, version); err != nil {
+					t.Fatalf("failed to put dist-tag: %v", err)
+				}
+			}
+
+			var attempts int
+			err := d.updatePackageRecord(ctx, "pkg", func(record *models.Package) {
+				attempts++
+				if attempts == 1 {
+					// Another update happens between reading and writing the record.
+					if err := d.PutDistTag(ctx, "pkg", "next", "2.0.0"); err != nil {
+						t.Fatalf("failed to put dist-tag: %v", err)
+					}
+				}
+				record.DistTags["latest"] = "1.0.0"
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if attempts != 2 {
+				t.Errorf("expected 2 attempts, got %d", attempts)
+			}
+			tags, err := d.GetDistTags(ctx, "pkg")
+			if err != nil {
+				t.Fatalf("failed to get dist-tags: %v", err)
+			}
+			if !maps.Equal(tags, tt.expectedTags) {
+				t.Errorf("expected dist-tags %v, got %v", tt.expectedTags, tags)
+			}
+		})
+	}
+}
+
+func TestMergePackage(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDB(t)
+
+	newPackage := func(readme, integrity string, tags map[string]string, versions ...string) models.Package {
+		pkg := models.Package{Name: "pkg", DistTags: tags, Versions: map[string]models.Version{}, Readme: readme}
+		for _, v := range versions {
+			pkg.Versions[v] = models.Version{AbbreviatedVersion: models.AbbreviatedVersion{
+				Name:    "pkg",
+				Version: v,
+				Dist:    &models.Dist{Integrity: integrity},
+			}}
+		}
+		return pkg
+	}
+	first := newPackage("first", "sha512-first", map[string]string{"latest": "1.1.0", "next": "1.1.0"}, "0.9.0", "1.0.0", "1.1.0")
+	if err := d.MergePackage(ctx, first, UpstreamCheck{}); err != nil {
+		t.Fatalf("failed to merge package: %v", err)
+	}
+
+	// Change the package in depot.
+	if err := d.DeletePackageVersion(ctx, "pkg", "0.9.0"); err != nil {
+		t.Fatalf("failed to delete version: %v", err)
+	}
+	if err := d.PutDistTag(ctx, "pkg", "next", "1.0.0"); err != nil {
+		t.Fatalf("failed to put dist-tag: %v", err)
+	}
+
+	second := newPackage("second", "sha512-second", map[string]string{"latest": "1.2.0", "next": "1.2.0", "beta": "1.2.0"}, "0.9.0", "1.0.0", "1.1.0", "1.2.0")
+	previous := UpstreamCheck{Versions: []string{"0.9.0", "1.0.0", "1.1.0"}, DistTags: first.DistTags}
+	if err := d.MergePackage(ctx, second, previous); err != nil {
+		t.Fatalf("failed to merge package: %v", err)
+	}
+
+	pkg, ok, err := d.GetPackage(ctx, "pkg")
+	if err != nil || !ok {
+		t.Fatalf("failed to get package: ok=%v, err=%v", ok, err)
+	}
+	if got := slices.Sorted(maps.Keys(pkg.Versions)); !slices.Equal(got, []string{"1.0.0", "1.1.0", "1.2.0"}) {
+		t.Errorf("expected new versions to be added without restoring deleted versions, got %v", got)
+	}
+	if got := pkg.Versions["1.1.0"].Dist.Integrity; got != "sha512-first" {
+		t.Errorf("expected the stored version to be kept, got integrity %q", got)
+	}
+	expectedTags := map[string]string{"latest": "1.2.0", "next": "1.0.0", "beta": "1.2.0"}
+	if !maps.Equal(pkg.DistTags, expectedTags) {
+		t.Errorf("expected dist-tags %v, got %v", expectedTags, pkg.DistTags)
+	}
+	if pkg.Readme != "first" {
+		t.Errorf("expected the stored readme to be kept, got %q", pkg.Readme)
+	}
+}
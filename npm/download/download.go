@@ -3,9 +3,11 @@ package download
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -21,10 +23,22 @@ import (
 
 const npmRegistryURL = "https://registry.npmjs.org"
 
+// ErrNotFound is returned when the upstream registry does not have the requested package or version.
+var ErrNotFound = errors.New("not found")
+
+// ErrUnsupportedPlatform is returned when an optional package doesn't support the platform in the Options.
+var ErrUnsupportedPlatform = errors.New("unsupported platform")
+
 // PackageSpec represents a package specification (name@version).
 type PackageSpec struct {
 	Name    string
 	Version string
+	// Integrity is the expected SRI hash of the tarball, e.g. from a lockfile.
+	// If set, the tarball is checked against it, as well as the registry metadata.
+	Integrity string
+	// Optional is true for optional dependencies, which are skipped if they
+	// don't support the platform.
+	Optional bool
 }
 
 func (pkg PackageSpec) String() string {
@@ -52,9 +66,11 @@ func ParsePackageSpec(spec string) PackageSpec {
 
 // Downloader handles concurrent package downloads.
 type Downloader struct {
-	log     *slog.Logger
-	client  *http.Client
-	storage storage.Storage
+	log         *slog.Logger
+	client      *http.Client
+	storage     storage.Storage
+	registryURL string
+	options     Options
 }
 
 // New creates a new downloader.
@@ -64,10 +80,21 @@ func New(log *slog.Logger, storage storage.Storage) *Downloader {
 		client: &http.Client{
 			Timeout: 5 * time.Minute,
 		},
-		storage: storage,
+		storage:     storage,
+		registryURL: npmRegistryURL,
 	}
 }
 
+// SetRegistryURL overrides the upstream registry URL.
+func (d *Downloader) SetRegistryURL(url string) {
+	d.registryURL = strings.TrimSuffix(url, "/")
+}
+
+// SetOptions sets the dependencies that are returned by Download.
+func (d *Downloader) SetOptions(options Options) {
+	d.options = options
+}
+
 func (d *Downloader) findVersion(versionConstraint string, versions map[string]models.AbbreviatedVersion, distTags map[string]string) (models.AbbreviatedVersion, bool) {
 	if versionConstraint == "" {
 		versionConstraint = "latest"
@@ -124,20 +151,51 @@ func (d *Downloader) Download(ctx context.Context, pkg PackageSpec, updateMetada
 		return nil, fmt.Errorf("version %s not found for package %s", pkg.Version, pkg.Name)
 	}
 
+	if pkg.Optional && !d.options.Platform.Match(version) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrUnsupportedPlatform, pkg.Name, version.Version)
+	}
+
 	d.log.Debug("downloading tarball", slog.String("name", pkg.Name), slog.String("version", version.Version))
-	if err := d.downloadTarball(ctx, version, overwriteTar); err != nil {
+	filePath := tarballPath(version)
+	if err := d.downloadTarball(ctx, version, filePath, overwriteTar); err != nil {
 		return nil, err
 	}
+	if pkg.Integrity != "" {
+		if err := d.verifyTarball(ctx, filePath, pkg.Integrity); err != nil {
+			return nil, fmt.Errorf("failed to verify tarball of %s@%s: %w", pkg.Name, version.Version, err)
+		}
+	}
 
 	d.log.Debug("collating dependencies", slog.String("name", pkg.Name), slog.String("version", version.Version))
+	return d.dependenciesOf(version), nil
+}
+
+// dependenciesOf returns the dependencies of a version, including optional and
+// peer dependencies if the options include them.
+func (d *Downloader) dependenciesOf(version models.AbbreviatedVersion) (deps []PackageSpec) {
+	add := func(name, versionRange string, optional bool) {
+		if isValidPackage(name, versionRange) {
+			deps = append(deps, PackageSpec{Name: name, Version: versionRange, Optional: optional})
+		}
+	}
 	for depName, depVersion := range version.Dependencies {
-		if !isValidPackage(depName, depVersion) {
+		// Optional dependencies may also be listed in dependencies.
+		if _, isOptional := version.OptionalDependencies[depName]; isOptional {
 			continue
 		}
-		deps = append(deps, PackageSpec{Name: depName, Version: depVersion})
+		add(depName, depVersion, false)
 	}
-
-	return deps, nil
+	if d.options.Optional {
+		for depName, depVersion := range version.OptionalDependencies {
+			add(depName, depVersion, true)
+		}
+	}
+	if d.options.Peer {
+		for depName, depVersion := range version.PeerDependencies {
+			add(depName, depVersion, version.PeerDependenciesMeta[depName].Optional)
+		}
+	}
+	return deps
 }
 
 func isValidPackage(name, version string) bool {
@@ -170,7 +228,7 @@ func (d *Downloader) fetchMetadata(ctx context.Context, packageName string, upda
 		}
 	}
 
-	url := fmt.Sprintf("%s/%s", npmRegistryURL, packageName)
+	url := fmt.Sprintf("%s/%s", d.registryURL, packageName)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return m, err
@@ -191,9 +249,11 @@ func (d *Downloader) fetchMetadata(ctx context.Context, packageName string, upda
 		return m, fmt.Errorf("failed to create metadata file: %w", err)
 	}
 	defer func() {
-		if cerr := f.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err != nil {
+			storage.Abort(f)
+			return
 		}
+		err = f.Close()
 	}()
 
 	// Copy response to the file and JSON decoder.
@@ -202,12 +262,75 @@ func (d *Downloader) fetchMetadata(ctx context.Context, packageName string, upda
 	return m, err
 }
 
-func (d *Downloader) downloadTarball(ctx context.Context, version models.AbbreviatedVersion, overwrite bool) (err error) {
-	if version.Dist == nil {
-		return fmt.Errorf("no dist information for version %s@%s", version.Name, version.Version)
+// FetchPackageIfChanged fetches the full package metadata from the upstream
+// registry without storing it, unless it still has the etag of a previous response. If it hasn't
+// changed, changed is false and m is empty. The etag of the response is
+// returned, and is empty if the registry doesn't send one.
+func (d *Downloader) FetchPackageIfChanged(ctx context.Context, packageName, etag string) (m models.Package, newETag string, changed bool, err error) {
+	newETag, changed, err = d.getJSONIfNoneMatch(ctx, fmt.Sprintf("%s/%s", d.registryURL, escapePackageName(packageName)), etag, &m)
+	return m, newETag, changed, err
+}
+
+// FetchVersion fetches the metadata for a single version (or dist-tag) of a
+// package from the upstream registry without storing it.
+func (d *Downloader) FetchVersion(ctx context.Context, packageName, version string) (v models.AbbreviatedVersion, err error) {
+	err = d.getJSON(ctx, fmt.Sprintf("%s/%s/%s", d.registryURL, escapePackageName(packageName), url.PathEscape(version)), &v)
+	return v, err
+}
+
+func (d *Downloader) getJSON(ctx context.Context, url string, v any) (err error) {
+	_, _, err = d.getJSONIfNoneMatch(ctx, url, "", v)
+	return err
+}
+
+func (d *Downloader) getJSONIfNoneMatch(ctx context.Context, url, etag string, v any) (newETag string, changed bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	// Request the full metadata format, so that fields such as readme, license and time are kept.
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
 
-	filePath := filepath.Join(version.Name, fmt.Sprintf("%s-%s.tgz", version.Name, version.Version))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if etag != "" {
+			return etag, false, nil
+		}
+		return "", false, fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	case http.StatusNotFound:
+		return "", false, fmt.Errorf("%w: HTTP %d from %s", ErrNotFound, resp.StatusCode, url)
+	default:
+		return "", false, fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", false, fmt.Errorf("failed to decode response from %s: %w", url, err)
+	}
+	return resp.Header.Get("ETag"), true, nil
+}
+
+// escapePackageName escapes the slash in scoped package names, as expected by
+// npm registries, e.g. @types/node becomes @types%2fnode.
+func escapePackageName(packageName string) string {
+	return strings.Replace(packageName, "/", "%2f", 1)
+}
+
+// tarballPath returns the storage path of the tarball of a package version.
+func tarballPath(version models.AbbreviatedVersion) string {
+	return filepath.Join(version.Name, fmt.Sprintf("%s-%s.tgz", version.Name, version.Version))
+}
+
+func (d *Downloader) downloadTarball(ctx context.Context, version models.AbbreviatedVersion, filePath string, overwrite bool) (err error) {
 	if !overwrite {
 		_, exists, err := d.storage.Stat(ctx, filePath)
 		if err != nil {
@@ -218,6 +341,19 @@ func (d *Downloader) downloadTarball(ctx context.Context, version models.Abbrevi
 			return nil
 		}
 	}
+	return d.DownloadTarball(ctx, version, filePath)
+}
+
+// DownloadTarball downloads the tarball of a package version into storage at
+// filePath, verifying it against the integrity hash in the version metadata.
+func (d *Downloader) DownloadTarball(ctx context.Context, version models.AbbreviatedVersion, filePath string) (err error) {
+	if version.Dist == nil {
+		return fmt.Errorf("no dist information for version %s@%s", version.Name, version.Version)
+	}
+	verifier, err := sri.NewVerifier(version.Dist.SRI())
+	if err != nil {
+		return fmt.Errorf("invalid dist for version %s@%s: %w", version.Name, version.Version, err)
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", version.Dist.Tarball, nil)
 	if err != nil {
@@ -240,25 +376,29 @@ func (d *Downloader) downloadTarball(ctx context.Context, version models.Abbrevi
 		return err
 	}
 	defer func() {
-		if cerr := file.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err != nil {
+			storage.Abort(file)
+			return
 		}
+		err = file.Close()
 	}()
 
-	// Download with streaming hash verification and progress reporting.
-	hasher, err := sri.Parse(version.Dist.Integrity)
-	if err != nil {
-		return err
-	}
-	mw := io.MultiWriter(file, hasher)
-	if _, err = io.Copy(mw, resp.Body); err != nil {
+	// Download with streaming hash verification.
+	if _, err = io.Copy(io.MultiWriter(file, verifier), resp.Body); err != nil {
 		return err
 	}
+	return verifier.Verify()
+}
 
-	// Verify hash.
-	if hasher.String() != version.Dist.Integrity {
-		return fmt.Errorf("hash mismatch: expected %s, got %s", version.Dist.Integrity, hasher.String())
+// verifyTarball checks a stored tarball against an SRI integrity string.
+func (d *Downloader) verifyTarball(ctx context.Context, filePath, integrity string) error {
+	r, exists, err := d.storage.Get(ctx, filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tarball: %w", err)
 	}
-
-	return nil
+	if !exists {
+		return fmt.Errorf("tarball %s not found", filePath)
+	}
+	defer r.Close()
+	return sri.Verify(r, integrity)
 }
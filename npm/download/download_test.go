@@ -0,0 +1,175 @@
+package download
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/a-h/depot/npm/models"
+	"github.com/a-h/depot/storage"
+)
+
+func TestPlatformMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		version  models.AbbreviatedVersion
+		expected bool
+	}{
+		{
+			name:     "packages without platform fields match every platform",
+			platform: Platform{OS: []string{"linux"}, CPU: []string{"x64"}},
+			expected: true,
+		},
+		{
+			name:     "an empty platform matches every package",
+			version:  models.AbbreviatedVersion{OS: []string{"darwin"}, CPU: []string{"arm64"}},
+			expected: true,
+		},
+		{
+			name:     "matching os and cpu",
+			platform: Platform{OS: []string{"linux"}, CPU: []string{"x64"}},
+			version:  models.AbbreviatedVersion{OS: []string{"linux"}, CPU: []string{"x64"}},
+			expected: true,
+		},
+		{
+			name:     "different cpu",
+			platform: Platform{OS: []string{"linux"}, CPU: []string{"x64"}},
+			version:  models.AbbreviatedVersion{OS: []string{"linux"}, CPU: []string{"arm64"}},
+			expected: false,
+		},
+		{
+			name:     "any of several platforms",
+			platform: Platform{OS: []string{"linux", "darwin"}},
+			version:  models.AbbreviatedVersion{OS: []string{"darwin"}},
+			expected: true,
+		},
+		{
+			name:     "excluded os",
+			platform: Platform{OS: []string{"win32"}},
+			version:  models.AbbreviatedVersion{OS: []string{"!win32"}},
+			expected: false,
+		},
+		{
+			name:     "os that isn't excluded",
+			platform: Platform{OS: []string{"linux"}},
+			version:  models.AbbreviatedVersion{OS: []string{"!win32"}},
+			expected: true,
+		},
+		{
+			name:     "different libc",
+			platform: Platform{OS: []string{"linux"}, Libc: []string{"glibc"}},
+			version:  models.AbbreviatedVersion{OS: []string{"linux"}, Libc: []string{"musl"}},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.platform.Match(tt.version); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDownloadDependencies(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tarball := []byte("tarball-content")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	var registryURL string
+	versions := map[string]models.AbbreviatedVersion{
+		"esbuild": {
+			Dependencies:         map[string]string{"required": "1.0.0"},
+			OptionalDependencies: map[string]string{"@esbuild/linux-x64": "1.0.0", "@esbuild/darwin-arm64": "1.0.0"},
+			PeerDependencies:     map[string]string{"peer": "1.0.0", "optional-peer": "1.0.0"},
+			PeerDependenciesMeta: map[string]models.PeerDependencyMeta{"optional-peer": {Optional: true}},
+		},
+		"@esbuild/linux-x64":    {OS: []string{"linux"}, CPU: []string{"x64"}},
+		"@esbuild/darwin-arm64": {OS: []string{"darwin"}, CPU: []string{"arm64"}},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".tgz") {
+			w.Write(tarball)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		v, ok := versions[name]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		v.Name, v.Version = name, "1.0.0"
+		v.Dist = &models.Dist{Integrity: integrity, Tarball: registryURL + "/" + name + "/-/package-1.0.0.tgz"}
+		json.NewEncoder(w).Encode(models.AbbreviatedPackage{
+			Name:     name,
+			DistTags: map[string]string{"latest": "1.0.0"},
+			Versions: map[string]models.AbbreviatedVersion{"1.0.0": v},
+		})
+	}))
+	defer ts.Close()
+	registryURL = ts.URL
+
+	newDownloader := func(options Options) *Downloader {
+		d := New(log, storage.NewFileSystem(t.TempDir()))
+		d.SetRegistryURL(ts.URL)
+		d.SetOptions(options)
+		return d
+	}
+	dependencyNames := func(deps []PackageSpec) (names []string) {
+		for _, dep := range deps {
+			name := dep.Name
+			if dep.Optional {
+				name += " (optional)"
+			}
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	t.Run("only dependencies are returned by default", func(t *testing.T) {
+		deps, err := newDownloader(Options{}).Download(ctx, PackageSpec{Name: "esbuild", Version: "1.0.0"}, false, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"required"}
+		if actual := dependencyNames(deps); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+	t.Run("optional and peer dependencies can be included", func(t *testing.T) {
+		deps, err := newDownloader(Options{Optional: true, Peer: true}).Download(ctx, PackageSpec{Name: "esbuild", Version: "1.0.0"}, false, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"@esbuild/darwin-arm64 (optional)", "@esbuild/linux-x64 (optional)", "optional-peer (optional)", "peer", "required"}
+		if actual := dependencyNames(deps); !slices.Equal(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+	t.Run("optional dependencies for other platforms are skipped", func(t *testing.T) {
+		d := newDownloader(Options{Optional: true, Platform: Platform{OS: []string{"linux"}, CPU: []string{"x64"}}})
+		if _, err := d.Download(ctx, PackageSpec{Name: "@esbuild/linux-x64", Version: "1.0.0", Optional: true}, false, false); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		_, err := d.Download(ctx, PackageSpec{Name: "@esbuild/darwin-arm64", Version: "1.0.0", Optional: true}, false, false)
+		if !errors.Is(err, ErrUnsupportedPlatform) {
+			t.Errorf("expected ErrUnsupportedPlatform, got %v", err)
+		}
+		if _, exists, _ := d.storage.Stat(ctx, "@esbuild/darwin-arm64/@esbuild/darwin-arm64-1.0.0.tgz"); exists {
+			t.Error("expected tarball for another platform to not be saved")
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package download
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/a-h/depot/npm/models"
+)
+
+// Platform selects the platform-specific optional dependencies to download,
+// e.g. the @esbuild/linux-x64 binary of esbuild. Empty fields match every platform.
+type Platform struct {
+	// OS values use the names of Node.js process.platform, e.g. linux, darwin or win32.
+	OS []string
+	// CPU values use the names of Node.js process.arch, e.g. x64 or arm64.
+	CPU []string
+	// Libc values are glibc or musl.
+	Libc []string
+}
+
+// Match returns true if the package version can be installed on one of the
+// platforms, according to its os, cpu and libc fields.
+func (p Platform) Match(v models.AbbreviatedVersion) bool {
+	return matchPlatformField(v.OS, p.OS) && matchPlatformField(v.CPU, p.CPU) && matchPlatformField(v.Libc, p.Libc)
+}
+
+// matchPlatformField returns true if the values of a package's os, cpu or libc
+// field allow any of the targets. As in npm, values starting with ! exclude a
+// target, and if there are no other values, every other target is allowed.
+func matchPlatformField(values, targets []string) bool {
+	if len(values) == 0 || len(targets) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(targets, func(target string) bool {
+		var allowed, hasAllowList bool
+		for _, value := range values {
+			if excluded, ok := strings.CutPrefix(value, "!"); ok {
+				if excluded == target {
+					return false
+				}
+				continue
+			}
+			hasAllowList = true
+			allowed = allowed || value == target
+		}
+		return allowed || !hasAllowList
+	})
+}
+
+// Options control the dependencies that Download returns, in addition to the
+// dependencies field.
+type Options struct {
+	// Optional includes optionalDependencies, e.g. platform-specific binaries.
+	Optional bool
+	// Peer includes peerDependencies.
+	Peer bool
+	// Platform that optional dependencies must support. Optional dependencies
+	// for other platforms are skipped.
+	Platform Platform
+}
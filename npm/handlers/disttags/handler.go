@@ -0,0 +1,142 @@
+package disttags
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/a-h/depot/npm/db"
+)
+
+// New creates a handler for the npm dist-tags API, used by npm dist-tag.
+func New(log *slog.Logger, db *db.DB) Handler {
+	return Handler{
+		log: log,
+		db:  db,
+	}
+}
+
+// Handler serves /-/package/<name>/dist-tags and /-/package/<name>/dist-tags/<tag>.
+type Handler struct {
+	log *slog.Logger
+	db  *db.DB
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, tag, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && tag == "":
+		h.Get(w, r, name)
+		return
+	case r.Method == http.MethodPut && tag != "":
+		h.Put(w, r, name, tag)
+		return
+	case r.Method == http.MethodDelete && tag != "":
+		h.Delete(w, r, name, tag)
+		return
+	}
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// parsePath extracts the package name and tag from a path such as
+// /-/package/@scope/name/dist-tags/beta. The tag is empty if not present.
+func parsePath(requestPath string) (name, tag string, ok bool) {
+	rest, ok := strings.CutPrefix(requestPath, "/-/package/")
+	if !ok {
+		return "", "", false
+	}
+	name, tag, ok = strings.Cut(rest, "/dist-tags")
+	if !ok || name == "" || (tag != "" && !strings.HasPrefix(tag, "/")) {
+		return "", "", false
+	}
+	tag = strings.Trim(tag, "/")
+	return name, tag, !strings.Contains(tag, "/")
+}
+
+func (h Handler) Get(w http.ResponseWriter, r *http.Request, name string) {
+	pkg, ok, err := h.db.GetPackage(r.Context(), name)
+	if err != nil {
+		h.log.Error("failed to get package", slog.String("package", name), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "package not found", http.StatusNotFound)
+		return
+	}
+	h.writeTags(w, http.StatusOK, pkg.DistTags)
+}
+
+// Put points the tag at the version in the request body, which is a JSON string.
+func (h Handler) Put(w http.ResponseWriter, r *http.Request, name, tag string) {
+	defer r.Body.Close()
+	var version string
+	if err := json.NewDecoder(r.Body).Decode(&version); err != nil || version == "" {
+		http.Error(w, "expected a JSON string containing a version", http.StatusBadRequest)
+		return
+	}
+	// npm doesn't allow tags that could be confused with versions or ranges.
+	if _, err := semver.NewConstraint(tag); err == nil {
+		http.Error(w, fmt.Sprintf("tag %q is a valid semver range", tag), http.StatusBadRequest)
+		return
+	}
+	v, ok, err := h.db.GetPackageVersion(r.Context(), name, version)
+	if err != nil {
+		h.log.Error("failed to get package version", slog.String("package", name), slog.String("version", version), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok || v.Version != version {
+		http.Error(w, fmt.Sprintf("version %s of %s not found", version, name), http.StatusNotFound)
+		return
+	}
+	if err = h.db.PutDistTag(r.Context(), name, tag, version); err != nil {
+		h.log.Error("failed to save dist-tag", slog.String("package", name), slog.String("tag", tag), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.log.Debug("set dist-tag", slog.String("package", name), slog.String("tag", tag), slog.String("version", version))
+	h.writeCurrentTags(w, r, http.StatusCreated, name)
+}
+
+func (h Handler) Delete(w http.ResponseWriter, r *http.Request, name, tag string) {
+	if tag == "latest" {
+		http.Error(w, "the latest tag can't be removed", http.StatusBadRequest)
+		return
+	}
+	if err := h.db.DeleteDistTag(r.Context(), name, tag); err != nil {
+		h.log.Error("failed to delete dist-tag", slog.String("package", name), slog.String("tag", tag), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.log.Debug("deleted dist-tag", slog.String("package", name), slog.String("tag", tag))
+	h.writeCurrentTags(w, r, http.StatusOK, name)
+}
+
+func (h Handler) writeCurrentTags(w http.ResponseWriter, r *http.Request, status int, name string) {
+	pkg, _, err := h.db.GetPackage(r.Context(), name)
+	if err != nil {
+		h.log.Error("failed to get package", slog.String("package", name), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.writeTags(w, status, pkg.DistTags)
+}
+
+func (h Handler) writeTags(w http.ResponseWriter, status int, tags map[string]string) {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		h.log.Error("failed to encode dist-tags", slog.Any("error", err))
+	}
+}
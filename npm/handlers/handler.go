@@ -4,21 +4,43 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/npm/db"
+	"github.com/a-h/depot/npm/download"
+	"github.com/a-h/depot/npm/handlers/disttags"
 	"github.com/a-h/depot/npm/handlers/metadata"
+	"github.com/a-h/depot/npm/handlers/search"
 	"github.com/a-h/depot/npm/handlers/tarball"
 	"github.com/a-h/depot/storage"
 )
 
-func New(log *slog.Logger, db *db.DB, storage storage.Storage, metrics metrics.Metrics) http.Handler {
-	mh := metadata.New(log, db)
-	th := tarball.New(log, storage, metrics)
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *download.Downloader, upstreamMaxAge time.Duration, baseURL string, metrics metrics.Metrics) http.Handler {
+	mh := metadata.New(log, db, storage, upstream, upstreamMaxAge, baseURL, metrics)
+	th := tarball.New(log, db, storage, upstream, metrics)
+	dh := disttags.New(log, db)
+	sh := search.New(log, db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// npm unpublish appends the revision of the document it read to the paths it writes to, e.g. /-rev/1-abc.
+		if before, _, ok := strings.Cut(r.URL.Path, "/-rev/"); ok {
+			r.URL.Path = before
+		}
 		path := strings.TrimPrefix(r.URL.Path, "/")
 
+		// Handle npm search.
+		if path == "-/v1/search" {
+			sh.ServeHTTP(w, r)
+			return
+		}
+
+		// Handle the dist-tags API used by npm dist-tag.
+		if strings.HasPrefix(path, "-/package/") {
+			dh.ServeHTTP(w, r)
+			return
+		}
+
 		// Handle tarball uploads/downloads (paths ending with .tgz).
 		if strings.HasSuffix(path, ".tgz") {
 			th.ServeHTTP(w, r)
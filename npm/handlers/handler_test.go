@@ -0,0 +1,1098 @@
+package npm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/npm/db"
+	"github.com/a-h/depot/npm/download"
+	"github.com/a-h/depot/npm/models"
+	"github.com/a-h/depot/storage"
+	"github.com/a-h/depot/store"
+)
+
+// newTestHandler creates a handler without an upstream registry.
+func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	return newTestHandlerWithUpstream(t, "", 0)
+}
+
+// newTestHandlerWithUpstream creates a handler with a database that isn't
+// shared with other tests, so that tests can use the same package names.
+func newTestHandlerWithUpstream(t *testing.T, upstreamURL string, upstreamMaxAge time.Duration) http.Handler {
+	t.Helper()
+	name := strings.ReplaceAll(t.Name(), "/", "_")
+	s, closer, err := store.New(context.Background(), "sqlite", "file:"+name+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create test store: %v", err)
+	}
+	t.Cleanup(func() { closer() })
+	m, err := metrics.New()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	fs := storage.NewFileSystem(t.TempDir())
+	var upstream *download.Downloader
+	if upstreamURL != "" {
+		upstream = download.New(log, fs)
+		upstream.SetRegistryURL(upstreamURL)
+	}
+	return New(log, db.New(s), fs, upstream, upstreamMaxAge, "http://depot.example.com", m)
+}
+
+func get(t *testing.T, h http.Handler, p string) *httptest.ResponseRecorder {
+	t.Helper()
+	return do(t, h, http.MethodGet, p, nil)
+}
+
+func putBody(t *testing.T, h http.Handler, p string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, p, bytes.NewReader(body)))
+	return w
+}
+
+// do sends a request to the handler, with the body encoded as JSON if it isn't nil.
+func do(t *testing.T, h http.Handler, method, p string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to marshal body: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, p, r))
+	return w
+}
+
+func TestUpstreamPullThrough(t *testing.T) {
+	tarball := []byte("fake-tarball-content")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	requestCount := func(p string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[p]
+	}
+	var upstreamURL string
+	version := func() models.AbbreviatedVersion {
+		return models.AbbreviatedVersion{
+			Name:    "@scope/pkg",
+			Version: "1.0.0",
+			Dist: &models.Dist{
+				Integrity: integrity,
+				Tarball:   upstreamURL + "/@scope/pkg/-/pkg-1.0.0.tgz",
+			},
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.EscapedPath()]++
+		mu.Unlock()
+		switch r.URL.EscapedPath() {
+		case "/@scope%2fpkg":
+			json.NewEncoder(w).Encode(models.AbbreviatedPackage{
+				Name:     "@scope/pkg",
+				DistTags: map[string]string{"latest": "1.0.0", "next": "1.0.0", "missing": "9.9.9"},
+				Versions: map[string]models.AbbreviatedVersion{"1.0.0": version()},
+			})
+		case "/@scope%2fpkg/1.0.0":
+			json.NewEncoder(w).Encode(version())
+		case "/@scope/pkg/-/pkg-1.0.0.tgz":
+			w.Write(tarball)
+		case "/corrupt/1.0.0":
+			json.NewEncoder(w).Encode(models.AbbreviatedVersion{
+				Name:    "corrupt",
+				Version: "1.0.0",
+				Dist:    &models.Dist{Integrity: integrity, Tarball: upstreamURL + "/corrupt/-/corrupt-1.0.0.tgz"},
+			})
+		case "/corrupt/-/corrupt-1.0.0.tgz":
+			w.Write([]byte("corrupt-tarball-content"))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	upstreamURL = ts.URL
+
+	h := newTestHandlerWithUpstream(t, ts.URL, time.Hour)
+
+	t.Run("missing package is fetched from upstream with rewritten tarball URLs", func(t *testing.T) {
+		w := get(t, h, "/@scope/pkg")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var pkg models.AbbreviatedPackage
+		if err := json.Unmarshal(w.Body.Bytes(), &pkg); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		expectedTags := map[string]string{"latest": "1.0.0", "next": "1.0.0"}
+		if !maps.Equal(pkg.DistTags, expectedTags) {
+			t.Errorf("expected dist-tags %v, got %v", expectedTags, pkg.DistTags)
+		}
+		v, ok := pkg.Versions["1.0.0"]
+		if !ok {
+			t.Fatalf("expected version 1.0.0, got %v", pkg.Versions)
+		}
+		expected := "http://depot.example.com/npm/@scope/pkg/-/pkg-1.0.0.tgz"
+		if v.Dist.Tarball != expected {
+			t.Errorf("expected tarball URL %q, got %q", expected, v.Dist.Tarball)
+		}
+	})
+	t.Run("fetched package is served from the database", func(t *testing.T) {
+		w := get(t, h, "/@scope/pkg/1.0.0")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if n := requestCount("/@scope%2fpkg"); n != 1 {
+			t.Errorf("expected 1 upstream package request, got %d", n)
+		}
+	})
+	t.Run("missing tarball is fetched from upstream", func(t *testing.T) {
+		for range 2 {
+			w := get(t, h, "/@scope/pkg/-/pkg-1.0.0.tgz")
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if w.Body.String() != string(tarball) {
+				t.Errorf("expected tarball %q, got %q", tarball, w.Body.String())
+			}
+		}
+		if n := requestCount("/@scope/pkg/-/pkg-1.0.0.tgz"); n != 1 {
+			t.Errorf("expected 1 upstream tarball request, got %d", n)
+		}
+	})
+	t.Run("tarballs that don't match the upstream integrity aren't stored", func(t *testing.T) {
+		for range 2 {
+			if w := get(t, h, "/corrupt/-/corrupt-1.0.0.tgz"); w.Code != http.StatusInternalServerError {
+				t.Errorf("expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body.String())
+			}
+		}
+		if n := requestCount("/corrupt/-/corrupt-1.0.0.tgz"); n != 2 {
+			t.Errorf("expected 2 upstream tarball requests, got %d", n)
+		}
+	})
+	t.Run("package missing upstream returns 404", func(t *testing.T) {
+		for _, p := range []string{"/missing", "/missing/1.0.0", "/missing/-/missing-1.0.0.tgz"} {
+			if w := get(t, h, p); w.Code != http.StatusNotFound {
+				t.Errorf("expected status %d for %s, got %d", http.StatusNotFound, p, w.Code)
+			}
+		}
+	})
+}
+
+func TestUpstreamRevalidation(t *testing.T) {
+	var mu sync.Mutex
+	versions := []string{"1.0.0"}
+	var notModified int
+	var unavailable bool
+	etag := func() string {
+		return strconv.Quote(strings.Join(versions, ","))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if unavailable {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/pkg" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag() {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		pkg := models.AbbreviatedPackage{
+			Name:     "pkg",
+			DistTags: map[string]string{"latest": versions[len(versions)-1]},
+			Versions: map[string]models.AbbreviatedVersion{},
+		}
+		for _, v := range versions {
+			pkg.Versions[v] = models.AbbreviatedVersion{Name: "pkg", Version: v, Dist: &models.Dist{Tarball: "http://upstream/pkg/-/pkg-" + v + ".tgz"}}
+		}
+		w.Header().Set("ETag", etag())
+		json.NewEncoder(w).Encode(pkg)
+	}))
+	defer ts.Close()
+
+	h := newTestHandlerWithUpstream(t, ts.URL, 0)
+
+	getPackage := func(t *testing.T) (pkg models.AbbreviatedPackage) {
+		t.Helper()
+		w := get(t, h, "/pkg")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &pkg); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		return pkg
+	}
+
+	t.Run("unchanged packages are revalidated with the upstream ETag", func(t *testing.T) {
+		getPackage(t)
+		pkg := getPackage(t)
+		if pkg.DistTags["latest"] != "1.0.0" {
+			t.Errorf("expected latest 1.0.0, got %v", pkg.DistTags)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if notModified != 1 {
+			t.Errorf("expected 1 not modified response, got %d", notModified)
+		}
+	})
+	t.Run("new upstream versions and dist-tags are merged", func(t *testing.T) {
+		mu.Lock()
+		versions = append(versions, "1.1.0")
+		mu.Unlock()
+
+		pkg := getPackage(t)
+		if pkg.DistTags["latest"] != "1.1.0" {
+			t.Errorf("expected latest 1.1.0, got %v", pkg.DistTags)
+		}
+		if got := slices.Sorted(maps.Keys(pkg.Versions)); !slices.Equal(got, []string{"1.0.0", "1.1.0"}) {
+			t.Errorf("expected versions 1.0.0 and 1.1.0, got %v", got)
+		}
+		w := get(t, h, "/pkg/latest")
+		var v models.AbbreviatedVersion
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("failed to decode version: %v", err)
+		}
+		if v.Version != "1.1.0" {
+			t.Errorf("expected latest version 1.1.0, got %q", v.Version)
+		}
+	})
+	t.Run("stored packages are served if the upstream registry is unavailable", func(t *testing.T) {
+		mu.Lock()
+		unavailable = true
+		mu.Unlock()
+
+		pkg := getPackage(t)
+		if pkg.DistTags["latest"] != "1.1.0" {
+			t.Errorf("expected latest 1.1.0, got %v", pkg.DistTags)
+		}
+		w := get(t, h, "/missing")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d for a package that isn't stored, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+}
+
+func TestUpstreamDoesNotReplacePublishedPackages(t *testing.T) {
+	upstreamTarball := []byte("upstream-tarball-content")
+	upstreamSum := sha512.Sum512(upstreamTarball)
+	var mu sync.Mutex
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		if r.URL.Path != "/pkg" {
+			http.NotFound(w, r)
+			return
+		}
+		pkg := models.Package{
+			Name:     "pkg",
+			DistTags: map[string]string{"latest": "2.0.0"},
+			Versions: map[string]models.Version{},
+			Readme:   "upstream readme",
+		}
+		for _, v := range []string{"1.0.0", "2.0.0"} {
+			pkg.Versions[v] = models.Version{AbbreviatedVersion: models.AbbreviatedVersion{
+				Name:    "pkg",
+				Version: v,
+				Dist: &models.Dist{
+					Integrity: "sha512-" + base64.StdEncoding.EncodeToString(upstreamSum[:]),
+					Tarball:   "http://upstream/pkg/-/pkg-" + v + ".tgz",
+				},
+			}}
+		}
+		json.NewEncoder(w).Encode(pkg)
+	}))
+	defer ts.Close()
+
+	h := newTestHandlerWithUpstream(t, ts.URL, 0)
+
+	tarball := []byte("local-tarball-content")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+	doc := models.PublishDocument{
+		Package: models.Package{
+			Name:     "pkg",
+			DistTags: map[string]string{"latest": "1.0.0"},
+			Versions: map[string]models.Version{
+				"1.0.0": {AbbreviatedVersion: models.AbbreviatedVersion{
+					Name:    "pkg",
+					Version: "1.0.0",
+					Dist:    &models.Dist{Integrity: integrity},
+				}},
+			},
+			Readme: "local readme",
+		},
+		Attachments: map[string]models.Attachment{
+			"pkg-1.0.0.tgz": {Data: base64.StdEncoding.EncodeToString(tarball), Length: len(tarball)},
+		},
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to marshal document: %v", err)
+	}
+	if w := putBody(t, h, "/pkg", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	for range 2 {
+		w := get(t, h, "/pkg")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var pkg models.Package
+		if err := json.Unmarshal(w.Body.Bytes(), &pkg); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		if !maps.Equal(pkg.DistTags, map[string]string{"latest": "1.0.0"}) {
+			t.Errorf("expected the local dist-tags to be kept, got %v", pkg.DistTags)
+		}
+		if got := slices.Sorted(maps.Keys(pkg.Versions)); !slices.Equal(got, []string{"1.0.0"}) {
+			t.Errorf("expected only the local version, got %v", got)
+		}
+		if got := pkg.Versions["1.0.0"].Dist.Integrity; got != integrity {
+			t.Errorf("expected the local integrity %q, got %q", integrity, got)
+		}
+		if pkg.Readme != "local readme" {
+			t.Errorf("expected the local readme, got %q", pkg.Readme)
+		}
+	}
+	if w := get(t, h, "/pkg/-/pkg-1.0.0.tgz"); w.Body.String() != string(tarball) {
+		t.Errorf("expected the local tarball, got %q", w.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 0 {
+		t.Errorf("expected no upstream requests, got %d", requests)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := newTestHandler(t)
+
+	// newDocument creates the document that npm publish sends for a tarball.
+	newDocument := func(version string, tarball []byte) models.PublishDocument {
+		sha512Sum := sha512.Sum512(tarball)
+		sha1Sum := sha1.Sum(tarball)
+		return models.PublishDocument{
+			Package: models.Package{
+				Name:     "@scope/published",
+				DistTags: map[string]string{"latest": version},
+				Versions: map[string]models.Version{
+					version: {
+						AbbreviatedVersion: models.AbbreviatedVersion{
+							Name:    "@scope/published",
+							Version: version,
+							Dist: &models.Dist{
+								Integrity: "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:]),
+								Shasum:    hex.EncodeToString(sha1Sum[:]),
+								Tarball:   "http://registry.example.com/@scope/published/-/@scope/published-" + version + ".tgz",
+							},
+						},
+					},
+				},
+			},
+			Attachments: map[string]models.Attachment{
+				"@scope/published-" + version + ".tgz": {
+					ContentType: "application/octet-stream",
+					Data:        base64.StdEncoding.EncodeToString(tarball),
+					Length:      len(tarball),
+				},
+			},
+		}
+	}
+	publish := func(t *testing.T, doc models.PublishDocument) *httptest.ResponseRecorder {
+		t.Helper()
+		body, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("failed to marshal document: %v", err)
+		}
+		w := putBody(t, h, "/@scope%2fpublished", body)
+		return w
+	}
+
+	tarball := []byte("published-tarball-content")
+
+	t.Run("published versions are stored with rewritten tarball URLs", func(t *testing.T) {
+		w := publish(t, newDocument("1.0.0", tarball))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		w = get(t, h, "/@scope/published/latest")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var v models.AbbreviatedVersion
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("failed to decode version: %v", err)
+		}
+		expected := "http://depot.example.com/npm/@scope/published/-/published-1.0.0.tgz"
+		if v.Version != "1.0.0" || v.Dist.Tarball != expected {
+			t.Errorf("expected version 1.0.0 with tarball %q, got %q with tarball %q", expected, v.Version, v.Dist.Tarball)
+		}
+	})
+	t.Run("published tarballs can be downloaded", func(t *testing.T) {
+		w := get(t, h, "/@scope/published/-/published-1.0.0.tgz")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if w.Body.String() != string(tarball) {
+			t.Errorf("expected tarball %q, got %q", tarball, w.Body.String())
+		}
+	})
+	t.Run("published versions cannot be republished", func(t *testing.T) {
+		w := publish(t, newDocument("1.0.0", []byte("different content")))
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status %d, got %d: %s", http.StatusConflict, w.Code, w.Body.String())
+		}
+		if w = get(t, h, "/@scope/published/-/published-1.0.0.tgz"); w.Body.String() != string(tarball) {
+			t.Errorf("expected original tarball to be kept, got %q", w.Body.String())
+		}
+	})
+	t.Run("invalid documents are rejected", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(doc *models.PublishDocument)
+		}{
+			{
+				name: "integrity mismatch",
+				modify: func(doc *models.PublishDocument) {
+					doc.Versions["2.0.0"].Dist.Integrity = "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+				},
+			},
+			{
+				name: "shasum mismatch",
+				modify: func(doc *models.PublishDocument) {
+					doc.Versions["2.0.0"].Dist.Shasum = hex.EncodeToString(make([]byte, sha1.Size))
+				},
+			},
+			{
+				name: "missing attachment",
+				modify: func(doc *models.PublishDocument) {
+					doc.Attachments = map[string]models.Attachment{"@scope/published-9.9.9.tgz": doc.Attachments["@scope/published-2.0.0.tgz"]}
+				},
+			},
+			{
+				name: "new version without attachments",
+				modify: func(doc *models.PublishDocument) {
+					doc.Attachments = nil
+				},
+			},
+			{
+				name: "package name mismatch",
+				modify: func(doc *models.PublishDocument) {
+					doc.Name = "other"
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				doc := newDocument("2.0.0", []byte("version 2 content"))
+				tt.modify(&doc)
+				if w := publish(t, doc); w.Code != http.StatusBadRequest {
+					t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+				}
+			})
+		}
+		if w := get(t, h, "/@scope/published/2.0.0"); w.Code != http.StatusNotFound {
+			t.Errorf("expected rejected version to not be stored, got status %d", w.Code)
+		}
+	})
+}
+
+func TestPackument(t *testing.T) {
+	h := newTestHandler(t)
+
+	tarball := []byte("packument-tarball-content")
+	sha512Sum := sha512.Sum512(tarball)
+	sha1Sum := sha1.Sum(tarball)
+	body := `{
+		"_id": "packument",
+		"name": "packument",
+		"description": "A package with full metadata",
+		"readme": "# packument",
+		"license": "MIT",
+		"dist-tags": {"latest": "1.0.0"},
+		"versions": {
+			"1.0.0": {
+				"name": "packument",
+				"version": "1.0.0",
+				"license": "MIT",
+				"gitHead": "abc123",
+				"scripts": {"test": "echo ok"},
+				"dependencies": {"left-pad": "^1.0.0"},
+				"dist": {
+					"integrity": "sha512-` + base64.StdEncoding.EncodeToString(sha512Sum[:]) + `",
+					"shasum": "` + hex.EncodeToString(sha1Sum[:]) + `",
+					"tarball": "http://registry.example.com/packument/-/packument-1.0.0.tgz"
+				}
+			}
+		},
+		"_attachments": {
+			"packument-1.0.0.tgz": {
+				"content_type": "application/octet-stream",
+				"data": "` + base64.StdEncoding.EncodeToString(tarball) + `",
+				"length": ` + strconv.Itoa(len(tarball)) + `
+			}
+		}
+	}`
+	w := putBody(t, h, "/packument", []byte(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	getPackument := func(t *testing.T, accept string) (doc map[string]any) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/packument", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		return doc
+	}
+
+	t.Run("the full document is returned by default", func(t *testing.T) {
+		doc := getPackument(t, "application/json")
+		for _, field := range []string{"_id", "readme", "license", "description"} {
+			if _, ok := doc[field]; !ok {
+				t.Errorf("expected field %q in %v", field, doc)
+			}
+		}
+		version := doc["versions"].(map[string]any)["1.0.0"].(map[string]any)
+		if version["gitHead"] != "abc123" || version["scripts"] == nil {
+			t.Errorf("expected version fields to be kept, got %v", version)
+		}
+		if dist := version["dist"].(map[string]any); dist["tarball"] != "http://depot.example.com/npm/packument/-/packument-1.0.0.tgz" {
+			t.Errorf("expected rewritten tarball URL, got %v", dist["tarball"])
+		}
+		times, ok := doc["time"].(map[string]any)
+		if !ok {
+			t.Fatalf("expected time field, got %v", doc["time"])
+		}
+		for _, key := range []string{"created", "modified", "1.0.0"} {
+			if _, ok := times[key]; !ok {
+				t.Errorf("expected time %q in %v", key, times)
+			}
+		}
+	})
+	t.Run("the abbreviated document is returned when requested", func(t *testing.T) {
+		doc := getPackument(t, "application/vnd.npm.install-v1+json; q=1.0, application/json; q=0.8, */*")
+		for _, field := range []string{"readme", "time", "_id"} {
+			if _, ok := doc[field]; ok {
+				t.Errorf("expected field %q to be omitted from the abbreviated document", field)
+			}
+		}
+		if _, ok := doc["modified"]; !ok {
+			t.Errorf("expected modified field, got %v", doc)
+		}
+		version := doc["versions"].(map[string]any)["1.0.0"].(map[string]any)
+		if _, ok := version["gitHead"]; ok {
+			t.Errorf("expected gitHead to be omitted from the abbreviated version, got %v", version)
+		}
+		if version["dependencies"] == nil {
+			t.Errorf("expected dependencies in the abbreviated version, got %v", version)
+		}
+	})
+	t.Run("package metadata can be updated without attachments", func(t *testing.T) {
+		before := getPackument(t, "")
+		body := `{"name": "packument", "readme": "# updated", "versions": {}}`
+		w := putBody(t, h, "/packument", []byte(body))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		after := getPackument(t, "")
+		if after["readme"] != "# updated" {
+			t.Errorf("expected updated readme, got %v", after["readme"])
+		}
+		if after["dist-tags"].(map[string]any)["latest"] != "1.0.0" {
+			t.Errorf("expected dist-tags to be kept, got %v", after["dist-tags"])
+		}
+		if created := after["time"].(map[string]any)["created"]; created != before["time"].(map[string]any)["created"] {
+			t.Errorf("expected created time to be kept, got %v", created)
+		}
+	})
+}
+
+func TestTarballIntegrity(t *testing.T) {
+	h := newTestHandler(t)
+
+	putVersion := func(t *testing.T, name, version string, tarball []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		sum := sha512.Sum512(tarball)
+		body, err := json.Marshal(models.AbbreviatedVersion{
+			Name:    name,
+			Version: version,
+			Dist: &models.Dist{
+				Integrity: "sha512-" + base64.StdEncoding.EncodeToString(sum[:]),
+				Tarball:   "http://depot.example.com/npm/" + name + "/-/" + name + "-" + version + ".tgz",
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal version: %v", err)
+		}
+		return putBody(t, h, "/"+name+"/"+version, body)
+	}
+	tarball := []byte("verified-tarball-content")
+	sum := sha512.Sum512(tarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	t.Run("tarballs must match stored version metadata", func(t *testing.T) {
+		if w := putVersion(t, "verified", "1.0.0", tarball); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		corrupt := []byte("corrupt-tarball-content")
+		corruptSum := sha512.Sum512(corrupt)
+		w := putBody(t, h, "/verified/-/verified-1.0.0.tgz", corrupt)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+		}
+		actual := "sha512-" + base64.StdEncoding.EncodeToString(corruptSum[:])
+		if !strings.Contains(w.Body.String(), integrity) || !strings.Contains(w.Body.String(), actual) {
+			t.Errorf("expected error to name the expected and actual integrity, got %q", w.Body.String())
+		}
+		w = get(t, h, "/verified/-/verified-1.0.0.tgz")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected rejected tarball to not be stored, got status %d", w.Code)
+		}
+		if w := putBody(t, h, "/verified/-/verified-1.0.0.tgz", tarball); w.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	})
+	t.Run("version metadata must match stored tarballs", func(t *testing.T) {
+		if w := putBody(t, h, "/verified/-/verified-2.0.0.tgz", tarball); w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if w := putVersion(t, "verified", "2.0.0", []byte("other-content")); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+		}
+		if w := putVersion(t, "verified", "2.0.0", tarball); w.Code != http.StatusCreated {
+			t.Errorf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestDistTags(t *testing.T) {
+	h := newTestHandler(t)
+
+	getTags := func(t *testing.T) map[string]string {
+		t.Helper()
+		w := get(t, h, "/-/package/@scope%2ftagged/dist-tags")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var tags map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &tags); err != nil {
+			t.Fatalf("failed to decode dist-tags: %v", err)
+		}
+		return tags
+	}
+
+	for _, version := range []string{"1.0.0", "2.0.0-beta.1"} {
+		v := models.AbbreviatedVersion{Name: "@scope/tagged", Version: version, Dist: &models.Dist{}}
+		if w := do(t, h, http.MethodPut, "/@scope/tagged/"+version, v); w.Code != http.StatusCreated {
+			t.Fatalf("failed to put version %s: %d %s", version, w.Code, w.Body.String())
+		}
+	}
+
+	t.Run("tags can be set by putting a version at the tag name", func(t *testing.T) {
+		v := models.AbbreviatedVersion{Name: "@scope/tagged", Version: "1.0.0", Dist: &models.Dist{}}
+		if w := do(t, h, http.MethodPut, "/@scope/tagged/latest", v); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		if tags := getTags(t); !maps.Equal(tags, map[string]string{"latest": "1.0.0"}) {
+			t.Errorf("unexpected dist-tags: %v", tags)
+		}
+	})
+	t.Run("tags can be added", func(t *testing.T) {
+		if w := do(t, h, http.MethodPut, "/-/package/@scope%2ftagged/dist-tags/beta", "2.0.0-beta.1"); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		if tags := getTags(t); !maps.Equal(tags, map[string]string{"latest": "1.0.0", "beta": "2.0.0-beta.1"}) {
+			t.Errorf("unexpected dist-tags: %v", tags)
+		}
+	})
+	t.Run("tags are included in the package metadata", func(t *testing.T) {
+		var pkg models.AbbreviatedPackage
+		if err := json.Unmarshal(get(t, h, "/@scope/tagged").Body.Bytes(), &pkg); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		if pkg.DistTags["beta"] != "2.0.0-beta.1" {
+			t.Errorf("expected beta dist-tag, got %v", pkg.DistTags)
+		}
+		if len(pkg.Versions) != 2 {
+			t.Errorf("expected 2 versions, got %d", len(pkg.Versions))
+		}
+	})
+	t.Run("versions can be retrieved by tag", func(t *testing.T) {
+		var v models.AbbreviatedVersion
+		if err := json.Unmarshal(get(t, h, "/@scope/tagged/beta").Body.Bytes(), &v); err != nil {
+			t.Fatalf("failed to decode version: %v", err)
+		}
+		if v.Version != "2.0.0-beta.1" {
+			t.Errorf("expected version 2.0.0-beta.1, got %q", v.Version)
+		}
+	})
+	t.Run("tags can be moved", func(t *testing.T) {
+		if w := do(t, h, http.MethodPut, "/-/package/@scope%2ftagged/dist-tags/latest", "2.0.0-beta.1"); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		if tags := getTags(t); tags["latest"] != "2.0.0-beta.1" {
+			t.Errorf("expected latest to be moved, got %v", tags)
+		}
+	})
+	t.Run("tags can be removed", func(t *testing.T) {
+		if w := do(t, h, http.MethodDelete, "/-/package/@scope%2ftagged/dist-tags/beta", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if tags := getTags(t); !maps.Equal(tags, map[string]string{"latest": "2.0.0-beta.1"}) {
+			t.Errorf("unexpected dist-tags: %v", tags)
+		}
+	})
+	t.Run("invalid changes are rejected", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			method   string
+			path     string
+			body     any
+			expected int
+		}{
+			{name: "missing version", method: http.MethodPut, path: "/-/package/@scope%2ftagged/dist-tags/next", body: "3.0.0", expected: http.StatusNotFound},
+			{name: "semver tag", method: http.MethodPut, path: "/-/package/@scope%2ftagged/dist-tags/1.0.0", body: "1.0.0", expected: http.StatusBadRequest},
+			{name: "remove latest", method: http.MethodDelete, path: "/-/package/@scope%2ftagged/dist-tags/latest", expected: http.StatusBadRequest},
+			{name: "missing package", method: http.MethodGet, path: "/-/package/missing/dist-tags", expected: http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if w := do(t, h, tt.method, tt.path, tt.body); w.Code != tt.expected {
+					t.Errorf("expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+				}
+			})
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	h := newTestHandler(t)
+
+	version := func(name, version, description string, keywords ...string) models.Version {
+		return models.Version{
+			AbbreviatedVersion: models.AbbreviatedVersion{Name: name, Version: version, Dist: &models.Dist{}},
+			Description:        description,
+			Keywords:           keywords,
+		}
+	}
+	versions := []models.Version{
+		version("search-router", "1.0.0", "An old router"),
+		version("search-router", "2.0.0", "A fast router", "http", "Routing"),
+		version("@search/router-plugin", "1.0.0", "Plugin for search-router", "plugin"),
+		version("search-logger", "1.0.0", "Structured logging for HTTP servers"),
+	}
+	for _, v := range versions {
+		w := do(t, h, http.MethodPut, "/"+v.Name+"/"+v.Version, v)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("failed to put %s@%s: %d %s", v.Name, v.Version, w.Code, w.Body.String())
+		}
+	}
+
+	search := func(t *testing.T, query string) (names []string, total int) {
+		t.Helper()
+		w := get(t, h, "/-/v1/search?"+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response models.SearchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode search response: %v", err)
+		}
+		for _, o := range response.Objects {
+			names = append(names, o.Package.Name)
+		}
+		return names, response.Total
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+		total    int
+	}{
+		{
+			name:     "names match before descriptions",
+			query:    "text=router",
+			expected: []string{"@search/router-plugin", "search-router"},
+			total:    2,
+		},
+		{
+			name:     "exact name matches are ranked first",
+			query:    "text=search-router",
+			expected: []string{"search-router", "@search/router-plugin"},
+			total:    2,
+		},
+		{
+			name:     "keywords are matched case-insensitively",
+			query:    "text=routing",
+			expected: []string{"search-router"},
+			total:    1,
+		},
+		{
+			name:     "descriptions are matched",
+			query:    "text=http",
+			expected: []string{"search-router", "search-logger"},
+			total:    2,
+		},
+		{
+			name:     "all terms must match",
+			query:    "text=router+fast",
+			expected: []string{"search-router"},
+			total:    1,
+		},
+		{
+			name:     "keywords qualifier",
+			query:    "text=keywords:plugin",
+			expected: []string{"@search/router-plugin"},
+			total:    1,
+		},
+		{
+			name:     "scope qualifier",
+			query:    "text=router+scope:search",
+			expected: []string{"@search/router-plugin"},
+			total:    1,
+		},
+		{
+			name:     "paging",
+			query:    "text=search&from=1&size=2",
+			expected: []string{"search-logger", "search-router"},
+			total:    3,
+		},
+		{
+			name:     "no matches",
+			query:    "text=database",
+			expected: nil,
+			total:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, total := search(t, tt.query)
+			if !slices.Equal(names, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+			if total != tt.total {
+				t.Errorf("expected total %d, got %d", tt.total, total)
+			}
+		})
+	}
+	t.Run("the latest version is described", func(t *testing.T) {
+		w := get(t, h, "/-/v1/search?text=search-router&size=1")
+		var response models.SearchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode search response: %v", err)
+		}
+		if len(response.Objects) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(response.Objects))
+		}
+		if p := response.Objects[0].Package; p.Version != "2.0.0" || p.Description != "A fast router" {
+			t.Errorf("expected version 2.0.0 with the latest description, got %q: %q", p.Version, p.Description)
+		}
+	})
+	t.Run("unpublished packages are removed from results", func(t *testing.T) {
+		w := do(t, h, http.MethodDelete, "/search-logger/-rev/1-abc", nil)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("failed to unpublish search-logger: %d %s", w.Code, w.Body.String())
+		}
+		if names, _ := search(t, "text=logger"); len(names) != 0 {
+			t.Errorf("expected no results, got %v", names)
+		}
+	})
+	t.Run("moving the latest tag describes the tagged version", func(t *testing.T) {
+		w := do(t, h, http.MethodPut, "/-/package/search-router/dist-tags/latest", "1.0.0")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("failed to tag search-router@1.0.0: %d %s", w.Code, w.Body.String())
+		}
+		if names, _ := search(t, "text=old"); !slices.Equal(names, []string{"search-router"}) {
+			t.Errorf("expected search-router to be described by version 1.0.0, got %v", names)
+		}
+	})
+}
+
+func TestDeprecateAndUnpublish(t *testing.T) {
+	h := newTestHandler(t)
+
+	// get returns the full package document, as read by npm deprecate and npm unpublish.
+	getDocument := func(t *testing.T, name string) (doc map[string]any) {
+		t.Helper()
+		w := get(t, h, "/"+name+"?write=true")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("failed to decode package: %v", err)
+		}
+		return doc
+	}
+	publish := func(t *testing.T, name string, versions ...string) {
+		t.Helper()
+		for _, version := range versions {
+			tarball := []byte(name + "@" + version)
+			sha512Sum := sha512.Sum512(tarball)
+			doc := models.PublishDocument{
+				Package: models.Package{
+					Name:     name,
+					DistTags: map[string]string{"latest": version},
+					Versions: map[string]models.Version{
+						version: {
+							AbbreviatedVersion: models.AbbreviatedVersion{
+								Name:    name,
+								Version: version,
+								Dist:    &models.Dist{Integrity: "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:])},
+							},
+						},
+					},
+				},
+				Attachments: map[string]models.Attachment{
+					name + "-" + version + ".tgz": {Data: base64.StdEncoding.EncodeToString(tarball)},
+				},
+			}
+			if w := do(t, h, http.MethodPut, "/"+name, doc); w.Code != http.StatusCreated {
+				t.Fatalf("failed to publish %s@%s: %d %s", name, version, w.Code, w.Body.String())
+			}
+		}
+	}
+	tarballExists := func(t *testing.T, name, version string) bool {
+		t.Helper()
+		w := get(t, h, "/"+name+"/-/"+name+"-"+version+".tgz")
+		if w.Code != http.StatusOK && w.Code != http.StatusNotFound {
+			t.Fatalf("failed to get tarball: %d %s", w.Code, w.Body.String())
+		}
+		return w.Code == http.StatusOK
+	}
+
+	t.Run("versions can be deprecated and undeprecated", func(t *testing.T) {
+		publish(t, "deprecated", "1.0.0", "2.0.0")
+
+		doc := getDocument(t, "deprecated")
+		doc["versions"].(map[string]any)["1.0.0"].(map[string]any)["deprecated"] = "use deprecated@2"
+		if w := do(t, h, http.MethodPut, "/deprecated", doc); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		versions := getDocument(t, "deprecated")["versions"].(map[string]any)
+		if deprecated := versions["1.0.0"].(map[string]any)["deprecated"]; deprecated != "use deprecated@2" {
+			t.Errorf("expected 1.0.0 to be deprecated, got %v", deprecated)
+		}
+		if deprecated, ok := versions["2.0.0"].(map[string]any)["deprecated"]; ok {
+			t.Errorf("expected 2.0.0 not to be deprecated, got %v", deprecated)
+		}
+
+		doc = getDocument(t, "deprecated")
+		doc["versions"].(map[string]any)["1.0.0"].(map[string]any)["deprecated"] = ""
+		if w := do(t, h, http.MethodPut, "/deprecated", doc); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		versions = getDocument(t, "deprecated")["versions"].(map[string]any)
+		if deprecated, ok := versions["1.0.0"].(map[string]any)["deprecated"]; ok {
+			t.Errorf("expected 1.0.0 to be undeprecated, got %v", deprecated)
+		}
+	})
+	t.Run("a version can be unpublished", func(t *testing.T) {
+		publish(t, "unpublished", "1.0.0", "1.1.0", "2.0.0")
+
+		// npm removes the version from the document, then deletes the tarball.
+		doc := getDocument(t, "unpublished")
+		delete(doc["versions"].(map[string]any), "2.0.0")
+		if w := do(t, h, http.MethodPut, "/unpublished/-rev/1-abc", doc); w.Code != http.StatusCreated {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		if w := do(t, h, http.MethodDelete, "/npm/unpublished/-/unpublished-2.0.0.tgz/-rev/2-def", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		doc = getDocument(t, "unpublished")
+		if _, ok := doc["versions"].(map[string]any)["2.0.0"]; ok {
+			t.Errorf("expected 2.0.0 to be removed, got %v", doc["versions"])
+		}
+		if _, ok := doc["time"].(map[string]any)["2.0.0"]; ok {
+			t.Errorf("expected the time of 2.0.0 to be removed, got %v", doc["time"])
+		}
+		if latest := doc["dist-tags"].(map[string]any)["latest"]; latest != "1.1.0" {
+			t.Errorf("expected latest to move to 1.1.0, got %v", latest)
+		}
+		if tarballExists(t, "unpublished", "2.0.0") {
+			t.Error("expected the 2.0.0 tarball to be deleted")
+		}
+		if !tarballExists(t, "unpublished", "1.1.0") {
+			t.Error("expected the 1.1.0 tarball to be kept")
+		}
+	})
+	t.Run("tarballs of published versions can't be deleted", func(t *testing.T) {
+		if w := do(t, h, http.MethodDelete, "/unpublished/-/unpublished-1.0.0.tgz/-rev/3-abc", nil); w.Code != http.StatusConflict {
+			t.Errorf("expected status %d, got %d: %s", http.StatusConflict, w.Code, w.Body.String())
+		}
+		if !tarballExists(t, "unpublished", "1.0.0") {
+			t.Error("expected the 1.0.0 tarball to be kept")
+		}
+	})
+	t.Run("tarball paths that can't be parsed can't be deleted", func(t *testing.T) {
+		for _, p := range []string{"/unpublished/unpublished-1.0.0.tgz", "/unpublished/-/other-1.0.0.tgz"} {
+			if w := do(t, h, http.MethodDelete, p, nil); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d for %s, got %d: %s", http.StatusBadRequest, p, w.Code, w.Body.String())
+			}
+		}
+	})
+	t.Run("a whole package can be unpublished", func(t *testing.T) {
+		if w := do(t, h, http.MethodDelete, "/unpublished/-rev/4-abc", nil); w.Code != http.StatusNoContent {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+		}
+		if w := get(t, h, "/unpublished"); w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+		}
+		for _, version := range []string{"1.0.0", "1.1.0"} {
+			if tarballExists(t, "unpublished", version) {
+				t.Errorf("expected the %s tarball to be deleted", version)
+			}
+		}
+		if w := do(t, h, http.MethodDelete, "/unpublished/-rev/5-abc", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d: %s", http.StatusNotFound, w.Code, w.Body.String())
+		}
+	})
+}
@@ -1,26 +1,54 @@
 package metadata
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"path"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/a-h/depot/auth"
+	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/npm/db"
+	"github.com/a-h/depot/npm/download"
 	"github.com/a-h/depot/npm/models"
+	"github.com/a-h/depot/npm/sri"
+	"github.com/a-h/depot/storage"
 )
 
-func New(log *slog.Logger, db *db.DB) Handler {
+// New creates a metadata handler. If upstream is non-nil, packages are fetched
+// from the upstream registry, and their tarball URLs are rewritten to point at
+// baseURL. Packages are checked for new versions and dist-tags in the upstream
+// registry at most once per upstreamMaxAge. Tarballs sent by npm publish are
+// written to storage.
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *download.Downloader, upstreamMaxAge time.Duration, baseURL string, metrics metrics.Metrics) Handler {
 	return Handler{
-		log: log,
-		db:  db,
+		log:            log,
+		db:             db,
+		storage:        storage,
+		upstream:       upstream,
+		upstreamMaxAge: upstreamMaxAge,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		metrics:        metrics,
 	}
 }
 
+// abbreviatedContentType is the content type of the abbreviated metadata format used by package managers.
+const abbreviatedContentType = "application/vnd.npm.install-v1+json"
+
 type Handler struct {
-	log *slog.Logger
-	db  *db.DB
+	log            *slog.Logger
+	db             *db.DB
+	storage        storage.Storage
+	upstream       *download.Downloader
+	upstreamMaxAge time.Duration
+	baseURL        string
+	metrics        metrics.Metrics
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -54,27 +82,7 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if version == "" {
-		metadata, ok, err := h.db.GetPackage(r.Context(), fullPkgName)
-		if err != nil {
-			h.log.Error("failed to get package metadata", slog.String("package", fullPkgName), slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
-		}
-		if !ok {
-			http.Error(w, "package not found", http.StatusNotFound)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(metadata); err != nil {
-			h.log.Error("failed to encode metadata", slog.String("package", fullPkgName), slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
-		}
-		return
-	}
-
-	if version == "latest" {
-		metadata, ok, err := h.db.GetPackage(r.Context(), fullPkgName)
+		metadata, ok, err := h.getPackage(r.Context(), fullPkgName)
 		if err != nil {
 			h.log.Error("failed to get package metadata", slog.String("package", fullPkgName), slog.Any("error", err))
 			http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -84,26 +92,23 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "package not found", http.StatusNotFound)
 			return
 		}
-		latestVersion, exists := metadata.DistTags["latest"]
-		if !exists {
-			http.Error(w, "latest version not found", http.StatusNotFound)
-			return
-		}
-		versionMetadata, ok := metadata.Versions[latestVersion]
-		if !ok {
-			http.Error(w, "latest version not found", http.StatusNotFound)
-			return
+		// Package managers request the abbreviated format, other tools get the full document.
+		var response any = metadata
+		contentType := "application/json"
+		if strings.Contains(r.Header.Get("Accept"), abbreviatedContentType) {
+			response = metadata.Abbreviated()
+			contentType = abbreviatedContentType
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(versionMetadata); err != nil {
-			h.log.Error("failed to encode version metadata", slog.String("package", fullPkgName), slog.String("version", latestVersion), slog.Any("error", err))
+		w.Header().Set("Content-Type", contentType)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			h.log.Error("failed to encode metadata", slog.String("package", fullPkgName), slog.Any("error", err))
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
 		return
 	}
 
-	versionMetadata, ok, err := h.db.GetPackageVersion(r.Context(), fullPkgName, version)
+	versionMetadata, ok, err := h.getPackageVersion(r.Context(), fullPkgName, version)
 	if err != nil {
 		h.log.Error("failed to get version metadata", slog.String("package", fullPkgName), slog.String("version", version), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -121,6 +126,118 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getPackage gets package metadata from the database, after merging in any
+// changes from the upstream registry if one is configured.
+func (h Handler) getPackage(ctx context.Context, name string) (metadata models.Package, ok bool, err error) {
+	upstreamErr := h.refreshFromUpstream(ctx, name)
+	metadata, ok, err = h.db.GetPackage(ctx, name)
+	if err != nil || upstreamErr == nil {
+		return metadata, ok, err
+	}
+	if !ok {
+		return metadata, false, upstreamErr
+	}
+	h.log.Warn("failed to refresh package from upstream, serving stored metadata", slog.String("package", name), slog.Any("error", upstreamErr))
+	return metadata, true, nil
+}
+
+// getPackageVersion gets version metadata from the database, after merging in
+// any changes from the upstream registry if one is configured. The version may
+// be a dist-tag.
+func (h Handler) getPackageVersion(ctx context.Context, name, version string) (metadata models.Version, ok bool, err error) {
+	upstreamErr := h.refreshFromUpstream(ctx, name)
+	metadata, ok, err = h.db.GetPackageVersion(ctx, name, version)
+	if err != nil || upstreamErr == nil {
+		return metadata, ok, err
+	}
+	if !ok {
+		return metadata, false, upstreamErr
+	}
+	h.log.Warn("failed to refresh package from upstream, serving stored metadata", slog.String("package", name), slog.Any("error", upstreamErr))
+	return metadata, true, nil
+}
+
+// refreshFromUpstream merges new versions, dist-tags and times of a package
+// from the upstream registry into the database, if the package hasn't been
+// checked within the upstream max age. Packages with versions published to
+// depot are never refreshed, so that a package of the same name in the
+// upstream registry can't replace them.
+func (h Handler) refreshFromUpstream(ctx context.Context, name string) (err error) {
+	if h.upstream == nil {
+		return nil
+	}
+	published, err := h.db.HasPublishedVersions(ctx, name)
+	if err != nil || published {
+		return err
+	}
+	check, checked, err := h.db.GetUpstreamCheck(ctx, name)
+	if err != nil {
+		return err
+	}
+	if checked && time.Since(check.Checked) < h.upstreamMaxAge {
+		return nil
+	}
+	metadata, etag, changed, err := h.upstream.FetchPackageIfChanged(ctx, name, check.ETag)
+	switch {
+	case errors.Is(err, download.ErrNotFound):
+		// Packages that aren't in the upstream registry are checked again once the max age has passed.
+	case err != nil:
+		return fmt.Errorf("failed to fetch package from upstream: %w", err)
+	case changed:
+		metadata, err = h.mergeFromUpstream(ctx, name, metadata, check)
+		if err != nil {
+			return err
+		}
+		for version := range metadata.Versions {
+			if !slices.Contains(check.Versions, version) {
+				check.Versions = append(check.Versions, version)
+			}
+		}
+		slices.Sort(check.Versions)
+		check.DistTags = metadata.DistTags
+	}
+	check.Checked, check.ETag = time.Now().UTC(), etag
+	return h.db.PutUpstreamCheck(ctx, name, check)
+}
+
+// mergeFromUpstream rewrites the tarball URLs of package metadata fetched from
+// the upstream registry to point at depot, and merges it into the database,
+// see db.MergePackage. The rewritten metadata is returned.
+func (h Handler) mergeFromUpstream(ctx context.Context, name string, metadata models.Package, previous db.UpstreamCheck) (models.Package, error) {
+	for version, versionMetadata := range metadata.Versions {
+		if versionMetadata.Dist != nil {
+			dist := *versionMetadata.Dist
+			dist.Tarball = h.tarballURL(name, versionMetadata.Version)
+			versionMetadata.Dist = &dist
+		}
+		metadata.Versions[version] = versionMetadata
+	}
+	tags := make(map[string]string, len(metadata.DistTags))
+	for tag, version := range metadata.DistTags {
+		if _, exists := metadata.Versions[version]; exists {
+			tags[tag] = version
+		}
+	}
+	metadata.DistTags = tags
+	metadata.Name = name
+	if err := h.db.MergePackage(ctx, metadata, previous); err != nil {
+		return metadata, fmt.Errorf("failed to save package: %w", err)
+	}
+	h.log.Debug("fetched package from upstream", slog.String("package", name), slog.Int("versions", len(metadata.Versions)))
+	return metadata, nil
+}
+
+// tarballURL returns the depot URL of a package tarball, following the npm
+// registry convention of <name>/-/<unscoped-name>-<version>.tgz.
+func (h Handler) tarballURL(name, version string) string {
+	return fmt.Sprintf("%s/npm/%s", h.baseURL, tarballPath(name, version))
+}
+
+// tarballPath returns the storage path of the tarball of a package version.
+func tarballPath(name, version string) string {
+	return fmt.Sprintf("%s/-/%s-%s.tgz", name, path.Base(name), version)
+}
+
 func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	scope, pkgName, version, err := parsePath(r.URL.Path)
 	if err != nil {
@@ -136,13 +253,13 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 		fullPkgName = scope + "/" + pkgName
 	}
 	if version == "" {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		h.publish(w, r, fullPkgName)
 		return
 	}
 
 	// Decode the version metadata from the request body.
 	defer r.Body.Close()
-	var versionMetadata models.AbbreviatedVersion
+	var versionMetadata models.Version
 	if err := json.NewDecoder(r.Body).Decode(&versionMetadata); err != nil {
 		h.log.Error("failed to parse version metadata", slog.Any("error", err))
 		http.Error(w, "invalid version metadata", http.StatusBadRequest)
@@ -155,8 +272,32 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Clients that predate the dist-tags API set a tag by putting the tagged version at the tag name.
+	if version != versionMetadata.Version {
+		if err := h.db.PutDistTag(r.Context(), fullPkgName, version, versionMetadata.Version); err != nil {
+			h.log.Error("failed to save dist-tag", slog.String("package", fullPkgName), slog.String("tag", version), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// If the tarball was pushed first, it must match the version metadata.
+	if err := h.verifyTarball(r.Context(), fullPkgName, versionMetadata.AbbreviatedVersion); err != nil {
+		var integrityErr sri.IntegrityError
+		if errors.As(err, &integrityErr) {
+			h.log.Warn("tarball doesn't match version metadata", slog.String("package", fullPkgName), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, fmt.Sprintf("version %s: %v", version, err), http.StatusBadRequest)
+			return
+		}
+		h.log.Error("failed to verify tarball", slog.String("package", fullPkgName), slog.String("version", version), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Save the version to the database.
-	if err := h.db.PutPackageVersion(r.Context(), fullPkgName, version, versionMetadata); err != nil {
+	if err := h.db.PublishPackageVersion(r.Context(), fullPkgName, version, versionMetadata); err != nil {
 		h.log.Error("failed to save package version", slog.String("package", fullPkgName), slog.String("version", version), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
@@ -166,6 +307,21 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+// verifyTarball checks the stored tarball of a version against the dist
+// integrity of the version metadata. Versions without a stored tarball, or
+// without an integrity or shasum, aren't checked.
+func (h Handler) verifyTarball(ctx context.Context, name string, version models.AbbreviatedVersion) error {
+	if version.Dist == nil || version.Dist.SRI() == "" {
+		return nil
+	}
+	r, exists, err := h.storage.Get(ctx, tarballPath(name, version.Version))
+	if err != nil || !exists {
+		return err
+	}
+	defer r.Close()
+	return sri.Verify(r, version.Dist.SRI())
+}
+
 // parsePath extracts scope, package name, and version from the request path.
 // Scope and version may be empty if not present in the path.
 // Handles scoped packages like @scope/package/version and unscoped like package/version.
@@ -211,16 +367,31 @@ func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	if scope != "" {
 		fullPkgName = scope + "/" + pkgName
 	}
+	fingerprint := auth.KeyFingerprint(r.Context())
+
+	// npm unpublish deletes the package document when the whole package, or its last version, is unpublished.
 	if version == "" {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		ok, err := h.unpublishPackage(r.Context(), fullPkgName)
+		if err != nil {
+			h.log.Error("failed to unpublish package", slog.String("package", fullPkgName), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "package not found", http.StatusNotFound)
+			return
+		}
+		h.log.Info("unpublished package", slog.String("package", fullPkgName), slog.String("fingerprint", fingerprint))
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	if err := h.db.DeletePackageVersion(r.Context(), fullPkgName, version); err != nil {
+	if err := h.unpublishVersion(r.Context(), fullPkgName, version); err != nil {
 		h.log.Error("failed to delete package version", slog.String("package", fullPkgName), slog.String("version", version), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	h.log.Info("unpublished package version", slog.String("package", fullPkgName), slog.String("version", version), slog.String("fingerprint", fingerprint))
 
 	w.WriteHeader(http.StatusNoContent)
 }
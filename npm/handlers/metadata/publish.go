@@ -0,0 +1,193 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/depot/npm/models"
+	"github.com/a-h/depot/npm/sri"
+	"github.com/a-h/depot/storage"
+)
+
+// publish handles the PUT /<name> request made by npm publish. The tarball of
+// each version is verified against its dist.integrity and dist.shasum, and
+// stored at the path served by the tarball handler. Documents without
+// attachments update the package level metadata instead.
+func (h Handler) publish(w http.ResponseWriter, r *http.Request, name string) {
+	defer r.Body.Close()
+	var doc models.PublishDocument
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		h.log.Warn("failed to parse publish document", slog.String("package", name), slog.Any("error", err))
+		http.Error(w, "invalid publish document", http.StatusBadRequest)
+		return
+	}
+	if doc.Name != name {
+		http.Error(w, "package name mismatch", http.StatusBadRequest)
+		return
+	}
+	if len(doc.Attachments) == 0 {
+		h.putPackageMetadata(w, r, doc.Package)
+		return
+	}
+	if len(doc.Versions) == 0 {
+		http.Error(w, "no versions to publish", http.StatusBadRequest)
+		return
+	}
+
+	tarballs := make(map[string][]byte, len(doc.Versions))
+	for version, versionMetadata := range doc.Versions {
+		if versionMetadata.Name != name || versionMetadata.Version != version {
+			http.Error(w, fmt.Sprintf("version %s: package name or version mismatch", version), http.StatusBadRequest)
+			return
+		}
+		if versionMetadata.Dist == nil {
+			http.Error(w, fmt.Sprintf("version %s: missing dist", version), http.StatusBadRequest)
+			return
+		}
+		attachment, ok := doc.Attachments[fmt.Sprintf("%s-%s.tgz", name, version)]
+		if !ok {
+			http.Error(w, fmt.Sprintf("version %s: missing tarball attachment", version), http.StatusBadRequest)
+			return
+		}
+		tarball, err := verifyAttachment(attachment, *versionMetadata.Dist)
+		if err != nil {
+			h.log.Warn("invalid tarball attachment", slog.String("package", name), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, fmt.Sprintf("version %s: %v", version, err), http.StatusBadRequest)
+			return
+		}
+		tarballs[version] = tarball
+
+		_, exists, err := h.db.GetPackageVersion(r.Context(), name, version)
+		if err != nil {
+			h.log.Error("failed to get package version", slog.String("package", name), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			http.Error(w, fmt.Sprintf("cannot publish over the previously published version %s", version), http.StatusConflict)
+			return
+		}
+	}
+
+	for version, versionMetadata := range doc.Versions {
+		filePath := tarballPath(name, version)
+		if err := h.putTarball(r, filePath, tarballs[version]); err != nil {
+			h.log.Error("failed to store tarball", slog.String("path", filePath), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.metrics.IncrementUploadMetrics(r.Context(), "npm", int64(len(tarballs[version])))
+
+		dist := *versionMetadata.Dist
+		dist.Tarball = h.tarballURL(name, version)
+		versionMetadata.Dist = &dist
+		doc.Versions[version] = versionMetadata
+		if err := h.db.PublishPackageVersion(r.Context(), name, version, versionMetadata); err != nil {
+			h.log.Error("failed to save package version", slog.String("package", name), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := h.db.PutPackageMetadata(r.Context(), doc.Package); err != nil {
+		h.log.Error("failed to save package metadata", slog.String("package", name), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for tag, version := range doc.DistTags {
+		if _, ok := doc.Versions[version]; !ok {
+			continue
+		}
+		if err := h.db.PutDistTag(r.Context(), name, tag, version); err != nil {
+			h.log.Error("failed to save dist-tag", slog.String("package", name), slog.String("tag", tag), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.log.Debug("published package", slog.String("package", name), slog.Int("versions", len(doc.Versions)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+}
+
+// putPackageMetadata saves the package level metadata of an existing package,
+// e.g. the readme and times pushed by depot npm push. Versions in the document
+// must already exist. If the document has versions, it's treated as the full
+// package document sent by npm deprecate and npm unpublish, see updateVersions.
+// Dist-tags in the document are ignored.
+func (h Handler) putPackageMetadata(w http.ResponseWriter, r *http.Request, pkg models.Package) {
+	existing, exists, err := h.db.GetPackage(r.Context(), pkg.Name)
+	if err != nil {
+		h.log.Error("failed to get package", slog.String("package", pkg.Name), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "package not found", http.StatusNotFound)
+		return
+	}
+	for version := range pkg.Versions {
+		if _, ok := existing.Versions[version]; !ok {
+			http.Error(w, fmt.Sprintf("version %s: missing tarball attachment", version), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = h.db.PutPackageMetadata(r.Context(), pkg); err != nil {
+		h.log.Error("failed to save package metadata", slog.String("package", pkg.Name), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(pkg.Versions) > 0 && !h.updateVersions(w, r, existing, pkg) {
+		return
+	}
+	h.log.Debug("saved package metadata", slog.String("package", pkg.Name))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// verifyAttachment decodes the attachment, and checks it against the dist integrity and shasum.
+func verifyAttachment(attachment models.Attachment, dist models.Dist) (tarball []byte, err error) {
+	tarball, err = base64.StdEncoding.DecodeString(attachment.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tarball data: %w", err)
+	}
+	if attachment.Length != 0 && attachment.Length != len(tarball) {
+		return nil, fmt.Errorf("tarball length mismatch: expected %d, got %d", attachment.Length, len(tarball))
+	}
+	if dist.Integrity == "" && dist.Shasum == "" {
+		return nil, fmt.Errorf("missing dist.integrity and dist.shasum")
+	}
+	if dist.Integrity != "" {
+		if err = sri.Verify(bytes.NewReader(tarball), dist.Integrity); err != nil {
+			return nil, err
+		}
+	}
+	if dist.Shasum != "" {
+		sum := sha1.Sum(tarball)
+		if hex.EncodeToString(sum[:]) != dist.Shasum {
+			return nil, fmt.Errorf("shasum mismatch: expected %s, got %x", dist.Shasum, sum)
+		}
+	}
+	return tarball, nil
+}
+
+func (h Handler) putTarball(r *http.Request, tarballPath string, tarball []byte) (err error) {
+	f, err := h.storage.Put(r.Context(), tarballPath)
+	if err != nil {
+		return fmt.Errorf("failed to create tarball: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			storage.Abort(f)
+			return
+		}
+		err = f.Close()
+	}()
+	_, err = bytes.NewReader(tarball).WriteTo(f)
+	return err
+}
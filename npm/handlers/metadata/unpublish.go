@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/a-h/depot/auth"
+	"github.com/a-h/depot/npm/models"
+)
+
+// updateVersions applies the changes that npm deprecate and npm unpublish make
+// to the full package document. Versions that are stored, but missing from the
+// document, are unpublished, and the deprecation message of the remaining
+// versions is updated. Dist-tags aren't taken from the document, they're
+// updated by the database when a version is removed.
+func (h Handler) updateVersions(w http.ResponseWriter, r *http.Request, existing, pkg models.Package) (ok bool) {
+	fingerprint := auth.KeyFingerprint(r.Context())
+	for _, version := range slices.Sorted(maps.Keys(existing.Versions)) {
+		if _, ok := pkg.Versions[version]; ok {
+			continue
+		}
+		if err := h.unpublishVersion(r.Context(), pkg.Name, version); err != nil {
+			h.log.Error("failed to unpublish package version", slog.String("package", pkg.Name), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return false
+		}
+		h.log.Info("unpublished package version", slog.String("package", pkg.Name), slog.String("version", version), slog.String("fingerprint", fingerprint))
+	}
+	for _, version := range slices.Sorted(maps.Keys(pkg.Versions)) {
+		stored := existing.Versions[version]
+		deprecated := normalizeDeprecated(pkg.Versions[version].Deprecated)
+		if bytes.Equal(deprecated, normalizeDeprecated(stored.Deprecated)) {
+			continue
+		}
+		stored.Deprecated = deprecated
+		if err := h.db.PutPackageVersion(r.Context(), pkg.Name, version, stored); err != nil {
+			h.log.Error("failed to save package version", slog.String("package", pkg.Name), slog.String("version", version), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return false
+		}
+		if deprecated == nil {
+			h.log.Info("undeprecated package version", slog.String("package", pkg.Name), slog.String("version", version), slog.String("fingerprint", fingerprint))
+			continue
+		}
+		h.log.Info("deprecated package version", slog.String("package", pkg.Name), slog.String("version", version), slog.String("message", string(deprecated)), slog.String("fingerprint", fingerprint))
+	}
+	return true
+}
+
+// normalizeDeprecated returns nil for the values that npm uses to remove a
+// deprecation, i.e. an empty string, false or null.
+func normalizeDeprecated(deprecated []byte) []byte {
+	switch string(bytes.TrimSpace(deprecated)) {
+	case "", `""`, "false", "null":
+		return nil
+	}
+	return deprecated
+}
+
+// unpublishVersion removes the tarball and metadata of a package version.
+func (h Handler) unpublishVersion(ctx context.Context, name, version string) error {
+	if err := h.deleteTarballs(ctx, name, version); err != nil {
+		return err
+	}
+	return h.db.DeletePackageVersion(ctx, name, version)
+}
+
+// unpublishPackage removes the tarballs and metadata of every version of a package.
+func (h Handler) unpublishPackage(ctx context.Context, name string) (ok bool, err error) {
+	pkg, ok, err := h.db.GetPackage(ctx, name)
+	if err != nil || !ok {
+		return ok, err
+	}
+	for version := range pkg.Versions {
+		if err = h.deleteTarballs(ctx, name, version); err != nil {
+			return false, err
+		}
+	}
+	return true, h.db.DeletePackage(ctx, name)
+}
+
+// deleteTarballs deletes the tarball of a version from storage. Tarballs of
+// scoped packages pushed by older versions of depot include the scope in the
+// filename, so they're deleted too.
+func (h Handler) deleteTarballs(ctx context.Context, name, version string) error {
+	paths := []string{tarballPath(name, version)}
+	if path.Base(name) != name {
+		paths = append(paths, fmt.Sprintf("%s/-/%s-%s.tgz", name, name, version))
+	}
+	for _, p := range paths {
+		if err := h.storage.Delete(ctx, p); err != nil {
+			return fmt.Errorf("failed to delete tarball %q: %w", p, err)
+		}
+	}
+	return nil
+}
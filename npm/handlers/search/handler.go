@@ -0,0 +1,186 @@
+package search
+
+import (
+	"cmp"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/depot/npm/db"
+	"github.com/a-h/depot/npm/models"
+)
+
+const (
+	defaultSize = 20
+	maxSize     = 250
+)
+
+// New creates a handler for the /-/v1/search endpoint used by npm search.
+func New(log *slog.Logger, db *db.DB) Handler {
+	return Handler{
+		log: log,
+		db:  db,
+	}
+}
+
+// Handler searches the names, and the descriptions and keywords of the latest
+// versions, of the packages in the database, using the package summaries.
+type Handler struct {
+	log *slog.Logger
+	db  *db.DB
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseInt(q.Get("from"), 0)
+	if err != nil || from < 0 {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	size, err := parseInt(q.Get("size"), defaultSize)
+	if err != nil || size < 1 {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	size = min(size, maxSize)
+
+	summaries, err := h.db.ListSummaries(r.Context())
+	if err != nil {
+		h.log.Error("failed to list package summaries", slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	query := ParseQuery(q.Get("text"))
+	var objects []models.SearchObject
+	for _, summary := range summaries {
+		result := newSearchPackage(summary)
+		score, ok := query.Score(result)
+		if !ok {
+			continue
+		}
+		objects = append(objects, models.SearchObject{
+			Package:     result,
+			Score:       models.SearchScore{Final: score},
+			SearchScore: score,
+		})
+	}
+	slices.SortStableFunc(objects, func(a, b models.SearchObject) int {
+		if c := cmp.Compare(b.SearchScore, a.SearchScore); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Package.Name, b.Package.Name)
+	})
+
+	response := models.SearchResponse{
+		Objects: objects[min(from, len(objects)):min(from+size, len(objects))],
+		Total:   len(objects),
+		Time:    time.Now().UTC().Format(time.RFC3339),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.log.Error("failed to encode search response", slog.Any("error", err))
+	}
+}
+
+func parseInt(s string, defaultValue int) (int, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// newSearchPackage describes a package in search results.
+func newSearchPackage(summary models.PackageSummary) models.SearchPackage {
+	scope := "unscoped"
+	if s, _, ok := strings.Cut(summary.Name, "/"); ok && strings.HasPrefix(s, "@") {
+		scope = strings.TrimPrefix(s, "@")
+	}
+	return models.SearchPackage{
+		Name:        summary.Name,
+		Scope:       scope,
+		Version:     summary.Version,
+		Description: summary.Description,
+		Keywords:    summary.Keywords,
+		Links:       map[string]string{},
+		Publisher:   summary.Publisher,
+	}
+}
+
+// Query is a parsed search query, e.g. "react router keywords:navigation".
+type Query struct {
+	// Terms must each match the name, a keyword, or the description of a package.
+	Terms []string
+	// Keywords restrict results to packages that have at least one of the keywords.
+	Keywords []string
+	// Scope restricts results to packages in the scope.
+	Scope string
+}
+
+// ParseQuery parses search text. The keywords: and scope: qualifiers are
+// supported, other qualifiers are ignored.
+func ParseQuery(text string) (q Query) {
+	for field := range strings.FieldsSeq(strings.ToLower(text)) {
+		qualifier, value, ok := strings.Cut(field, ":")
+		if !ok {
+			q.Terms = append(q.Terms, field)
+			continue
+		}
+		switch qualifier {
+		case "keywords":
+			q.Keywords = append(q.Keywords, strings.Split(value, ",")...)
+		case "scope":
+			q.Scope = strings.TrimPrefix(value, "@")
+		}
+	}
+	return q
+}
+
+// Score returns a score between 0 and 1 for how well the package matches the
+// query, and false if the package doesn't match.
+func (q Query) Score(pkg models.SearchPackage) (score float64, ok bool) {
+	name := strings.ToLower(pkg.Name)
+	description := strings.ToLower(pkg.Description)
+	keywords := make([]string, len(pkg.Keywords))
+	for i, keyword := range pkg.Keywords {
+		keywords[i] = strings.ToLower(keyword)
+	}
+
+	if q.Scope != "" && pkg.Scope != q.Scope {
+		return 0, false
+	}
+	if len(q.Keywords) > 0 && !slices.ContainsFunc(q.Keywords, func(k string) bool { return slices.Contains(keywords, k) }) {
+		return 0, false
+	}
+	if len(q.Terms) == 0 {
+		return 1, true
+	}
+
+	const maxTermScore = 4
+	var total int
+	for _, term := range q.Terms {
+		var termScore int
+		switch {
+		case name == term || strings.TrimPrefix(name, "@"+pkg.Scope+"/") == term:
+			termScore = 4
+		case strings.Contains(name, term):
+			termScore = 3
+		case slices.Contains(keywords, term):
+			termScore = 2
+		case strings.Contains(description, term):
+			termScore = 1
+		default:
+			return 0, false
+		}
+		total += termScore
+	}
+	return float64(total) / float64(maxTermScore*len(q.Terms)), true
+}
@@ -1,29 +1,42 @@
 package tarball
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"path"
 	"strings"
 
+	"github.com/a-h/depot/auth"
 	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/npm/db"
+	"github.com/a-h/depot/npm/download"
+	"github.com/a-h/depot/npm/sri"
 	"github.com/a-h/depot/storage"
 )
 
-func New(log *slog.Logger, storage storage.Storage, metrics metrics.Metrics) Handler {
+// New creates a tarball handler. If upstream is non-nil, tarballs that are not
+// in storage are downloaded from the upstream registry.
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *download.Downloader, metrics metrics.Metrics) Handler {
 	return Handler{
-		log:     log,
-		storage: storage,
-		metrics: metrics,
+		log:      log,
+		db:       db,
+		storage:  storage,
+		upstream: upstream,
+		metrics:  metrics,
 	}
 }
 
 // Handler serves NPM package tarballs.
 type Handler struct {
-	log     *slog.Logger
-	storage storage.Storage
-	metrics metrics.Metrics
+	log      *slog.Logger
+	db       *db.DB
+	storage  storage.Storage
+	upstream *download.Downloader
+	metrics  metrics.Metrics
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +47,9 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		h.Put(w, r)
 		return
+	case http.MethodDelete:
+		h.Delete(w, r)
+		return
 	}
 	http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
 }
@@ -55,6 +71,14 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if !exists && h.upstream != nil {
+		file, exists, err = h.getFromUpstream(r.Context(), requestPath)
+		if err != nil {
+			h.log.Error("failed to download tarball from upstream", slog.String("path", requestPath), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
 	if !exists {
 		http.Error(w, "tarball not found", http.StatusNotFound)
 		return
@@ -74,6 +98,47 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 	h.metrics.IncrementDownloadMetrics(r.Context(), "npm", bytesDownloaded)
 }
 
+// getFromUpstream downloads a tarball from the upstream registry into storage,
+// and returns a reader for it. The request path must follow the npm registry
+// convention of <name>/-/<unscoped-name>-<version>.tgz.
+func (h Handler) getFromUpstream(ctx context.Context, requestPath string) (file io.ReadCloser, exists bool, err error) {
+	name, version, ok := parseTarballPath(requestPath)
+	if !ok {
+		return nil, false, nil
+	}
+	versionMetadata, err := h.upstream.FetchVersion(ctx, name, version)
+	if errors.Is(err, download.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err = h.upstream.DownloadTarball(ctx, versionMetadata, requestPath); err != nil {
+		return nil, false, err
+	}
+	h.log.Debug("downloaded tarball from upstream", slog.String("path", requestPath))
+	return h.storage.Get(ctx, requestPath)
+}
+
+// parseTarballPath extracts the package name and version from a tarball path
+// such as @scope/name/-/name-1.0.0.tgz. Tarballs of scoped packages pushed by
+// older versions of depot include the scope, e.g. @scope/name/-/@scope/name-1.0.0.tgz.
+func parseTarballPath(requestPath string) (name, version string, ok bool) {
+	name, file, ok := strings.Cut(requestPath, "/-/")
+	if !ok {
+		return "", "", false
+	}
+	file = strings.TrimSuffix(file, ".tgz")
+	version, ok = strings.CutPrefix(file, path.Base(name)+"-")
+	if !ok {
+		version, ok = strings.CutPrefix(file, name+"-")
+	}
+	if !ok || version == "" {
+		return "", "", false
+	}
+	return name, version, true
+}
+
 func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
@@ -86,6 +151,14 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If the version metadata has already been stored, the tarball must match its integrity.
+	verifier, err := h.getVerifier(r.Context(), path)
+	if err != nil {
+		h.log.Error("failed to get version metadata", slog.String("path", path), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Use Storage interface for writing.
 	f, err := h.storage.Put(r.Context(), path)
 	if err != nil {
@@ -93,17 +166,88 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer f.Close()
 
-	// Copy request body to storage.
-	bytesWritten, err := io.Copy(f, r.Body)
+	// Copy request body to storage, computing the integrity while streaming.
+	var dst io.Writer = f
+	if verifier != nil {
+		dst = io.MultiWriter(f, verifier)
+	}
+	bytesWritten, err := io.Copy(dst, r.Body)
 	if err != nil {
+		storage.Abort(f)
 		h.log.Error("failed to save tarball", slog.String("path", path), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if verifier != nil {
+		if err := verifier.Verify(); err != nil {
+			storage.Abort(f)
+			h.log.Warn("tarball doesn't match version metadata", slog.String("path", path), slog.Any("error", err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := f.Close(); err != nil {
+		h.log.Error("failed to complete upload to storage", slog.String("path", path), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
 	h.metrics.IncrementUploadMetrics(r.Context(), "npm", bytesWritten)
 
 	h.log.Debug("tarball uploaded successfully", slog.String("path", path))
 	w.WriteHeader(http.StatusOK)
 }
+
+// getVerifier returns a verifier for the integrity of the stored version
+// metadata of the tarball at requestPath. It returns nil if there's no stored
+// metadata, or the metadata has no integrity or shasum.
+func (h Handler) getVerifier(ctx context.Context, requestPath string) (verifier *sri.Verifier, err error) {
+	name, version, ok := parseTarballPath(requestPath)
+	if !ok {
+		return nil, nil
+	}
+	metadata, ok, err := h.db.GetPackageVersion(ctx, name, version)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if metadata.Dist == nil || metadata.Dist.SRI() == "" {
+		return nil, nil
+	}
+	return sri.NewVerifier(metadata.Dist.SRI())
+}
+
+// Delete handles the tarball deletion made by npm unpublish after it has removed
+// the version from the package document. Tarballs of versions that are still
+// published can't be deleted, and paths that aren't tarball paths are rejected.
+func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	requestPath := strings.TrimPrefix(r.URL.Path, "/")
+	// npm builds the path from the tarball URL, so it includes the /npm/ prefix of the registry.
+	if trimmed, ok := strings.CutPrefix(requestPath, "npm/"); ok && strings.Contains(trimmed, "/-/") {
+		requestPath = trimmed
+	}
+
+	name, version, ok := parseTarballPath(requestPath)
+	if !ok {
+		http.Error(w, "invalid tarball path", http.StatusBadRequest)
+		return
+	}
+	_, exists, err := h.db.GetPackageVersion(r.Context(), name, version)
+	if err != nil {
+		h.log.Error("failed to get version metadata", slog.String("path", requestPath), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, fmt.Sprintf("version %s of %s is published, unpublish it first", version, name), http.StatusConflict)
+		return
+	}
+
+	if err := h.storage.Delete(r.Context(), requestPath); err != nil {
+		h.log.Error("failed to delete tarball", slog.String("path", requestPath), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.log.Info("deleted tarball", slog.String("path", requestPath), slog.String("fingerprint", auth.KeyFingerprint(r.Context())))
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 )
@@ -15,23 +17,32 @@ type AbbreviatedPackage struct {
 
 // AbbreviatedVersion represents the abbreviated version metadata.
 type AbbreviatedVersion struct {
-	Name                 string            `json:"name"`
-	Version              string            `json:"version"`
-	Deprecated           json.RawMessage   `json:"deprecated,omitempty"`
-	Dist                 *Dist             `json:"dist"`
-	Dependencies         map[string]string `json:"dependencies,omitempty"`
-	OptionalDependencies map[string]string `json:"optionalDependencies,omitempty"`
-	DevDependencies      map[string]string `json:"devDependencies,omitempty"`
-	BundledDependencies  []string          `json:"bundledDependencies,omitempty"`
-	PeerDependencies     map[string]string `json:"peerDependencies,omitempty"`
-	Bin                  json.RawMessage   `json:"bin,omitempty"`
-	Directories          json.RawMessage   `json:"directories,omitempty"`
-	Engines              json.RawMessage   `json:"engines,omitempty"`
-	ID                   json.RawMessage   `json:"_id"`
-	NodeVersion          json.RawMessage   `json:"_nodeVersion,omitempty"`
-	NpmVersion           json.RawMessage   `json:"_npmVersion,omitempty"`
-	NpmUser              *Person           `json:"_npmUser,omitempty"`
-	HasShrinkwrap        bool              `json:"_hasShrinkwrap,omitempty"`
+	Name                 string                        `json:"name"`
+	Version              string                        `json:"version"`
+	Deprecated           json.RawMessage               `json:"deprecated,omitempty"`
+	Dist                 *Dist                         `json:"dist"`
+	Dependencies         map[string]string             `json:"dependencies,omitempty"`
+	OptionalDependencies map[string]string             `json:"optionalDependencies,omitempty"`
+	DevDependencies      map[string]string             `json:"devDependencies,omitempty"`
+	BundledDependencies  []string                      `json:"bundledDependencies,omitempty"`
+	PeerDependencies     map[string]string             `json:"peerDependencies,omitempty"`
+	PeerDependenciesMeta map[string]PeerDependencyMeta `json:"peerDependenciesMeta,omitempty"`
+	Bin                  json.RawMessage               `json:"bin,omitempty"`
+	Directories          json.RawMessage               `json:"directories,omitempty"`
+	Engines              json.RawMessage               `json:"engines,omitempty"`
+	ID                   json.RawMessage               `json:"_id"`
+	NodeVersion          json.RawMessage               `json:"_nodeVersion,omitempty"`
+	NpmVersion           json.RawMessage               `json:"_npmVersion,omitempty"`
+	NpmUser              *Person                       `json:"_npmUser,omitempty"`
+	HasShrinkwrap        bool                          `json:"_hasShrinkwrap,omitempty"`
+	OS                   []string                      `json:"os,omitempty"`
+	CPU                  []string                      `json:"cpu,omitempty"`
+	Libc                 []string                      `json:"libc,omitempty"`
+}
+
+// PeerDependencyMeta is the metadata of a peer dependency, e.g. whether it's optional.
+type PeerDependencyMeta struct {
+	Optional bool `json:"optional,omitempty"`
 }
 
 // Dist represents distribution information.
@@ -44,6 +55,20 @@ type Dist struct {
 	Signatures   []DistSignature `json:"signatures,omitempty"`
 }
 
+// SRI returns the integrity of the tarball, falling back to the SHA-1 shasum
+// for packages published before the integrity field was added. It's empty if
+// neither is set.
+func (d Dist) SRI() string {
+	if d.Integrity != "" {
+		return d.Integrity
+	}
+	sum, err := hex.DecodeString(d.Shasum)
+	if err != nil || len(sum) == 0 {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(sum)
+}
+
 // DistSignature represents a distribution signature.
 type DistSignature struct {
 	KeyID string `json:"keyid"`
@@ -57,3 +82,66 @@ type Person struct {
 	URL      string `json:"url,omitempty"`
 	Username string `json:"username,omitempty"`
 }
+
+// PublishDocument is the document sent by npm publish.
+type PublishDocument struct {
+	Package
+	Attachments map[string]Attachment `json:"_attachments,omitempty"`
+}
+
+// Attachment is a package tarball within a publish document.
+type Attachment struct {
+	ContentType string `json:"content_type"`
+	// Data is the base64 encoded tarball.
+	Data   string `json:"data"`
+	Length int    `json:"length"`
+}
+
+// SearchResponse is the response of the /-/v1/search endpoint.
+type SearchResponse struct {
+	Objects []SearchObject `json:"objects"`
+	Total   int            `json:"total"`
+	Time    string         `json:"time"`
+}
+
+// SearchObject is a single search result.
+type SearchObject struct {
+	Package     SearchPackage `json:"package"`
+	Score       SearchScore   `json:"score"`
+	SearchScore float64       `json:"searchScore"`
+}
+
+// SearchPackage is the package metadata within a search result.
+type SearchPackage struct {
+	Name        string            `json:"name"`
+	Scope       string            `json:"scope"`
+	Version     string            `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Links       map[string]string `json:"links"`
+	Publisher   *Person           `json:"publisher,omitempty"`
+}
+
+// SearchScore is the score of a search result.
+type SearchScore struct {
+	Final  float64           `json:"final"`
+	Detail SearchScoreDetail `json:"detail"`
+}
+
+// SearchScoreDetail contains the components of a search score. Depot doesn't
+// track quality, popularity or maintenance, so they're always zero, and only
+// the final score is meaningful. The fields are kept because npm clients expect them.
+type SearchScoreDetail struct {
+	Quality     float64 `json:"quality"`
+	Popularity  float64 `json:"popularity"`
+	Maintenance float64 `json:"maintenance"`
+}
+
+// PackageSummary is the metadata of a package that's searched, taken from the latest version of the package.
+type PackageSummary struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Publisher   *Person  `json:"publisher,omitempty"`
+}
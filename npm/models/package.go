@@ -0,0 +1,144 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Package is the full package metadata document, also known as a packument.
+// It's returned to clients that don't request the abbreviated format, e.g. npm view.
+type Package struct {
+	ID             string               `json:"_id,omitempty"`
+	Name           string               `json:"name"`
+	Description    string               `json:"description,omitempty"`
+	DistTags       map[string]string    `json:"dist-tags"`
+	Versions       map[string]Version   `json:"versions"`
+	Time           map[string]time.Time `json:"time,omitempty"`
+	Maintainers    []Person             `json:"maintainers,omitempty"`
+	Author         json.RawMessage      `json:"author,omitempty"`
+	Repository     json.RawMessage      `json:"repository,omitempty"`
+	Homepage       string               `json:"homepage,omitempty"`
+	Bugs           json.RawMessage      `json:"bugs,omitempty"`
+	License        json.RawMessage      `json:"license,omitempty"`
+	Keywords       []string             `json:"keywords,omitempty"`
+	Readme         string               `json:"readme,omitempty"`
+	ReadmeFilename string               `json:"readmeFilename,omitempty"`
+}
+
+// Abbreviated returns the abbreviated metadata of the package, used by package managers to install packages.
+func (p Package) Abbreviated() AbbreviatedPackage {
+	versions := make(map[string]AbbreviatedVersion, len(p.Versions))
+	for version, v := range p.Versions {
+		versions[version] = v.AbbreviatedVersion
+	}
+	return AbbreviatedPackage{
+		Name:     p.Name,
+		Modified: p.Modified(),
+		DistTags: p.DistTags,
+		Versions: versions,
+	}
+}
+
+// Modified returns the time that the package was last modified.
+func (p Package) Modified() (modified time.Time) {
+	if t, ok := p.Time["modified"]; ok {
+		return t
+	}
+	for _, t := range p.Time {
+		if t.After(modified) {
+			modified = t
+		}
+	}
+	return modified
+}
+
+// Version is the full metadata of a package version, i.e. the package.json of
+// the version with additional registry fields such as dist. Fields that aren't
+// part of the abbreviated metadata, e.g. license and repository, are preserved
+// when the version is unmarshalled and marshalled again.
+type Version struct {
+	AbbreviatedVersion
+	// Description and Keywords aren't part of the abbreviated metadata, but are used to search for packages.
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	raw         json.RawMessage
+}
+
+func (v *Version) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.AbbreviatedVersion); err != nil {
+		return err
+	}
+	var fields struct {
+		Description json.RawMessage `json:"description"`
+		Keywords    json.RawMessage `json:"keywords"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	// Older packages may have invalid descriptions and keywords, so they're ignored rather than failing.
+	v.Description, v.Keywords = "", nil
+	_ = json.Unmarshal(fields.Description, &v.Description)
+	if err := json.Unmarshal(fields.Keywords, &v.Keywords); err != nil {
+		// npm splits keywords given as a string.
+		var keywords string
+		if json.Unmarshal(fields.Keywords, &keywords) == nil {
+			v.Keywords = strings.FieldsFunc(keywords, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+		}
+	}
+	v.raw = slices.Clone(data)
+	return nil
+}
+
+// MarshalJSON returns the original document, with the abbreviated fields,
+// description and keywords replaced by their current values, e.g. a rewritten dist.tarball.
+func (v Version) MarshalJSON() ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if len(v.raw) > 0 {
+		if err := json.Unmarshal(v.raw, &fields); err != nil {
+			return nil, err
+		}
+	}
+	abbreviated, err := json.Marshal(v.AbbreviatedVersion)
+	if err != nil {
+		return nil, err
+	}
+	var abbreviatedFields map[string]json.RawMessage
+	if err = json.Unmarshal(abbreviated, &abbreviatedFields); err != nil {
+		return nil, err
+	}
+	for _, name := range abbreviatedVersionFields {
+		delete(fields, name)
+	}
+	for name, value := range abbreviatedFields {
+		if string(value) != "null" {
+			fields[name] = value
+		}
+	}
+	delete(fields, "description")
+	if v.Description != "" {
+		if fields["description"], err = json.Marshal(v.Description); err != nil {
+			return nil, err
+		}
+	}
+	delete(fields, "keywords")
+	if len(v.Keywords) > 0 {
+		if fields["keywords"], err = json.Marshal(v.Keywords); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// abbreviatedVersionFields are the JSON field names of AbbreviatedVersion.
+var abbreviatedVersionFields = func() (names []string) {
+	t := reflect.TypeFor[AbbreviatedVersion]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}()
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestVersion(t *testing.T) {
+	input := `{
+		"name": "pkg",
+		"version": "1.0.0",
+		"description": "A package",
+		"license": "MIT",
+		"repository": {"type": "git", "url": "git+https://github.com/example/pkg.git"},
+		"deprecated": "use pkg@2",
+		"dist": {"shasum": "abc", "tarball": "https://registry.npmjs.org/pkg/-/pkg-1.0.0.tgz"}
+	}`
+	var v Version
+	if err := json.Unmarshal([]byte(input), &v); err != nil {
+		t.Fatalf("failed to unmarshal version: %v", err)
+	}
+	if v.Name != "pkg" || v.Version != "1.0.0" || v.Description != "A package" {
+		t.Errorf("unexpected abbreviated fields: %+v", v.AbbreviatedVersion)
+	}
+
+	v.Dist.Tarball = "https://depot.example.com/npm/pkg/-/pkg-1.0.0.tgz"
+	v.Deprecated = nil
+	output, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal version: %v", err)
+	}
+	var actual map[string]any
+	if err := json.Unmarshal(output, &actual); err != nil {
+		t.Fatalf("failed to unmarshal output: %v", err)
+	}
+
+	t.Run("fields that aren't in the abbreviated metadata are kept", func(t *testing.T) {
+		if actual["license"] != "MIT" {
+			t.Errorf("expected license MIT, got %v", actual["license"])
+		}
+		repository, ok := actual["repository"].(map[string]any)
+		if !ok || repository["url"] != "git+https://github.com/example/pkg.git" {
+			t.Errorf("expected repository to be kept, got %v", actual["repository"])
+		}
+	})
+	t.Run("changes to abbreviated fields are applied", func(t *testing.T) {
+		dist, ok := actual["dist"].(map[string]any)
+		if !ok || dist["tarball"] != v.Dist.Tarball {
+			t.Errorf("expected tarball %q, got %v", v.Dist.Tarball, actual["dist"])
+		}
+		if _, ok := actual["deprecated"]; ok {
+			t.Errorf("expected deprecated to be removed, got %v", actual["deprecated"])
+		}
+	})
+	t.Run("the description isn't part of the abbreviated metadata", func(t *testing.T) {
+		if actual["description"] != "A package" {
+			t.Errorf("expected description to be kept, got %v", actual["description"])
+		}
+		abbreviated, err := json.Marshal(v.AbbreviatedVersion)
+		if err != nil {
+			t.Fatalf("failed to marshal abbreviated version: %v", err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(abbreviated, &fields); err != nil {
+			t.Fatalf("failed to unmarshal abbreviated version: %v", err)
+		}
+		if _, ok := fields["description"]; ok {
+			t.Errorf("expected no description in the abbreviated version, got %v", fields["description"])
+		}
+	})
+}
+
+func TestVersionKeywords(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "array",
+			input:    `{"name": "pkg", "version": "1.0.0", "keywords": ["http", "router"]}`,
+			expected: []string{"http", "router"},
+		},
+		{
+			name:     "string",
+			input:    `{"name": "pkg", "version": "1.0.0", "keywords": "http, router"}`,
+			expected: []string{"http", "router"},
+		},
+		{
+			name:     "invalid",
+			input:    `{"name": "pkg", "version": "1.0.0", "keywords": {"http": true}}`,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Version
+			if err := json.Unmarshal([]byte(tt.input), &v); err != nil {
+				t.Fatalf("failed to unmarshal version: %v", err)
+			}
+			if !slices.Equal(v.Keywords, tt.expected) {
+				t.Errorf("expected keywords %v, got %v", tt.expected, v.Keywords)
+			}
+		})
+	}
+}
+
+func TestPackageAbbreviated(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	modified := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	pkg := Package{
+		Name:     "pkg",
+		Readme:   "# pkg",
+		DistTags: map[string]string{"latest": "1.0.0"},
+		Versions: map[string]Version{
+			"1.0.0": {AbbreviatedVersion: AbbreviatedVersion{Name: "pkg", Version: "1.0.0"}},
+		},
+	}
+
+	t.Run("modified is read from the time field", func(t *testing.T) {
+		pkg.Time = map[string]time.Time{"created": created, "modified": modified, "1.0.0": created}
+		if actual := pkg.Abbreviated().Modified; !actual.Equal(modified) {
+			t.Errorf("expected %v, got %v", modified, actual)
+		}
+	})
+	t.Run("modified falls back to the latest time", func(t *testing.T) {
+		pkg.Time = map[string]time.Time{"created": created, "1.0.0": modified}
+		if actual := pkg.Abbreviated().Modified; !actual.Equal(modified) {
+			t.Errorf("expected %v, got %v", modified, actual)
+		}
+	})
+	t.Run("versions are abbreviated", func(t *testing.T) {
+		abbreviated := pkg.Abbreviated()
+		if abbreviated.Versions["1.0.0"].Version != "1.0.0" || abbreviated.DistTags["latest"] != "1.0.0" {
+			t.Errorf("unexpected abbreviated package: %+v", abbreviated)
+		}
+	})
+}
@@ -1,15 +1,65 @@
 package pkglock
 
 import (
-	"context"
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
 	"maps"
+	"path/filepath"
 	"slices"
 	"strings"
+
+	"github.com/Masterminds/semver/v3"
 )
 
+// Locked is a package version locked by a lockfile.
+type Locked struct {
+	Name    string
+	Version string
+	// Integrity is the SRI hash of the tarball recorded in the lockfile, e.g. "sha512-...".
+	// It's empty if the lockfile doesn't record a hash that can be checked against the tarball.
+	Integrity string
+	// Optional is true if the package is only installed as an optional dependency,
+	// e.g. a platform-specific binary.
+	Optional bool
+}
+
+func (l Locked) String() string {
+	return l.Name + "@" + l.Version
+}
+
+// Lockfile is the registry packages locked by a lockfile.
+type Lockfile struct {
+	// Packages sorted by name and version.
+	Packages []Locked
+	// Skipped contains dependencies that aren't installed from a registry, e.g. git, file and workspace dependencies.
+	Skipped []string
+}
+
+// IsLockfile returns true if the file at path is a lockfile supported by Parse.
+func IsLockfile(path string) bool {
+	switch filepath.Base(path) {
+	case "package-lock.json", "npm-shrinkwrap.json", "yarn.lock", "pnpm-lock.yaml":
+		return true
+	}
+	return false
+}
+
+// Parse parses a package-lock.json, npm-shrinkwrap.json, yarn.lock or
+// pnpm-lock.yaml file, depending on the filename.
+func Parse(path string, r io.Reader) (f Lockfile, err error) {
+	switch filepath.Base(path) {
+	case "package-lock.json", "npm-shrinkwrap.json":
+		return ParsePackageLock(r)
+	case "yarn.lock":
+		return ParseYarnLock(r)
+	case "pnpm-lock.yaml":
+		return ParsePNPMLock(r)
+	}
+	return f, fmt.Errorf("unsupported lockfile %q", path)
+}
+
 type NPMLock struct {
 	Name     string             `json:"name"`
 	Version  string             `json:"version"`
@@ -21,47 +71,41 @@ type Package struct {
 	Version      string            `json:"version"`
 	Resolved     string            `json:"resolved"`
 	Integrity    string            `json:"integrity"`
+	Link         bool              `json:"link"`
+	Optional     bool              `json:"optional"`
 	Dependencies map[string]string `json:"dependencies"`
 }
 
-// Parse reads an npm package-lock.json (v2/v3) and returns a sorted
-// list of unique "name@version" strings for registry packages.
-func Parse(ctx context.Context, r io.Reader) (pkgs []string, err error) {
+// ParsePackageLock parses an npm package-lock.json or npm-shrinkwrap.json (v2/v3).
+func ParsePackageLock(r io.Reader) (f Lockfile, err error) {
 	var lockFile NPMLock
 	if err = json.NewDecoder(r).Decode(&lockFile); err != nil {
-		return nil, fmt.Errorf("failed to parse lock file: %w", err)
+		return f, fmt.Errorf("failed to parse lock file: %w", err)
 	}
 
-	unique := make(map[string]struct{})
-
+	packages := newLockedSet()
 	for installPath, pkg := range lockFile.Packages {
-		if installPath == "" {
+		// Skip the root project, and the source directories of workspaces.
+		if !strings.Contains(installPath, "node_modules/") {
 			continue
 		}
 
-		// Skip packages that don't come from the npm registry (local, git, etc.).
-		if pkg.Resolved == "" ||
-			strings.HasPrefix(pkg.Resolved, "file:") ||
-			strings.HasPrefix(pkg.Resolved, "git+") {
-			continue
-		}
-
-		// Use the true published name if present.
+		// Use the true published name if present, e.g. for aliases.
 		name := pkg.Name
 		if name == "" {
 			name = stripNodeModulesPath(installPath)
 		}
 
-		if name == "" || pkg.Version == "" {
+		// Skip packages that don't come from the npm registry (workspaces, local, git, etc.).
+		if pkg.Link || (pkg.Resolved != "" && !isRegistryTarball(pkg.Resolved)) || !isVersion(pkg.Version) {
+			packages.skip(name + "@" + cmp.Or(pkg.Resolved, pkg.Version))
 			continue
 		}
 
-		unique[fmt.Sprintf("%s@%s", name, pkg.Version)] = struct{}{}
+		packages.add(Locked{Name: name, Version: pkg.Version, Integrity: pkg.Integrity, Optional: pkg.Optional})
 	}
 
-	pkgs = slices.Collect(maps.Keys(unique))
-	slices.Sort(pkgs)
-	return pkgs, nil
+	return packages.lockfile(), nil
 }
 
 func stripNodeModulesPath(p string) string {
@@ -71,3 +115,59 @@ func stripNodeModulesPath(p string) string {
 	}
 	return p[idx+len("node_modules/"):]
 }
+
+// lockedSet collects the unique packages of a lockfile.
+type lockedSet struct {
+	packages map[string]Locked
+	skipped  map[string]struct{}
+}
+
+func newLockedSet() *lockedSet {
+	return &lockedSet{
+		packages: make(map[string]Locked),
+		skipped:  make(map[string]struct{}),
+	}
+}
+
+func (s *lockedSet) add(l Locked) {
+	if existing, ok := s.packages[l.String()]; ok {
+		// The package is required if any copy of it is required.
+		l.Optional = l.Optional && existing.Optional
+		l.Integrity = cmp.Or(existing.Integrity, l.Integrity)
+	}
+	s.packages[l.String()] = l
+}
+
+func (s *lockedSet) skip(dependency string) {
+	s.skipped[dependency] = struct{}{}
+}
+
+func (s *lockedSet) lockfile() (f Lockfile) {
+	for _, key := range slices.Sorted(maps.Keys(s.packages)) {
+		f.Packages = append(f.Packages, s.packages[key])
+	}
+	f.Skipped = slices.Sorted(maps.Keys(s.skipped))
+	return f
+}
+
+// splitSpec splits a dependency specifier such as "@scope/name@^1.0.0" into the name and range.
+func splitSpec(spec string) (name, rng string) {
+	i := strings.Index(spec[min(1, len(spec)):], "@")
+	if i == -1 {
+		return spec, ""
+	}
+	i++
+	return spec[:i], spec[i+1:]
+}
+
+// isRegistryTarball returns true if the URL is a tarball in an npm registry,
+// e.g. https://registry.npmjs.org/accepts/-/accepts-1.3.8.tgz.
+func isRegistryTarball(url string) bool {
+	return (strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) && strings.Contains(url, "/-/")
+}
+
+// isVersion returns true if v is an exact version, rather than a range, path or URL.
+func isVersion(v string) bool {
+	_, err := semver.StrictNewVersion(v)
+	return err == nil
+}
@@ -0,0 +1,276 @@
+package pkglock
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		expected []Locked
+		skipped  []string
+	}{
+		{
+			name:     "package-lock.json",
+			filename: "package-lock.json",
+			content: `{
+  "name": "app",
+  "version": "1.0.0",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app", "version": "1.0.0", "workspaces": ["packages/*"]},
+    "node_modules/@babel/code-frame": {
+      "version": "7.18.6",
+      "resolved": "https://registry.npmjs.org/@babel/code-frame/-/code-frame-7.18.6.tgz",
+      "integrity": "sha512-aaaa"
+    },
+    "node_modules/string-width-cjs": {
+      "name": "string-width",
+      "version": "4.2.3",
+      "resolved": "https://registry.npmjs.org/string-width/-/string-width-4.2.3.tgz",
+      "integrity": "sha512-bbbb"
+    },
+    "node_modules/@esbuild/linux-x64": {
+      "version": "0.19.0",
+      "resolved": "https://registry.npmjs.org/@esbuild/linux-x64/-/linux-x64-0.19.0.tgz",
+      "integrity": "sha512-cccc",
+      "cpu": ["x64"],
+      "optional": true,
+      "os": ["linux"]
+    },
+    "node_modules/lib": {"resolved": "packages/lib", "link": true},
+    "node_modules/from-git": {"version": "1.0.0", "resolved": "git+ssh://git@github.com/example/from-git.git#abc123"},
+    "packages/lib": {"name": "lib", "version": "1.0.0"}
+  }
+}`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6", Integrity: "sha512-aaaa"},
+				{Name: "@esbuild/linux-x64", Version: "0.19.0", Integrity: "sha512-cccc", Optional: true},
+				{Name: "string-width", Version: "4.2.3", Integrity: "sha512-bbbb"},
+			},
+			skipped: []string{"from-git@git+ssh://git@github.com/example/from-git.git#abc123", "lib@packages/lib"},
+		},
+		{
+			name:     "yarn classic",
+			filename: "yarn.lock",
+			content: `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4":
+  version "7.18.6"
+  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.18.6.tgz#3b25d38c89600baa2dcc219edfa88a74eb2c427a"
+  integrity sha512-aaaa
+  dependencies:
+    "@babel/highlight" "^7.18.6"
+
+"string-width-cjs@npm:string-width@^4.2.0":
+  version "4.2.3"
+  resolved "https://registry.yarnpkg.com/string-width/-/string-width-4.2.3.tgz#269c7117d27b05ad2e536830a8ec895ef9c6d010"
+  integrity sha512-bbbb
+
+old@^1.0.0:
+  version "1.0.0"
+  resolved "https://registry.yarnpkg.com/old/-/old-1.0.0.tgz#0123456789abcdef0123456789abcdef01234567"
+
+"from-git@https://github.com/example/from-git.git":
+  version "1.0.0"
+  resolved "https://github.com/example/from-git.git#abc123"
+
+"local@file:../local":
+  version "1.0.0"
+`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6", Integrity: "sha512-aaaa"},
+				{Name: "old", Version: "1.0.0", Integrity: "sha1-ASNFZ4mrze8BI0VniavN7wEjRWc="},
+				{Name: "string-width", Version: "4.2.3", Integrity: "sha512-bbbb"},
+			},
+			skipped: []string{"from-git@https://github.com/example/from-git.git", "local@file:../local"},
+		},
+		{
+			name:     "yarn berry",
+			filename: "yarn.lock",
+			content: `# This file is generated by running "yarn install" inside your project.
+# Manual changes might be lost - proceed with caution!
+
+__metadata:
+  version: 8
+  cacheKey: 10c0
+
+"@babel/code-frame@npm:^7.0.0, @babel/code-frame@npm:^7.10.4":
+  version: 7.18.6
+  resolution: "@babel/code-frame@npm:7.18.6"
+  dependencies:
+    "@babel/highlight": "npm:^7.18.6"
+  checksum: 10c0/aaaa
+  languageName: node
+  linkType: hard
+
+"string-width-cjs@npm:string-width@^4.2.0":
+  version: 4.2.3
+  resolution: "string-width@npm:4.2.3"
+  checksum: 10c0/bbbb
+  languageName: node
+  linkType: hard
+
+"resolve@npm:^1.22.1":
+  version: 1.22.8
+  resolution: "resolve@npm:1.22.8"
+  languageName: node
+  linkType: hard
+
+"resolve@patch:resolve@npm%3A^1.22.1#optional!builtin<compat/resolve>":
+  version: 1.22.8
+  resolution: "resolve@patch:resolve@npm%3A1.22.8#optional!builtin<compat/resolve>::version=1.22.8&hash=c3c19d"
+  languageName: node
+  linkType: hard
+
+"from-git@https://github.com/example/from-git.git":
+  version: 1.0.0
+  resolution: "from-git@https://github.com/example/from-git.git#commit=abc123"
+  languageName: node
+  linkType: hard
+
+"app@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "app@workspace:."
+  languageName: unknown
+  linkType: soft
+`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6"},
+				{Name: "resolve", Version: "1.22.8"},
+				{Name: "string-width", Version: "4.2.3"},
+			},
+			skipped: []string{"app@workspace:.", "from-git@https://github.com/example/from-git.git#commit=abc123"},
+		},
+		{
+			name:     "pnpm v5",
+			filename: "pnpm-lock.yaml",
+			content: `lockfileVersion: 5.4
+
+specifiers:
+  '@babel/code-frame': ^7.0.0
+  react-dom: ^18.0.0
+
+packages:
+
+  /@babel/code-frame/7.18.6:
+    resolution: {integrity: sha512-aaaa}
+    dev: false
+
+  /react-dom/18.2.0_react@18.2.0:
+    resolution: {integrity: sha512-bbbb}
+    peerDependencies:
+      react: ^18.2.0
+
+  github.com/example/from-git/abc123:
+    resolution: {tarball: https://codeload.github.com/example/from-git/tar.gz/abc123}
+    name: from-git
+    version: 1.0.0
+`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6", Integrity: "sha512-aaaa"},
+				{Name: "react-dom", Version: "18.2.0", Integrity: "sha512-bbbb"},
+			},
+			skipped: []string{"github.com/example/from-git/abc123"},
+		},
+		{
+			name:     "pnpm v6",
+			filename: "pnpm-lock.yaml",
+			content: `lockfileVersion: '6.0'
+
+dependencies:
+  string-width-cjs:
+    specifier: npm:string-width@^4.2.0
+    version: /string-width@4.2.3
+
+packages:
+
+  /@babel/code-frame@7.18.6:
+    resolution: {integrity: sha512-aaaa}
+    dev: false
+
+  /react-dom@18.2.0(react@18.2.0):
+    resolution: {integrity: sha512-bbbb}
+
+  /string-width@4.2.3:
+    resolution: {integrity: sha512-cccc}
+
+  file:../local:
+    resolution: {directory: ../local, type: directory}
+    name: local
+    version: 1.0.0
+`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6", Integrity: "sha512-aaaa"},
+				{Name: "react-dom", Version: "18.2.0", Integrity: "sha512-bbbb"},
+				{Name: "string-width", Version: "4.2.3", Integrity: "sha512-cccc"},
+			},
+			skipped: []string{"file:../local"},
+		},
+		{
+			name:     "pnpm v9",
+			filename: "pnpm-lock.yaml",
+			content: `lockfileVersion: '9.0'
+
+importers:
+
+  .:
+    dependencies:
+      lib:
+        specifier: workspace:*
+        version: link:packages/lib
+
+packages:
+
+  '@babel/code-frame@7.18.6':
+    resolution: {integrity: sha512-aaaa}
+
+  react-dom@18.2.0:
+    resolution: {integrity: sha512-bbbb}
+    peerDependencies:
+      react: ^18.2.0
+
+  from-git@https://codeload.github.com/example/from-git/tar.gz/abc123:
+    resolution: {tarball: https://codeload.github.com/example/from-git/tar.gz/abc123}
+    version: 1.0.0
+
+snapshots:
+
+  '@babel/code-frame@7.18.6': {}
+
+  react-dom@18.2.0(react@18.2.0):
+    dependencies:
+      react: 18.2.0
+    optional: true
+`,
+			expected: []Locked{
+				{Name: "@babel/code-frame", Version: "7.18.6", Integrity: "sha512-aaaa"},
+				{Name: "react-dom", Version: "18.2.0", Integrity: "sha512-bbbb", Optional: true},
+			},
+			skipped: []string{"from-git@https://codeload.github.com/example/from-git/tar.gz/abc123"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsLockfile("/src/app/" + tt.filename) {
+				t.Fatalf("expected %s to be a lockfile", tt.filename)
+			}
+			f, err := Parse(tt.filename, strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(f.Packages, tt.expected) {
+				t.Errorf("expected packages %v, got %v", tt.expected, f.Packages)
+			}
+			if !slices.Equal(f.Skipped, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, f.Skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package pkglock
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+type pnpmLock struct {
+	LockfileVersion string                 `yaml:"lockfileVersion"`
+	Packages        map[string]pnpmPackage `yaml:"packages"`
+	// Snapshots are the installed instances of packages in version 9 lockfiles.
+	Snapshots map[string]struct {
+		Optional bool `yaml:"optional"`
+	} `yaml:"snapshots"`
+}
+
+type pnpmPackage struct {
+	// Name and Version are only set for packages that aren't from a registry, or when the key doesn't include them.
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	// Optional is set in version 5 and 6 lockfiles. Version 9 lockfiles set it in snapshots.
+	Optional   bool `yaml:"optional"`
+	Resolution struct {
+		Integrity string `yaml:"integrity"`
+		Tarball   string `yaml:"tarball"`
+		// Type is set for git and directory resolutions.
+		Type string `yaml:"type"`
+	} `yaml:"resolution"`
+}
+
+// ParsePNPMLock parses a pnpm-lock.yaml file. Lockfile versions 5, 6 and 9 are supported.
+func ParsePNPMLock(r io.Reader) (f Lockfile, err error) {
+	var lock pnpmLock
+	if err = yaml.NewDecoder(r).Decode(&lock); err != nil {
+		return f, fmt.Errorf("failed to parse pnpm-lock.yaml: %w", err)
+	}
+	if lock.LockfileVersion == "" {
+		return f, fmt.Errorf("failed to parse pnpm-lock.yaml: missing lockfileVersion")
+	}
+	// A package is optional if every snapshot of it is optional.
+	optional := make(map[string]bool)
+	for key, snapshot := range lock.Snapshots {
+		key, _, _ = strings.Cut(key, "(")
+		isOptional, seen := optional[key]
+		optional[key] = snapshot.Optional && (isOptional || !seen)
+	}
+	packages := newLockedSet()
+	for key, pkg := range lock.Packages {
+		name, version := parsePNPMKey(lock.LockfileVersion, key)
+		name, version = cmp.Or(pkg.Name, name), cmp.Or(pkg.Version, version)
+		resolution := pkg.Resolution
+		if resolution.Type != "" || resolution.Integrity == "" || (resolution.Tarball != "" && !isRegistryTarball(resolution.Tarball)) || !isVersion(version) {
+			packages.skip(strings.TrimPrefix(key, "/"))
+			continue
+		}
+		packages.add(Locked{Name: name, Version: version, Integrity: resolution.Integrity, Optional: pkg.Optional || optional[key]})
+	}
+	return packages.lockfile(), nil
+}
+
+// parsePNPMKey parses the key of a package, e.g. "/@babel/core/7.0.0_react@18.0.0"
+// in version 5 lockfiles, "/@babel/core@7.0.0(react@18.0.0)" in version 6, and
+// "@babel/core@7.0.0" in version 9. Peer dependency suffixes are removed.
+func parsePNPMKey(lockfileVersion, key string) (name, version string) {
+	key = strings.TrimPrefix(key, "/")
+	if strings.HasPrefix(lockfileVersion, "5.") || lockfileVersion == "5" {
+		i := strings.LastIndex(key, "/")
+		if i == -1 {
+			return key, ""
+		}
+		name, version = key[:i], key[i+1:]
+		version, _, _ = strings.Cut(version, "_")
+		return name, version
+	}
+	key, _, _ = strings.Cut(key, "(")
+	return splitSpec(key)
+}
@@ -0,0 +1,174 @@
+package pkglock
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ParseYarnLock parses a yarn.lock file created by Yarn classic (v1) or Yarn Berry (v2+).
+func ParseYarnLock(r io.Reader) (f Lockfile, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return f, fmt.Errorf("failed to read yarn.lock: %w", err)
+	}
+	// Berry lockfiles are YAML documents with a __metadata entry.
+	if bytes.HasPrefix(data, []byte("__metadata:")) || bytes.Contains(data, []byte("\n__metadata:")) {
+		return parseYarnBerryLock(data)
+	}
+	return parseYarnClassicLock(data)
+}
+
+type yarnClassicEntry struct {
+	specs     []string
+	version   string
+	resolved  string
+	integrity string
+}
+
+// parseYarnClassicLock parses the custom format of Yarn classic, e.g.:
+//
+//	"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4":
+//	  version "7.10.4"
+//	  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.10.4.tgz#168da1a36e90da68ae8d49c0f1b48c7c6249eb2a"
+//	  integrity sha512-vG6SvB6oYEhvgisZNFRmRCUkLz11c7rp+tbNTynGqc6mS1d5ATd/sGyV6W0KZZnXRKMTzZDRgQT3Ou9jhpAfUg==
+func parseYarnClassicLock(data []byte) (f Lockfile, err error) {
+	var entries []*yarnClassicEntry
+	var entry *yarnClassicEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// Unindented lines start a new entry.
+		if !strings.HasPrefix(line, " ") {
+			entry = &yarnClassicEntry{}
+			for spec := range strings.SplitSeq(strings.TrimSuffix(trimmed, ":"), ",") {
+				entry.specs = append(entry.specs, unquote(strings.TrimSpace(spec)))
+			}
+			entries = append(entries, entry)
+			continue
+		}
+		// Fields of dependencies and other nested objects are indented further.
+		if entry == nil || strings.HasPrefix(line, "    ") {
+			continue
+		}
+		key, value, _ := strings.Cut(trimmed, " ")
+		switch unquote(key) {
+		case "version":
+			entry.version = unquote(value)
+		case "resolved":
+			entry.resolved = unquote(value)
+		case "integrity":
+			entry.integrity = unquote(value)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return f, fmt.Errorf("failed to read yarn.lock line %d: %w", lineNumber, err)
+	}
+
+	packages := newLockedSet()
+	for _, entry := range entries {
+		name, rng := splitSpec(entry.specs[0])
+		name, _ = resolveAlias(name, rng)
+		if !isRegistryTarball(entry.resolved) || !isVersion(entry.version) {
+			packages.skip(entry.specs[0])
+			continue
+		}
+		integrity := entry.integrity
+		if integrity == "" {
+			integrity = sha1IntegrityOf(entry.resolved)
+		}
+		packages.add(Locked{Name: name, Version: entry.version, Integrity: integrity})
+	}
+	return packages.lockfile(), nil
+}
+
+// resolveAlias returns the name and range of the package installed by an
+// alias range, e.g. "npm:string-width@^4.2.0" installs string-width.
+func resolveAlias(name, rng string) (string, string) {
+	aliased, ok := strings.CutPrefix(rng, "npm:")
+	if !ok {
+		return name, rng
+	}
+	if aliasedName, aliasedRange := splitSpec(aliased); aliasedRange != "" || !isRangeStart(aliased) {
+		return aliasedName, aliasedRange
+	}
+	return name, aliased
+}
+
+// isRangeStart returns true if s starts like a version range, rather than a package name.
+func isRangeStart(s string) bool {
+	return s == "" || strings.ContainsAny(s[:1], "0123456789^~<>=*xX")
+}
+
+var sha1FragmentRegexp = regexp.MustCompile(`#([0-9a-f]{40})$`)
+
+// sha1IntegrityOf returns the SRI hash of the SHA-1 that older versions of Yarn
+// classic append to the resolved URL, or an empty string if there isn't one.
+func sha1IntegrityOf(resolved string) string {
+	m := sha1FragmentRegexp.FindStringSubmatch(resolved)
+	if m == nil {
+		return ""
+	}
+	sum, err := hex.DecodeString(m[1])
+	if err != nil {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+func unquote(s string) string {
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return unquoted
+	}
+	return s
+}
+
+type yarnBerryEntry struct {
+	Version    string `yaml:"version"`
+	Resolution string `yaml:"resolution"`
+}
+
+// parseYarnBerryLock parses a Yarn Berry lockfile. Berry records a checksum of
+// the package in the Yarn cache, rather than the tarball, so the packages don't
+// have an integrity hash.
+func parseYarnBerryLock(data []byte) (f Lockfile, err error) {
+	var lock map[string]yarnBerryEntry
+	if err = yaml.Unmarshal(data, &lock); err != nil {
+		return f, fmt.Errorf("failed to parse yarn.lock: %w", err)
+	}
+	packages := newLockedSet()
+	for key, entry := range lock {
+		if key == "__metadata" {
+			continue
+		}
+		// The resolution is the package that's installed, e.g. "string-width@npm:4.2.3" for "string-width-cjs@npm:string-width@^4.2.0".
+		name, reference := splitSpec(entry.Resolution)
+		if strings.HasPrefix(reference, "patch:") {
+			// The unpatched package is locked in its own entry.
+			continue
+		}
+		version, ok := strings.CutPrefix(reference, "npm:")
+		if !ok || !isVersion(version) {
+			packages.skip(cmp.Or(entry.Resolution, key))
+			continue
+		}
+		packages.add(Locked{Name: name, Version: version})
+	}
+	return packages.lockfile(), nil
+}
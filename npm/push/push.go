@@ -9,6 +9,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -53,7 +54,7 @@ func (p *Pusher) Push(ctx context.Context, baseDir string) error {
 				return fmt.Errorf("failed to read metadata %s: %w", path, err)
 			}
 
-			var metadata models.AbbreviatedPackage
+			var metadata models.Package
 			if err := json.Unmarshal(metadataData, &metadata); err != nil {
 				return fmt.Errorf("failed to unmarshal metadata %s: %w", path, err)
 			}
@@ -84,10 +85,11 @@ func (p *Pusher) Push(ctx context.Context, baseDir string) error {
 }
 
 // pushPackage pushes a single package to the remote depot.
-func (p *Pusher) pushPackage(ctx context.Context, metadata models.AbbreviatedPackage, packageDir string) error {
+func (p *Pusher) pushPackage(ctx context.Context, metadata models.Package, packageDir string) error {
 	p.log.Debug("pushing package", slog.String("name", metadata.Name))
 
 	// Push each version that we bothered to download.
+	var pushed int
 	for version, versionInfo := range metadata.Versions {
 		if _, err := os.Stat(filepath.Join(packageDir, fmt.Sprintf("%s-%s.tgz", versionInfo.Name, versionInfo.Version))); err != nil {
 			p.log.Debug("skipping version, tarball not found", slog.String("package", versionInfo.Name), slog.String("version", versionInfo.Version))
@@ -104,6 +106,22 @@ func (p *Pusher) pushPackage(ctx context.Context, metadata models.AbbreviatedPac
 		if err := p.pushVersion(ctx, versionInfo, tagsForVersion, packageDir); err != nil {
 			return fmt.Errorf("failed to push version %s: %w", version, err)
 		}
+		pushed++
+	}
+	if pushed == 0 {
+		return nil
+	}
+
+	// Push the package level metadata, e.g. the readme and publish times.
+	metadata.Versions = nil
+	metadata.DistTags = nil
+	packageData, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal package metadata: %w", err)
+	}
+	packageURL := fmt.Sprintf("%s/npm/%s", p.target, metadata.Name)
+	if err := p.putData(ctx, packageURL, bytes.NewReader(packageData), "application/json"); err != nil {
+		return fmt.Errorf("failed to push package metadata: %w", err)
 	}
 
 	p.log.Debug("package pushed successfully", slog.String("name", metadata.Name))
@@ -111,7 +129,7 @@ func (p *Pusher) pushPackage(ctx context.Context, metadata models.AbbreviatedPac
 }
 
 // pushVersion pushes a single version of a package.
-func (p *Pusher) pushVersion(ctx context.Context, versionInfo models.AbbreviatedVersion, tagsForVersion []string, packageDir string) error {
+func (p *Pusher) pushVersion(ctx context.Context, versionInfo models.Version, tagsForVersion []string, packageDir string) error {
 	p.log.Debug("pushing version", slog.String("package", versionInfo.Name), slog.String("version", versionInfo.Version))
 
 	// Find the tarball file.
@@ -125,8 +143,9 @@ func (p *Pusher) pushVersion(ctx context.Context, versionInfo models.Abbreviated
 	}
 	defer tarballFile.Close()
 
-	// Push tarball.
-	tarballURL := fmt.Sprintf("%s/npm/%s/-/%s", p.target, versionInfo.Name, tarballName)
+	// Push tarball to the path used by npm registries, e.g. @scope/name/-/name-1.0.0.tgz.
+	// The server checks it against the version metadata when that is pushed.
+	tarballURL := fmt.Sprintf("%s/npm/%s/-/%s-%s.tgz", p.target, versionInfo.Name, path.Base(versionInfo.Name), versionInfo.Version)
 	if err := p.putData(ctx, tarballURL, tarballFile, "application/octet-stream"); err != nil {
 		return fmt.Errorf("failed to push tarball: %w", err)
 	}
@@ -140,11 +159,20 @@ func (p *Pusher) pushVersion(ctx context.Context, versionInfo models.Abbreviated
 		return fmt.Errorf("failed to marshal version metadata: %w", err)
 	}
 
-	tagsForVersion = append(tagsForVersion, versionInfo.Version)
+	versionURL := fmt.Sprintf("%s/npm/%s/%s", p.target, versionInfo.Name, versionInfo.Version)
+	if err := p.putData(ctx, versionURL, bytes.NewReader(versionData), "application/json"); err != nil {
+		return fmt.Errorf("failed to push version metadata: %w", err)
+	}
+
+	// Push dist-tags, e.g. latest, next and canary.
+	versionJSON, err := json.Marshal(versionInfo.Version)
+	if err != nil {
+		return fmt.Errorf("failed to marshal version: %w", err)
+	}
 	for _, tag := range tagsForVersion {
-		versionURL := fmt.Sprintf("%s/npm/%s/%s", p.target, versionInfo.Name, tag)
-		if err := p.putData(ctx, versionURL, bytes.NewReader(versionData), "application/json"); err != nil {
-			return fmt.Errorf("failed to push version metadata: %w", err)
+		tagURL := fmt.Sprintf("%s/npm/-/package/%s/dist-tags/%s", p.target, versionInfo.Name, tag)
+		if err := p.putData(ctx, tagURL, bytes.NewReader(versionJSON), "application/json"); err != nil {
+			return fmt.Errorf("failed to push dist-tag %s: %w", tag, err)
 		}
 	}
 
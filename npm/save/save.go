@@ -3,13 +3,16 @@ package save
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
 	"log/slog"
+	"os"
 	"strings"
 
 	"github.com/a-h/depot/npm/download"
+	"github.com/a-h/depot/npm/pkglock"
 	"github.com/a-h/depot/storage"
 )
 
@@ -27,6 +30,12 @@ func New(log *slog.Logger, storage storage.Storage) *Saver {
 	}
 }
 
+// SetOptions sets the optional and peer dependencies that are saved, and the
+// platforms that optional dependencies must support.
+func (s *Saver) SetOptions(options download.Options) {
+	s.downloader.SetOptions(options)
+}
+
 // Save saves packages from command line arguments.
 func (s *Saver) Save(ctx context.Context, packages []string) error {
 	if len(packages) == 0 {
@@ -47,6 +56,12 @@ func (s *Saver) Save(ctx context.Context, packages []string) error {
 		}
 		alreadySeen[spec.String()] = true
 		deps, err := s.downloader.Download(ctx, spec, false, false)
+		if err != nil && spec.Optional {
+			s.logSkippedOptional(spec, err)
+			// The package may still be required by another package.
+			delete(alreadySeen, spec.String())
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("failed to download package %s: %w", spec.String(), err)
 		}
@@ -69,6 +84,54 @@ func (s *Saver) Save(ctx context.Context, packages []string) error {
 	return nil
 }
 
+// SaveFromLockfile saves the packages locked in a package-lock.json,
+// npm-shrinkwrap.json, yarn.lock or pnpm-lock.yaml file. Lockfiles already list
+// every dependency, so dependencies aren't resolved. Tarballs are checked
+// against the integrity hashes recorded in the lockfile.
+func (s *Saver) SaveFromLockfile(ctx context.Context, path string) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open lockfile: %w", err)
+	}
+	defer r.Close()
+	f, err := pkglock.Parse(path, r)
+	if err != nil {
+		return err
+	}
+	for _, skipped := range f.Skipped {
+		s.log.Warn("skipping dependency, only packages from a registry can be saved", slog.String("dependency", skipped))
+	}
+	if len(f.Packages) == 0 {
+		return fmt.Errorf("no packages found in lockfile %s", path)
+	}
+
+	s.log.Info("saving packages", slog.Int("count", len(f.Packages)))
+	for _, pkg := range f.Packages {
+		spec := download.PackageSpec{Name: pkg.Name, Version: pkg.Version, Integrity: pkg.Integrity, Optional: pkg.Optional}
+		_, err := s.downloader.Download(ctx, spec, false, false)
+		if err != nil && spec.Optional {
+			s.logSkippedOptional(spec, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download package %s: %w", spec.String(), err)
+		}
+		s.log.Info("downloaded package", slog.String("package", spec.String()), slog.Bool("verified", pkg.Integrity != ""))
+	}
+	s.log.Info("all packages saved", slog.Int("total", len(f.Packages)))
+	return nil
+}
+
+// logSkippedOptional logs an optional dependency that couldn't be downloaded.
+// Optional dependencies for other platforms are expected, so they're only logged at debug level.
+func (s *Saver) logSkippedOptional(spec download.PackageSpec, err error) {
+	if errors.Is(err, download.ErrUnsupportedPlatform) {
+		s.log.Debug("skipping optional dependency for another platform", slog.String("dependency", spec.String()))
+		return
+	}
+	s.log.Warn("skipping optional dependency", slog.String("dependency", spec.String()), slog.Any("error", err))
+}
+
 func NewSliceIterator[T any](slice []T) *SliceIterator[T] {
 	return &SliceIterator[T]{slice: slice}
 }
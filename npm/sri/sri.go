@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"hash"
+	"io"
 	"strings"
 )
 
@@ -80,3 +81,77 @@ func newHasher(algorithm Algorithm) (hasher hash.Hash, err error) {
 		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
 	}
 }
+
+// IntegrityError is returned when content doesn't match the expected integrity.
+type IntegrityError struct {
+	// Expected integrity, e.g. from dist.integrity.
+	Expected string
+	// Actual integrity of the content, using the algorithm of the expected integrity.
+	Actual string
+}
+
+func (e IntegrityError) Error() string {
+	return fmt.Sprintf("integrity mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// strength orders algorithms from weakest to strongest.
+var strength = map[Algorithm]int{MD5: 1, SHA1: 2, SHA256: 3, SHA512: 4}
+
+// Verifier computes the hash of content written to it, and checks it against an
+// integrity string. As in the SRI specification, the integrity string may contain
+// several space separated hashes, e.g. "sha512-... sha1-...", and only the hashes
+// of the strongest supported algorithm are checked.
+type Verifier struct {
+	integrity string
+	expected  []string
+	hasher    *SRI
+}
+
+// NewVerifier creates a Verifier for the integrity string.
+func NewVerifier(integrity string) (v *Verifier, err error) {
+	v = &Verifier{integrity: integrity}
+	for s := range strings.FieldsSeq(integrity) {
+		hasher, err := Parse(s)
+		if err != nil {
+			// Hashes with unsupported algorithms are ignored.
+			continue
+		}
+		switch {
+		case v.hasher == nil || strength[hasher.Algorithm] > strength[v.hasher.Algorithm]:
+			v.hasher, v.expected = hasher, []string{s}
+		case hasher.Algorithm == v.hasher.Algorithm:
+			v.expected = append(v.expected, s)
+		}
+	}
+	if v.hasher == nil {
+		return nil, fmt.Errorf("no supported hashes in integrity %q", integrity)
+	}
+	return v, nil
+}
+
+func (v *Verifier) Write(p []byte) (n int, err error) {
+	return v.hasher.Write(p)
+}
+
+// Verify returns an IntegrityError if the content written doesn't match the integrity.
+func (v *Verifier) Verify() error {
+	actual := v.hasher.String()
+	for _, expected := range v.expected {
+		if actual == expected {
+			return nil
+		}
+	}
+	return IntegrityError{Expected: v.integrity, Actual: actual}
+}
+
+// Verify reads r, and checks that it matches the integrity string.
+func Verify(r io.Reader, integrity string) error {
+	v, err := NewVerifier(integrity)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(v, r); err != nil {
+		return err
+	}
+	return v.Verify()
+}
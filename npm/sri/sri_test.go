@@ -0,0 +1,49 @@
+package sri
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	content := "tarball content"
+	sha512Sum := sha512.Sum512([]byte(content))
+	sha1Sum := sha1.Sum([]byte(content))
+	sha512Integrity := "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:])
+	sha1Integrity := "sha1-" + base64.StdEncoding.EncodeToString(sha1Sum[:])
+	wrongIntegrity := "sha512-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+
+	tests := []struct {
+		name      string
+		integrity string
+		expectErr bool
+	}{
+		{name: "single hash", integrity: sha512Integrity},
+		{name: "sha1 hash", integrity: sha1Integrity},
+		{name: "any hash of the strongest algorithm", integrity: wrongIntegrity + " " + sha512Integrity + " " + sha1Integrity},
+		{name: "only the strongest algorithm is checked", integrity: wrongIntegrity + " " + sha1Integrity, expectErr: true},
+		{name: "unsupported algorithms are ignored", integrity: "sha3-abcd " + sha512Integrity},
+		{name: "mismatch", integrity: wrongIntegrity, expectErr: true},
+		{name: "no supported hashes", integrity: "sha3-abcd", expectErr: true},
+		{name: "empty", integrity: "", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(strings.NewReader(content), tt.integrity)
+			if tt.expectErr && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			var integrityErr IntegrityError
+			if errors.As(err, &integrityErr) && (integrityErr.Expected != tt.integrity || integrityErr.Actual != sha512Integrity) {
+				t.Errorf("expected error to name the expected and actual hashes, got %v", err)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
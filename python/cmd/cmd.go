@@ -16,9 +16,16 @@ type PythonCmd struct {
 }
 
 type Save struct {
-	Dir      string   `help:"Directory to save packages to" default:".depot-storage/python" env:"DEPOT_PYTHON_DIR"`
-	Packages []string `arg:"" help:"Package names to save (format: package==version)" optional:"true"`
-	Stdin    bool     `help:"Read package list from stdin" default:"false"`
+	Dir            string            `help:"Directory to save packages to" default:".depot-storage/python" env:"DEPOT_PYTHON_DIR"`
+	Packages       []string          `arg:"" help:"Package names to save (format: package==version)" optional:"true"`
+	Stdin          bool              `help:"Read package list from stdin" default:"false"`
+	Requirement    []string          `short:"r" help:"Save packages from a requirements file or lockfile (requirements.txt, poetry.lock, uv.lock or Pipfile.lock)" type:"existingfile"`
+	NoDeps         bool              `help:"Don't save the dependencies of packages" default:"false"`
+	Marker         map[string]string `help:"Environment marker values used to select dependencies (e.g. python_version=3.11;sys_platform=darwin)"`
+	PythonVersion  []string          `help:"Only save files that support these Python versions (e.g. 3.12)"`
+	Implementation string            `help:"Only save wheels for this Python implementation (e.g. cp, pp, or py for pure Python wheels)"`
+	Platform       []string          `help:"Only save wheels for these platforms (e.g. manylinux_2_28_x86_64, win_amd64, macosx_14_0_arm64)"`
+	Format         string            `help:"File formats to save (all, sdist or wheel)" default:"all" enum:"all,sdist,wheel"`
 }
 
 func (cmd *Save) Run(globals *globals.Globals) error {
@@ -32,11 +39,30 @@ func (cmd *Save) Run(globals *globals.Globals) error {
 	defer stop()
 	storage := storage.NewFileSystem(cmd.Dir)
 	saver := save.New(log, storage)
+	saver.SetIncludeDependencies(!cmd.NoDeps)
+	filter := save.Filter{
+		PythonVersions: cmd.PythonVersion,
+		Implementation: cmd.Implementation,
+		Platforms:      cmd.Platform,
+		Format:         save.Format(cmd.Format),
+	}
+	saver.SetFilter(filter)
+	// Select dependencies for the target Python versions and platforms, unless overridden by a marker.
+	saver.SetEnvironments(filter.Environments(cmd.Marker))
 
 	if cmd.Stdin {
 		return saver.SaveFromReader(ctx, os.Stdin)
 	}
 
+	for _, file := range cmd.Requirement {
+		if err := saver.SaveFromFile(ctx, file); err != nil {
+			return err
+		}
+	}
+	if len(cmd.Requirement) > 0 && len(cmd.Packages) == 0 {
+		return nil
+	}
+
 	if len(cmd.Packages) == 0 {
 		log.Info("no packages specified, reading from stdin")
 		return saver.SaveFromReader(ctx, os.Stdin)
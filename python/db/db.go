@@ -26,6 +26,12 @@ func (d *DB) buildPackageKey(packageName, version string) string {
 	return path.Join("/python", encodedName, encodedVersion)
 }
 
+// buildFileKey builds a database key for a single file within a package version.
+// A version can have many files, e.g. an sdist and a wheel per platform.
+func (d *DB) buildFileKey(packageName, version, filename string) string {
+	return d.buildPackageKey(packageName, version) + "/" + url.PathEscape(filename)
+}
+
 // normalizeName normalizes a Python package name according to PEP 503.
 // Package names are case-insensitive and hyphens/underscores are equivalent.
 func normalizeName(name string) string {
@@ -35,17 +41,41 @@ func normalizeName(name string) string {
 	return normalized
 }
 
-// GetPackageVersion retrieves specific version metadata.
-func (d *DB) GetPackageVersion(ctx context.Context, packageName, version string) (metadata models.SimpleFileEntry, ok bool, err error) {
+// GetPackageVersion retrieves the metadata of all files of a specific version.
+func (d *DB) GetPackageVersion(ctx context.Context, packageName, version string) (files []models.SimpleFileEntry, err error) {
 	key := d.buildPackageKey(packageName, version)
-	_, ok, err = d.store.Get(ctx, key, &metadata)
+	var legacy models.SimpleFileEntry
+	_, ok, err := d.store.Get(ctx, key, &legacy)
 	if err != nil {
-		return models.SimpleFileEntry{}, false, err
+		return nil, err
+	}
+	if ok {
+		files = append(files, legacy)
 	}
-	if !ok {
-		return models.SimpleFileEntry{}, false, nil
+	records, err := d.store.GetPrefix(ctx, key+"/", 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	versionFiles, err := kv.ValuesOf[models.SimpleFileEntry](records)
+	if err != nil {
+		return nil, err
 	}
-	return metadata, true, nil
+	return append(files, versionFiles...), nil
+}
+
+// GetPackageFile retrieves the metadata of a single file of a package.
+func (d *DB) GetPackageFile(ctx context.Context, packageName, filename string) (file models.SimpleFileEntry, ok bool, err error) {
+	version := models.SimpleFileEntry{Filename: filename}.Version()
+	_, ok, err = d.store.Get(ctx, d.buildFileKey(packageName, version, filename), &file)
+	if err != nil || ok {
+		return file, ok, err
+	}
+	// Files stored before per-file keys were introduced are keyed by version.
+	_, ok, err = d.store.Get(ctx, d.buildPackageKey(packageName, version), &file)
+	if err != nil || !ok || file.Filename != filename {
+		return models.SimpleFileEntry{}, false, err
+	}
+	return file, true, nil
 }
 
 // GetPackage retrieves all versions of a package.
@@ -114,9 +144,14 @@ func (d *DB) ListPackages(ctx context.Context) (packages []string, err error) {
 	return packages, nil
 }
 
-// PutPackageVersion saves specific version metadata.
+// PutPackageVersion saves file metadata, using the package name from the filename.
 func (d *DB) PutPackageVersion(ctx context.Context, file models.SimpleFileEntry) error {
-	key := d.buildPackageKey(file.PackageName(), file.Version())
+	return d.PutPackageFile(ctx, file.PackageName(), file)
+}
+
+// PutPackageFile saves file metadata under the given package name.
+func (d *DB) PutPackageFile(ctx context.Context, packageName string, file models.SimpleFileEntry) error {
+	key := d.buildFileKey(packageName, file.Version(), file.Filename)
 	return d.store.Put(ctx, key, -1, file)
 }
 
@@ -134,6 +169,9 @@ func (d *DB) DeletePackage(ctx context.Context, packageName string) error {
 // DeletePackageVersion deletes a specific version of a package.
 func (d *DB) DeletePackageVersion(ctx context.Context, packageName, version string) error {
 	key := d.buildPackageKey(packageName, version)
-	_, err := d.store.Delete(ctx, key)
+	if _, err := d.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	_, err := d.store.DeletePrefix(ctx, key+"/", 0, -1)
 	return err
 }
@@ -7,9 +7,10 @@ import (
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/python/db"
 	"github.com/a-h/depot/python/handlers/simple"
+	"github.com/a-h/depot/python/upstream"
 	"github.com/a-h/depot/storage"
 )
 
-func New(log *slog.Logger, db *db.DB, storage storage.Storage, baseURL string, metrics metrics.Metrics) http.Handler {
-	return simple.New(log, db, storage, baseURL, metrics)
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *upstream.Client, baseURL string, metrics metrics.Metrics) http.Handler {
+	return simple.New(log, db, storage, upstream, baseURL, metrics)
 }
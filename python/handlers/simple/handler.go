@@ -2,6 +2,7 @@ package simple
 
 import (
 	"encoding/json"
+	"errors"
 	"html"
 	"io"
 	"log/slog"
@@ -12,25 +13,30 @@ import (
 	"github.com/a-h/depot/metrics"
 	"github.com/a-h/depot/python/db"
 	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/upstream"
 	"github.com/a-h/depot/storage"
 )
 
-func New(log *slog.Logger, db *db.DB, storage storage.Storage, baseURL string, metrics metrics.Metrics) Handler {
+// New creates a new simple index handler. If upstream is not nil, packages and
+// files that are not in the depot are fetched from the upstream index.
+func New(log *slog.Logger, db *db.DB, storage storage.Storage, upstream *upstream.Client, baseURL string, metrics metrics.Metrics) Handler {
 	return Handler{
-		log:     log,
-		db:      db,
-		storage: storage,
-		baseURL: strings.TrimSuffix(baseURL, "/"),
-		metrics: metrics,
+		log:      log,
+		db:       db,
+		storage:  storage,
+		upstream: upstream,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		metrics:  metrics,
 	}
 }
 
 type Handler struct {
-	log     *slog.Logger
-	db      *db.DB
-	storage storage.Storage
-	baseURL string
-	metrics metrics.Metrics
+	log      *slog.Logger
+	db       *db.DB
+	storage  storage.Storage
+	upstream *upstream.Client
+	baseURL  string
+	metrics  metrics.Metrics
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -44,6 +50,9 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		h.Put(w, r)
 		return
+	case http.MethodPost:
+		h.Post(w, r)
+		return
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -134,6 +143,19 @@ func (h Handler) getPackage(w http.ResponseWriter, r *http.Request, packageName
 		return
 	}
 
+	if len(index.Files) == 0 && h.upstream != nil {
+		index, err = h.getPackageFromUpstream(r, packageName)
+		if errors.Is(err, upstream.ErrNotFound) {
+			http.Error(w, "package not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.log.Error("failed to get package from upstream", slog.String("package", packageName), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	if len(index.Files) == 0 {
 		http.Error(w, "package not found", http.StatusNotFound)
 		return
@@ -151,6 +173,23 @@ func (h Handler) getPackage(w http.ResponseWriter, r *http.Request, packageName
 	h.getPackageHTML(w, index)
 }
 
+// getPackageFromUpstream stores the metadata of all files of the package in the
+// upstream index. The upstream file URLs are kept in the database so that files
+// can be downloaded when they are first requested.
+func (h Handler) getPackageFromUpstream(r *http.Request, packageName string) (index models.SimplePackageIndex, err error) {
+	h.log.Debug("Getting package from upstream", slog.String("package", packageName))
+	upstreamIndex, err := h.upstream.GetPackageIndex(r.Context(), packageName)
+	if err != nil {
+		return index, err
+	}
+	for _, file := range upstreamIndex.Files {
+		if err = h.db.PutPackageFile(r.Context(), packageName, file); err != nil {
+			return index, err
+		}
+	}
+	return h.db.GetPackage(r.Context(), packageName, h.baseURL)
+}
+
 func (h Handler) getPackageHTML(w http.ResponseWriter, index models.SimplePackageIndex) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte("<!DOCTYPE html>\n<html>\n<head><title>Links for " + html.EscapeString(index.Name) + "</title></head>\n<body>\n<h1>Links for " + html.EscapeString(index.Name) + "</h1>\n"))
@@ -158,8 +197,8 @@ func (h Handler) getPackageHTML(w http.ResponseWriter, index models.SimplePackag
 	for _, file := range index.Files {
 		w.Write([]byte("<a href=\"" + html.EscapeString(file.URL) + "\""))
 
-		if sha256, ok := file.Hashes["sha256"]; ok {
-			w.Write([]byte(" data-dist-info-metadata=\"sha256=" + html.EscapeString(sha256) + "\""))
+		if metadata := coreMetadataAttribute(file); metadata != "" {
+			w.Write([]byte(" data-dist-info-metadata=\"" + html.EscapeString(metadata) + "\""))
 		}
 
 		if file.RequiresPython != "" {
@@ -172,6 +211,19 @@ func (h Handler) getPackageHTML(w http.ResponseWriter, index models.SimplePackag
 	w.Write([]byte("</body>\n</html>\n"))
 }
 
+// coreMetadataAttribute returns the PEP 658 attribute value for a file's core
+// metadata, or an empty string if the metadata is not available.
+func coreMetadataAttribute(file models.SimpleFileEntry) string {
+	hashes, ok := file.CoreMetadataHashes()
+	if !ok {
+		return ""
+	}
+	if sha256, ok := hashes["sha256"]; ok {
+		return "sha256=" + sha256
+	}
+	return "true"
+}
+
 func (h Handler) getPackageFile(w http.ResponseWriter, r *http.Request, pkg string, fileName string) {
 	path := path.Join(pkg, fileName)
 	h.log.Debug("Getting package file", slog.String("path", path), slog.String("pkg", pkg), slog.String("filename", fileName))
@@ -181,6 +233,14 @@ func (h Handler) getPackageFile(w http.ResponseWriter, r *http.Request, pkg stri
 		http.Error(w, "failed to get file", http.StatusInternalServerError)
 		return
 	}
+	if !exists && h.upstream != nil {
+		reader, exists, err = h.getPackageFileFromUpstream(r, pkg, fileName, path)
+		if err != nil {
+			h.log.Error("failed to get file from upstream", slog.String("path", path), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
 	if !exists {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
@@ -196,6 +256,33 @@ func (h Handler) getPackageFile(w http.ResponseWriter, r *http.Request, pkg stri
 	h.metrics.IncrementDownloadMetrics(r.Context(), "python", bytesDownloaded)
 }
 
+// getPackageFileFromUpstream downloads a file, or its PEP 658 core metadata, from
+// the URL recorded when the package index was fetched from upstream.
+func (h Handler) getPackageFileFromUpstream(r *http.Request, pkg, fileName, path string) (reader io.ReadCloser, ok bool, err error) {
+	distFileName, isMetadata := strings.CutSuffix(fileName, ".metadata")
+	file, ok, err := h.db.GetPackageFile(r.Context(), pkg, distFileName)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	fileURL, hashes := file.URL, file.Hashes
+	if isMetadata {
+		metadataHashes, ok := file.CoreMetadataHashes()
+		if !ok {
+			return nil, false, nil
+		}
+		fileURL, hashes = file.URL+".metadata", metadataHashes
+	}
+	h.log.Debug("Downloading file from upstream", slog.String("path", path), slog.String("url", fileURL))
+	err = h.upstream.Download(r.Context(), h.storage, fileURL, path, hashes, nil)
+	if errors.Is(err, upstream.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return h.storage.Get(r.Context(), path)
+}
+
 func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	if path == "" {
@@ -230,14 +317,19 @@ func (h Handler) Put(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
-	defer writer.Close()
 
 	bytesWritten, err := io.Copy(writer, r.Body)
 	if err != nil {
+		storage.Abort(writer)
 		h.log.Error("failed to write file", slog.String("path", path), slog.Any("error", err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
+	if err := writer.Close(); err != nil {
+		h.log.Error("failed to complete upload to storage", slog.String("path", path), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
 	h.metrics.IncrementUploadMetrics(r.Context(), "python", bytesWritten)
 
 	h.log.Debug("stored file", slog.String("path", path))
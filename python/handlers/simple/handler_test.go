@@ -0,0 +1,165 @@
+package simple
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/python/db"
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/upstream"
+	"github.com/a-h/depot/storage"
+	"github.com/a-h/depot/store"
+)
+
+func TestUpstreamPullThrough(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	wheel := []byte("wheel content")
+	wheelSum := sha256.Sum256(wheel)
+	metadata := []byte("Metadata-Version: 2.1\nName: pull-through-pkg\nVersion: 1.0.0\n")
+	metadataSum := sha256.Sum256(metadata)
+	coreMetadata, err := json.Marshal(map[string]string{"sha256": hex.EncodeToString(metadataSum[:])})
+	if err != nil {
+		t.Fatalf("failed to marshal core metadata: %v", err)
+	}
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	requestCount := func(p string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[p]
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/simple/pull-through-pkg/":
+			json.NewEncoder(w).Encode(models.SimplePackageIndex{
+				Name: "pull-through-pkg",
+				Files: []models.SimpleFileEntry{
+					{
+						Filename:     "pull_through_pkg-1.0.0-py3-none-any.whl",
+						URL:          "/files/pull_through_pkg-1.0.0-py3-none-any.whl",
+						Hashes:       map[string]string{"sha256": hex.EncodeToString(wheelSum[:])},
+						CoreMetadata: coreMetadata,
+					},
+					{
+						Filename: "pull_through_pkg-1.0.0.tar.gz",
+						URL:      "/files/pull_through_pkg-1.0.0.tar.gz",
+						Hashes:   map[string]string{"sha256": "0000"},
+					},
+				},
+			})
+		case "/files/pull_through_pkg-1.0.0-py3-none-any.whl":
+			w.Write(wheel)
+		case "/files/pull_through_pkg-1.0.0-py3-none-any.whl.metadata":
+			w.Write(metadata)
+		case "/files/pull_through_pkg-1.0.0.tar.gz":
+			w.Write([]byte("tampered content"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	s, closer, err := store.New(ctx, "sqlite", "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer closer()
+	m, err := metrics.New()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	fs := storage.NewFileSystem(t.TempDir())
+	h := New(log, db.New(s), fs, upstream.New(log, ts.URL+"/simple"), "http://depot.example.com/python", m)
+
+	get := func(t *testing.T, p string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, p, nil)
+		r.Header.Set("Accept", "application/vnd.pypi.simple.v1+json")
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("missing package is fetched from upstream with rewritten file URLs", func(t *testing.T) {
+		for range 2 {
+			w := get(t, "/simple/pull-through-pkg/")
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			var index models.SimplePackageIndex
+			if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+				t.Fatalf("failed to decode index: %v", err)
+			}
+			if len(index.Files) != 2 {
+				t.Fatalf("expected 2 files, got %d", len(index.Files))
+			}
+			for _, file := range index.Files {
+				expected := "http://depot.example.com/python/pull_through_pkg/" + file.Filename
+				if file.URL != expected {
+					t.Errorf("expected URL %q, got %q", expected, file.URL)
+				}
+			}
+		}
+		if n := requestCount("/simple/pull-through-pkg/"); n != 1 {
+			t.Errorf("expected 1 upstream index request, got %d", n)
+		}
+	})
+	t.Run("missing file is fetched from upstream", func(t *testing.T) {
+		for range 2 {
+			w := get(t, "/simple/pull_through_pkg/pull_through_pkg-1.0.0-py3-none-any.whl")
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if w.Body.String() != string(wheel) {
+				t.Errorf("expected %q, got %q", wheel, w.Body.String())
+			}
+		}
+		if n := requestCount("/files/pull_through_pkg-1.0.0-py3-none-any.whl"); n != 1 {
+			t.Errorf("expected 1 upstream file request, got %d", n)
+		}
+	})
+	t.Run("core metadata is fetched from upstream", func(t *testing.T) {
+		w := get(t, "/simple/pull_through_pkg/pull_through_pkg-1.0.0-py3-none-any.whl.metadata")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if w.Body.String() != string(metadata) {
+			t.Errorf("expected %q, got %q", metadata, w.Body.String())
+		}
+	})
+	t.Run("file with mismatched hash is not served", func(t *testing.T) {
+		w := get(t, "/simple/pull_through_pkg/pull_through_pkg-1.0.0.tar.gz")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+		_, exists, err := fs.Stat(ctx, "pull_through_pkg/pull_through_pkg-1.0.0.tar.gz")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected file with mismatched hash not to be stored")
+		}
+	})
+	t.Run("package missing upstream returns 404", func(t *testing.T) {
+		for _, p := range []string{"/simple/missing/", "/simple/missing/missing-1.0.0.tar.gz"} {
+			if w := get(t, p); w.Code != http.StatusNotFound {
+				t.Errorf("expected status %d for %s, got %d", http.StatusNotFound, p, w.Code)
+			}
+		}
+	})
+}
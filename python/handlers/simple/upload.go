@@ -0,0 +1,187 @@
+package simple
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/requirement"
+	"github.com/a-h/depot/python/sdist"
+	"github.com/a-h/depot/python/wheel"
+	"github.com/a-h/depot/storage"
+	"golang.org/x/crypto/blake2b"
+)
+
+// maxUploadMemory is the amount of an upload that is held in memory. The rest is written to temporary files.
+const maxUploadMemory = 32 << 20
+
+// Post handles uploads made with the legacy upload API used by twine, e.g.
+// twine upload --repository-url https://depot.example.com/python/ dist/*
+func (h Handler) Post(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		h.log.Warn("failed to parse upload form", slog.Any("error", err))
+		http.Error(w, "invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	if action := r.FormValue(":action"); action != "file_upload" {
+		http.Error(w, fmt.Sprintf("unsupported action %q", action), http.StatusBadRequest)
+		return
+	}
+	content, header, err := r.FormFile("content")
+	if err != nil {
+		http.Error(w, "missing content", http.StatusBadRequest)
+		return
+	}
+	defer content.Close()
+
+	file, metadata, err := h.readUpload(r, content, header)
+	if err != nil {
+		h.log.Warn("invalid upload", slog.String("filename", header.Filename), slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pkg := file.PackageName()
+	_, exists, err := h.db.GetPackageFile(r.Context(), pkg, file.Filename)
+	if err != nil {
+		h.log.Error("failed to get package file", slog.String("filename", file.Filename), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, fmt.Sprintf("file %q already exists", file.Filename), http.StatusConflict)
+		return
+	}
+
+	filePath := path.Join(pkg, file.Filename)
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		h.log.Error("failed to read upload", slog.String("path", filePath), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err = h.putFile(r, filePath, content); err != nil {
+		h.log.Error("failed to store file", slog.String("path", filePath), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Store the core metadata of wheels so that clients can resolve dependencies without downloading the wheel (PEP 658).
+	if strings.HasSuffix(file.Filename, ".whl") {
+		if err = h.putFile(r, filePath+".metadata", bytes.NewReader(metadata)); err != nil {
+			h.log.Error("failed to store core metadata", slog.String("path", filePath), slog.Any("error", err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		metadataSum := sha256.Sum256(metadata)
+		file.CoreMetadata, _ = json.Marshal(map[string]string{"sha256": hex.EncodeToString(metadataSum[:])})
+	}
+	if err = h.db.PutPackageVersion(r.Context(), file); err != nil {
+		h.log.Error("failed to store package version", slog.String("path", filePath), slog.Any("error", err))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.metrics.IncrementUploadMetrics(r.Context(), "python", file.Size)
+
+	h.log.Debug("uploaded file", slog.String("path", filePath))
+	w.WriteHeader(http.StatusOK)
+}
+
+// readUpload reads the core metadata from the uploaded distribution, checks
+// that it matches the filename and form fields, and verifies any digests sent
+// by the client.
+func (h Handler) readUpload(r *http.Request, content multipart.File, header *multipart.FileHeader) (file models.SimpleFileEntry, metadata []byte, err error) {
+	filename := header.Filename
+	if filename == "" || filename != path.Base(filename) || strings.ContainsAny(filename, `\`) || strings.HasPrefix(filename, ".") {
+		return file, nil, fmt.Errorf("invalid filename %q", filename)
+	}
+	if strings.HasSuffix(filename, ".whl") {
+		metadata, err = wheel.ReadMetadata(content, header.Size)
+	} else {
+		metadata, err = sdist.ReadMetadata(filename, content, header.Size)
+	}
+	if err != nil {
+		return file, nil, err
+	}
+	md, err := models.ParseCoreMetadata(bytes.NewReader(metadata))
+	if err != nil {
+		return file, nil, err
+	}
+	if md.Version == "" {
+		return file, nil, fmt.Errorf("core metadata is missing the Version field")
+	}
+
+	file = models.SimpleFileEntry{
+		Filename:       filename,
+		RequiresPython: md.RequiresPython,
+		Size:           header.Size,
+	}
+	if requirement.NormalizeName(file.PackageName()) != requirement.NormalizeName(md.Name) || file.Version() != md.Version {
+		return file, nil, fmt.Errorf("filename %q does not match the name %q and version %q in the core metadata", filename, md.Name, md.Version)
+	}
+	if name := r.FormValue("name"); name != "" && requirement.NormalizeName(name) != requirement.NormalizeName(md.Name) {
+		return file, nil, fmt.Errorf("name %q does not match the name %q in the core metadata", name, md.Name)
+	}
+	if version := r.FormValue("version"); version != "" && version != md.Version {
+		return file, nil, fmt.Errorf("version %q does not match the version %q in the core metadata", version, md.Version)
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return file, nil, err
+	}
+	sha256Hash, md5Hash, blake2Hash := sha256.New(), md5.New(), newBlake2b256()
+	if _, err = io.Copy(io.MultiWriter(sha256Hash, md5Hash, blake2Hash), content); err != nil {
+		return file, nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	digests := []struct {
+		field string
+		hash  hash.Hash
+	}{
+		{field: "sha256_digest", hash: sha256Hash},
+		{field: "md5_digest", hash: md5Hash},
+		{field: "blake2_256_digest", hash: blake2Hash},
+	}
+	for _, digest := range digests {
+		expected := r.FormValue(digest.field)
+		if expected != "" && !strings.EqualFold(expected, hex.EncodeToString(digest.hash.Sum(nil))) {
+			return file, nil, fmt.Errorf("%s does not match the uploaded file", digest.field)
+		}
+	}
+	file.Hashes = map[string]string{"sha256": hex.EncodeToString(sha256Hash.Sum(nil))}
+	return file, metadata, nil
+}
+
+func newBlake2b256() hash.Hash {
+	// New256 only returns an error if the key is too long.
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+func (h Handler) putFile(r *http.Request, filePath string, content io.Reader) (err error) {
+	writer, err := h.storage.Put(r.Context(), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			storage.Abort(writer)
+			return
+		}
+		err = writer.Close()
+	}()
+	if _, err = io.Copy(writer, content); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package simple
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/a-h/depot/metrics"
+	"github.com/a-h/depot/python/db"
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/storage"
+	"github.com/a-h/depot/store"
+)
+
+func newWheel(t *testing.T, distInfo, metadata string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(distInfo + "/METADATA")
+	if err != nil {
+		t.Fatalf("failed to create METADATA: %v", err)
+	}
+	if _, err = w.Write([]byte(metadata)); err != nil {
+		t.Fatalf("failed to write METADATA: %v", err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("failed to close wheel: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newSdist(t *testing.T, dir, metadata string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	files := []struct{ name, content string }{
+		{name: dir + "/src/example.egg-info/PKG-INFO", content: "Metadata-Version: 2.1\nName: wrong\nVersion: 0.0.0\n"},
+		{name: dir + "/PKG-INFO", content: metadata},
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatalf("failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("failed to close gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newUploadRequest(t *testing.T, filename string, content []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatalf("failed to write field: %v", err)
+		}
+	}
+	fw, err := mw.CreateFormFile("content", filename)
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	if _, err = fw.Write(content); err != nil {
+		t.Fatalf("failed to write form file: %v", err)
+	}
+	if err = mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	s, closer, err := store.New(ctx, "sqlite", "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer closer()
+	m, err := metrics.New()
+	if err != nil {
+		t.Fatalf("failed to create metrics: %v", err)
+	}
+	fs := storage.NewFileSystem(t.TempDir())
+	h := New(log, db.New(s), fs, nil, "http://depot.example.com/python", m)
+
+	upload := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	readFile := func(t *testing.T, p string) []byte {
+		t.Helper()
+		r, ok, err := fs.Get(ctx, p)
+		if err != nil || !ok {
+			t.Fatalf("expected %s to be stored, got ok=%v, err=%v", p, ok, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", p, err)
+		}
+		return data
+	}
+
+	metadata := "Metadata-Version: 2.1\nName: Upload.Pkg\nVersion: 1.0.0\nRequires-Python: >=3.9\nRequires-Dist: requests\n"
+	wheel := newWheel(t, "upload_pkg-1.0.0.dist-info", metadata)
+	wheelSum := sha256.Sum256(wheel)
+	wheelFilename := "upload_pkg-1.0.0-py3-none-any.whl"
+
+	t.Run("wheels are stored with their core metadata", func(t *testing.T) {
+		w := upload(newUploadRequest(t, wheelFilename, wheel, map[string]string{
+			":action":       "file_upload",
+			"name":          "upload-pkg",
+			"version":       "1.0.0",
+			"filetype":      "bdist_wheel",
+			"sha256_digest": hex.EncodeToString(wheelSum[:]),
+		}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if !bytes.Equal(readFile(t, "upload_pkg/"+wheelFilename), wheel) {
+			t.Error("stored wheel does not match the upload")
+		}
+		if string(readFile(t, "upload_pkg/"+wheelFilename+".metadata")) != metadata {
+			t.Error("stored core metadata does not match the wheel METADATA")
+		}
+	})
+	t.Run("uploaded files are listed in the index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/simple/upload-pkg/", nil)
+		r.Header.Set("Accept", "application/vnd.pypi.simple.v1+json")
+		h.ServeHTTP(w, r)
+		var index models.SimplePackageIndex
+		if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+			t.Fatalf("failed to decode index: %v", err)
+		}
+		if len(index.Files) != 1 {
+			t.Fatalf("expected 1 file, got %d", len(index.Files))
+		}
+		file := index.Files[0]
+		if file.Hashes["sha256"] != hex.EncodeToString(wheelSum[:]) {
+			t.Errorf("expected sha256 %x, got %q", wheelSum, file.Hashes["sha256"])
+		}
+		if file.RequiresPython != ">=3.9" {
+			t.Errorf("expected requires-python >=3.9, got %q", file.RequiresPython)
+		}
+		if file.Size != int64(len(wheel)) {
+			t.Errorf("expected size %d, got %d", len(wheel), file.Size)
+		}
+		if _, ok := file.CoreMetadataHashes(); !ok {
+			t.Error("expected core metadata to be available")
+		}
+	})
+	t.Run("sdists are stored", func(t *testing.T) {
+		sdist := newSdist(t, "upload_pkg-1.0.0", metadata)
+		w := upload(newUploadRequest(t, "upload_pkg-1.0.0.tar.gz", sdist, map[string]string{":action": "file_upload"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if !bytes.Equal(readFile(t, "upload_pkg/upload_pkg-1.0.0.tar.gz"), sdist) {
+			t.Error("stored sdist does not match the upload")
+		}
+	})
+	t.Run("existing files cannot be replaced", func(t *testing.T) {
+		w := upload(newUploadRequest(t, wheelFilename, wheel, map[string]string{":action": "file_upload"}))
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status %d, got %d", http.StatusConflict, w.Code)
+		}
+	})
+	t.Run("invalid uploads are rejected", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			filename string
+			content  []byte
+			fields   map[string]string
+		}{
+			{
+				name:     "unsupported action",
+				filename: "upload_pkg-2.0.0-py3-none-any.whl",
+				content:  newWheel(t, "upload_pkg-2.0.0.dist-info", "Name: upload-pkg\nVersion: 2.0.0\n"),
+				fields:   map[string]string{":action": "submit"},
+			},
+			{
+				name:     "digest mismatch",
+				filename: "upload_pkg-2.0.0-py3-none-any.whl",
+				content:  newWheel(t, "upload_pkg-2.0.0.dist-info", "Name: upload-pkg\nVersion: 2.0.0\n"),
+				fields:   map[string]string{":action": "file_upload", "sha256_digest": hex.EncodeToString(wheelSum[:])},
+			},
+			{
+				name:     "version mismatch",
+				filename: "upload_pkg-2.0.0-py3-none-any.whl",
+				content:  newWheel(t, "upload_pkg-2.0.0.dist-info", "Name: upload-pkg\nVersion: 3.0.0\n"),
+				fields:   map[string]string{":action": "file_upload"},
+			},
+			{
+				name:     "name mismatch",
+				filename: "upload_pkg-2.0.0-py3-none-any.whl",
+				content:  newWheel(t, "upload_pkg-2.0.0.dist-info", "Name: upload-pkg\nVersion: 2.0.0\n"),
+				fields:   map[string]string{":action": "file_upload", "name": "other-pkg"},
+			},
+			{
+				name:     "not a wheel",
+				filename: "upload_pkg-2.0.0-py3-none-any.whl",
+				content:  []byte("not a zip"),
+				fields:   map[string]string{":action": "file_upload"},
+			},
+			{
+				name:     "filename mismatch",
+				filename: "other_pkg-2.0.0.tar.gz",
+				content:  newSdist(t, "upload_pkg-2.0.0", "Name: upload-pkg\nVersion: 2.0.0\n"),
+				fields:   map[string]string{":action": "file_upload"},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := upload(newUploadRequest(t, tt.filename, tt.content, tt.fields))
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+				}
+			})
+		}
+		files, err := db.New(s).GetPackageVersion(ctx, "upload-pkg", "2.0.0")
+		if err != nil {
+			t.Fatalf("failed to get package version: %v", err)
+		}
+		if len(files) != 0 {
+			t.Errorf("expected no files to be recorded, got %d", len(files))
+		}
+	})
+}
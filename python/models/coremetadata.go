@@ -0,0 +1,38 @@
+package models
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+)
+
+// CoreMetadata is the subset of a distribution's core metadata (the METADATA
+// file of a wheel, or PKG-INFO of an sdist) that depot uses.
+type CoreMetadata struct {
+	Name           string
+	Version        string
+	RequiresPython string
+	// RequiresDist contains PEP 508 dependency specifications.
+	RequiresDist  []string
+	ProvidesExtra []string
+}
+
+// ParseCoreMetadata parses core metadata in the email header format.
+func ParseCoreMetadata(r io.Reader) (md CoreMetadata, err error) {
+	header, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return md, fmt.Errorf("failed to read core metadata: %w", err)
+	}
+	md = CoreMetadata{
+		Name:           header.Get("Name"),
+		Version:        header.Get("Version"),
+		RequiresPython: header.Get("Requires-Python"),
+		RequiresDist:   header.Values("Requires-Dist"),
+		ProvidesExtra:  header.Values("Provides-Extra"),
+	}
+	if md.Name == "" {
+		return md, fmt.Errorf("core metadata is missing the Name field")
+	}
+	return md, nil
+}
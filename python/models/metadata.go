@@ -51,3 +51,20 @@ func (sf SimpleFileEntry) Version() string {
 	}
 	return parts[1]
 }
+
+// CoreMetadataHashes returns the hashes of the file's PEP 658 core metadata,
+// and whether the upstream index has the core metadata file at all.
+func (sf SimpleFileEntry) CoreMetadataHashes() (hashes map[string]string, ok bool) {
+	metadata := sf.CoreMetadata
+	if len(metadata) == 0 {
+		// Indexes that predate PEP 714 use the data-dist-info-metadata name.
+		metadata = sf.DataDistInfoMetadata
+	}
+	if string(metadata) == "true" {
+		return map[string]string{}, true
+	}
+	if err := json.Unmarshal(metadata, &hashes); err != nil || hashes == nil {
+		return nil, false
+	}
+	return hashes, true
+}
@@ -4,6 +4,8 @@ import (
 	_ "embed"
 	"encoding/json"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -75,3 +77,33 @@ func TestVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCoreMetadata(t *testing.T) {
+	input := `Metadata-Version: 2.1
+Name: requests
+Version: 2.32.3
+Summary: Python HTTP for Humans.
+Requires-Python: >=3.8
+Requires-Dist: charset-normalizer<4,>=2
+Requires-Dist: idna<4,>=2.5
+Requires-Dist: PySocks!=1.5.7,>=1.5.6; extra == "socks"
+Provides-Extra: socks
+
+Requests is an HTTP library.
+Name: not-a-header
+`
+	md, err := ParseCoreMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if md.Name != "requests" || md.Version != "2.32.3" || md.RequiresPython != ">=3.8" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	expected := []string{"charset-normalizer<4,>=2", "idna<4,>=2.5", `PySocks!=1.5.7,>=1.5.6; extra == "socks"`}
+	if !slices.Equal(md.RequiresDist, expected) {
+		t.Errorf("expected Requires-Dist %v, got %v", expected, md.RequiresDist)
+	}
+	if !slices.Equal(md.ProvidesExtra, []string{"socks"}) {
+		t.Errorf("expected Provides-Extra [socks], got %v", md.ProvidesExtra)
+	}
+}
@@ -33,7 +33,7 @@ func (p *Pusher) SetAuthToken(token string) {
 func (p *Pusher) Push(ctx context.Context, dir string) error {
 	p.log.Info("pushing Python packages", slog.String("target", p.target), slog.String("dir", dir))
 
-	binaryExtensions := []string{".gz", ".tar.gz", ".whl", ".zip"}
+	binaryExtensions := []string{".gz", ".tar.gz", ".whl", ".zip", ".metadata"}
 	var metadataFiles []string
 	var binaryFiles []string
 	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
@@ -0,0 +1,181 @@
+package reqfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+	"github.com/a-h/depot/python/requirement"
+)
+
+// IsLockfile returns true if the file at path is a lockfile supported by ParseLockfile.
+func IsLockfile(path string) bool {
+	switch filepath.Base(path) {
+	case "poetry.lock", "uv.lock", "Pipfile.lock":
+		return true
+	}
+	return false
+}
+
+// ParseLockfile parses a poetry.lock, uv.lock or Pipfile.lock file, depending
+// on the filename. Each locked package is returned as a requirement pinned to
+// the locked version, with the locked hashes.
+func ParseLockfile(path string, r io.Reader) (f File, err error) {
+	switch filepath.Base(path) {
+	case "poetry.lock":
+		return ParsePoetryLock(r)
+	case "uv.lock":
+		return ParseUVLock(r)
+	case "Pipfile.lock":
+		return ParsePipfileLock(r)
+	}
+	return f, fmt.Errorf("unsupported lockfile %q", path)
+}
+
+type lockedFile struct {
+	File string `toml:"file"`
+	URL  string `toml:"url"`
+	Hash string `toml:"hash"`
+}
+
+type poetryLock struct {
+	Package []struct {
+		Name    string       `toml:"name"`
+		Version string       `toml:"version"`
+		Files   []lockedFile `toml:"files"`
+		Source  struct {
+			Type string `toml:"type"`
+			URL  string `toml:"url"`
+		} `toml:"source"`
+	} `toml:"package"`
+	Metadata struct {
+		// Files is used by lockfiles created by Poetry versions before 1.5.
+		Files map[string][]lockedFile `toml:"files"`
+	} `toml:"metadata"`
+}
+
+// ParsePoetryLock parses a poetry.lock file.
+func ParsePoetryLock(r io.Reader) (f File, err error) {
+	var lock poetryLock
+	if _, err = toml.NewDecoder(r).Decode(&lock); err != nil {
+		return f, fmt.Errorf("failed to parse poetry.lock: %w", err)
+	}
+	for _, pkg := range lock.Package {
+		// Packages from directories, files, URLs and git repositories aren't in an index.
+		if pkg.Source.Type != "" && pkg.Source.Type != "legacy" {
+			f.Unsupported = append(f.Unsupported, pkg.Name+" @ "+pkg.Source.URL)
+			continue
+		}
+		files := pkg.Files
+		if len(files) == 0 {
+			files = lock.Metadata.Files[pkg.Name]
+		}
+		f.Requirements = append(f.Requirements, pinned(pkg.Name, pkg.Version, hashesOf(files)))
+	}
+	return f, nil
+}
+
+type uvLock struct {
+	Package []struct {
+		Name    string `toml:"name"`
+		Version string `toml:"version"`
+		Source  struct {
+			Registry string `toml:"registry"`
+		} `toml:"source"`
+		Sdist  *lockedFile  `toml:"sdist"`
+		Wheels []lockedFile `toml:"wheels"`
+	} `toml:"package"`
+}
+
+// ParseUVLock parses a uv.lock file.
+func ParseUVLock(r io.Reader) (f File, err error) {
+	var lock uvLock
+	if _, err = toml.NewDecoder(r).Decode(&lock); err != nil {
+		return f, fmt.Errorf("failed to parse uv.lock: %w", err)
+	}
+	for _, pkg := range lock.Package {
+		// The project itself, and packages from directories, URLs and git repositories aren't in an index.
+		if pkg.Source.Registry == "" {
+			f.Unsupported = append(f.Unsupported, pkg.Name)
+			continue
+		}
+		files := pkg.Wheels
+		if pkg.Sdist != nil {
+			files = append(files, *pkg.Sdist)
+		}
+		f.Requirements = append(f.Requirements, pinned(pkg.Name, pkg.Version, hashesOf(files)))
+	}
+	return f, nil
+}
+
+type pipfileLockPackage struct {
+	Version string   `json:"version"`
+	Hashes  []string `json:"hashes"`
+	Extras  []string `json:"extras"`
+	Markers string   `json:"markers"`
+}
+
+type pipfileLock struct {
+	Meta struct {
+		Sources []struct {
+			URL string `json:"url"`
+		} `json:"sources"`
+	} `json:"_meta"`
+	Default map[string]pipfileLockPackage `json:"default"`
+	Develop map[string]pipfileLockPackage `json:"develop"`
+}
+
+// ParsePipfileLock parses a Pipfile.lock file. Both the default and develop packages are returned.
+func ParsePipfileLock(r io.Reader) (f File, err error) {
+	var lock pipfileLock
+	if err = json.NewDecoder(r).Decode(&lock); err != nil {
+		return f, fmt.Errorf("failed to parse Pipfile.lock: %w", err)
+	}
+	if len(lock.Meta.Sources) > 0 {
+		f.IndexURL = lock.Meta.Sources[0].URL
+		for _, source := range lock.Meta.Sources[1:] {
+			f.ExtraIndexURLs = append(f.ExtraIndexURLs, source.URL)
+		}
+	}
+	for _, packages := range []map[string]pipfileLockPackage{lock.Default, lock.Develop} {
+		for _, name := range slices.Sorted(maps.Keys(packages)) {
+			pkg := packages[name]
+			// Packages from paths and VCS repositories don't have a version.
+			if pkg.Version == "" {
+				f.Unsupported = append(f.Unsupported, name)
+				continue
+			}
+			req := Requirement{
+				Requirement: requirement.Requirement{Name: name, Extras: pkg.Extras, Specifier: pkg.Version},
+				Hashes:      pkg.Hashes,
+			}
+			if pkg.Markers != "" {
+				if req.Marker, err = requirement.ParseMarker(pkg.Markers); err != nil {
+					return f, fmt.Errorf("invalid markers for %s: %w", name, err)
+				}
+			}
+			f.Requirements = append(f.Requirements, req)
+		}
+	}
+	return f, nil
+}
+
+func pinned(name, version string, hashes []string) Requirement {
+	return Requirement{
+		Requirement: requirement.Requirement{Name: name, Specifier: "==" + version},
+		Hashes:      hashes,
+	}
+}
+
+func hashesOf(files []lockedFile) (hashes []string) {
+	for _, file := range files {
+		if file.Hash != "" {
+			hashes = append(hashes, file.Hash)
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,214 @@
+package reqfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/a-h/depot/python/requirement"
+)
+
+// Requirement is a requirement read from a requirements file or lockfile.
+type Requirement struct {
+	requirement.Requirement
+	// Hashes that the saved files must match, in "algorithm:hex" form, e.g. "sha256:2cf2...".
+	// If empty, any file can be saved.
+	Hashes []string
+}
+
+// File is a parsed pip requirements file, including any files it references.
+type File struct {
+	Requirements []Requirement
+	// Constraints restrict the versions of packages that are saved, but don't cause packages to be saved.
+	Constraints []Requirement
+	// IndexURL is set by the --index-url option.
+	IndexURL string
+	// ExtraIndexURLs are set by the --extra-index-url option.
+	ExtraIndexURLs []string
+	// Unsupported contains requirements that can't be saved from an index, e.g. local paths, VCS URLs and editable installs.
+	Unsupported []string
+}
+
+// ParseFile parses the pip requirements file at path. Referenced files are
+// resolved relative to the directory of the file that references them.
+func ParseFile(path string) (f File, err error) {
+	p := parser{seen: map[string]bool{}}
+	if err = p.parseFile(&f, path, false); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// Parse parses a pip requirements file from r. Referenced files are resolved
+// relative to dir.
+func Parse(r io.Reader, dir string) (f File, err error) {
+	p := parser{seen: map[string]bool{}}
+	if err = p.parse(&f, r, "<input>", dir, false); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+type parser struct {
+	seen map[string]bool
+}
+
+func (p *parser) parseFile(f *File, path string, constraints bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %q: %w", path, err)
+	}
+	if p.seen[abs] {
+		return nil
+	}
+	p.seen[abs] = true
+	r, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open requirements file: %w", err)
+	}
+	defer r.Close()
+	return p.parse(f, r, path, filepath.Dir(path), constraints)
+}
+
+var (
+	// commentRegexp matches comments, which start with # at the start of a line, or after whitespace.
+	commentRegexp = regexp.MustCompile(`(^|\s+)#.*$`)
+	// envVarRegexp matches environment variables, which pip supports in the ${NAME} form only.
+	envVarRegexp = regexp.MustCompile(`\$\{([A-Z0-9_]+)\}`)
+	// optionRegexp matches the start of per-requirement options, e.g. --hash.
+	optionRegexp = regexp.MustCompile(`\s--?[A-Za-z]`)
+)
+
+func (p *parser) parse(f *File, r io.Reader, name, dir string, constraints bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lineNumber int
+	var line strings.Builder
+	for scanner.Scan() {
+		lineNumber++
+		text := scanner.Text()
+		// Lines ending with a backslash are joined with the next line.
+		if continued, ok := strings.CutSuffix(text, `\`); ok {
+			line.WriteString(continued)
+			continue
+		}
+		line.WriteString(text)
+		if err := p.parseLine(f, line.String(), dir, constraints); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, lineNumber, err)
+		}
+		line.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if line.Len() > 0 {
+		if err := p.parseLine(f, line.String(), dir, constraints); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, lineNumber, err)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseLine(f *File, line, dir string, constraints bool) error {
+	line = commentRegexp.ReplaceAllString(line, "")
+	line = envVarRegexp.ReplaceAllStringFunc(line, func(s string) string {
+		return os.Getenv(s[2 : len(s)-1])
+	})
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "-") {
+		return p.parseOption(f, line, dir, constraints)
+	}
+
+	spec, options := line, ""
+	if loc := optionRegexp.FindStringIndex(line); loc != nil {
+		spec, options = strings.TrimSpace(line[:loc[0]]), line[loc[0]:]
+	}
+	if isPathOrURL(spec) {
+		f.Unsupported = append(f.Unsupported, spec)
+		return nil
+	}
+	req, err := requirement.Parse(spec)
+	if err != nil {
+		return err
+	}
+	r := Requirement{Requirement: req}
+	fields := strings.Fields(options)
+	for i := 0; i < len(fields); i++ {
+		option, value, hasValue := strings.Cut(fields[i], "=")
+		if option != "--hash" {
+			// Other per-requirement options, e.g. --config-settings, don't affect which files are saved.
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(fields) {
+				return fmt.Errorf("missing value for --hash")
+			}
+			i++
+			value = fields[i]
+		}
+		if !strings.Contains(value, ":") {
+			return fmt.Errorf("invalid hash %q, expected algorithm:hash", value)
+		}
+		r.Hashes = append(r.Hashes, value)
+	}
+	if constraints {
+		f.Constraints = append(f.Constraints, r)
+		return nil
+	}
+	f.Requirements = append(f.Requirements, r)
+	return nil
+}
+
+func (p *parser) parseOption(f *File, line, dir string, constraints bool) error {
+	option, value := splitOption(line)
+	switch option {
+	case "-r", "--requirement":
+		return p.parseFile(f, resolvePath(dir, value), constraints)
+	case "-c", "--constraint":
+		return p.parseFile(f, resolvePath(dir, value), true)
+	case "-i", "--index-url":
+		f.IndexURL = value
+	case "--extra-index-url":
+		f.ExtraIndexURLs = append(f.ExtraIndexURLs, value)
+	case "-e", "--editable":
+		f.Unsupported = append(f.Unsupported, value)
+	case "--no-index", "-f", "--find-links", "--pre", "--trusted-host", "--prefer-binary", "--only-binary",
+		"--no-binary", "--require-hashes", "--use-feature", "--config-settings":
+		// Options that only affect how pip installs packages.
+	default:
+		return fmt.Errorf("unsupported option %q", option)
+	}
+	return nil
+}
+
+// splitOption splits "--option=value", "--option value" and "-o value" forms.
+func splitOption(line string) (option, value string) {
+	option, value, ok := strings.Cut(line, "=")
+	if ok && !strings.ContainsAny(option, " \t") {
+		return option, strings.TrimSpace(value)
+	}
+	fields := strings.Fields(line)
+	option = fields[0]
+	if len(fields) > 1 {
+		value = strings.Join(fields[1:], " ")
+	}
+	return option, value
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func isPathOrURL(s string) bool {
+	return strings.HasPrefix(s, ".") || strings.HasPrefix(s, "/") || strings.Contains(strings.SplitN(s, " ", 2)[0], "://")
+}
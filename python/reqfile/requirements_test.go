@@ -0,0 +1,216 @@
+package reqfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	base := `# Shared requirements.
+idna==3.7 \
+    --hash=sha256:82fee1fc78add43492d3a1898bfa6d8a904cc97d8427f683ed8e798d07761aa0
+`
+	if err := os.WriteFile(filepath.Join(dir, "base.txt"), []byte(base), 0644); err != nil {
+		t.Fatalf("failed to write base.txt: %v", err)
+	}
+	constraints := "urllib3<2\n"
+	if err := os.WriteFile(filepath.Join(dir, "constraints.txt"), []byte(constraints), 0644); err != nil {
+		t.Fatalf("failed to write constraints.txt: %v", err)
+	}
+	t.Setenv("REQUESTS_VERSION", "2.32.3")
+	input := `--index-url https://pypi.example.com/simple
+--extra-index-url=https://extra.example.com/simple
+-r base.txt
+-c constraints.txt
+--only-binary :all:
+
+requests[socks]==${REQUESTS_VERSION} # Pinned.
+pywin32==306; sys_platform == "win32"
+certifi==2024.7.4 --hash=sha256:aaaa --hash sha256:bbbb
+-e ./local-package
+./dist/local-1.0.0-py3-none-any.whl
+git+https://github.com/example/repo.git#egg=repo
+`
+	f, err := Parse(strings.NewReader(input), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("index options are parsed", func(t *testing.T) {
+		if f.IndexURL != "https://pypi.example.com/simple" {
+			t.Errorf("unexpected index URL %q", f.IndexURL)
+		}
+		if !slices.Equal(f.ExtraIndexURLs, []string{"https://extra.example.com/simple"}) {
+			t.Errorf("unexpected extra index URLs %v", f.ExtraIndexURLs)
+		}
+	})
+	t.Run("requirements are parsed, including referenced files", func(t *testing.T) {
+		var actual []string
+		for _, r := range f.Requirements {
+			actual = append(actual, r.String())
+		}
+		expected := []string{"idna==3.7", "requests[socks]==2.32.3", `pywin32==306; sys_platform == "win32"`, "certifi==2024.7.4"}
+		if !slices.Equal(actual, expected) {
+			t.Errorf("expected requirements %v, got %v", expected, actual)
+		}
+	})
+	t.Run("hashes are parsed", func(t *testing.T) {
+		expected := []string{"sha256:82fee1fc78add43492d3a1898bfa6d8a904cc97d8427f683ed8e798d07761aa0"}
+		if !slices.Equal(f.Requirements[0].Hashes, expected) {
+			t.Errorf("expected hashes %v, got %v", expected, f.Requirements[0].Hashes)
+		}
+		expected = []string{"sha256:aaaa", "sha256:bbbb"}
+		if !slices.Equal(f.Requirements[3].Hashes, expected) {
+			t.Errorf("expected hashes %v, got %v", expected, f.Requirements[3].Hashes)
+		}
+	})
+	t.Run("constraints are parsed", func(t *testing.T) {
+		if len(f.Constraints) != 1 || f.Constraints[0].String() != "urllib3<2" {
+			t.Errorf("unexpected constraints %v", f.Constraints)
+		}
+	})
+	t.Run("paths, URLs and editable requirements are unsupported", func(t *testing.T) {
+		expected := []string{"./local-package", "./dist/local-1.0.0-py3-none-any.whl", "git+https://github.com/example/repo.git#egg=repo"}
+		if !slices.Equal(f.Unsupported, expected) {
+			t.Errorf("expected unsupported %v, got %v", expected, f.Unsupported)
+		}
+	})
+	t.Run("invalid lines are an error", func(t *testing.T) {
+		for _, input := range []string{"--unknown-option", "requests>=>1", "-r missing.txt"} {
+			if _, err := Parse(strings.NewReader(input), dir); err == nil {
+				t.Errorf("expected error for %q", input)
+			}
+		}
+	})
+}
+
+func TestParseLockfile(t *testing.T) {
+	tests := []struct {
+		filename    string
+		content     string
+		expected    []string
+		hashes      []string
+		unsupported []string
+	}{
+		{
+			filename: "poetry.lock",
+			content: `[[package]]
+name = "idna"
+version = "3.7"
+description = "Internationalized Domain Names in Applications (IDNA)"
+optional = false
+python-versions = ">=3.5"
+files = [
+    {file = "idna-3.7-py3-none-any.whl", hash = "sha256:aaaa"},
+    {file = "idna-3.7.tar.gz", hash = "sha256:bbbb"},
+]
+
+[[package]]
+name = "local"
+version = "1.0.0"
+files = []
+
+[package.source]
+type = "directory"
+url = "../local"
+
+[metadata]
+lock-version = "2.0"
+python-versions = "^3.12"
+content-hash = "abc"
+`,
+			expected:    []string{"idna==3.7"},
+			hashes:      []string{"sha256:aaaa", "sha256:bbbb"},
+			unsupported: []string{"local @ ../local"},
+		},
+		{
+			filename: "poetry.lock",
+			content: `[[package]]
+name = "idna"
+version = "3.7"
+category = "main"
+
+[metadata]
+lock-version = "1.1"
+
+[metadata.files]
+idna = [
+    {file = "idna-3.7-py3-none-any.whl", hash = "sha256:aaaa"},
+]
+`,
+			expected: []string{"idna==3.7"},
+			hashes:   []string{"sha256:aaaa"},
+		},
+		{
+			filename: "uv.lock",
+			content: `version = 1
+requires-python = ">=3.12"
+
+[[package]]
+name = "app"
+version = "0.1.0"
+source = { virtual = "." }
+dependencies = [
+    { name = "idna" },
+]
+
+[[package]]
+name = "idna"
+version = "3.7"
+source = { registry = "https://pypi.org/simple" }
+sdist = { url = "https://files.pythonhosted.org/idna-3.7.tar.gz", hash = "sha256:bbbb", size = 189575 }
+wheels = [
+    { url = "https://files.pythonhosted.org/idna-3.7-py3-none-any.whl", hash = "sha256:aaaa", size = 66836 },
+]
+`,
+			expected:    []string{"idna==3.7"},
+			hashes:      []string{"sha256:aaaa", "sha256:bbbb"},
+			unsupported: []string{"app"},
+		},
+		{
+			filename: "Pipfile.lock",
+			content: `{
+    "_meta": {"sources": [{"name": "pypi", "url": "https://pypi.org/simple", "verify_ssl": true}]},
+    "default": {
+        "idna": {"hashes": ["sha256:aaaa", "sha256:bbbb"], "index": "pypi", "markers": "python_version >= '3.5'", "version": "==3.7"},
+        "local": {"editable": true, "path": "."}
+    },
+    "develop": {
+        "pytest": {"hashes": ["sha256:cccc"], "version": "==8.3.2"}
+    }
+}`,
+			expected:    []string{"idna==3.7; python_version >= '3.5'", "pytest==8.3.2"},
+			hashes:      []string{"sha256:aaaa", "sha256:bbbb"},
+			unsupported: []string{"local"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.filename)
+			if !IsLockfile(path) {
+				t.Fatalf("expected %s to be a lockfile", tt.filename)
+			}
+			f, err := ParseLockfile(path, strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual []string
+			for _, r := range f.Requirements {
+				actual = append(actual, r.String())
+			}
+			if !slices.Equal(actual, tt.expected) {
+				t.Errorf("expected requirements %v, got %v", tt.expected, actual)
+			}
+			if !slices.Equal(f.Requirements[0].Hashes, tt.hashes) {
+				t.Errorf("expected hashes %v, got %v", tt.hashes, f.Requirements[0].Hashes)
+			}
+			if !slices.Equal(f.Unsupported, tt.unsupported) {
+				t.Errorf("expected unsupported %v, got %v", tt.unsupported, f.Unsupported)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package requirement
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	version "github.com/aquasecurity/go-pep440-version"
+)
+
+// Environment holds the values of PEP 508 marker variables, e.g. python_version.
+type Environment map[string]string
+
+// DefaultEnvironment returns the environment of a 64-bit Linux CPython 3.12 interpreter.
+func DefaultEnvironment() Environment {
+	return Environment{
+		"os_name":                        "posix",
+		"sys_platform":                   "linux",
+		"platform_machine":               "x86_64",
+		"platform_python_implementation": "CPython",
+		"platform_release":               "",
+		"platform_system":                "Linux",
+		"platform_version":               "",
+		"python_version":                 "3.12",
+		"python_full_version":            "3.12.0",
+		"implementation_name":            "cpython",
+		"implementation_version":         "3.12.0",
+		"extra":                          "",
+	}
+}
+
+// WithExtra returns a copy of the environment with the extra variable set.
+func (e Environment) WithExtra(extra string) Environment {
+	env := maps.Clone(e)
+	env["extra"] = extra
+	return env
+}
+
+// Marker is a parsed PEP 508 environment marker, e.g. python_version < "3.8" and extra == "test".
+type Marker struct {
+	raw  string
+	expr expression
+}
+
+// ParseMarker parses a PEP 508 environment marker.
+func ParseMarker(s string) (m *Marker, err error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid marker %q: %w", s, err)
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid marker %q: %w", s, err)
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("invalid marker %q: unexpected %q", s, p.tokens[p.pos].value)
+	}
+	return &Marker{raw: strings.TrimSpace(s), expr: expr}, nil
+}
+
+// Evaluate returns true if the marker matches the environment. A nil marker matches all environments.
+func (m *Marker) Evaluate(env Environment) (bool, error) {
+	if m == nil {
+		return true, nil
+	}
+	return m.expr.evaluate(env)
+}
+
+func (m *Marker) String() string {
+	if m == nil {
+		return ""
+	}
+	return m.raw
+}
+
+type expression interface {
+	evaluate(env Environment) (bool, error)
+}
+
+type orExpression struct {
+	left, right expression
+}
+
+func (e orExpression) evaluate(env Environment) (bool, error) {
+	ok, err := e.left.evaluate(env)
+	if err != nil || ok {
+		return ok, err
+	}
+	return e.right.evaluate(env)
+}
+
+type andExpression struct {
+	left, right expression
+}
+
+func (e andExpression) evaluate(env Environment) (bool, error) {
+	ok, err := e.left.evaluate(env)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return e.right.evaluate(env)
+}
+
+type value struct {
+	variable bool
+	s        string
+}
+
+func (v value) resolve(env Environment) (string, error) {
+	if !v.variable {
+		return v.s, nil
+	}
+	s, ok := env[v.s]
+	if !ok {
+		return "", fmt.Errorf("unknown marker variable %q", v.s)
+	}
+	return s, nil
+}
+
+type comparison struct {
+	lhs value
+	op  string
+	rhs value
+}
+
+func (c comparison) evaluate(env Environment) (bool, error) {
+	lhs, err := c.lhs.resolve(env)
+	if err != nil {
+		return false, err
+	}
+	rhs, err := c.rhs.resolve(env)
+	if err != nil {
+		return false, err
+	}
+	// Extra names are compared in normalized form.
+	if (c.lhs.variable && c.lhs.s == "extra") || (c.rhs.variable && c.rhs.s == "extra") {
+		lhs, rhs = NormalizeName(lhs), NormalizeName(rhs)
+	}
+	switch c.op {
+	case "in":
+		return strings.Contains(rhs, lhs), nil
+	case "not in":
+		return !strings.Contains(rhs, lhs), nil
+	case "===":
+		return lhs == rhs, nil
+	}
+	// Use version comparison if both sides are versions, and fall back to string comparison otherwise.
+	if v, err := version.Parse(lhs); err == nil {
+		if spec, err := version.NewSpecifiers(c.op+rhs, version.WithPreRelease(true)); err == nil {
+			return spec.Check(v), nil
+		}
+	}
+	switch c.op {
+	case "==":
+		return lhs == rhs, nil
+	case "!=":
+		return lhs != rhs, nil
+	case "<":
+		return lhs < rhs, nil
+	case "<=":
+		return lhs <= rhs, nil
+	case ">":
+		return lhs > rhs, nil
+	case ">=":
+		return lhs >= rhs, nil
+	}
+	return false, fmt.Errorf("operator %q cannot be used to compare %q and %q", c.op, lhs, rhs)
+}
+
+type tokenType int
+
+const (
+	tokenVariable tokenType = iota
+	tokenString
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type token struct {
+	typ   tokenType
+	value string
+}
+
+var operators = []string{"===", "==", "!=", "<=", ">=", "~=", "<", ">"}
+
+func tokenize(s string) (tokens []token, err error) {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{typ: tokenOpenParen, value: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{typ: tokenCloseParen, value: ")"})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{typ: tokenString, value: s[i+1 : i+1+end]})
+			i += end + 2
+		case isIdentifierChar(c):
+			start := i
+			for i < len(s) && isIdentifierChar(s[i]) {
+				i++
+			}
+			switch word := s[start:i]; word {
+			case "and":
+				tokens = append(tokens, token{typ: tokenAnd, value: word})
+			case "or":
+				tokens = append(tokens, token{typ: tokenOr, value: word})
+			case "in":
+				tokens = append(tokens, token{typ: tokenOperator, value: word})
+			case "not":
+				rest := strings.TrimLeft(s[i:], " \t")
+				if !strings.HasPrefix(rest, "in") {
+					return nil, fmt.Errorf("expected \"in\" after \"not\" at position %d", start)
+				}
+				i = len(s) - len(rest) + len("in")
+				tokens = append(tokens, token{typ: tokenOperator, value: "not in"})
+			default:
+				// Legacy dotted names, e.g. platform.python_implementation.
+				tokens = append(tokens, token{typ: tokenVariable, value: strings.ReplaceAll(word, ".", "_")})
+			}
+		default:
+			var op string
+			for _, candidate := range operators {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{typ: tokenOperator, value: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) next() (t token, ok bool) {
+	if p.pos >= len(p.tokens) {
+		return t, false
+	}
+	t = p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *parser) peek(typ tokenType) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].typ == typ
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOr) {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseAtom()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenAnd) {
+		p.pos++
+		right, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpression{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAtom() (expression, error) {
+	if p.peek(tokenOpenParen) {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(tokenCloseParen) {
+			return nil, fmt.Errorf("expected \")\"")
+		}
+		p.pos++
+		return expr, nil
+	}
+	lhs, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.next()
+	if !ok || op.typ != tokenOperator {
+		return nil, fmt.Errorf("expected comparison operator")
+	}
+	rhs, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{lhs: lhs, op: op.value, rhs: rhs}, nil
+}
+
+func (p *parser) parseValue() (v value, err error) {
+	t, ok := p.next()
+	if !ok {
+		return v, fmt.Errorf("unexpected end of marker")
+	}
+	switch t.typ {
+	case tokenVariable:
+		return value{variable: true, s: t.value}, nil
+	case tokenString:
+		return value{s: t.value}, nil
+	}
+	return v, fmt.Errorf("expected variable or string, got %q", t.value)
+}
@@ -0,0 +1,125 @@
+package requirement
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	version "github.com/aquasecurity/go-pep440-version"
+)
+
+// Requirement is a PEP 508 dependency specification, e.g.
+// requests[security] >= 2.8.1, == 2.8.* ; python_version < "2.7"
+type Requirement struct {
+	// Name of the package, as written.
+	Name string
+	// Extras requested, e.g. "security".
+	Extras []string
+	// Specifier is the version specifier, e.g. ">=2.8.1,==2.8.*", or empty for any version.
+	Specifier string
+	// URL is set when the requirement is a direct reference, e.g. name @ https://example.com/name.whl
+	URL string
+	// Marker restricts the environments the requirement applies to. A nil marker applies to all environments.
+	Marker *Marker
+}
+
+var (
+	nameRegexp          = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?`)
+	normalizeNameRegexp = regexp.MustCompile(`[-_.]+`)
+)
+
+// NormalizeName normalizes a package name according to PEP 503.
+func NormalizeName(name string) string {
+	return strings.ToLower(normalizeNameRegexp.ReplaceAllString(name, "-"))
+}
+
+// Parse parses a PEP 508 dependency specification.
+func Parse(s string) (r Requirement, err error) {
+	rest := strings.TrimSpace(s)
+	r.Name = nameRegexp.FindString(rest)
+	if r.Name == "" {
+		return r, fmt.Errorf("invalid requirement %q: missing package name", s)
+	}
+	rest = strings.TrimSpace(rest[len(r.Name):])
+
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]")
+		if end == -1 {
+			return r, fmt.Errorf("invalid requirement %q: unterminated extras", s)
+		}
+		for extra := range strings.SplitSeq(rest[1:end], ",") {
+			extra = strings.TrimSpace(extra)
+			if extra == "" {
+				continue
+			}
+			if !nameRegexp.MatchString(extra) {
+				return r, fmt.Errorf("invalid requirement %q: invalid extra %q", s, extra)
+			}
+			r.Extras = append(r.Extras, extra)
+		}
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	var marker string
+	if strings.HasPrefix(rest, "@") {
+		// The URL must be followed by whitespace before the marker, since URLs can contain ";".
+		rest = strings.TrimSpace(rest[1:])
+		r.URL, marker, _ = strings.Cut(rest, " ")
+		if r.URL == "" {
+			return r, fmt.Errorf("invalid requirement %q: missing URL", s)
+		}
+		marker = strings.TrimSpace(marker)
+		if marker != "" && !strings.HasPrefix(marker, ";") {
+			return r, fmt.Errorf("invalid requirement %q: unexpected %q after URL", s, marker)
+		}
+		marker = strings.TrimPrefix(marker, ";")
+	} else {
+		var specifier string
+		specifier, marker, _ = strings.Cut(rest, ";")
+		specifier = strings.TrimSpace(specifier)
+		if strings.HasPrefix(specifier, "(") && strings.HasSuffix(specifier, ")") {
+			specifier = strings.TrimSpace(specifier[1 : len(specifier)-1])
+		}
+		if specifier != "" {
+			if _, err = version.NewSpecifiers(specifier); err != nil {
+				return r, fmt.Errorf("invalid requirement %q: invalid version specifier: %w", s, err)
+			}
+		}
+		r.Specifier = strings.ReplaceAll(specifier, " ", "")
+	}
+
+	if marker = strings.TrimSpace(marker); marker != "" {
+		if r.Marker, err = ParseMarker(marker); err != nil {
+			return r, fmt.Errorf("invalid requirement %q: %w", s, err)
+		}
+	}
+	return r, nil
+}
+
+// HasExtra returns true if the requirement requests the extra.
+func (r Requirement) HasExtra(extra string) bool {
+	return slices.ContainsFunc(r.Extras, func(e string) bool {
+		return NormalizeName(e) == NormalizeName(extra)
+	})
+}
+
+// String returns the requirement in PEP 508 form, with a normalized name.
+func (r Requirement) String() string {
+	var sb strings.Builder
+	sb.WriteString(NormalizeName(r.Name))
+	if len(r.Extras) > 0 {
+		sb.WriteString("[" + strings.Join(r.Extras, ",") + "]")
+	}
+	if r.URL != "" {
+		sb.WriteString(" @ " + r.URL)
+	}
+	sb.WriteString(r.Specifier)
+	if r.Marker != nil {
+		if r.URL != "" {
+			sb.WriteString(" ")
+		}
+		sb.WriteString("; " + r.Marker.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,114 @@
+package requirement
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input     string
+		name      string
+		extras    []string
+		specifier string
+		url       string
+		marker    string
+	}{
+		{input: "requests", name: "requests"},
+		{input: "requests>=2.0.0", name: "requests", specifier: ">=2.0.0"},
+		{input: "requests >= 2.8.1, == 2.8.*", name: "requests", specifier: ">=2.8.1,==2.8.*"},
+		{input: "requests (>=2.8.1)", name: "requests", specifier: ">=2.8.1"},
+		{input: "requests[security, tests]>=2.8.1", name: "requests", extras: []string{"security", "tests"}, specifier: ">=2.8.1"},
+		{input: `requests[security] >= 2.8.1 ; python_version < "2.7"`, name: "requests", extras: []string{"security"}, specifier: ">=2.8.1", marker: `python_version < "2.7"`},
+		{input: "zope.interface~=5.0", name: "zope.interface", specifier: "~=5.0"},
+		{input: "pip @ https://example.com/pip-1.0.whl ; sys_platform == 'linux'", name: "pip", url: "https://example.com/pip-1.0.whl", marker: "sys_platform == 'linux'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			r, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Name != tt.name {
+				t.Errorf("expected name %q, got %q", tt.name, r.Name)
+			}
+			if !slices.Equal(r.Extras, tt.extras) {
+				t.Errorf("expected extras %v, got %v", tt.extras, r.Extras)
+			}
+			if r.Specifier != tt.specifier {
+				t.Errorf("expected specifier %q, got %q", tt.specifier, r.Specifier)
+			}
+			if r.URL != tt.url {
+				t.Errorf("expected URL %q, got %q", tt.url, r.URL)
+			}
+			if r.Marker.String() != tt.marker {
+				t.Errorf("expected marker %q, got %q", tt.marker, r.Marker.String())
+			}
+		})
+	}
+	for _, input := range []string{"", ">=1.0", "requests[security", "requests>=>1", "requests; python_version <", "requests; os_name = 'posix'"} {
+		t.Run("invalid "+input, func(t *testing.T) {
+			if _, err := Parse(input); err == nil {
+				t.Errorf("expected error parsing %q", input)
+			}
+		})
+	}
+}
+
+func TestMarkerEvaluate(t *testing.T) {
+	env := DefaultEnvironment()
+	tests := []struct {
+		marker   string
+		extra    string
+		expected bool
+	}{
+		{marker: `python_version >= "3.8"`, expected: true},
+		{marker: `python_version < "3.8"`, expected: false},
+		{marker: `python_version < "3.13"`, expected: true},
+		{marker: `"3.8" <= python_version`, expected: true},
+		{marker: `python_full_version >= "3.12.0rc1"`, expected: true},
+		{marker: `sys_platform == "win32"`, expected: false},
+		{marker: `sys_platform != "win32"`, expected: true},
+		{marker: `platform_machine in "x86_64 aarch64"`, expected: true},
+		{marker: `platform_machine not in "x86_64 aarch64"`, expected: false},
+		{marker: `platform.python_implementation == "CPython"`, expected: true},
+		{marker: `extra == "socks"`, expected: false},
+		{marker: `extra == "socks"`, extra: "socks", expected: true},
+		{marker: `extra == "Test_Utils"`, extra: "test-utils", expected: true},
+		{marker: `python_version >= "3.8" and extra == "socks"`, extra: "socks", expected: true},
+		{marker: `sys_platform == "win32" or python_version >= "3.8"`, expected: true},
+		{marker: `(sys_platform == "win32" or sys_platform == "darwin") and python_version >= "3.8"`, expected: false},
+		{marker: `sys_platform == "win32" or sys_platform == "darwin" and python_version >= "3.8"`, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.marker, func(t *testing.T) {
+			m, err := ParseMarker(tt.marker)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual, err := m.Evaluate(env.WithExtra(tt.extra))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+	t.Run("unknown variables are an error", func(t *testing.T) {
+		m, err := ParseMarker(`unknown_variable == "1"`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := m.Evaluate(env); err == nil {
+			t.Error("expected error")
+		}
+	})
+	t.Run("nil marker matches all environments", func(t *testing.T) {
+		var m *Marker
+		ok, err := m.Evaluate(env)
+		if err != nil || !ok {
+			t.Errorf("expected nil marker to match, got %v, %v", ok, err)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package save
+
+import (
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/requirement"
+	"github.com/a-h/depot/python/wheel"
+	version "github.com/aquasecurity/go-pep440-version"
+)
+
+// Format selects source distributions, wheels, or both.
+type Format string
+
+const (
+	FormatAll   Format = "all"
+	FormatSdist Format = "sdist"
+	FormatWheel Format = "wheel"
+)
+
+var sdistExtensions = []string{".tar.gz", ".zip", ".tar.bz2", ".tar.xz", ".tar", ".tgz"}
+
+// Filter selects the files of a package to save. The zero value saves all files.
+type Filter struct {
+	// PythonVersions that the files must support, e.g. "3.12".
+	PythonVersions []string
+	// Implementation that wheels must support, e.g. "cp" for CPython, or "py" for pure Python wheels only.
+	Implementation string
+	// Platforms that wheels must support, e.g. "manylinux_2_28_x86_64". Wheels for "any" platform are always saved.
+	Platforms []string
+	// Format of files to save.
+	Format Format
+}
+
+func (f Filter) restrictsTargets() bool {
+	return len(f.PythonVersions) > 0 || f.Implementation != "" || len(f.Platforms) > 0
+}
+
+// Match returns true if the file should be saved.
+func (f Filter) Match(file models.SimpleFileEntry) bool {
+	isWheel := strings.HasSuffix(file.Filename, ".whl")
+	isSdist := hasAnySuffix(file.Filename, sdistExtensions)
+	switch f.Format {
+	case FormatSdist:
+		if !isSdist {
+			return false
+		}
+	case FormatWheel:
+		if !isWheel {
+			return false
+		}
+	}
+	if !f.matchRequiresPython(file.RequiresPython) {
+		return false
+	}
+	if isSdist {
+		return true
+	}
+	if !isWheel {
+		// Legacy binary formats, e.g. eggs, are platform specific and can't be installed by pip.
+		return !f.restrictsTargets()
+	}
+	wf, err := wheel.ParseFilename(file.Filename)
+	if err != nil {
+		return false
+	}
+	pythonVersions := f.PythonVersions
+	if len(pythonVersions) == 0 {
+		pythonVersions = []string{""}
+	}
+	for _, tag := range wf.Tags {
+		for _, pythonVersion := range pythonVersions {
+			if tag.Compatible(pythonVersion, f.Implementation, f.Platforms) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchRequiresPython returns true if any of the Python versions satisfy the
+// requires-python specifier. Invalid specifiers are ignored, as pip does.
+func (f Filter) matchRequiresPython(requiresPython string) bool {
+	if requiresPython == "" || len(f.PythonVersions) == 0 {
+		return true
+	}
+	spec, err := version.NewSpecifiers(requiresPython, version.WithPreRelease(true))
+	if err != nil {
+		return true
+	}
+	for _, pythonVersion := range f.PythonVersions {
+		v, err := version.Parse(normalizePythonVersion(pythonVersion))
+		if err != nil || spec.Check(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Environments returns the environments used to evaluate dependency markers
+// for the Python versions, implementation and platforms of the filter, e.g.
+// sys_platform is win32 for the win_amd64 platform. There's an environment for
+// each combination of Python version and platform. Values that the filter
+// doesn't restrict are taken from requirement.DefaultEnvironment. The markers
+// override the values of every environment.
+func (f Filter) Environments(markers map[string]string) (envs []requirement.Environment) {
+	pythonVersions := f.PythonVersions
+	if len(pythonVersions) == 0 {
+		pythonVersions = []string{""}
+	}
+	platforms := f.Platforms
+	if len(platforms) == 0 {
+		platforms = []string{""}
+	}
+	for _, pythonVersion := range pythonVersions {
+		for _, platform := range platforms {
+			env := requirement.DefaultEnvironment()
+			if pythonVersion != "" {
+				v := normalizePythonVersion(pythonVersion)
+				env["python_version"] = v
+				env["python_full_version"] = v + ".0"
+				env["implementation_version"] = v + ".0"
+			}
+			if f.Implementation == "pp" {
+				env["platform_python_implementation"] = "PyPy"
+				env["implementation_name"] = "pypy"
+			}
+			maps.Copy(env, platformEnvironment(platform))
+			maps.Copy(env, markers)
+			envs = append(envs, env)
+		}
+	}
+	return envs
+}
+
+var platformArchRegexp = regexp.MustCompile(`^(?:(?:manylinux|musllinux|macosx)_\d+_\d+|manylinux1|manylinux2010|manylinux2014|linux)_(.+)$`)
+
+// platformEnvironment returns the marker values of a wheel platform, e.g.
+// manylinux_2_28_aarch64. It's empty for unknown platforms.
+func platformEnvironment(platform string) requirement.Environment {
+	if platform == "win32" {
+		return requirement.Environment{"os_name": "nt", "sys_platform": "win32", "platform_system": "Windows", "platform_machine": "x86"}
+	}
+	if arch, ok := strings.CutPrefix(platform, "win_"); ok {
+		return requirement.Environment{"os_name": "nt", "sys_platform": "win32", "platform_system": "Windows", "platform_machine": strings.ToUpper(arch)}
+	}
+	m := platformArchRegexp.FindStringSubmatch(platform)
+	if m == nil {
+		return nil
+	}
+	arch := m[1]
+	if strings.HasPrefix(platform, "macosx_") {
+		env := requirement.Environment{"os_name": "posix", "sys_platform": "darwin", "platform_system": "Darwin"}
+		// Multi-architecture wheels, e.g. universal2, don't determine the machine.
+		if arch == "arm64" || arch == "x86_64" {
+			env["platform_machine"] = arch
+		}
+		return env
+	}
+	return requirement.Environment{"os_name": "posix", "sys_platform": "linux", "platform_system": "Linux", "platform_machine": arch}
+}
+
+// normalizePythonVersion converts Python versions in the "312" form used by
+// pip's --python-version to the "3.12" form.
+func normalizePythonVersion(v string) string {
+	if !strings.Contains(v, ".") && len(v) > 1 {
+		return v[:1] + "." + v[1:]
+	}
+	return v
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
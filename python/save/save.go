@@ -1,106 +1,224 @@
 package save
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/reqfile"
+	"github.com/a-h/depot/python/requirement"
+	"github.com/a-h/depot/python/upstream"
+	"github.com/a-h/depot/python/wheel"
 	"github.com/a-h/depot/storage"
 	version "github.com/aquasecurity/go-pep440-version"
 )
 
 func New(log *slog.Logger, storage storage.Storage) *Saver {
 	return &Saver{
-		log:     log,
-		storage: storage,
-		client:  &http.Client{},
+		log:                 log,
+		storage:             storage,
+		upstream:            upstream.New(log, upstream.DefaultIndexURL),
+		environments:        []requirement.Environment{requirement.DefaultEnvironment()},
+		includeDependencies: true,
+		filter:              Filter{Format: FormatAll},
 	}
 }
 
 type Saver struct {
-	log     *slog.Logger
-	storage storage.Storage
-	client  *http.Client
+	log                 *slog.Logger
+	storage             storage.Storage
+	upstream            *upstream.Client
+	environments        []requirement.Environment
+	includeDependencies bool
+	filter              Filter
 }
 
+// SetIndexURL overrides the upstream simple index URL for testing.
+func (s *Saver) SetIndexURL(url string) {
+	s.upstream = upstream.New(s.log, url)
+}
+
+// SetEnvironments sets the environments used to evaluate the markers of
+// dependencies. Dependencies are saved if their marker matches any of them.
+func (s *Saver) SetEnvironments(envs []requirement.Environment) {
+	s.environments = envs
+}
+
+// matchMarker returns true if the marker matches any of the environments, with
+// no extra or any of the extras.
+func (s *Saver) matchMarker(m *requirement.Marker, extras ...string) (bool, error) {
+	for _, env := range s.environments {
+		for _, extra := range append([]string{""}, extras...) {
+			if ok, err := m.Evaluate(env.WithExtra(extra)); err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// SetIncludeDependencies sets whether dependencies of packages are saved.
+func (s *Saver) SetIncludeDependencies(include bool) {
+	s.includeDependencies = include
+}
+
+// SetFilter sets the filter used to select which files of each package are saved.
+func (s *Saver) SetFilter(filter Filter) {
+	s.filter = filter
+}
+
+// Save saves packages specified as PEP 508 requirements, e.g. "requests[socks]>=2.0.0",
+// and the packages they depend on. All versions that match a requirement are
+// saved, but only the latest matching version of each dependency is saved.
 func (s *Saver) Save(ctx context.Context, packages []string) error {
+	var f reqfile.File
 	for _, pkg := range packages {
-		if err := s.savePackage(ctx, pkg); err != nil {
-			s.log.Error("failed to save package", slog.String("package", pkg), slog.Any("error", err))
+		req, err := requirement.Parse(pkg)
+		if err != nil {
 			return err
 		}
+		f.Requirements = append(f.Requirements, reqfile.Requirement{Requirement: req})
 	}
-	return nil
+	return s.saveFile(ctx, f, s.includeDependencies)
 }
 
+// SaveFromReader saves the packages listed in a pip requirements file, and the
+// packages they depend on.
 func (s *Saver) SaveFromReader(ctx context.Context, r io.Reader) error {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		if err := s.savePackage(ctx, line); err != nil {
-			s.log.Error("failed to save package", slog.String("package", line), slog.Any("error", err))
-			return err
+	f, err := reqfile.Parse(r, ".")
+	if err != nil {
+		return fmt.Errorf("failed to parse requirements: %w", err)
+	}
+	return s.saveFile(ctx, f, s.includeDependencies)
+}
+
+// SaveFromFile saves the packages listed in a pip requirements file, or locked
+// in a poetry.lock, uv.lock or Pipfile.lock file. Lockfiles already list every
+// dependency, so dependencies are only resolved for requirements files.
+func (s *Saver) SaveFromFile(ctx context.Context, path string) error {
+	if !reqfile.IsLockfile(path) {
+		f, err := reqfile.ParseFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to parse requirements file %s: %w", path, err)
 		}
+		return s.saveFile(ctx, f, s.includeDependencies)
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open lockfile: %w", err)
+	}
+	defer r.Close()
+	f, err := reqfile.ParseLockfile(path, r)
+	if err != nil {
+		return err
 	}
-	return scanner.Err()
+	return s.saveFile(ctx, f, false)
 }
 
-// requests [security,tests] >= 2.8.1, == 2.8.* ; python_version < "2.7"
-var splitters = []string{"===", "==", "~=", ">=", "<=", ">", "<", "!="}
+func (s *Saver) saveFile(ctx context.Context, f reqfile.File, includeDependencies bool) error {
+	for _, unsupported := range f.Unsupported {
+		s.log.Warn("skipping requirement, only packages from an index can be saved", slog.String("requirement", unsupported))
+	}
+	if f.IndexURL != "" {
+		s.log.Info("using index URL from requirements", slog.String("url", f.IndexURL))
+		s.SetIndexURL(f.IndexURL)
+	}
+	for _, extraIndexURL := range f.ExtraIndexURLs {
+		s.log.Warn("ignoring extra index URL, only a single index is supported", slog.String("url", extraIndexURL))
+	}
 
-func firstIndexOfAny(s string, subs []string) (minIndex int) {
-	var found bool
-	for _, sub := range subs {
-		idx := strings.Index(s, sub)
-		if idx != -1 && (!found || idx < minIndex) {
-			found = true
-			minIndex = idx
+	// Constraints are combined with the specifier of any requirement for the same package.
+	constraints := make(map[string][]string)
+	for _, c := range f.Constraints {
+		ok, err := s.matchMarker(c.Marker)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate marker for constraint %q: %w", c.String(), err)
+		}
+		if ok && c.Specifier != "" {
+			name := requirement.NormalizeName(c.Name)
+			constraints[name] = append(constraints[name], c.Specifier)
 		}
 	}
-	if !found {
-		return -1
+
+	var reqs []reqfile.Requirement
+	for _, req := range f.Requirements {
+		ok, err := s.matchMarker(req.Marker)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate marker for %q: %w", req.String(), err)
+		}
+		if !ok {
+			s.log.Info("skipping package, marker does not match environment", slog.String("package", req.String()))
+			continue
+		}
+		reqs = append(reqs, req)
 	}
-	return minIndex
-}
 
-func splitOnFirst(s string, subs []string) (before, after string) {
-	minIndex := firstIndexOfAny(s, subs)
-	if minIndex == -1 {
-		return s, ""
+	seen := make(map[string]bool)
+	requested := len(reqs)
+	for i := 0; i < len(reqs); i++ {
+		req := reqs[i]
+		if seen[req.String()] {
+			continue
+		}
+		seen[req.String()] = true
+		if c := constraints[requirement.NormalizeName(req.Name)]; len(c) > 0 {
+			req.Specifier = strings.Join(append([]string{req.Specifier}, c...), ",")
+			req.Specifier = strings.TrimPrefix(req.Specifier, ",")
+		}
+		deps, err := s.savePackage(ctx, req, i >= requested, includeDependencies)
+		if err != nil {
+			s.log.Error("failed to save package", slog.String("package", req.String()), slog.Any("error", err))
+			return err
+		}
+		for _, dep := range deps {
+			reqs = append(reqs, reqfile.Requirement{Requirement: dep})
+		}
 	}
-	return s[:minIndex], s[minIndex:]
+	s.log.Info("all packages saved", slog.Int("total", len(seen)))
+	return nil
 }
 
-func (s *Saver) savePackage(ctx context.Context, line string) error {
-	s.log.Info("saving package", slog.String("line", line))
+// savePackage saves the files of all versions matching the requirement, or only
+// the latest matching version if latestOnly is set. If includeDependencies is
+// set, the dependencies of the latest matching version are returned.
+func (s *Saver) savePackage(ctx context.Context, req reqfile.Requirement, latestOnly, includeDependencies bool) (deps []requirement.Requirement, err error) {
+	s.log.Info("saving package", slog.String("requirement", req.String()))
+	pkg := req.Name
 
-	// Split on the lowest index of a splitter to get the package name.
-	pkg, specifiers := splitOnFirst(line, splitters)
+	if req.URL != "" {
+		s.log.Warn("skipping package, direct URL requirements are not supported", slog.String("requirement", req.String()))
+		return nil, nil
+	}
 
 	// Parse package spec, e.g. "requests>=2.0.0,<3.0.0"
 	var spec version.Specifiers
-	if specifiers != "" {
-		var err error
-		spec, err = version.NewSpecifiers(specifiers)
+	if req.Specifier != "" {
+		spec, err = version.NewSpecifiers(req.Specifier)
 		if err != nil {
-			return fmt.Errorf("invalid package specifier %q: %w", line, err)
+			return nil, fmt.Errorf("invalid package specifier %q: %w", req.Specifier, err)
 		}
 	}
 
 	s.log.Debug("fetching package index", slog.String("package", pkg))
 	index, err := s.getPackageIndex(ctx, pkg)
 	if err != nil {
-		return fmt.Errorf("failed to get package index for %s: %w", pkg, err)
+		return nil, fmt.Errorf("failed to get package index for %s: %w", pkg, err)
+	}
+	if len(index.Versions) == 0 {
+		// Indexes that predate PEP 700 don't list versions.
+		for _, f := range index.Files {
+			if v := f.Version(); v != "" && !slices.Contains(index.Versions, v) {
+				index.Versions = append(index.Versions, v)
+			}
+		}
 	}
 
 	s.log.Debug("filtering package versions", slog.String("package", pkg), slog.String("spec", spec.String()), slog.Int("totalVersions", len(index.Versions)))
@@ -115,54 +233,227 @@ func (s *Saver) savePackage(ctx context.Context, line string) error {
 		return spec.Check(version), nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to filter versions for %s: %w", pkg, err)
+		return nil, fmt.Errorf("failed to filter versions for %s: %w", pkg, err)
+	}
+	// Files that don't match the filter or hashes are not saved, but can still be used to find dependencies.
+	candidates := filteredIndex.Files
+	filteredIndex.Files = slices.DeleteFunc(slices.Clone(candidates), func(f models.SimpleFileEntry) bool {
+		return !s.filter.Match(f) || !matchesAnyHash(f, req.Hashes)
+	})
+	filteredIndex.Versions = slices.DeleteFunc(filteredIndex.Versions, func(v string) bool {
+		return !slices.ContainsFunc(filteredIndex.Files, func(f models.SimpleFileEntry) bool { return f.Version() == v })
+	})
+	if len(filteredIndex.Files) == 0 {
+		if len(req.Hashes) > 0 {
+			// pip refuses to install a requirement with hashes if no file matches them.
+			return nil, fmt.Errorf("no files of %s match the filter and the required hashes %s", pkg, strings.Join(req.Hashes, ", "))
+		}
+		s.log.Warn("no files match the filter", slog.String("requirement", req.String()), slog.Int("candidates", len(candidates)))
+	}
+	latest, hasLatest := latestVersion(filteredIndex.Versions)
+	if latestOnly && hasLatest {
+		filteredIndex, err = filterVersions(filteredIndex, func(v string) (ok bool, err error) {
+			version, err := version.Parse(v)
+			return err == nil && version.Equal(latest), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter versions for %s: %w", pkg, err)
+		}
 	}
 
 	s.log.Debug("saving package files", slog.String("package", pkg), slog.Int("totalFiles", len(filteredIndex.Files)))
 	for _, file := range filteredIndex.Files {
 		s.log.Debug("saving package file", slog.String("package", pkg), slog.String("file", file.Filename))
-		if err = s.savePackageFile(ctx, pkg, file); err != nil {
-			return fmt.Errorf("failed to save package file %s for %s: %w", file.Filename, pkg, err)
+		if err = s.savePackageFile(ctx, pkg, file, req.Hashes); err != nil {
+			return nil, fmt.Errorf("failed to save package file %s for %s: %w", file.Filename, pkg, err)
 		}
 	}
 
 	s.log.Info("saved package", slog.String("package", pkg), slog.Int("versions", len(filteredIndex.Versions)), slog.Int("files", len(filteredIndex.Files)))
-	return nil
+	if !includeDependencies {
+		return nil, nil
+	}
+	if !hasLatest {
+		s.log.Warn("no matching versions found, dependencies not saved", slog.String("requirement", req.String()))
+		return nil, nil
+	}
+	return s.getDependencies(ctx, req.Requirement, latest, candidates)
 }
 
-func (s *Saver) savePackageFile(ctx context.Context, pkg string, file models.SimpleFileEntry) (err error) {
-	// Check the existing file size.
-	fileName := fmt.Sprintf("%s/%s", pkg, file.Filename)
-	size, exists, err := s.storage.Stat(ctx, fileName)
+// getDependencies returns the dependencies of the latest matching version that
+// apply to the environments and the extras of the requirement. This is the
+// version that pip installs when there are no other constraints.
+func (s *Saver) getDependencies(ctx context.Context, req requirement.Requirement, latest version.Version, indexFiles []models.SimpleFileEntry) (deps []requirement.Requirement, err error) {
+	var files []models.SimpleFileEntry
+	for _, f := range indexFiles {
+		if v, err := version.Parse(f.Version()); err == nil && v.Equal(latest) {
+			files = append(files, f)
+		}
+	}
+	md, ok, err := s.getCoreMetadata(ctx, req.Name, files)
 	if err != nil {
-		return fmt.Errorf("failed to stat storage file %s/%s: %w", pkg, file.Filename, err)
+		return nil, fmt.Errorf("failed to get core metadata for %s %s: %w", req.Name, latest, err)
 	}
-	if exists && size == file.Size {
-		s.log.Debug("file already exists with matching size, skipping download", slog.String("package", pkg), slog.String("file", file.Filename))
-		return nil
+	if !ok {
+		s.log.Warn("no wheel metadata found, dependencies not saved", slog.String("package", req.Name), slog.String("version", latest.String()))
+		return nil, nil
 	}
 
-	// Download and save the file.
-	w, err := s.storage.Put(ctx, fileName)
-	if err != nil {
-		return fmt.Errorf("failed to create storage writer for %s/%s: %w", pkg, file.Filename, err)
+	for _, rd := range md.RequiresDist {
+		dep, err := requirement.Parse(rd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependency of %s %s: %w", req.Name, latest, err)
+		}
+		ok, err := s.matchMarker(dep.Marker, req.Extras...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate marker of dependency %q of %s %s: %w", rd, req.Name, latest, err)
+		}
+		if ok {
+			dep.Marker = nil
+			deps = append(deps, dep)
+		}
 	}
-	defer func() {
-		if cerr := w.Close(); cerr != nil && err == nil {
-			err = cerr
+	s.log.Debug("found dependencies", slog.String("package", req.Name), slog.String("version", latest.String()), slog.Int("count", len(deps)))
+	return deps, nil
+}
+
+// matchesAnyHash returns true if there are no hashes, or if one of the file's
+// hashes in the index matches one of the hashes, given in "algorithm:hex" form.
+// Files without an index hash of any of the algorithms may match, so they're
+// kept. Saved files are verified against the hashes, see savePackageFile.
+func matchesAnyHash(f models.SimpleFileEntry, hashes []string) bool {
+	if len(hashes) == 0 {
+		return true
+	}
+	var comparable bool
+	for _, hash := range hashes {
+		algorithm, expected, _ := strings.Cut(hash, ":")
+		actual, ok := f.Hashes[algorithm]
+		if ok && strings.EqualFold(actual, expected) {
+			return true
 		}
-	}()
-	resp, err := s.client.Get(file.URL)
+		comparable = comparable || ok
+	}
+	return !comparable
+}
+
+// latestVersion returns the highest final release, or the highest pre-release
+// if there are no final releases.
+func latestVersion(versions []string) (latest version.Version, ok bool) {
+	var latestPreRelease version.Version
+	var hasPreRelease bool
+	for _, s := range versions {
+		v, err := version.Parse(s)
+		if err != nil {
+			continue
+		}
+		if v.IsPreRelease() {
+			if !hasPreRelease || v.GreaterThan(latestPreRelease) {
+				latestPreRelease, hasPreRelease = v, true
+			}
+			continue
+		}
+		if !ok || v.GreaterThan(latest) {
+			latest, ok = v, true
+		}
+	}
+	if !ok {
+		return latestPreRelease, hasPreRelease
+	}
+	return latest, true
+}
+
+// getCoreMetadata gets the core metadata of one of the files. The PEP 658
+// metadata file is used if the index has one, otherwise the METADATA file is
+// read from a saved wheel. Core metadata of sdists is not reliable, so sdists
+// are not used.
+func (s *Saver) getCoreMetadata(ctx context.Context, pkg string, files []models.SimpleFileEntry) (md models.CoreMetadata, ok bool, err error) {
+	for _, f := range files {
+		hashes, hasMetadata := f.CoreMetadataHashes()
+		if !strings.HasSuffix(f.Filename, ".whl") || !hasMetadata {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s.metadata", pkg, f.Filename)
+		_, exists, err := s.storage.Stat(ctx, key)
+		if err != nil {
+			return md, false, fmt.Errorf("failed to stat storage file %s: %w", key, err)
+		}
+		if !exists {
+			if err = s.upstream.Download(ctx, s.storage, f.URL+".metadata", key, hashes, nil); err != nil {
+				return md, false, err
+			}
+		}
+		r, _, err := s.storage.Get(ctx, key)
+		if err != nil {
+			return md, false, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		defer r.Close()
+		md, err = models.ParseCoreMetadata(r)
+		return md, err == nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Filename, ".whl") {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s", pkg, f.Filename)
+		_, exists, err := s.storage.Stat(ctx, key)
+		if err != nil {
+			return md, false, fmt.Errorf("failed to stat storage file %s: %w", key, err)
+		}
+		if !exists {
+			continue
+		}
+		md, err = s.readWheelMetadata(ctx, key)
+		return md, err == nil, err
+	}
+	return md, false, nil
+}
+
+func (s *Saver) readWheelMetadata(ctx context.Context, key string) (md models.CoreMetadata, err error) {
+	r, exists, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return md, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if !exists {
+		return md, fmt.Errorf("wheel %s not found in storage", key)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to download file %s: %w", file.URL, err)
+		return md, fmt.Errorf("failed to read %s: %w", key, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code %d when downloading file %s", resp.StatusCode, file.URL)
+	metadata, err := wheel.ReadMetadata(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return md, fmt.Errorf("failed to read wheel %s: %w", key, err)
 	}
-	_, err = io.Copy(w, resp.Body)
+	return models.ParseCoreMetadata(bytes.NewReader(metadata))
+}
+
+// savePackageFile saves a file, verifying it against the index hash and the
+// hashes of the requirement. Files that have already been saved are verified
+// instead of being downloaded again.
+func (s *Saver) savePackageFile(ctx context.Context, pkg string, file models.SimpleFileEntry, hashes []string) (err error) {
+	// Check the existing file size and hashes.
+	fileName := fmt.Sprintf("%s/%s", pkg, file.Filename)
+	size, exists, err := s.storage.Stat(ctx, fileName)
 	if err != nil {
-		return fmt.Errorf("failed to write file %s to storage: %w", file.Filename, err)
+		return fmt.Errorf("failed to stat storage file %s/%s: %w", pkg, file.Filename, err)
+	}
+	if exists && size == file.Size {
+		err = s.verifyFile(ctx, fileName, file.Hashes, hashes)
+		if err == nil {
+			s.log.Debug("file already exists with matching size and hashes, skipping download", slog.String("package", pkg), slog.String("file", file.Filename))
+			return nil
+		}
+		if !errors.Is(err, upstream.ErrHashMismatch) {
+			return err
+		}
+		s.log.Warn("existing file doesn't match its hashes, downloading it again", slog.String("package", pkg), slog.String("file", file.Filename), slog.Any("error", err))
+	}
+
+	// Download and save the file, verifying it against the index and requirement hashes.
+	if err = s.upstream.Download(ctx, s.storage, file.URL, fileName, file.Hashes, hashes); err != nil {
+		return fmt.Errorf("failed to download file %s: %w", file.Filename, err)
 	}
 
 	// Save the metadata alongside the file.
@@ -172,9 +463,11 @@ func (s *Saver) savePackageFile(ctx context.Context, pkg string, file models.Sim
 		return fmt.Errorf("failed to create storage writer for metadata %s: %w", metadataName, err)
 	}
 	defer func() {
-		if cerr := metadataWriter.Close(); cerr != nil && err == nil {
-			err = cerr
+		if err != nil {
+			storage.Abort(metadataWriter)
+			return
 		}
+		err = metadataWriter.Close()
 	}()
 	encoder := json.NewEncoder(metadataWriter)
 	encoder.SetIndent("", "  ")
@@ -185,26 +478,21 @@ func (s *Saver) savePackageFile(ctx context.Context, pkg string, file models.Sim
 	return nil
 }
 
-func (s *Saver) getPackageIndex(ctx context.Context, name string) (index models.SimplePackageIndex, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://pypi.org/simple/"+url.PathEscape(name), nil)
-	if err != nil {
-		return index, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("User-Agent", "Depot/0.1 (+https://github.com/a-h/depot)")
-	req.Header.Set("Accept", "application/vnd.pypi.simple.v1+json")
-	resp, err := s.client.Do(req)
+// verifyFile checks a saved file against the index hash and the hashes of the requirement.
+func (s *Saver) verifyFile(ctx context.Context, key string, indexHashes map[string]string, hashes []string) error {
+	r, exists, err := s.storage.Get(ctx, key)
 	if err != nil {
-		return index, fmt.Errorf("failed to perform request: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return index, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to read %s: %w", key, err)
 	}
-	err = json.NewDecoder(resp.Body).Decode(&index)
-	if err != nil {
-		return index, fmt.Errorf("failed to decode response: %w", err)
+	if !exists {
+		return fmt.Errorf("file %s not found in storage", key)
 	}
-	return index, nil
+	defer r.Close()
+	return upstream.Verify(r, key, indexHashes, hashes)
+}
+
+func (s *Saver) getPackageIndex(ctx context.Context, name string) (index models.SimplePackageIndex, err error) {
+	return s.upstream.GetPackageIndex(ctx, name)
 }
 
 func filterVersions(index models.SimplePackageIndex, shouldKeep func(version string) (ok bool, err error)) (filtered models.SimplePackageIndex, err error) {
@@ -0,0 +1,435 @@
+package save
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/python/upstream"
+	"github.com/a-h/depot/storage"
+)
+
+func newWheel(t *testing.T, distInfo, metadata string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(distInfo + "/METADATA")
+	if err != nil {
+		t.Fatalf("failed to create METADATA: %v", err)
+	}
+	if _, err = w.Write([]byte(metadata)); err != nil {
+		t.Fatalf("failed to write METADATA: %v", err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("failed to close wheel: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveResolvesTransitiveDependencies(t *testing.T) {
+	// app depends on lib, and on extra-lib with the "extra" extra, and on win-lib on Windows.
+	// lib 2.0.0 has no PEP 658 metadata, so its dependencies are read from the wheel.
+	appMetadata := `Metadata-Version: 2.1
+Name: app
+Version: 1.0.0
+Requires-Dist: lib>=1.0.0
+Requires-Dist: extra-lib; extra == "extra"
+Requires-Dist: win-lib; sys_platform == "win32"
+`
+	libWheel := newWheel(t, "lib-2.0.0.dist-info", "Metadata-Version: 2.1\nName: lib\nVersion: 2.0.0\nRequires-Dist: leaf\n")
+
+	var ts *httptest.Server
+	index := func(name string, files ...models.SimpleFileEntry) models.SimplePackageIndex {
+		for i := range files {
+			files[i].URL = ts.URL + "/files/" + files[i].Filename
+		}
+		return models.SimplePackageIndex{Name: name, Files: files}
+	}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/simple/app/":
+			json.NewEncoder(w).Encode(index("app",
+				models.SimpleFileEntry{Filename: "app-1.0.0-py3-none-any.whl", CoreMetadata: json.RawMessage("true")},
+			))
+		case "/simple/lib/":
+			json.NewEncoder(w).Encode(index("lib",
+				models.SimpleFileEntry{Filename: "lib-1.0.0-py3-none-any.whl"},
+				models.SimpleFileEntry{Filename: "lib-2.0.0-py3-none-any.whl"},
+				models.SimpleFileEntry{Filename: "lib-3.0.0b1-py3-none-any.whl"},
+			))
+		case "/simple/extra-lib/":
+			json.NewEncoder(w).Encode(index("extra-lib",
+				models.SimpleFileEntry{Filename: "extra_lib-1.0.0.tar.gz"},
+			))
+		case "/simple/leaf/":
+			json.NewEncoder(w).Encode(index("leaf",
+				models.SimpleFileEntry{Filename: "leaf-1.0.0.tar.gz"},
+			))
+		case "/files/app-1.0.0-py3-none-any.whl.metadata":
+			w.Write([]byte(appMetadata))
+		case "/files/lib-2.0.0-py3-none-any.whl":
+			w.Write(libWheel)
+		case "/files/app-1.0.0-py3-none-any.whl", "/files/lib-1.0.0-py3-none-any.whl", "/files/lib-3.0.0b1-py3-none-any.whl", "/files/leaf-1.0.0.tar.gz", "/files/extra_lib-1.0.0.tar.gz":
+			w.Write([]byte("content"))
+		default:
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	newSaver := func(t *testing.T) (*Saver, string) {
+		dir := t.TempDir()
+		saver := New(log, storage.NewFileSystem(dir))
+		saver.SetIndexURL(ts.URL + "/simple")
+		return saver, dir
+	}
+	assertFiles := func(t *testing.T, dir string, expected map[string]bool) {
+		t.Helper()
+		for name, shouldExist := range expected {
+			_, err := os.Stat(filepath.Join(dir, name))
+			if exists := err == nil; exists != shouldExist {
+				t.Errorf("expected %s to exist: %v, but exists: %v", name, shouldExist, exists)
+			}
+		}
+	}
+
+	t.Run("the latest matching version of each dependency is saved", func(t *testing.T) {
+		saver, dir := newSaver(t)
+		if err := saver.Save(context.Background(), []string{"app==1.0.0"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertFiles(t, dir, map[string]bool{
+			"app/app-1.0.0-py3-none-any.whl":          true,
+			"app/app-1.0.0-py3-none-any.whl.metadata": true,
+			"lib/lib-2.0.0-py3-none-any.whl":          true,
+			"lib/lib-1.0.0-py3-none-any.whl":          false,
+			"lib/lib-3.0.0b1-py3-none-any.whl":        false,
+			"leaf/leaf-1.0.0.tar.gz":                  true,
+			"extra-lib":                               false,
+			"win-lib":                                 false,
+		})
+	})
+	t.Run("dependencies of requested extras are saved", func(t *testing.T) {
+		saver, dir := newSaver(t)
+		if err := saver.Save(context.Background(), []string{"app[extra]==1.0.0"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertFiles(t, dir, map[string]bool{
+			"extra-lib/extra_lib-1.0.0.tar.gz": true,
+			"win-lib":                          false,
+		})
+	})
+	t.Run("dependencies are not saved if disabled", func(t *testing.T) {
+		saver, dir := newSaver(t)
+		saver.SetIncludeDependencies(false)
+		if err := saver.Save(context.Background(), []string{"app==1.0.0"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertFiles(t, dir, map[string]bool{
+			"app/app-1.0.0-py3-none-any.whl": true,
+			"lib":                            false,
+		})
+	})
+	t.Run("requirements with markers that don't match the environment are skipped", func(t *testing.T) {
+		saver, dir := newSaver(t)
+		if err := saver.Save(context.Background(), []string{`app==1.0.0; sys_platform == "win32"`}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertFiles(t, dir, map[string]bool{"app": false})
+	})
+}
+
+func TestFilter(t *testing.T) {
+	files := []models.SimpleFileEntry{
+		{Filename: "pkg-1.0.0.tar.gz"},
+		{Filename: "pkg-1.0.0-py3-none-any.whl"},
+		{Filename: "pkg-1.0.0-cp312-cp312-manylinux_2_17_x86_64.whl"},
+		{Filename: "pkg-1.0.0-cp312-cp312-win_amd64.whl"},
+		{Filename: "pkg-1.0.0-cp311-cp311-manylinux_2_17_x86_64.whl"},
+		{Filename: "pkg-1.0.0-py2.7.egg"},
+		{Filename: "old-0.1.0.tar.gz", RequiresPython: "<3.0"},
+	}
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+	}{
+		{
+			name:   "the zero value matches all files",
+			filter: Filter{},
+			expected: []string{
+				"pkg-1.0.0.tar.gz", "pkg-1.0.0-py3-none-any.whl", "pkg-1.0.0-cp312-cp312-manylinux_2_17_x86_64.whl",
+				"pkg-1.0.0-cp312-cp312-win_amd64.whl", "pkg-1.0.0-cp311-cp311-manylinux_2_17_x86_64.whl", "pkg-1.0.0-py2.7.egg", "old-0.1.0.tar.gz",
+			},
+		},
+		{
+			name:     "sdists only",
+			filter:   Filter{Format: FormatSdist},
+			expected: []string{"pkg-1.0.0.tar.gz", "old-0.1.0.tar.gz"},
+		},
+		{
+			name:     "Python version and platform",
+			filter:   Filter{PythonVersions: []string{"3.12"}, Platforms: []string{"manylinux_2_28_x86_64"}},
+			expected: []string{"pkg-1.0.0.tar.gz", "pkg-1.0.0-py3-none-any.whl", "pkg-1.0.0-cp312-cp312-manylinux_2_17_x86_64.whl"},
+		},
+		{
+			name:     "wheels for multiple Python versions",
+			filter:   Filter{PythonVersions: []string{"3.11", "3.12"}, Platforms: []string{"manylinux_2_28_x86_64"}, Format: FormatWheel},
+			expected: []string{"pkg-1.0.0-py3-none-any.whl", "pkg-1.0.0-cp312-cp312-manylinux_2_17_x86_64.whl", "pkg-1.0.0-cp311-cp311-manylinux_2_17_x86_64.whl"},
+		},
+		{
+			name:     "pure Python wheels",
+			filter:   Filter{Implementation: "py", Format: FormatWheel},
+			expected: []string{"pkg-1.0.0-py3-none-any.whl"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actual []string
+			for _, f := range files {
+				if tt.filter.Match(f) {
+					actual = append(actual, f.Filename)
+				}
+			}
+			if !slices.Equal(actual, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFilterEnvironments(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   Filter
+		markers  map[string]string
+		expected []map[string]string
+	}{
+		{
+			name:     "the zero value uses the default environment",
+			filter:   Filter{},
+			expected: []map[string]string{{"sys_platform": "linux", "platform_machine": "x86_64", "python_version": "3.12"}},
+		},
+		{
+			name:   "Windows platforms",
+			filter: Filter{PythonVersions: []string{"311"}, Platforms: []string{"win_amd64"}},
+			expected: []map[string]string{{
+				"sys_platform": "win32", "os_name": "nt", "platform_system": "Windows", "platform_machine": "AMD64",
+				"python_version": "3.11", "python_full_version": "3.11.0",
+			}},
+		},
+		{
+			name:   "an environment for each Python version and platform",
+			filter: Filter{PythonVersions: []string{"3.11", "3.12"}, Platforms: []string{"manylinux_2_28_aarch64", "macosx_14_0_arm64"}},
+			expected: []map[string]string{
+				{"python_version": "3.11", "sys_platform": "linux", "platform_machine": "aarch64"},
+				{"python_version": "3.11", "sys_platform": "darwin", "platform_system": "Darwin", "platform_machine": "arm64"},
+				{"python_version": "3.12", "sys_platform": "linux", "platform_machine": "aarch64"},
+				{"python_version": "3.12", "sys_platform": "darwin", "platform_system": "Darwin", "platform_machine": "arm64"},
+			},
+		},
+		{
+			name:     "legacy manylinux platforms",
+			filter:   Filter{Platforms: []string{"manylinux2014_i686"}},
+			expected: []map[string]string{{"sys_platform": "linux", "platform_machine": "i686"}},
+		},
+		{
+			name:     "PyPy",
+			filter:   Filter{Implementation: "pp"},
+			expected: []map[string]string{{"implementation_name": "pypy", "platform_python_implementation": "PyPy"}},
+		},
+		{
+			name:     "markers override the target",
+			filter:   Filter{Platforms: []string{"win_amd64"}},
+			markers:  map[string]string{"platform_machine": "ARM64"},
+			expected: []map[string]string{{"sys_platform": "win32", "platform_machine": "ARM64"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := tt.filter.Environments(tt.markers)
+			if len(envs) != len(tt.expected) {
+				t.Fatalf("expected %d environments, got %d: %v", len(tt.expected), len(envs), envs)
+			}
+			for i, expected := range tt.expected {
+				for k, v := range expected {
+					if envs[i][k] != v {
+						t.Errorf("environment %d: expected %s=%q, got %q", i, k, v, envs[i][k])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaveFromFile(t *testing.T) {
+	files := map[string][]byte{
+		"lib-1.0.0-py3-none-any.whl": newWheel(t, "lib-1.0.0.dist-info", "Name: lib\nVersion: 1.0.0\n"),
+		"lib-2.0.0-py3-none-any.whl": newWheel(t, "lib-2.0.0.dist-info", "Name: lib\nVersion: 2.0.0\n"),
+		"bad-1.0.0-py3-none-any.whl": []byte("tampered"),
+	}
+	hashOf := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	entry := func(ts *httptest.Server, filename string) models.SimpleFileEntry {
+		return models.SimpleFileEntry{
+			Filename: filename,
+			URL:      ts.URL + "/files/" + filename,
+			Hashes:   map[string]string{"sha256": hashOf(string(files[filename]))},
+			Size:     int64(len(files[filename])),
+		}
+	}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/simple/lib/":
+			json.NewEncoder(w).Encode(models.SimplePackageIndex{Name: "lib", Files: []models.SimpleFileEntry{
+				entry(ts, "lib-1.0.0-py3-none-any.whl"),
+				entry(ts, "lib-2.0.0-py3-none-any.whl"),
+			}})
+		case "/simple/bad/":
+			f := entry(ts, "bad-1.0.0-py3-none-any.whl")
+			f.Hashes["sha256"] = hashOf("original")
+			json.NewEncoder(w).Encode(models.SimplePackageIndex{Name: "bad", Files: []models.SimpleFileEntry{f}})
+		default:
+			content, ok := files[filepath.Base(r.URL.Path)]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(content)
+		}
+	}))
+	defer ts.Close()
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	saveTo := func(t *testing.T, dir, filename, content string) error {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), filename)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", filename, err)
+		}
+		if err := os.WriteFile(filepath.Join(filepath.Dir(path), "constraints.txt"), []byte("lib<2\n"), 0644); err != nil {
+			t.Fatalf("failed to write constraints: %v", err)
+		}
+		saver := New(log, storage.NewFileSystem(dir))
+		saver.SetIndexURL(ts.URL + "/simple")
+		return saver.SaveFromFile(context.Background(), path)
+	}
+	save := func(t *testing.T, filename, content string) (dir string, err error) {
+		t.Helper()
+		dir = t.TempDir()
+		return dir, saveTo(t, dir, filename, content)
+	}
+	exists := func(dir, name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	t.Run("only files matching requirement hashes are saved", func(t *testing.T) {
+		dir, err := save(t, "requirements.txt", "lib --hash=sha256:"+hashOf(string(files["lib-1.0.0-py3-none-any.whl"]))+"\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists(dir, "lib/lib-1.0.0-py3-none-any.whl") || exists(dir, "lib/lib-2.0.0-py3-none-any.whl") {
+			t.Error("expected only lib 1.0.0 to be saved")
+		}
+	})
+	t.Run("requirements with hashes that match no files are rejected", func(t *testing.T) {
+		dir, err := save(t, "requirements.txt", "lib --hash=sha256:"+hashOf("other")+"\n")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if exists(dir, "lib/lib-1.0.0-py3-none-any.whl") || exists(dir, "lib/lib-2.0.0-py3-none-any.whl") {
+			t.Error("expected no files to be saved")
+		}
+	})
+	t.Run("files are verified against requirement hashes that the index doesn't publish", func(t *testing.T) {
+		sum := sha512.Sum512(files["lib-1.0.0-py3-none-any.whl"])
+		dir, err := save(t, "requirements.txt", "lib==1.0.0 --hash=sha512:"+hex.EncodeToString(sum[:])+"\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists(dir, "lib/lib-1.0.0-py3-none-any.whl") {
+			t.Error("expected lib 1.0.0 to be saved")
+		}
+
+		sum = sha512.Sum512([]byte("other"))
+		dir, err = save(t, "requirements.txt", "lib==1.0.0 --hash=sha512:"+hex.EncodeToString(sum[:])+"\n")
+		if !errors.Is(err, upstream.ErrHashMismatch) {
+			t.Fatalf("expected hash mismatch error, got %v", err)
+		}
+		if exists(dir, "lib/lib-1.0.0-py3-none-any.whl") {
+			t.Error("expected file that doesn't match the requirement hash not to be saved")
+		}
+	})
+	t.Run("existing files that don't match their hashes are downloaded again", func(t *testing.T) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "lib", "lib-1.0.0-py3-none-any.whl")
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		tampered := bytes.Repeat([]byte("x"), len(files["lib-1.0.0-py3-none-any.whl"]))
+		if err := os.WriteFile(name, tampered, 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := saveTo(t, dir, "requirements.txt", "lib==1.0.0\n"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if !bytes.Equal(content, files["lib-1.0.0-py3-none-any.whl"]) {
+			t.Error("expected the tampered file to be replaced")
+		}
+	})
+	t.Run("constraints restrict versions", func(t *testing.T) {
+		dir, err := save(t, "requirements.txt", "-c constraints.txt\nlib\n")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists(dir, "lib/lib-1.0.0-py3-none-any.whl") || exists(dir, "lib/lib-2.0.0-py3-none-any.whl") {
+			t.Error("expected only lib 1.0.0 to be saved")
+		}
+	})
+	t.Run("locked versions are saved", func(t *testing.T) {
+		lock := `[[package]]
+name = "lib"
+version = "2.0.0"
+source = { registry = "https://pypi.org/simple" }
+wheels = [{ url = "https://example.com/lib-2.0.0-py3-none-any.whl", hash = "sha256:` + hashOf(string(files["lib-2.0.0-py3-none-any.whl"])) + `" }]
+`
+		dir, err := save(t, "uv.lock", lock)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists(dir, "lib/lib-1.0.0-py3-none-any.whl") || !exists(dir, "lib/lib-2.0.0-py3-none-any.whl") {
+			t.Error("expected only lib 2.0.0 to be saved")
+		}
+	})
+	t.Run("files that don't match the index hash are not saved", func(t *testing.T) {
+		dir, err := save(t, "requirements.txt", "bad\n")
+		if !errors.Is(err, upstream.ErrHashMismatch) {
+			t.Fatalf("expected hash mismatch error, got %v", err)
+		}
+		if exists(dir, "bad/bad-1.0.0-py3-none-any.whl") {
+			t.Error("expected file with mismatched hash not to be saved")
+		}
+	})
+}
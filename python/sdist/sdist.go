@@ -0,0 +1,76 @@
+package sdist
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadMetadata returns the contents of the PKG-INFO file at the root of a
+// source distribution. The archive format is determined by the filename.
+func ReadMetadata(filename string, r io.ReaderAt, size int64) (metadata []byte, err error) {
+	switch {
+	case strings.HasSuffix(filename, ".tar.gz"), strings.HasSuffix(filename, ".tgz"):
+		gr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sdist: %w", err)
+		}
+		defer gr.Close()
+		return readTarMetadata(gr)
+	case strings.HasSuffix(filename, ".tar.bz2"):
+		return readTarMetadata(bzip2.NewReader(io.NewSectionReader(r, 0, size)))
+	case strings.HasSuffix(filename, ".tar"):
+		return readTarMetadata(io.NewSectionReader(r, 0, size))
+	case strings.HasSuffix(filename, ".zip"):
+		return readZipMetadata(r, size)
+	}
+	return nil, fmt.Errorf("unsupported sdist format %q", filename)
+}
+
+// isPKGInfo returns true for the PKG-INFO file in the top level directory of
+// the sdist, e.g. example-1.0.0/PKG-INFO, but not for the copies in .egg-info
+// directories.
+func isPKGInfo(name string) bool {
+	dir, file, ok := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+	return ok && dir != "" && file == "PKG-INFO"
+}
+
+func readTarMetadata(r io.Reader) (metadata []byte, err error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sdist: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && isPKGInfo(hdr.Name) {
+			return io.ReadAll(tr)
+		}
+	}
+	return nil, fmt.Errorf("sdist does not contain a PKG-INFO file")
+}
+
+func readZipMetadata(r io.ReaderAt, size int64) (metadata []byte, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sdist: %w", err)
+	}
+	for _, f := range zr.File {
+		if !isPKGInfo(f.Name) {
+			continue
+		}
+		fr, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+		}
+		defer fr.Close()
+		return io.ReadAll(fr)
+	}
+	return nil, fmt.Errorf("sdist does not contain a PKG-INFO file")
+}
@@ -0,0 +1,209 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/storage"
+)
+
+// DefaultIndexURL is the URL of the PyPI simple index.
+const DefaultIndexURL = "https://pypi.org/simple"
+
+// ErrNotFound is returned when the upstream index does not have the requested package or file.
+var ErrNotFound = errors.New("not found")
+
+// ErrHashMismatch is returned when a downloaded file does not match the hash
+// published by the upstream index, or the hashes of a requirement.
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// Client fetches package indexes and files from an upstream simple index.
+type Client struct {
+	log      *slog.Logger
+	client   *http.Client
+	indexURL string
+}
+
+// New creates a new Client for the simple index at indexURL, e.g. https://pypi.org/simple.
+func New(log *slog.Logger, indexURL string) *Client {
+	return &Client{
+		log:      log,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		indexURL: strings.TrimSuffix(indexURL, "/"),
+	}
+}
+
+// GetPackageIndex fetches the PEP 691 JSON index for a package. Relative file
+// URLs are resolved against the index URL, so that they can be downloaded later.
+func (c *Client) GetPackageIndex(ctx context.Context, name string) (index models.SimplePackageIndex, err error) {
+	indexURL := c.indexURL + "/" + url.PathEscape(name) + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return index, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Depot/0.1 (+https://github.com/a-h/depot)")
+	req.Header.Set("Accept", "application/vnd.pypi.simple.v1+json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return index, fmt.Errorf("failed to perform request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err = checkStatus(resp, indexURL); err != nil {
+		return index, err
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return index, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return index, fmt.Errorf("failed to parse index URL: %w", err)
+	}
+	for i, file := range index.Files {
+		u, err := url.Parse(file.URL)
+		if err != nil {
+			return index, fmt.Errorf("invalid URL for file %q: %w", file.Filename, err)
+		}
+		index.Files[i].URL = base.ResolveReference(u).String()
+	}
+	return index, nil
+}
+
+// Download streams the file at fileURL into storage at key. If hashes contains
+// a sha256 hash, the file is verified against it. If required isn't empty, the
+// file must also match one of the required hashes, given in "algorithm:hex"
+// form, as pip requires in hash-checking mode. Nothing is stored if the file
+// does not match.
+func (c *Client) Download(ctx context.Context, s storage.Storage, fileURL, key string, hashes map[string]string, required []string) (err error) {
+	v, err := newVerifier(hashes, required)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Depot/0.1 (+https://github.com/a-h/depot)")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download file %s: %w", fileURL, err)
+	}
+	defer resp.Body.Close()
+	if err = checkStatus(resp, fileURL); err != nil {
+		return err
+	}
+
+	w, err := s.Put(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to create storage writer for %s: %w", key, err)
+	}
+	defer func() {
+		if err != nil {
+			storage.Abort(w)
+			return
+		}
+		err = w.Close()
+	}()
+
+	if _, err = io.Copy(io.MultiWriter(w, v), resp.Body); err != nil {
+		return fmt.Errorf("failed to write %s to storage: %w", key, err)
+	}
+	if v.indexHash == "" && len(required) == 0 {
+		c.log.Warn("no sha256 hash available, skipping verification", slog.String("url", fileURL))
+		return nil
+	}
+	return v.verify(fileURL)
+}
+
+// Verify checks the content of r in the same way as Download.
+func Verify(r io.Reader, name string, hashes map[string]string, required []string) error {
+	v, err := newVerifier(hashes, required)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(v, r); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return v.verify(name)
+}
+
+// hashAlgorithms are the algorithms supported by pip in hash-checking mode.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// verifier computes the digests needed to check a file against the sha256
+// hash published by the index, and against the hashes of a requirement.
+type verifier struct {
+	indexHash string
+	required  []string
+	digests   map[string]hash.Hash
+}
+
+func newVerifier(hashes map[string]string, required []string) (v *verifier, err error) {
+	v = &verifier{
+		indexHash: hashes["sha256"],
+		required:  required,
+		digests:   map[string]hash.Hash{"sha256": sha256.New()},
+	}
+	for _, h := range required {
+		algorithm, _, _ := strings.Cut(h, ":")
+		newHash, ok := hashAlgorithms[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm in %q", h)
+		}
+		if _, ok := v.digests[algorithm]; !ok {
+			v.digests[algorithm] = newHash()
+		}
+	}
+	return v, nil
+}
+
+func (v *verifier) Write(p []byte) (n int, err error) {
+	for _, h := range v.digests {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (v *verifier) verify(name string) error {
+	actual := hex.EncodeToString(v.digests["sha256"].Sum(nil))
+	if v.indexHash != "" && !strings.EqualFold(actual, v.indexHash) {
+		return fmt.Errorf("%w: %s expected sha256 %s, got %s", ErrHashMismatch, name, v.indexHash, actual)
+	}
+	if len(v.required) == 0 {
+		return nil
+	}
+	for _, h := range v.required {
+		algorithm, expected, _ := strings.Cut(h, ":")
+		if strings.EqualFold(hex.EncodeToString(v.digests[algorithm].Sum(nil)), expected) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s doesn't match any of the required hashes %s, got sha256 %s", ErrHashMismatch, name, strings.Join(v.required, ", "), actual)
+}
+
+func checkStatus(resp *http.Response, url string) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: HTTP %d from %s", ErrNotFound, resp.StatusCode, url)
+	}
+	return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+}
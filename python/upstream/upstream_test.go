@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/a-h/depot/python/models"
+	"github.com/a-h/depot/storage"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	wheel := []byte("wheel content")
+	sum := sha256.Sum256(wheel)
+	wheelHash := hex.EncodeToString(sum[:])
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/simple/example-pkg/":
+			if r.Header.Get("Accept") != "application/vnd.pypi.simple.v1+json" {
+				http.Error(w, "unexpected accept header", http.StatusNotAcceptable)
+				return
+			}
+			json.NewEncoder(w).Encode(models.SimplePackageIndex{
+				Name: "example-pkg",
+				Files: []models.SimpleFileEntry{
+					{Filename: "example_pkg-1.0.0-py3-none-any.whl", URL: "../../files/example_pkg-1.0.0-py3-none-any.whl", Hashes: map[string]string{"sha256": wheelHash}},
+				},
+			})
+		case "/files/example_pkg-1.0.0-py3-none-any.whl":
+			w.Write(wheel)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c := New(log, ts.URL+"/simple/")
+
+	t.Run("relative file URLs are resolved against the index", func(t *testing.T) {
+		index, err := c.GetPackageIndex(ctx, "example-pkg")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(index.Files) != 1 {
+			t.Fatalf("expected 1 file, got %d", len(index.Files))
+		}
+		expected := ts.URL + "/files/example_pkg-1.0.0-py3-none-any.whl"
+		if index.Files[0].URL != expected {
+			t.Errorf("expected URL %q, got %q", expected, index.Files[0].URL)
+		}
+	})
+	t.Run("missing package is not found", func(t *testing.T) {
+		_, err := c.GetPackageIndex(ctx, "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found error, got %v", err)
+		}
+	})
+	t.Run("file is downloaded to storage", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		err := c.Download(ctx, s, ts.URL+"/files/example_pkg-1.0.0-py3-none-any.whl", "example-pkg/example_pkg-1.0.0-py3-none-any.whl", map[string]string{"sha256": wheelHash}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r, exists, err := s.Get(ctx, "example-pkg/example_pkg-1.0.0-py3-none-any.whl")
+		if err != nil || !exists {
+			t.Fatalf("expected file in storage, exists=%v, err=%v", exists, err)
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(content) != string(wheel) {
+			t.Errorf("expected %q, got %q", wheel, content)
+		}
+	})
+	t.Run("file with mismatched hash is not stored", func(t *testing.T) {
+		s := storage.NewFileSystem(t.TempDir())
+		err := c.Download(ctx, s, ts.URL+"/files/example_pkg-1.0.0-py3-none-any.whl", "example-pkg/example_pkg-1.0.0-py3-none-any.whl", map[string]string{"sha256": "0000"}, nil)
+		if !errors.Is(err, ErrHashMismatch) {
+			t.Fatalf("expected hash mismatch error, got %v", err)
+		}
+		_, exists, err := s.Stat(ctx, "example-pkg/example_pkg-1.0.0-py3-none-any.whl")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected file with mismatched hash not to be stored")
+		}
+	})
+}
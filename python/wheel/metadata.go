@@ -0,0 +1,29 @@
+package wheel
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadMetadata returns the contents of the .dist-info/METADATA file of a wheel.
+func ReadMetadata(r io.ReaderAt, size int64) (metadata []byte, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wheel: %w", err)
+	}
+	for _, f := range zr.File {
+		dir, name, _ := strings.Cut(f.Name, "/")
+		if !strings.HasSuffix(dir, ".dist-info") || name != "METADATA" {
+			continue
+		}
+		mr, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+		}
+		defer mr.Close()
+		return io.ReadAll(mr)
+	}
+	return nil, fmt.Errorf("wheel does not contain a METADATA file")
+}
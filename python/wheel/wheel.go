@@ -0,0 +1,235 @@
+package wheel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filename is a parsed wheel filename, e.g. numpy-2.0.0-cp312-cp312-manylinux_2_17_x86_64.whl
+type Filename struct {
+	Name    string
+	Version string
+	Build   string
+	// Tags contains every combination of the compressed tag sets, e.g. py2.py3-none-any is expanded to py2-none-any and py3-none-any.
+	Tags []Tag
+}
+
+// ParseFilename parses a wheel filename according to the binary distribution format specification.
+func ParseFilename(filename string) (f Filename, err error) {
+	base, ok := strings.CutSuffix(filename, ".whl")
+	if !ok {
+		return f, fmt.Errorf("invalid wheel filename %q: missing .whl extension", filename)
+	}
+	parts := strings.Split(base, "-")
+	switch len(parts) {
+	case 5:
+		f.Name, f.Version = parts[0], parts[1]
+	case 6:
+		f.Name, f.Version, f.Build = parts[0], parts[1], parts[2]
+	default:
+		return f, fmt.Errorf("invalid wheel filename %q: expected 5 or 6 parts, got %d", filename, len(parts))
+	}
+	tags := parts[len(parts)-3:]
+	for interpreter := range strings.SplitSeq(tags[0], ".") {
+		for abi := range strings.SplitSeq(tags[1], ".") {
+			for platform := range strings.SplitSeq(tags[2], ".") {
+				f.Tags = append(f.Tags, Tag{Interpreter: interpreter, ABI: abi, Platform: platform})
+			}
+		}
+	}
+	return f, nil
+}
+
+// Tag is a PEP 425 compatibility tag.
+type Tag struct {
+	// Interpreter, e.g. py3 or cp312.
+	Interpreter string
+	// ABI, e.g. none, abi3 or cp312.
+	ABI string
+	// Platform, e.g. any, win_amd64 or manylinux_2_17_x86_64.
+	Platform string
+}
+
+func (t Tag) String() string {
+	return t.Interpreter + "-" + t.ABI + "-" + t.Platform
+}
+
+// Compatible returns true if a wheel with the tag can be installed by the Python
+// version (e.g. "3.12"), implementation (e.g. "cp") and on one of the platforms
+// (e.g. "manylinux_2_28_x86_64"). An empty version, implementation or platform
+// list matches everything.
+func (t Tag) Compatible(pythonVersion, implementation string, platforms []string) bool {
+	major, minor, err := parsePythonVersion(pythonVersion)
+	if err != nil {
+		return false
+	}
+	interpreterImpl, interpreterVersion := splitTag(t.Interpreter)
+	if implementation != "" && interpreterImpl != "py" && interpreterImpl != implementation {
+		return false
+	}
+	if pythonVersion != "" && !compatibleInterpreterVersion(interpreterImpl, interpreterVersion, t.ABI, major, minor) {
+		return false
+	}
+	if !compatibleABI(t.ABI, implementation, pythonVersion, major, minor) {
+		return false
+	}
+	if len(platforms) == 0 || t.Platform == "any" {
+		return true
+	}
+	for _, platform := range platforms {
+		if CompatiblePlatform(t.Platform, platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePythonVersion parses versions in "3.12" or "312" form.
+func parsePythonVersion(v string) (major, minor string, err error) {
+	if v == "" {
+		return "", "", nil
+	}
+	major, minor, ok := strings.Cut(v, ".")
+	if !ok && len(v) > 1 {
+		major, minor = v[:1], v[1:]
+	}
+	for _, s := range []string{major, minor} {
+		if s == "" {
+			continue
+		}
+		if _, err = strconv.Atoi(s); err != nil {
+			return "", "", fmt.Errorf("invalid Python version %q", v)
+		}
+	}
+	return major, minor, nil
+}
+
+// splitTag splits a tag into the letters of the implementation and the digits
+// of the version, e.g. cp312 into cp and 312.
+func splitTag(tag string) (impl, version string) {
+	i := strings.IndexFunc(tag, func(r rune) bool { return r >= '0' && r <= '9' })
+	if i == -1 {
+		return tag, ""
+	}
+	j := strings.IndexFunc(tag[i:], func(r rune) bool { return r < '0' || r > '9' })
+	if j == -1 {
+		return tag[:i], tag[i:]
+	}
+	return tag[:i], tag[i : i+j]
+}
+
+func compatibleInterpreterVersion(impl, version, abi, major, minor string) bool {
+	if version == "" || version[:1] != major {
+		return false
+	}
+	if len(version) == 1 || minor == "" {
+		return true
+	}
+	tagMinor, err := strconv.Atoi(version[1:])
+	if err != nil {
+		return false
+	}
+	targetMinor, err := strconv.Atoi(minor)
+	if err != nil {
+		return false
+	}
+	// Pure Python wheels, and wheels built against the stable ABI, work with later versions.
+	if impl == "py" || abi == "abi3" {
+		return tagMinor <= targetMinor
+	}
+	return tagMinor == targetMinor
+}
+
+func compatibleABI(abi, implementation, pythonVersion, major, minor string) bool {
+	if abi == "none" {
+		return true
+	}
+	if implementation == "py" {
+		return false
+	}
+	if abi == "abi3" {
+		return implementation == "" || implementation == "cp"
+	}
+	abiImpl, abiVersion := splitTag(abi)
+	if abiImpl == "pypy" {
+		abiImpl = "pp"
+	}
+	if implementation != "" && abiImpl != implementation {
+		return false
+	}
+	return pythonVersion == "" || abiVersion == major+minor
+}
+
+var (
+	platformRegexp = regexp.MustCompile(`^(manylinux|musllinux|macosx)_(\d+)_(\d+)_(.+)$`)
+	legacyPlatform = map[string]string{
+		"manylinux1_":    "manylinux_2_5_",
+		"manylinux2010_": "manylinux_2_12_",
+		"manylinux2014_": "manylinux_2_17_",
+	}
+	// macOSArchitectures maps the multi-architecture macOS platforms to the architectures they contain.
+	macOSArchitectures = map[string][]string{
+		"universal2": {"arm64", "x86_64"},
+		"universal":  {"i386", "ppc", "ppc64", "x86_64"},
+		"intel":      {"i386", "x86_64"},
+		"fat":        {"i386", "ppc"},
+		"fat3":       {"i386", "ppc", "x86_64"},
+		"fat64":      {"ppc64", "x86_64"},
+	}
+)
+
+type platform struct {
+	family       string
+	major, minor int
+	arch         string
+}
+
+func parsePlatform(s string) (p platform, ok bool) {
+	for legacy, replacement := range legacyPlatform {
+		if rest, found := strings.CutPrefix(s, legacy); found {
+			s = replacement + rest
+		}
+	}
+	m := platformRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return p, false
+	}
+	p.family, p.arch = m[1], m[4]
+	p.major, _ = strconv.Atoi(m[2])
+	p.minor, _ = strconv.Atoi(m[3])
+	return p, true
+}
+
+// CompatiblePlatform returns true if a wheel built for the tag platform can be
+// installed on the target platform. manylinux, musllinux and macOS wheels built
+// for older versions of the platform can be installed on newer versions.
+func CompatiblePlatform(tag, target string) bool {
+	if tag == target {
+		return true
+	}
+	t, ok := parsePlatform(tag)
+	if !ok {
+		return false
+	}
+	p, ok := parsePlatform(target)
+	if !ok || t.family != p.family {
+		return false
+	}
+	if t.major > p.major || (t.major == p.major && t.minor > p.minor) {
+		return false
+	}
+	if t.arch == p.arch {
+		return true
+	}
+	if t.family != "macosx" {
+		return false
+	}
+	for _, arch := range macOSArchitectures[t.arch] {
+		if arch == p.arch {
+			return true
+		}
+	}
+	return false
+}
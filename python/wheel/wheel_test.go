@@ -0,0 +1,80 @@
+package wheel
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseFilename(t *testing.T) {
+	t.Run("compressed tag sets are expanded", func(t *testing.T) {
+		f, err := ParseFilename("six-1.16.0-py2.py3-none-any.whl")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if f.Name != "six" || f.Version != "1.16.0" || f.Build != "" {
+			t.Errorf("unexpected filename: %+v", f)
+		}
+		expected := []Tag{{"py2", "none", "any"}, {"py3", "none", "any"}}
+		if !slices.Equal(f.Tags, expected) {
+			t.Errorf("expected tags %v, got %v", expected, f.Tags)
+		}
+	})
+	t.Run("build tags are parsed", func(t *testing.T) {
+		f, err := ParseFilename("pkg-1.0-1-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if f.Build != "1" || len(f.Tags) != 2 {
+			t.Errorf("unexpected filename: %+v", f)
+		}
+	})
+	t.Run("invalid filenames are rejected", func(t *testing.T) {
+		for _, filename := range []string{"pkg-1.0.tar.gz", "pkg-1.0-py3.whl"} {
+			if _, err := ParseFilename(filename); err == nil {
+				t.Errorf("expected error for %q", filename)
+			}
+		}
+	})
+}
+
+func TestTagCompatible(t *testing.T) {
+	linux := []string{"manylinux_2_28_x86_64"}
+	tests := []struct {
+		tag            string
+		pythonVersion  string
+		implementation string
+		platforms      []string
+		expected       bool
+	}{
+		{tag: "py3-none-any", pythonVersion: "3.12", expected: true},
+		{tag: "py2-none-any", pythonVersion: "3.12", expected: false},
+		{tag: "py38-none-any", pythonVersion: "3.12", expected: true},
+		{tag: "py313-none-any", pythonVersion: "3.12", expected: false},
+		{tag: "py3-none-any", pythonVersion: "312", implementation: "py", platforms: linux, expected: true},
+		{tag: "cp312-cp312-manylinux_2_17_x86_64", pythonVersion: "3.12", implementation: "cp", platforms: linux, expected: true},
+		{tag: "cp312-cp312-manylinux2014_x86_64", pythonVersion: "3.12", platforms: linux, expected: true},
+		{tag: "cp312-cp312-manylinux_2_34_x86_64", pythonVersion: "3.12", platforms: linux, expected: false},
+		{tag: "cp312-cp312-manylinux_2_17_aarch64", pythonVersion: "3.12", platforms: linux, expected: false},
+		{tag: "cp312-cp312-musllinux_1_1_x86_64", pythonVersion: "3.12", platforms: linux, expected: false},
+		{tag: "cp311-cp311-manylinux_2_17_x86_64", pythonVersion: "3.12", platforms: linux, expected: false},
+		{tag: "cp38-abi3-manylinux_2_17_x86_64", pythonVersion: "3.12", platforms: linux, expected: true},
+		{tag: "cp312-cp312-manylinux_2_17_x86_64", pythonVersion: "3.12", implementation: "pp", platforms: linux, expected: false},
+		{tag: "cp312-cp312-manylinux_2_17_x86_64", pythonVersion: "3.12", implementation: "py", platforms: linux, expected: false},
+		{tag: "pp310-pypy310_pp73-manylinux_2_17_x86_64", pythonVersion: "3.10", implementation: "pp", platforms: linux, expected: true},
+		{tag: "cp312-cp312-win_amd64", pythonVersion: "3.12", platforms: []string{"win_amd64"}, expected: true},
+		{tag: "cp312-cp312-macosx_10_9_universal2", pythonVersion: "3.12", platforms: []string{"macosx_14_0_arm64"}, expected: true},
+		{tag: "cp312-cp312-macosx_14_0_x86_64", pythonVersion: "3.12", platforms: []string{"macosx_14_0_arm64"}, expected: false},
+		{tag: "cp312-cp312-win_amd64", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			f, err := ParseFilename("pkg-1.0-" + tt.tag + ".whl")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := f.Tags[0].Compatible(tt.pythonVersion, tt.implementation, tt.platforms); actual != tt.expected {
+				t.Errorf("expected %v for python %q, implementation %q, platforms %v, got %v", tt.expected, tt.pythonVersion, tt.implementation, tt.platforms, actual)
+			}
+		})
+	}
+}
@@ -3,64 +3,84 @@ package routes
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/a-h/depot/auth"
 	gomoddb "github.com/a-h/depot/gomod/db"
+	gomoddownload "github.com/a-h/depot/gomod/download"
 	gomodhandler "github.com/a-h/depot/gomod/handlers"
 	"github.com/a-h/depot/metrics"
 	authmiddleware "github.com/a-h/depot/middleware/auth"
 	"github.com/a-h/depot/middleware/logger"
 	nixdb "github.com/a-h/depot/nix/db"
 	nixhandler "github.com/a-h/depot/nix/handlers"
+	"github.com/a-h/depot/nix/upstream"
 	npmdb "github.com/a-h/depot/npm/db"
+	npmdownload "github.com/a-h/depot/npm/download"
 	npmhandler "github.com/a-h/depot/npm/handlers"
 	pythondb "github.com/a-h/depot/python/db"
 	pythonhandler "github.com/a-h/depot/python/handlers"
+	pythonupstream "github.com/a-h/depot/python/upstream"
 	"github.com/a-h/depot/storage"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
 )
 
 // HandlerConfig holds the dependencies for each package type handler.
 type HandlerConfig struct {
-	GoMod  PackageHandlerConfig[*gomoddb.DB]
+	GoMod  GoModHandlerConfig
 	Nix    NixHandlerConfig
-	NPM    PackageHandlerConfig[*npmdb.DB]
+	NPM    NPMHandlerConfig
 	Python PythonHandlerConfig
 }
 
-// PackageHandlerConfig holds the DB and storage for a package type.
-type PackageHandlerConfig[T any] struct {
-	DB      T
-	Storage storage.Storage
+// GoModHandlerConfig holds the DB, storage and options of the Go module handler.
+type GoModHandlerConfig struct {
+	DB       *gomoddb.DB
+	Storage  storage.Storage
+	Upstream *gomoddownload.Downloader
+	// RejectChangedZips rejects pushed zips whose hash differs from the recorded hash.
+	RejectChangedZips bool
 }
 
-// NixHandlerConfig extends PackageHandlerConfig with Nix-specific options.
+// NixHandlerConfig holds the DB, storage and options of the Nix handler.
 type NixHandlerConfig struct {
 	DB         *nixdb.DB
 	Storage    storage.Storage
 	PrivateKey *signature.SecretKey
+	Upstream   *upstream.Client
 }
 
-// PythonHandlerConfig extends PackageHandlerConfig with Python-specific options.
+// NPMHandlerConfig holds the DB, storage and options of the NPM handler.
+type NPMHandlerConfig struct {
+	DB       *npmdb.DB
+	Storage  storage.Storage
+	Upstream *npmdownload.Downloader
+	// UpstreamMaxAge is how long package metadata fetched from the upstream registry is served before it's checked for changes.
+	UpstreamMaxAge time.Duration
+	BaseURL        string
+}
+
+// PythonHandlerConfig holds the DB, storage and options of the Python handler.
 type PythonHandlerConfig struct {
-	DB      *pythondb.DB
-	Storage storage.Storage
-	BaseURL string
+	DB       *pythondb.DB
+	Storage  storage.Storage
+	Upstream *pythonupstream.Client
+	BaseURL  string
 }
 
 func New(log *slog.Logger, cfg HandlerConfig, authConfig *auth.AuthConfig, metrics metrics.Metrics) http.Handler {
 	mux := http.NewServeMux()
 
-	goh := gomodhandler.New(log, cfg.GoMod.DB, cfg.GoMod.Storage, metrics)
+	goh := gomodhandler.New(log, cfg.GoMod.DB, cfg.GoMod.Storage, cfg.GoMod.Upstream, cfg.GoMod.RejectChangedZips, metrics)
 	mux.Handle("/go/", http.StripPrefix("/go", goh))
 
-	nih := nixhandler.New(log, cfg.Nix.DB, cfg.Nix.Storage, cfg.Nix.PrivateKey, metrics)
+	nih := nixhandler.New(log, cfg.Nix.DB, cfg.Nix.Storage, cfg.Nix.PrivateKey, cfg.Nix.Upstream, metrics)
 	mux.Handle("/nix/", http.StripPrefix("/nix", nih))
 
-	npmh := npmhandler.New(log, cfg.NPM.DB, cfg.NPM.Storage, metrics)
+	npmh := npmhandler.New(log, cfg.NPM.DB, cfg.NPM.Storage, cfg.NPM.Upstream, cfg.NPM.UpstreamMaxAge, cfg.NPM.BaseURL, metrics)
 	mux.Handle("/npm/", http.StripPrefix("/npm", npmh))
 
-	pythonh := pythonhandler.New(log, cfg.Python.DB, cfg.Python.Storage, cfg.Python.BaseURL, metrics)
+	pythonh := pythonhandler.New(log, cfg.Python.DB, cfg.Python.Storage, cfg.Python.Upstream, cfg.Python.BaseURL, metrics)
 	mux.Handle("/python/", http.StripPrefix("/python", pythonh))
 
 	authHandler := authmiddleware.New(log, authConfig, mux)
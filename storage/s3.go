@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -123,6 +124,46 @@ func (s *S3) Put(ctx context.Context, filename string) (w io.WriteCloser, err er
 	return &pipeWriter{pw: pw, done: uploadDone}, nil
 }
 
+func (s *S3) Delete(ctx context.Context, filename string) (err error) {
+	// S3 does not return an error when deleting a key that does not exist.
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(filepath.Join(s.prefix, filename)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) List(ctx context.Context, prefix, startAfter string, limit int) (filenames []string, err error) {
+	keyPrefix := s.prefix
+	if keyPrefix != "" && !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(keyPrefix + prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(keyPrefix + startAfter)
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list S3 objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			if limit >= 0 && len(filenames) >= limit {
+				return filenames, nil
+			}
+			filenames = append(filenames, strings.TrimPrefix(aws.ToString(obj.Key), keyPrefix))
+		}
+	}
+	return filenames, nil
+}
+
 // pipeWriter wraps a PipeWriter so that Close blocks until the background upload
 // goroutine has finished, ensuring callers see upload errors and objects are
 // visible immediately after Close returns.
@@ -135,6 +176,16 @@ func (w *pipeWriter) Write(p []byte) (n int, err error) {
 	return w.pw.Write(p)
 }
 
+// errUploadAborted is passed to the upload goroutine to cancel an upload.
+var errUploadAborted = errors.New("upload aborted")
+
+// Abort cancels the upload, so that no object is written.
+func (w *pipeWriter) Abort() error {
+	w.pw.CloseWithError(errUploadAborted)
+	<-w.done
+	return nil
+}
+
 func (w *pipeWriter) Close() error {
 	if err := w.pw.Close(); err != nil {
 		return err
@@ -235,6 +235,54 @@ func TestS3Storage(t *testing.T) {
 			t.Errorf("content mismatch, expected %d bytes, got %d bytes", len(testContent), len(content))
 		}
 	})
+
+	t.Run("list returns files with prefix", func(t *testing.T) {
+		for _, name := range []string{"list/a.txt", "list/b.txt", "list/c.txt", "other.txt"} {
+			w, err := storage.Put(ctx, name)
+			if err != nil {
+				t.Fatalf("failed to create writer: %v", err)
+			}
+			if _, err := w.Write([]byte(name)); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("failed to close writer: %v", err)
+			}
+		}
+
+		filenames, err := storage.List(ctx, "list/", "", -1)
+		if err != nil {
+			t.Fatalf("failed to list: %v", err)
+		}
+		expected := []string{"list/a.txt", "list/b.txt", "list/c.txt"}
+		if fmt.Sprint(filenames) != fmt.Sprint(expected) {
+			t.Errorf("expected %v, got %v", expected, filenames)
+		}
+
+		page, err := storage.List(ctx, "list/", "list/a.txt", 1)
+		if err != nil {
+			t.Fatalf("failed to list page: %v", err)
+		}
+		if len(page) != 1 || page[0] != "list/b.txt" {
+			t.Errorf("expected [list/b.txt], got %v", page)
+		}
+	})
+
+	t.Run("delete removes file", func(t *testing.T) {
+		if err := storage.Delete(ctx, "list/a.txt"); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		_, exists, err := storage.Stat(ctx, "list/a.txt")
+		if err != nil {
+			t.Errorf("stat failed: %v", err)
+		}
+		if exists {
+			t.Errorf("expected exists=false, got true")
+		}
+		if err := storage.Delete(ctx, "list/a.txt"); err != nil {
+			t.Errorf("expected no error deleting missing file, got %v", err)
+		}
+	})
 }
 
 func waitForS3(ctx context.Context, client *s3.Client) error {
@@ -2,17 +2,60 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 )
 
+// tempFileSuffix marks files that are still being written by FileSystem.Put.
+const tempFileSuffix = ".depot-tmp"
+
 // Storage interface abstracts file storage operations for reading and writing.
 type Storage interface {
 	Stat(ctx context.Context, filename string) (size int64, exists bool, err error)
 	Get(ctx context.Context, filename string) (r io.ReadCloser, exists bool, err error)
 	Put(ctx context.Context, filename string) (w io.WriteCloser, err error)
+	// Delete removes a file. Deleting a file that does not exist is not an error.
+	Delete(ctx context.Context, filename string) (err error)
+	// List returns up to limit filenames that start with prefix, in lexical order.
+	// Only filenames that sort after startAfter are returned, so the last filename
+	// of one page can be passed as startAfter to fetch the next page. A limit of -1
+	// returns all matching filenames.
+	List(ctx context.Context, prefix, startAfter string, limit int) (filenames []string, err error)
+}
+
+// Aborter is implemented by writers returned from Put that can discard a partially
+// written file instead of committing it to storage on Close.
+type Aborter interface {
+	Abort() error
+}
+
+// Abort discards a partially written file. If the writer does not support aborting,
+// it is closed instead.
+func Abort(w io.WriteCloser) error {
+	if a, ok := w.(Aborter); ok {
+		return a.Abort()
+	}
+	return w.Close()
+}
+
+// WriteFile writes data to filename. If the write fails, the partially written
+// file is discarded.
+func WriteFile(ctx context.Context, s Storage, filename string, data []byte) (err error) {
+	w, err := s.Put(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if _, err = w.Write(data); err != nil {
+		Abort(w)
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return w.Close()
 }
 
 var _ Storage = (*FileSystem)(nil)
@@ -61,11 +104,138 @@ func (fs *FileSystem) Put(ctx context.Context, filename string) (w io.WriteClose
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Create.
-	file, err := os.Create(fullPath)
+	// Write to a temporary file in the same directory, so that the file can be
+	// atomically renamed into place once the write is complete.
+	file, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".*"+tempFileSuffix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
 
-	return file, nil
+	return &atomicFile{file: file, path: fullPath}, nil
+}
+
+// RemoveTempFiles removes temporary files left behind by writes that were
+// interrupted, e.g. by a server crash. It should only be called when no writes
+// are in progress, such as at startup.
+func (fs *FileSystem) RemoveTempFiles(ctx context.Context) (removed int, err error) {
+	err = filepath.WalkDir(fs.basePath, func(fullPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), tempFileSuffix) {
+			return nil
+		}
+		if err = os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to remove temporary files: %w", err)
+	}
+	return removed, nil
+}
+
+// atomicFile writes to a temporary file, which is synced and renamed to its
+// final path on Close. Readers never see a partially written file.
+type atomicFile struct {
+	file   *os.File
+	path   string
+	closed bool
+}
+
+func (f *atomicFile) Write(p []byte) (n int, err error) {
+	return f.file.Write(p)
+}
+
+func (f *atomicFile) Close() (err error) {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer func() {
+		if err != nil {
+			os.Remove(f.file.Name())
+		}
+	}()
+	if err = f.file.Sync(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err = f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err = os.Rename(f.file.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the temporary file without replacing the file at the final path.
+func (f *atomicFile) Abort() (err error) {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	f.file.Close()
+	if err = os.Remove(f.file.Name()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove temporary file: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileSystem) Delete(ctx context.Context, filename string) (err error) {
+	fullPath := filepath.Join(fs.basePath, filename)
+	if err = os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (fs *FileSystem) List(ctx context.Context, prefix, startAfter string, limit int) (filenames []string, err error) {
+	// Only walk the directory that contains the prefix, rather than the whole tree.
+	// Appending a character ensures that a prefix ending in "/" walks that directory.
+	walkRoot := fs.basePath
+	if dir := path.Dir(prefix + "x"); dir != "." {
+		walkRoot = filepath.Join(fs.basePath, filepath.FromSlash(dir))
+	}
+	err = filepath.WalkDir(walkRoot, func(fullPath string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), tempFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(fs.basePath, fullPath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) || name <= startAfter {
+			return nil
+		}
+		filenames = append(filenames, name)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	slices.Sort(filenames)
+	if limit >= 0 && len(filenames) > limit {
+		filenames = filenames[:limit]
+	}
+	return filenames, nil
 }
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileSystem(t *testing.T) {
+	ctx := context.Background()
+	storage := NewFileSystem(t.TempDir())
+
+	put := func(t *testing.T, filename, content string) {
+		t.Helper()
+		w, err := storage.Put(ctx, filename)
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close writer: %v", err)
+		}
+	}
+
+	put(t, "a/1.txt", "1")
+	put(t, "a/2.txt", "2")
+	put(t, "a/b/3.txt", "3")
+	put(t, "ab/4.txt", "4")
+	put(t, "c.txt", "5")
+
+	t.Run("list returns all files in lexical order", func(t *testing.T) {
+		filenames, err := storage.List(ctx, "", "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"a/1.txt", "a/2.txt", "a/b/3.txt", "ab/4.txt", "c.txt"}
+		if diff := cmp.Diff(expected, filenames); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("list filters by directory prefix", func(t *testing.T) {
+		filenames, err := storage.List(ctx, "a/", "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"a/1.txt", "a/2.txt", "a/b/3.txt"}
+		if diff := cmp.Diff(expected, filenames); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("list filters by partial prefix", func(t *testing.T) {
+		filenames, err := storage.List(ctx, "a", "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"a/1.txt", "a/2.txt", "a/b/3.txt", "ab/4.txt"}
+		if diff := cmp.Diff(expected, filenames); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("list can be paginated", func(t *testing.T) {
+		var pages [][]string
+		var startAfter string
+		for {
+			page, err := storage.List(ctx, "", startAfter, 2)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			pages = append(pages, page)
+			startAfter = page[len(page)-1]
+		}
+		expected := [][]string{
+			{"a/1.txt", "a/2.txt"},
+			{"a/b/3.txt", "ab/4.txt"},
+			{"c.txt"},
+		}
+		if diff := cmp.Diff(expected, pages); diff != "" {
+			t.Error(diff)
+		}
+	})
+	t.Run("list of a missing prefix returns no files", func(t *testing.T) {
+		filenames, err := storage.List(ctx, "missing/", "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(filenames) != 0 {
+			t.Errorf("expected no files, got %v", filenames)
+		}
+	})
+	t.Run("delete removes the file", func(t *testing.T) {
+		if err := storage.Delete(ctx, "c.txt"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, exists, err := storage.Stat(ctx, "c.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected exists=false after delete, got true")
+		}
+	})
+	t.Run("delete of a missing file is not an error", func(t *testing.T) {
+		if err := storage.Delete(ctx, "does-not-exist.txt"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestFileSystemAtomicWrites(t *testing.T) {
+	ctx := context.Background()
+	basePath := t.TempDir()
+	storage := NewFileSystem(basePath)
+
+	t.Run("unclosed writes are not visible", func(t *testing.T) {
+		w, err := storage.Put(ctx, "pending.txt")
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		_, exists, err := storage.Stat(ctx, "pending.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected exists=false before close, got true")
+		}
+		filenames, err := storage.List(ctx, "", "", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(filenames) != 0 {
+			t.Errorf("expected temporary files to be excluded from list, got %v", filenames)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("failed to close writer: %v", err)
+		}
+		_, exists, err = storage.Stat(ctx, "pending.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !exists {
+			t.Error("expected exists=true after close, got false")
+		}
+	})
+	t.Run("aborted writes leave no file", func(t *testing.T) {
+		w, err := storage.Put(ctx, "aborted/file.txt")
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if err := Abort(w); err != nil {
+			t.Fatalf("failed to abort writer: %v", err)
+		}
+		_, exists, err := storage.Stat(ctx, "aborted/file.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists {
+			t.Error("expected exists=false after abort, got true")
+		}
+		entries, err := os.ReadDir(filepath.Join(basePath, "aborted"))
+		if err != nil {
+			t.Fatalf("failed to read directory: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected no files after abort, got %d", len(entries))
+		}
+	})
+	t.Run("aborted writes do not replace existing files", func(t *testing.T) {
+		w, err := storage.Put(ctx, "pending.txt")
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		if _, err := w.Write([]byte("replacement")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if err := Abort(w); err != nil {
+			t.Fatalf("failed to abort writer: %v", err)
+		}
+		content, err := os.ReadFile(filepath.Join(basePath, "pending.txt"))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(content) != "partial" {
+			t.Errorf("expected original content %q, got %q", "partial", string(content))
+		}
+	})
+	t.Run("temporary files left by a crash are removed", func(t *testing.T) {
+		w, err := storage.Put(ctx, "crashed/file.txt")
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		if _, err := w.Write([]byte("partial")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		// Simulate a crash by abandoning the writer without closing it.
+		removed, err := storage.RemoveTempFiles(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if removed != 1 {
+			t.Errorf("expected 1 file to be removed, got %d", removed)
+		}
+		entries, err := os.ReadDir(filepath.Join(basePath, "crashed"))
+		if err != nil {
+			t.Fatalf("failed to read directory: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected no files after cleanup, got %d", len(entries))
+		}
+	})
+}